@@ -0,0 +1,34 @@
+module goexp/cmd/goexp
+
+go 1.24.3
+
+require (
+	goexp/graceful_restarts/SocketHandoff v0.0.0
+	goexp/graceful_restarts/sysdsockack v0.0.0
+	goexp/graceful_restarts/tbflip v0.0.0
+	goexp/iowait v0.0.0
+	goexp/sendf v0.0.0
+	goexp/tcpq v0.0.0
+	s1 v0.0.0
+	tproxy v0.0.0
+)
+
+require (
+	github.com/cloudflare/tableflip v1.2.3 // indirect
+	github.com/coreos/go-systemd v0.0.0-20191104093116-d3cd4ed1dbcf // indirect
+	goexp/internal v0.0.0 // indirect
+	golang.org/x/sys v0.8.0 // indirect
+)
+
+// Every experiment is a module of its own in this repo.
+replace (
+	goexp/graceful_restarts/SocketHandoff => ../../graceful_restarts/SocketHandoff
+	goexp/graceful_restarts/sysdsockack => ../../graceful_restarts/systemd-socket-activation
+	goexp/graceful_restarts/tbflip => ../../graceful_restarts/tbflip
+	goexp/internal => ../../internal
+	goexp/iowait => ../../iowait
+	goexp/sendf => ../../sendfl
+	goexp/tcpq => ../../tcpqueue
+	s1 => ../../proxyProto
+	tproxy => ../../transparentProxy
+)
@@ -0,0 +1,77 @@
+// Command goexp runs the repo's experiments as subcommands of one binary.
+// Each subcommand takes the flags of the experiment's own binary, and -h
+// lists them.
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+
+	"goexp/graceful_restarts/SocketHandoff/handoff"
+	"goexp/graceful_restarts/sysdsockack/sdactivate"
+	"goexp/graceful_restarts/tbflip/flip"
+	"goexp/iowait/iowait"
+	"goexp/sendf/sendfl"
+	"goexp/tcpq/tcpqueue"
+	"s1/demo"
+	"tproxy/txproxy"
+)
+
+// A command is one experiment.
+type command struct {
+	name    string
+	summary string
+	run     func(args []string) error
+}
+
+var commands = []command{
+	{"handoff", "HTTP server that hands its listener to a re-executed copy on SIGHUP", handoff.Run},
+	{"tableflip", "the same server upgraded with cloudflare/tableflip or a manual handoff", flip.Run},
+	{"sdactivate", "HTTP server on listeners passed by systemd socket activation", sdactivate.Run},
+	{"sendfl", "benchmark of ways to send a file over TCP, and its receiver", sendfl.Run},
+	{"txproxy", "transparent TCP proxy, and replay of its recordings", txproxy.Run},
+	{"iowait", "concurrent writers measuring fsync and I/O wait", iowait.Run},
+	{"tcpqueue", "accept queue and backlog overflow experiments", tcpqueue.Run},
+	{"proxyproto", "PROXY protocol server and relay", demo.Run},
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: goexp <command> [flags]\n\ncommands:")
+	for _, c := range commands {
+		fmt.Fprintf(os.Stderr, "  %-11s %s\n", c.name, c.summary)
+	}
+	fmt.Fprintln(os.Stderr, "\ngoexp <command> -h lists the command's flags.")
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+	name := os.Args[1]
+	switch name {
+	case "-h", "-help", "--help", "help":
+		usage()
+		return
+	}
+	for _, c := range commands {
+		if c.name != name {
+			continue
+		}
+		log.SetPrefix(name + ": ")
+		if err := c.run(os.Args[2:]); err != nil {
+			log.Print(err)
+			var exit interface{ ExitCode() int }
+			if errors.As(err, &exit) {
+				os.Exit(exit.ExitCode())
+			}
+			os.Exit(1)
+		}
+		return
+	}
+	fmt.Fprintf(os.Stderr, "goexp: unknown command %q\n", name)
+	usage()
+	os.Exit(2)
+}
@@ -1,3 +1,8 @@
-module SocketHandoff
+module goexp/graceful_restarts/SocketHandoff
 
 go 1.24.3
+
+require goexp/internal v0.0.0
+
+// The colored PID-prefixed logger is shared with the other restart demos.
+replace goexp/internal => ../../internal
@@ -0,0 +1,357 @@
+// Package handoff implements a minimal-but-complete Go program that demonstrates
+// zero-downtime graceful restart without any external libraries, using classic FD handoff +
+// a simple "I'm ready" pipe handshake.
+//
+// Features:
+//   - Listens on :8080 (-addr) and serves the demos' shared workload (goexp/internal/demoload): "hello world" + PID,
+//     generation and a monotonically increasing request id.
+//   - Every Nth request (default 3, -slow-every) is slow (default 10s, -slow), printing a heartbeat every second to stdout
+//     so you can watch an old process finish a long request while new process serves fresh ones.
+//   - On SIGHUP: parent forks/execs a new copy of itself, passing the listening socket via ExtraFiles,
+//     plus a pipe FD the child writes to when it is "ready". Parent stops accepting only after ready.
+//     The child gets the parent's arguments, so it runs the same command with the same flags.
+//   - On SIGTERM/SIGINT: graceful shutdown (stop accepting, drain in-flight requests, then return).
+//   - Uses http.Server.ConnState to track active connections accurately, and syscall.RawConn to show
+//     how to inspect the underlying file descriptor.
+//
+// Note: When we Close() the listener the http.Serve goroutine returns with an
+// "use of closed network connection" error. This is expected and safe to ignore.
+// We explicitly check for it before logging to avoid confusion.
+//
+// Useful references (read alongside this code):
+// - net/http Server & ConnState: https://pkg.go.dev/net/http#Server
+// - net.FileListener (FD -> Listener): https://pkg.go.dev/net#FileListener
+// - os/exec ExtraFiles (FD inheritance): https://pkg.go.dev/os/exec#Cmd
+// - Listener.File() dup semantics: https://pkg.go.dev/net#TCPListener.File
+// - Unix signals (SIGHUP/SIGTERM): man 7 signal (https://man7.org/linux/man-pages/man7/signal.7.html)
+// - Nginx/HAProxy graceful patterns (background): nginx reload docs, HAProxy seamless reload articles
+//
+// Tested on Linux/macOS. Windows does not support Unix signals in the same way; consider other patterns there.
+package handoff
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"goexp/internal/clog"
+	"goexp/internal/demoload"
+)
+
+// generationEnv carries the generation number to the child.
+const generationEnv = "GRACEFUL_GENERATION"
+
+// generation is 0 on a cold start and parent+1 after each restart.
+var generation = getenvInt(generationEnv, 0)
+
+// logger prefixes every line with this process's PID, in its own color, and
+// the generation too with LOG_FORMAT=tagged.
+var logger = clog.New("SocketHandoff").WithGeneration(generation)
+
+// getenvInt retrieves an environment variable as int, falling back to def if unset or invalid.
+func getenvInt(key string, def int) int {
+	if v := strings.TrimSpace(os.Getenv(key)); v != "" {
+		if n, err := strconv.Atoi(v); err == nil {
+			return n
+		}
+	}
+	return def
+}
+
+// getenvDur retrieves an environment variable as seconds and returns a time.Duration, fallback def.
+func getenvDur(key string, def time.Duration) time.Duration {
+	if v := strings.TrimSpace(os.Getenv(key)); v != "" {
+		if n, err := strconv.Atoi(v); err == nil {
+			return time.Duration(n) * time.Second
+		}
+	}
+	return def
+}
+
+// activeConns is the current number of active HTTP connections.
+// connTrack tracks them for the drain logs.
+var (
+	activeConns int64
+	connTrack   = newConnTracker()
+)
+
+// connTracker tracks active connections by listening to http.Server.ConnState callbacks.
+// It increments/decrements activeConns appropriately.
+type connTracker struct {
+	mu   sync.Mutex
+	seen map[net.Conn]bool // whether this conn is currently counted as active
+}
+
+// newConnTracker constructs a new connection tracker.
+func newConnTracker() *connTracker { return &connTracker{seen: make(map[net.Conn]bool)} }
+
+// onState updates active connection count based on HTTP state changes.
+func (t *connTracker) onState(c net.Conn, st http.ConnState) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	switch st {
+	case http.StateNew:
+		// not counted yet; we'll count on Active
+	case http.StateActive:
+		if !t.seen[c] {
+			t.seen[c] = true
+			atomic.AddInt64(&activeConns, 1)
+		}
+	case http.StateIdle, http.StateHijacked, http.StateClosed:
+		if t.seen[c] {
+			delete(t.seen, c)
+			atomic.AddInt64(&activeConns, -1)
+		}
+	}
+}
+
+// Run is the entrypoint: it sets up the listener, HTTP server, and handles graceful restart/shutdown signals.
+// It returns once a shutdown, or the handoff to a child, has drained this process's connections.
+// The slow-request flags default to SLOW_EVERY_N, SLOW_SECS and HEARTBEAT_SECS from the environment.
+func Run(args []string) error {
+	fs := flag.NewFlagSet("handoff", flag.ExitOnError)
+	addr := fs.String("addr", ":8080", "address to listen on; a child inherits its parent's listener instead")
+	opts := demoload.DefaultOptions()
+	opts.SlowEvery = getenvInt("SLOW_EVERY_N", opts.SlowEvery)
+	opts.Slow = getenvDur("SLOW_SECS", opts.Slow)
+	opts.Heartbeat = getenvDur("HEARTBEAT_SECS", opts.Heartbeat)
+	opts.RegisterFlags(fs)
+	fs.Parse(args)
+
+	log.SetFlags(log.LstdFlags | log.Lmicroseconds)
+	opts.PID = os.Getpid()
+	opts.Generation = generation
+	opts.Logf = logger.Logf
+
+	var newListner net.Listener
+	var err error
+
+	// Determine if we are starting a new process or inheriting a listener FD via graceful restart.
+	readyPipeFD := 0
+	if os.Getenv("GRACEFUL_RESTART") == "1" {
+		// After starting the server, the child notifies its parent via the inherited pipe FD.
+		if fd, err := strconv.Atoi(os.Getenv("READY_PIPE_FD")); err == nil {
+			readyPipeFD = fd
+		}
+
+		// Child path: reconstruct the listener from an inherited FD (default 3).
+		// The default number is 3 because that will be the first open file after ,fd0(stdin),fd1(stdout),fd2(stderr)
+		fdNum := 3
+		if v := strings.TrimSpace(os.Getenv("GRACEFUL_FD")); v != "" {
+			if n, conv := strconv.Atoi(v); conv == nil {
+				fdNum = n
+			}
+		}
+		parentFDCopy := os.NewFile(uintptr(fdNum), "graceful-listener")
+		if parentFDCopy == nil {
+			return fmt.Errorf("failed to open inherited FD=%d", fdNum)
+		}
+		newListner, err = net.FileListener(parentFDCopy)
+		if err != nil {
+			return fmt.Errorf("net.FileListener: %w", err)
+		}
+		// Note: No need to Close f here; net.FileListener consumes it.
+		logger.Logf("child reconstructed listener from FD=%d", fdNum)
+
+		// Optional: scrub GRACEFUL_* env so this process, when upgraded later, starts with a clean slate.
+		_ = os.Unsetenv("GRACEFUL_RESTART")
+		_ = os.Unsetenv("GRACEFUL_FD")
+		_ = os.Unsetenv("READY_PIPE_FD")
+	} else {
+		// Parent path: bind a fresh TCP listener on -addr
+		tcpAddr, err := net.ResolveTCPAddr("tcp", *addr)
+		if err != nil {
+			return fmt.Errorf("-addr: %w", err)
+		}
+		primaryTCPlistner, err := net.ListenTCP("tcp", tcpAddr)
+		if err != nil {
+			return fmt.Errorf("listen %s: %w", *addr, err)
+		}
+		newListner = primaryTCPlistner
+		logger.Logf("parent listening on %s", newListner.Addr())
+	}
+
+	// Demonstrate syscall.RawConn to introspect the underlying FD (educational)
+	if tl, ok := newListner.(*net.TCPListener); ok {
+		if rc, err := tl.SyscallConn(); err == nil {
+			rc.Control(func(fd uintptr) {
+				logger.Logf("listener raw fd=%d (via SyscallConn)", fd)
+			})
+		}
+	}
+
+	// HTTP server setup: slow/heartbeat behaviour comes from the flags.
+	load := demoload.NewHandler(opts)
+	srv := &http.Server{
+		Handler:   load,
+		ConnState: connTrack.onState, // track active connections for draining.
+	}
+
+	// Signal handling: SIGHUP (upgrade), SIGTERM/SIGINT (shutdown)
+	sigCh := make(chan os.Signal, 2)
+	signal.Notify(sigCh, syscall.SIGHUP, syscall.SIGTERM, syscall.SIGINT)
+
+	// Serve in a goroutine so we can coordinate signals.
+	serveErr := make(chan error, 1)
+	go func() {
+		// http.Serve will return when ln is closed (e.g., during upgrade/shutdown)
+		serveErr <- srv.Serve(newListner)
+	}()
+
+	logger.Logf("serving on %s (child=%v)", newListner.Addr(), readyPipeFD != 0)
+
+	// If this is a child from a graceful restart, notify parent we're ready.
+	if readyPipeFD != 0 {
+		pipe := os.NewFile(uintptr(readyPipeFD), "ready-pipe")
+		n, err := pipe.Write([]byte("ready\n"))
+		if err != nil {
+			logger.Logf("failed to write ready signal: %v", err)
+		} else {
+			logger.Logf("wrote %d bytes to ready pipe", n)
+		}
+		_ = pipe.Close()
+	}
+
+	for {
+		select {
+		case sig := <-sigCh:
+			switch sig {
+			case syscall.SIGHUP:
+				logger.Phasef("Restart sequence started")
+				logger.Logf("received SIGHUP: attempting graceful restart")
+				attemptGracefulRestart(newListner)
+				logger.Phasef("Graceful sequence finished")
+			case syscall.SIGTERM, syscall.SIGINT:
+				logger.Logf("received %v: graceful shutdown", sig)
+				shutdown(srv, load)
+				return nil
+			}
+		case err := <-serveErr:
+			// Serve returned. If this happens while we still have active connections, wait for drain.
+			if !errors.Is(err, http.ErrServerClosed) && err != nil {
+				// Only log non-expected errors; "use of closed network connection" is normal.
+				if !strings.Contains(err.Error(), "use of closed network connection") {
+					logger.Logf("http.Serve error: %v", err)
+				}
+			}
+			waitForDrain(load)
+			return nil
+		}
+	}
+}
+
+// attemptGracefulRestart execs a new copy of ourselves with FD inheritance + readiness pipe.
+func attemptGracefulRestart(currentLn net.Listener) {
+	// To pass the listener, we need a dup'd *os.File from it.
+	tcpLn, ok := currentLn.(*net.TCPListener)
+	if !ok {
+		logger.Logf("listener is not *net.TCPListener; cannot gracefully restart")
+		return
+	}
+	lf, err := tcpLn.File() // dup of the underlying FD; safe to pass across exec
+	if err != nil {
+		logger.Logf("TCPListener.File: %v", err)
+		return
+	}
+	// Pipe for readiness handshake: parent holds read end; child gets write end as extra FD.
+	r, w, err := os.Pipe()
+	if err != nil {
+		logger.Logf("os.Pipe: %v", err)
+		_ = lf.Close()
+		return
+	}
+
+	// Exec the same binary (argv[0]) or override with NEW_BINARY_PATH if provided,
+	// with our own arguments, which carry the subcommand when run as goexp handoff.
+	bin := os.Getenv("NEW_BINARY_PATH")
+	if strings.TrimSpace(bin) == "" {
+		bin = os.Args[0]
+	}
+	cmd := exec.Command(bin, os.Args[1:]...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.Env = append(os.Environ(),
+		"GRACEFUL_RESTART=1",
+		"GRACEFUL_FD=3",   // first ExtraFile goes to fd=3
+		"READY_PIPE_FD=4", // second ExtraFile goes to fd=4
+		fmt.Sprintf("%s=%d", generationEnv, generation+1),
+	)
+	cmd.ExtraFiles = []*os.File{lf, w}
+
+	if err := cmd.Start(); err != nil {
+		logger.Logf("failed to start child: %v (keeping old process)", err)
+		_ = lf.Close()
+		_ = r.Close()
+		_ = w.Close()
+		return
+	}
+	// Parent no longer needs child's copy of write end; child inherited it.
+	_ = w.Close()
+	_ = lf.Close()
+
+	logger.Logf("started child pid=%d; waiting for readiness signal", cmd.Process.Pid)
+
+	// Wait for readiness with a timeout, but keep serving if child fails.
+	readyCh := make(chan struct{})
+	go func() {
+		defer close(readyCh)
+		reader := bufio.NewReader(r)
+		line, _ := reader.ReadString('\n')
+		if strings.TrimSpace(line) != "" {
+			logger.Logf("child pid=%d signaled ready: %q", cmd.Process.Pid, strings.TrimSpace(line))
+		}
+	}()
+
+	select {
+	case <-readyCh:
+		logger.Logf("child is ready; closing listener in parent and beginning drain")
+		_ = currentLn.Close()
+		_ = r.Close()
+	case <-time.After(10 * time.Second):
+		logger.Logf("child did not signal ready in time; keeping old process active")
+		_ = r.Close()
+	}
+
+}
+
+// shutdown stops accepting, gracefully shuts down server, then waits for drain.
+func shutdown(srv *http.Server, load *demoload.Handler) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		logger.Logf("Server.Shutdown error: %v", err)
+	}
+	waitForDrain(load)
+}
+
+// waitForDrain waits for the requests in flight to finish, or gives up after a minute.
+func waitForDrain(load *demoload.Handler) {
+	deadline := time.Now().Add(60 * time.Second)
+	for {
+		n := load.InFlight()
+		if n == 0 {
+			logger.Logf("all requests drained (%d served); exiting", load.Requests())
+			return
+		}
+		if time.Now().After(deadline) {
+			logger.Logf("drain timeout; force exiting with %d requests in flight", n)
+			return
+		}
+		logger.Logf("draining... in-flight=%d active-conns=%d", n, atomic.LoadInt64(&activeConns))
+		time.Sleep(1 * time.Second)
+	}
+}
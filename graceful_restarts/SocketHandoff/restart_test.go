@@ -0,0 +1,50 @@
+//go:build restarttest && (linux || darwin)
+
+package main
+
+import (
+	"syscall"
+	"testing"
+	"time"
+
+	"goexp/internal/restarttest"
+)
+
+// TestRestart restarts the server under a steady stream of requests, some
+// of them slow, and checks that none of them noticed.
+func TestRestart(t *testing.T) {
+	bin := restarttest.Build(t, ".")
+	addr := restarttest.FreeAddr(t)
+	p := restarttest.Start(t, bin, []string{"LOG_FORMAT=plain"},
+		"-addr", addr, "-slow-every", "3", "-slow", "2s", "-heartbeat", "500ms")
+	if err := p.WaitOutput("serving on", 10*time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	loop := restarttest.StartLoop(restarttest.HTTPGet("http://"+addr+"/"), 20*time.Millisecond)
+	// Long enough for slow requests to be in flight at the restart.
+	time.Sleep(time.Second)
+	hup := time.Now()
+	p.Signal(syscall.SIGHUP)
+	// The parent exits once its slow requests are done, and the child
+	// carries on alone for a while.
+	exitErr := p.WaitExit(15 * time.Second)
+	time.Sleep(500 * time.Millisecond)
+	results := loop.Stop()
+
+	if exitErr != nil {
+		t.Errorf("parent pid %d: %v", p.PID, exitErr)
+	}
+	if err := restarttest.NoFailures(results); err != nil {
+		t.Error(err)
+	}
+	child, err := restarttest.Switched(results, p.PID, hup, 2*time.Second)
+	if err != nil {
+		t.Error(err)
+	} else {
+		t.Logf("pid %d handed over to pid %d", p.PID, child)
+	}
+	if err := restarttest.InFlightCompleted(results, p.PID, hup); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,11 @@
+module goexp/graceful_restarts/sysdsockack
+
+go 1.21
+
+require (
+	github.com/coreos/go-systemd v0.0.0-20191104093116-d3cd4ed1dbcf
+	goexp/internal v0.0.0
+)
+
+// The colored PID-prefixed logger is shared with the other restart demos.
+replace goexp/internal => ../../internal
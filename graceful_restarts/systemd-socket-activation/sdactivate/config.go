@@ -0,0 +1,157 @@
+package sdactivate
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"goexp/internal/demoload"
+)
+
+// Config holds the tuning knobs that can be changed at runtime with SIGHUP.
+type Config struct {
+	SlowDelay   time.Duration // total simulated work for a slow command
+	SlowEveryN  int           // every Nth command in a session is slow; 0 disables
+	Heartbeat   time.Duration // period of heartbeat logs during slow work
+	IdleTimeout time.Duration // close sessions idle this long; 0 disables
+}
+
+var (
+	configPath = flags.String("config", "", "optional key=value config file, re-read on SIGHUP")
+
+	// flagConfig holds the command-line values; the config file is layered on top.
+	flagConfig = Config{}
+
+	current atomic.Pointer[Config]
+)
+
+func init() {
+	flags.DurationVar(&flagConfig.SlowDelay, "slow-delay", 10*time.Second, "simulated work for slow commands")
+	flags.IntVar(&flagConfig.SlowEveryN, "slow-every", 3, "make every Nth command in a session slow (0 disables)")
+	flags.DurationVar(&flagConfig.Heartbeat, "heartbeat", time.Second, "heartbeat period during slow work")
+	flags.DurationVar(&flagConfig.IdleTimeout, "idle-timeout", 0, "close sessions idle for this long (0 disables)")
+}
+
+// setConfig makes c the active config, for servers created from now on, and
+// returns the previous one.
+func setConfig(c *Config) *Config {
+	return current.Swap(c)
+}
+
+// options are the session workload's options under c.
+func (c *Config) options() demoload.Options {
+	return demoload.Options{
+		SlowEvery:    c.SlowEveryN,
+		Slow:         c.SlowDelay,
+		Heartbeat:    c.Heartbeat,
+		PID:          os.Getpid(),
+		IdleTimeout:  c.IdleTimeout,
+		WriteTimeout: *writeTimeout,
+		Logf:         logger.Logf,
+	}
+}
+
+// loadConfig builds a Config from the flag values and, if set, the config file.
+func loadConfig() (*Config, error) {
+	c := flagConfig
+	if *configPath != "" {
+		if err := c.readFile(*configPath); err != nil {
+			return nil, err
+		}
+	}
+	if err := c.validate(); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// readFile overrides fields of c with the key=value pairs found in path.
+func (c *Config) readFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	sc := bufio.NewScanner(f)
+	lineNo := 0
+	for sc.Scan() {
+		lineNo++
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, val, ok := strings.Cut(line, "=")
+		if !ok {
+			return fmt.Errorf("%s:%d: expected key=value", path, lineNo)
+		}
+		key, val = strings.TrimSpace(key), strings.TrimSpace(val)
+		switch key {
+		case "slow_delay":
+			c.SlowDelay, err = time.ParseDuration(val)
+		case "slow_every":
+			c.SlowEveryN, err = strconv.Atoi(val)
+		case "heartbeat":
+			c.Heartbeat, err = time.ParseDuration(val)
+		case "idle_timeout":
+			c.IdleTimeout, err = time.ParseDuration(val)
+		default:
+			err = fmt.Errorf("unknown key %q", key)
+		}
+		if err != nil {
+			return fmt.Errorf("%s:%d: %v", path, lineNo, err)
+		}
+	}
+	return sc.Err()
+}
+
+func (c *Config) validate() error {
+	switch {
+	case c.SlowDelay < 0:
+		return fmt.Errorf("slow_delay must not be negative")
+	case c.SlowEveryN < 0:
+		return fmt.Errorf("slow_every must not be negative")
+	case c.Heartbeat <= 0:
+		return fmt.Errorf("heartbeat must be positive")
+	case c.IdleTimeout < 0:
+		return fmt.Errorf("idle_timeout must not be negative")
+	}
+	return nil
+}
+
+// diff lists the fields that differ between old and c as "name: old -> new".
+func (c *Config) diff(old *Config) []string {
+	var out []string
+	add := func(name string, a, b interface{}) {
+		if a != b {
+			out = append(out, fmt.Sprintf("%s: %v -> %v", name, a, b))
+		}
+	}
+	add("slow_delay", old.SlowDelay, c.SlowDelay)
+	add("slow_every", old.SlowEveryN, c.SlowEveryN)
+	add("heartbeat", old.Heartbeat, c.Heartbeat)
+	add("idle_timeout", old.IdleTimeout, c.IdleTimeout)
+	return out
+}
+
+// reloadConfig re-reads the config, swaps it in and returns it. A config that
+// fails to parse is rejected: the previous one stays active and it returns nil.
+func reloadConfig() *Config {
+	next, err := loadConfig()
+	if err != nil {
+		logger.Logf("config reload rejected, keeping previous config: %v", err)
+		return nil
+	}
+	changes := next.diff(setConfig(next))
+	if len(changes) == 0 {
+		logger.Logf("config reloaded, no changes")
+	}
+	for _, ch := range changes {
+		logger.Logf("config reloaded: %s", ch)
+	}
+	return next
+}
@@ -0,0 +1,169 @@
+// Package sdactivate serves a line protocol on the sockets systemd passes it
+// (socket activation), or on :8080 when started by hand.
+package sdactivate
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/coreos/go-systemd/activation"
+
+	"goexp/internal/clog"
+	"goexp/internal/demoload"
+)
+
+var (
+	// logger prefixes every line, banners too, with this process's PID, in
+	// its own color.
+	logger = clog.New("sysdsockack").WithLayout(clog.Layout{PhasePID: true})
+
+	// flags are the command line of Run.
+	flags = flag.NewFlagSet("sdactivate", flag.ExitOnError)
+
+	writeTimeout = flags.Duration("write-timeout", 10*time.Second, "deadline for each write to a session")
+)
+
+// Run serves until SIGTERM or SIGINT closes the listeners, leaving any
+// sessions still open to be cut off when the process exits.
+func Run(args []string) error {
+	flags.Parse(args)
+	log.SetFlags(log.LstdFlags | log.Lmicroseconds)
+
+	logger.Phasef("Starting process")
+
+	c, err := loadConfig()
+	if err != nil {
+		return fmt.Errorf("config: %w", err)
+	}
+	setConfig(c)
+
+	// SIGHUP reloads the tuning knobs without touching open sessions
+	// (systemctl reload sends it via ExecReload=). Catch it from here on;
+	// the reloads start once there is a server to apply them to.
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+
+	listeners, err := activation.Listeners()
+	if err != nil {
+		return fmt.Errorf("activation.Listeners: %w", err)
+	}
+	if len(listeners) == 0 {
+		logger.Logf("No systemd sockets found, falling back to manual listener on :8080")
+		appL, err := net.Listen("tcp", ":8080")
+		if err != nil {
+			return fmt.Errorf("listen :8080: %w", err)
+		}
+		listeners = []net.Listener{appL}
+	}
+
+	// SIGTERM/SIGINT cancel the context, which closes the listeners and ends Run.
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT)
+	defer stop()
+
+	srv := NewServer(listeners)
+	go func() {
+		for range hup {
+			logger.Phasef("SIGHUP received, reloading config")
+			if c := reloadConfig(); c != nil {
+				srv.setConfig(c)
+			}
+		}
+	}()
+	if err := srv.Run(ctx); err != nil {
+		logger.Logf("Run error: %v", err)
+	}
+	logger.Phasef("Listeners stopped, %d sessions still active", srv.Active())
+	return nil
+}
+
+// Server owns the activated listeners and accounts for every session they produce.
+type Server struct {
+	listeners []net.Listener
+
+	load     *demoload.SessionHandler // serves the sessions, and counts those in flight
+	sessions sync.WaitGroup           // tracks the same sessions, for waiting on them
+}
+
+// NewServer returns a Server for the given listeners, serving sessions under
+// the active config; nil listeners are skipped by Run.
+func NewServer(listeners []net.Listener) *Server {
+	var opts demoload.Options
+	if c := current.Load(); c != nil {
+		opts = c.options()
+	}
+	return &Server{listeners: listeners, load: demoload.NewSessionHandler(opts)}
+}
+
+// setConfig serves new commands under c. Sessions keep the options a
+// command started with, so a reload never changes a job already running.
+func (s *Server) setConfig(c *Config) { s.load.SetOptions(c.options()) }
+
+// Active returns the number of sessions currently being handled.
+func (s *Server) Active() int64 { return s.load.InFlight() }
+
+// Wait blocks until every session accepted so far has finished.
+func (s *Server) Wait() { s.sessions.Wait() }
+
+// Run accepts on every listener until ctx is cancelled. Cancelling ctx closes
+// the listeners; Run returns once all accept loops have stopped. Sessions that
+// are still open are left running — use Active/Wait to account for them.
+func (s *Server) Run(ctx context.Context) error {
+	var loops sync.WaitGroup
+	for i, l := range s.listeners {
+		if l == nil {
+			logger.Logf("Listener %d is nil, skipping", i)
+			continue
+		}
+		logger.Logf("Listener %d: %s", i, l.Addr())
+		loops.Add(1)
+		go func(idx int, l net.Listener) {
+			defer loops.Done()
+			s.serve(idx, l)
+		}(i, l)
+	}
+
+	stopped := make(chan struct{})
+	go func() {
+		loops.Wait()
+		close(stopped)
+	}()
+
+	select {
+	case <-ctx.Done():
+		logger.Phasef("Context cancelled, closing listeners")
+		for _, l := range s.listeners {
+			if l != nil {
+				l.Close()
+			}
+		}
+		<-stopped
+		return nil
+	case <-stopped:
+		return fmt.Errorf("all listeners stopped")
+	}
+}
+
+func (s *Server) serve(idx int, l net.Listener) {
+	logger.Phasef("Server %d listening on %s", idx, l.Addr())
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			logger.Logf("Accept error on %s: %v", l.Addr(), err)
+			return
+		}
+		s.sessions.Add(1)
+		logger.Logf("Accepted %s on %s (active=%d)", conn.RemoteAddr(), l.Addr(), s.Active())
+		go func() {
+			defer s.sessions.Done()
+			s.load.ServeConn(conn)
+		}()
+	}
+}
@@ -0,0 +1,196 @@
+package sdactivate
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// pipeListener hands out the server ends of net.Pipe pairs made by dial.
+type pipeListener struct {
+	conns  chan net.Conn
+	closed chan struct{}
+}
+
+func newPipeListener() *pipeListener {
+	return &pipeListener{conns: make(chan net.Conn), closed: make(chan struct{})}
+}
+
+func (l *pipeListener) Accept() (net.Conn, error) {
+	select {
+	case c := <-l.conns:
+		return c, nil
+	case <-l.closed:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *pipeListener) Close() error {
+	select {
+	case <-l.closed:
+	default:
+		close(l.closed)
+	}
+	return nil
+}
+
+func (l *pipeListener) Addr() net.Addr { return pipeAddr{} }
+
+// dial returns the client end of a new connection.
+func (l *pipeListener) dial(t *testing.T) net.Conn {
+	t.Helper()
+	client, server := net.Pipe()
+	select {
+	case l.conns <- server:
+	case <-time.After(time.Second):
+		t.Fatal("listener not accepting")
+	}
+	return client
+}
+
+type pipeAddr struct{}
+
+func (pipeAddr) Network() string { return "pipe" }
+func (pipeAddr) String() string  { return "pipe" }
+
+// useConfig makes c the active config for the test.
+func useConfig(t *testing.T, c Config) {
+	t.Helper()
+	prev := setConfig(&c)
+	t.Cleanup(func() {
+		if prev != nil {
+			setConfig(prev)
+		}
+	})
+}
+
+// waitActive polls until s has want active sessions.
+func waitActive(t *testing.T, s *Server, want int64) {
+	t.Helper()
+	for deadline := time.Now().Add(2 * time.Second); s.Active() != want; time.Sleep(5 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("Active() = %d, want %d", s.Active(), want)
+		}
+	}
+}
+
+func TestServerSessionAccounting(t *testing.T) {
+	useConfig(t, Config{Heartbeat: time.Second})
+	ln := newPipeListener()
+	srv := NewServer([]net.Listener{ln})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	runDone := make(chan error, 1)
+	go func() { runDone <- srv.Run(ctx) }()
+
+	client := ln.dial(t)
+	waitActive(t, srv, 1)
+
+	if _, err := client.Write([]byte("hello\n")); err != nil {
+		t.Fatal(err)
+	}
+	reply, err := bufio.NewReader(client).ReadString('\n')
+	if err != nil || !strings.HasPrefix(reply, "fast reply pid=") || !strings.HasSuffix(reply, ": hello\n") {
+		t.Fatalf("reply = %q, %v", reply, err)
+	}
+
+	second := ln.dial(t)
+	waitActive(t, srv, 2)
+	client.Close()
+	waitActive(t, srv, 1)
+	second.Close()
+	waitActive(t, srv, 0)
+	srv.Wait()
+
+	cancel()
+	select {
+	case err := <-runDone:
+		if err != nil {
+			t.Fatalf("Run after cancel = %v, want nil", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Run did not return after the context was cancelled")
+	}
+}
+
+// lockedBuffer collects log output written from several goroutines.
+type lockedBuffer struct {
+	mu sync.Mutex
+	b  bytes.Buffer
+}
+
+func (l *lockedBuffer) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.b.Write(p)
+}
+
+func (l *lockedBuffer) String() string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.b.String()
+}
+
+// smallBuffers shrinks a socket's buffers before it connects or listens;
+// accepted sockets inherit the listener's.
+func smallBuffers(network, address string, c syscall.RawConn) error {
+	var err error
+	c.Control(func(fd uintptr) {
+		if err = syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_SNDBUF, 4096); err == nil {
+			err = syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_RCVBUF, 4096)
+		}
+	})
+	return err
+}
+
+func TestWriteDeadlineOnStalledReader(t *testing.T) {
+	defer func(d time.Duration) { *writeTimeout = d }(*writeTimeout)
+	*writeTimeout = 200 * time.Millisecond
+	useConfig(t, Config{Heartbeat: time.Second})
+	var logs lockedBuffer
+	log.SetOutput(&logs)
+	defer log.SetOutput(os.Stderr)
+
+	lc := net.ListenConfig{Control: smallBuffers}
+	ln, err := lc.Listen(context.Background(), "tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := NewServer([]net.Listener{ln})
+	ctx, cancel := context.WithCancel(context.Background())
+	runDone := make(chan error, 1)
+	go func() { runDone <- srv.Run(ctx) }()
+	defer func() {
+		cancel()
+		<-runDone
+	}()
+
+	// A client that sends commands and never reads a reply.
+	dialer := net.Dialer{Control: smallBuffers}
+	client, err := dialer.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	go client.Write(bytes.Repeat([]byte("x\n"), 20000))
+
+	// Replies back up until a write misses its deadline and the session ends,
+	// although the client never closed its side.
+	waitActive(t, srv, 1)
+	for deadline := time.Now().Add(5 * time.Second); srv.Active() != 0; time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("session still open; write deadline never fired")
+		}
+	}
+	if out := logs.String(); !strings.Contains(out, "write error, marking session broken") || !strings.Contains(out, "i/o timeout") {
+		t.Fatalf("no write-timeout log line in:\n%s", out)
+	}
+}
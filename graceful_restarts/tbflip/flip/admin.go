@@ -0,0 +1,66 @@
+package flip
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	startTime = time.Now()
+
+	// exiting flips once upg.Exit() fires; /healthz reports 503 from then on.
+	exiting atomic.Bool
+)
+
+// newAdminMux builds the out-of-band admin handlers. upgrade is invoked by
+// POST /upgrade exactly as the signal handler would; state is re-read on each
+// /stats so counts appended by parents that finished draining show up, and
+// requests is this process's count.
+func newAdminMux(upgrade func() error, state *os.File, requests func() uint64) *http.ServeMux {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		if exiting.Load() {
+			http.Error(w, "draining", http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprintln(w, "ok")
+	})
+
+	mux.HandleFunc("/stats", func(w http.ResponseWriter, r *http.Request) {
+		served := requests()
+		stats := map[string]interface{}{
+			"pid":        os.Getpid(),
+			"generation": generation,
+			"requests":   served,
+			"uptime":     time.Since(startTime).Truncate(time.Second).String(),
+		}
+		if total, gens, err := readStateTotal(state); err == nil {
+			stats["retired_generations"] = gens
+			stats["cumulative_requests"] = total + served
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(stats)
+	})
+
+	mux.HandleFunc("/upgrade", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "use POST", http.StatusMethodNotAllowed)
+			return
+		}
+		logger.Phasef("pid=%d admin POST /upgrade → Upgrade()", os.Getpid())
+		if err := upgrade(); err != nil {
+			logger.Logf("Upgrade error: %v", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		fmt.Fprintln(w, "upgrade complete")
+	})
+
+	return mux
+}
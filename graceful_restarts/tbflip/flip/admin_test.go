@@ -0,0 +1,46 @@
+package flip
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestStatsSeesLateParentCount(t *testing.T) {
+	state, err := os.OpenFile(filepath.Join(t.TempDir(), "tbflip.state"), os.O_RDWR|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer state.Close()
+	if err := appendState(state, 100, 7); err != nil {
+		t.Fatal(err)
+	}
+	mux := newAdminMux(func() error { return nil }, state, func() uint64 { return 3 })
+
+	stats := func() (cumulative float64, gens float64) {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/stats", nil))
+		var got map[string]interface{}
+		if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+			t.Fatal(err)
+		}
+		cumulative, _ = got["cumulative_requests"].(float64)
+		gens, _ = got["retired_generations"].(float64)
+		return cumulative, gens
+	}
+
+	if c, g := stats(); c != 10 || g != 1 {
+		t.Errorf("before the parent exits: cumulative=%v generations=%v, want 10 and 1", c, g)
+	}
+	// The draining parent writes its count after the child started.
+	if err := appendState(state, 101, 5); err != nil {
+		t.Fatal(err)
+	}
+	if c, g := stats(); c != 15 || g != 2 {
+		t.Errorf("after the parent exits: cumulative=%v generations=%v, want 15 and 2", c, g)
+	}
+}
@@ -0,0 +1,42 @@
+package flip
+
+import (
+	"net"
+	"net/http"
+	"sort"
+	"sync"
+)
+
+// connTracker follows http.Server.ConnState so the drain path knows exactly
+// which connections are still open when the deadline hits.
+type connTracker struct {
+	mu    sync.Mutex
+	conns map[net.Conn]http.ConnState
+}
+
+// newConnTracker constructs a new connection tracker.
+func newConnTracker() *connTracker { return &connTracker{conns: make(map[net.Conn]http.ConnState)} }
+
+// onState is installed as http.Server.ConnState.
+func (t *connTracker) onState(c net.Conn, st http.ConnState) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	switch st {
+	case http.StateHijacked, http.StateClosed:
+		delete(t.conns, c)
+	default:
+		t.conns[c] = st
+	}
+}
+
+// alive returns "remote (state)" for every connection not yet closed, sorted.
+func (t *connTracker) alive() []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	out := make([]string, 0, len(t.conns))
+	for c, st := range t.conns {
+		out = append(out, c.RemoteAddr().String()+" ("+st.String()+")")
+	}
+	sort.Strings(out)
+	return out
+}
@@ -0,0 +1,293 @@
+// Package flip serves HTTP and upgrades to a new generation of its binary
+// without dropping a connection, with cloudflare/tableflip or with an
+// ExtraFiles handoff of its own (-mode).
+package flip
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/cloudflare/tableflip"
+
+	"goexp/internal/clog"
+	"goexp/internal/demoload"
+)
+
+// generationEnv carries the generation number to the child; tableflip execs
+// the new binary with the current environment.
+const generationEnv = "TBFLIP_GENERATION"
+
+// generation is 0 on a cold start and parent+1 after each successful upgrade.
+var generation = getenvInt(generationEnv, 0)
+
+// logger prefixes every line with this process's PID, in its own color, and
+// puts the generation in the banners; the banner messages carry the PID
+// themselves, as they always have.
+var logger = clog.New("tbflip").WithGeneration(generation).WithLayout(clog.Layout{PhaseGen: true})
+
+// reqLogger is logger for the lines about a single request, which carry the
+// generation too.
+var reqLogger = logger.WithLayout(clog.Layout{PhaseGen: true, LogGen: true})
+
+// exitChildStartFailed is used when an upgraded child cannot Listen or Ready,
+// so it is obvious in the logs that the parent simply keeps serving.
+const exitChildStartFailed = 3
+
+// exitError is an error Run returns with an exit status other than 1,
+// which the commands exit with.
+type exitError struct {
+	code int
+	err  error
+}
+
+func (e exitError) Error() string { return e.err.Error() }
+func (e exitError) Unwrap() error { return e.err }
+func (e exitError) ExitCode() int { return e.code }
+
+// flags are the command line of Run; the upgraded child gets the same one.
+var flags = flag.NewFlagSet("tableflip", flag.ExitOnError)
+
+var (
+	addr      = flags.String("addr", ":8080", "address of the HTTP listener")
+	mode      = flags.String("mode", "tableflip", "upgrade mechanism: tableflip or handoff (ExtraFiles + ready pipe)")
+	pidFile   = flags.String("pidfile", "tbflip.pid", "pid file tableflip keeps pointing at the accepting process (empty disables)")
+	adminAddr = flags.String("admin-addr", ":9090", "address of the admin listener (/healthz, /stats, POST /upgrade)")
+
+	upgradeTimeout = flags.Duration("upgrade-timeout", time.Minute, "how long a new generation may take to call Ready()")
+	upgradeRetries = flags.Int("upgrade-retries", 3, "retries after a failed upgrade attempt")
+	upgradeBackoff = flags.Duration("upgrade-backoff", time.Second, "initial backoff between upgrade retries, doubled per attempt")
+	stateFile      = flags.String("state-file", "tbflip.state", "request-count state file handed from generation to generation")
+	drainTimeout   = flags.Duration("drain-timeout", 60*time.Second, "how long the old generation drains before force-closing connections")
+	upgradeSignals = flags.String("upgrade-signals", "hup", "comma separated signals that trigger an upgrade (hup, usr1, usr2)")
+
+	// loadOpts is the demo workload, tuned by -slow-every, -slow and -heartbeat.
+	loadOpts = demoload.DefaultOptions()
+)
+
+func init() {
+	loadOpts.RegisterFlags(flags)
+}
+
+// Run serves until this generation has been replaced by the next, or told to
+// stop, and has drained. A failed start of an upgraded child returns an error
+// whose ExitCode is 3, and a drain that had to force connections closed one
+// whose ExitCode is 1.
+func Run(args []string) error {
+	flags.Parse(args)
+
+	log.SetFlags(log.LstdFlags | log.Lmicroseconds)
+
+	pid := os.Getpid()
+	logger.Phasef("Starting process pid=%d", pid)
+
+	if *pidFile != "" {
+		stale, err := removeStalePIDFile(*pidFile)
+		if err != nil {
+			return fmt.Errorf("removing stale pidfile %s: %w", *pidFile, err)
+		}
+		if stale {
+			logger.Logf("removed stale pidfile %s", *pidFile)
+		}
+	}
+
+	upg, err := newUpgrader(*mode)
+	if err != nil {
+		return fmt.Errorf("%s upgrader: %w", *mode, err)
+	}
+	defer upg.Stop()
+	logger.Phasef("pid=%d upgrade mode=%s", pid, *mode)
+
+	// All upgrade triggers funnel into one loop that owns retries and backoff.
+	upgradeReqs := make(chan upgradeRequest)
+	go runUpgrades(upgradeReqs, func() error { return upgradeNextGeneration(upg.Upgrade) }, *upgradeRetries, *upgradeBackoff)
+	requestUpgrade := func(reason string) error {
+		done := make(chan error, 1)
+		upgradeReqs <- upgradeRequest{reason: reason, done: done}
+		return <-done
+	}
+
+	// Upgrade signal loop (README-style): each configured signal requests an upgrade.
+	sigs, err := parseSignals(*upgradeSignals)
+	if err != nil {
+		return fmt.Errorf("-upgrade-signals: %w", err)
+	}
+	actions := signalActions{}
+	for _, s := range sigs {
+		actions[s] = func(sig os.Signal) {
+			logger.Phasef("pid=%d received %v → Upgrade()", pid, sig)
+			upgradeReqs <- upgradeRequest{reason: sig.String()}
+		}
+	}
+	go actions.run()
+
+	// Listen must be called before Ready (README contract)
+	ln, err := upg.Listen("tcp", *addr)
+	if err != nil {
+		return startupFailed(upg, fmt.Errorf("upg.Listen: %w", err))
+	}
+	defer ln.Close()
+	logger.Phasef("HTTP server pid=%d listening on %s", pid, *addr)
+
+	// Only now (listener in hand) say who we are, so interleaved logs read in order.
+	if upg.HasParent() {
+		logger.Phasef("pid=%d upgraded from parent pid=%d", pid, os.Getppid())
+	} else {
+		logger.Phasef("pid=%d cold start", pid)
+	}
+
+	// The admin listener is inherited across upgrades just like the main one,
+	// so it too has to exist before Ready().
+	adminLn, err := upg.Listen("tcp", *adminAddr)
+	if err != nil {
+		return startupFailed(upg, fmt.Errorf("upg.Listen admin: %w", err))
+	}
+	defer adminLn.Close()
+	logger.Phasef("admin server pid=%d listening on %s", pid, *adminAddr)
+
+	// The state file travels through upg.Fds like the listeners do.
+	state, inherited, err := openStateFile(upg, *stateFile)
+	if err != nil {
+		return fmt.Errorf("state file: %w", err)
+	}
+	defer state.Close()
+	// Our parent is still draining and only appends its count when it exits,
+	// so this is the total up to our grandparent; /stats re-reads the file.
+	if total, gens, err := readStateTotal(state); err != nil {
+		logger.Logf("reading state file: %v", err)
+	} else if inherited {
+		logger.Phasef("pid=%d inherited state file: %d requests on record from %d retired generations, parent pid=%d not yet counted", pid, total, gens, os.Getppid())
+	} else {
+		logger.Phasef("pid=%d cold start, opened state file %s (%d requests on record from %d earlier runs)", pid, *stateFile, total, gens)
+	}
+
+	// The shared demo workload: slow every Nth request + heartbeats
+	loadOpts.PID = pid
+	loadOpts.Generation = generation
+	loadOpts.Logf = reqLogger.Logf
+	load := demoload.NewHandler(loadOpts)
+	mux := http.NewServeMux()
+	mux.Handle("/", load)
+
+	// Use a real http.Server so we can gracefully Shutdown on Exit
+	conns := newConnTracker()
+	srv := &http.Server{Handler: trackInFlight(mux, load), ConnState: conns.onState}
+	go func() {
+		logger.Logf("starting http.Serve loop")
+		if err := srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.Logf("http.Serve error: %v", err)
+		}
+	}()
+
+	adminSrv := &http.Server{Handler: newAdminMux(func() error { return requestUpgrade("admin POST /upgrade") }, state, load.Requests)}
+	go func() {
+		if err := adminSrv.Serve(adminLn); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.Logf("admin http.Serve error: %v", err)
+		}
+	}()
+
+	// Warm up before Ready(): until then the parent keeps owning traffic.
+	warmupDur := getenvDur("WARMUP_SECS", 0)
+	logger.Phasef("pid=%d warming up (WARMUP_SECS=%s)", pid, warmupDur)
+	warmStart := time.Now()
+	wctx, wcancel := context.WithTimeout(context.Background(), warmupDur+30*time.Second)
+	err = demoWarmup(ln.Addr(), warmupDur)(wctx)
+	wcancel()
+	if err != nil {
+		upg.Stop()
+		return fmt.Errorf("warmup failed after %s: %w — not calling Ready(), parent keeps serving", time.Since(warmStart).Truncate(time.Millisecond), err)
+	}
+	logger.Phasef("pid=%d warmup done in %s", pid, time.Since(warmStart).Truncate(time.Millisecond))
+
+	// Child signals readiness; parent will stop accepting but keep serving existing requests
+	// tableflip rewrites the pidfile inside Ready(), so log it on both sides.
+	oldPID := readPIDFile(*pidFile)
+	if err := upg.Ready(); err != nil {
+		return startupFailed(upg, fmt.Errorf("Ready: %w", err))
+	}
+	logger.Phasef("pid=%d signaled Ready()", pid)
+	if *pidFile != "" {
+		logger.Logf("pidfile %s: %d -> %d", *pidFile, oldPID, readPIDFile(*pidFile))
+	}
+
+	// Wait until it's time for this process to wind down (child is up or SIGTERM)
+	<-upg.Exit()
+	exiting.Store(true)
+	logger.Phasef("pid=%d received Exit() — graceful shutdown", pid)
+
+	// Gracefully shutdown old server: finish in-flight, refuse new
+	drainStart := time.Now()
+	completedBefore := load.Completed()
+	ctx, cancel := context.WithTimeout(context.Background(), *drainTimeout)
+	defer cancel()
+	drained := make(chan struct{})
+	go logInFlightUntil(drained, load)
+	forced := false
+	if err := srv.Shutdown(ctx); err != nil {
+		logger.Logf("Server.Shutdown error: %v", err)
+		if errors.Is(err, context.DeadlineExceeded) {
+			// Whatever is still tracked now is about to be cut off.
+			left := conns.alive()
+			logger.Logf("drain deadline hit, force-closing %d connections", len(left))
+			for _, c := range left {
+				logger.Logf("  force-closed %s", c)
+			}
+			srv.Close()
+			forced = true
+		}
+	}
+	close(drained)
+
+	actx, acancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer acancel()
+	if err := adminSrv.Shutdown(actx); err != nil {
+		logger.Logf("admin Server.Shutdown error: %v", err)
+	}
+	served := load.Requests()
+	if err := appendState(state, pid, served); err != nil {
+		logger.Logf("writing state file: %v", err)
+	} else if total, gens, err := readStateTotal(state); err == nil {
+		logger.Phasef("pid=%d state file now records %d requests across %d generations", pid, total, gens)
+	}
+	logger.Phasef("pid=%d drain summary: %d requests completed during drain in %s, forced=%v", pid, load.Completed()-completedBefore, time.Since(drainStart).Truncate(time.Millisecond), forced)
+	logger.Phasef("pid=%d shutdown complete, served %d requests", pid, served)
+
+	// Supervisors can tell a clean drain (0) from a forced close (1).
+	if forced {
+		upg.Stop()
+		return errors.New("drain deadline hit, connections force-closed")
+	}
+	return nil
+}
+
+// newUpgrader returns the upgrade mechanism selected by -mode. Everything
+// else (handlers, logs, drain reporting) is shared between the two.
+func newUpgrader(mode string) (upgrader, error) {
+	switch mode {
+	case "tableflip":
+		return tableflip.New(tableflip.Options{
+			PIDFile:        *pidFile,
+			UpgradeTimeout: *upgradeTimeout,
+		})
+	case "handoff":
+		return newHandoffUpgrader(*pidFile, *upgradeTimeout)
+	default:
+		return nil, fmt.Errorf("unknown -mode %q (want tableflip or handoff)", mode)
+	}
+}
+
+// startupFailed aborts a failed start with err: exitChildStartFailed for an
+// upgraded child (the parent keeps serving), 1 for a cold start.
+func startupFailed(upg upgrader, err error) error {
+	if upg.HasParent() {
+		logger.Phasef("pid=%d child start failed, parent pid=%d keeps serving", os.Getpid(), os.Getppid())
+		upg.Stop()
+		return exitError{exitChildStartFailed, err}
+	}
+	return err
+}
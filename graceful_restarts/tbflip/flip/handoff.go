@@ -0,0 +1,297 @@
+package flip
+
+// The handoff upgrader reproduces graceful_restarts/SocketHandoff behind the
+// same interface tableflip offers, so -mode=handoff and -mode=tableflip run the
+// identical server and differ only in how the next generation gets its FDs:
+// here we exec ourselves with the listeners/files in cmd.ExtraFiles and wait
+// for the child to write "ready" on an inherited pipe.
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	handoffFdsEnv   = "GRACEFUL_FDS"  // "name=fd,name=fd" describing inherited ExtraFiles
+	handoffReadyEnv = "READY_PIPE_FD" // write end of the parent's readiness pipe
+)
+
+// upgrader is the subset of *tableflip.Upgrader the server relies on.
+type upgrader interface {
+	fileFds
+	Listen(network, addr string) (net.Listener, error)
+	Ready() error
+	Exit() <-chan struct{}
+	Upgrade() error
+	HasParent() bool
+	Stop()
+}
+
+type handoffUpgrader struct {
+	pidFile      string
+	readyTimeout time.Duration
+
+	mu        sync.Mutex
+	inherited map[string]*os.File         // from our parent, consumed by Listen/File
+	listeners map[string]*net.TCPListener // passed on at the next Upgrade
+	files     map[string]*os.File
+	readyFD   int // 0 on a cold start
+	ready     bool
+	upgraded  bool
+
+	exitOnce sync.Once
+	exitC    chan struct{}
+}
+
+// newHandoffUpgrader picks up any FDs described in the environment by our parent.
+func newHandoffUpgrader(pidFile string, readyTimeout time.Duration) (*handoffUpgrader, error) {
+	u := &handoffUpgrader{
+		pidFile:      pidFile,
+		readyTimeout: readyTimeout,
+		inherited:    make(map[string]*os.File),
+		listeners:    make(map[string]*net.TCPListener),
+		files:        make(map[string]*os.File),
+		readyFD:      getenvInt(handoffReadyEnv, 0),
+		exitC:        make(chan struct{}),
+	}
+	if spec := strings.TrimSpace(os.Getenv(handoffFdsEnv)); spec != "" {
+		for _, kv := range strings.Split(spec, ",") {
+			name, fdStr, ok := strings.Cut(kv, "=")
+			fd, err := strconv.Atoi(fdStr)
+			if !ok || err != nil {
+				return nil, fmt.Errorf("bad %s entry %q", handoffFdsEnv, kv)
+			}
+			u.inherited[name] = os.NewFile(uintptr(fd), name)
+		}
+	}
+	// Scrub so a later generation starts from what we explicitly pass on.
+	os.Unsetenv(handoffFdsEnv)
+	os.Unsetenv(handoffReadyEnv)
+	return u, nil
+}
+
+func (u *handoffUpgrader) HasParent() bool { return u.readyFD != 0 }
+
+func (u *handoffUpgrader) Exit() <-chan struct{} { return u.exitC }
+
+func (u *handoffUpgrader) Stop() { u.exitOnce.Do(func() { close(u.exitC) }) }
+
+// Listen reuses an inherited listener for network/addr or binds a fresh one.
+func (u *handoffUpgrader) Listen(network, addr string) (net.Listener, error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	key := "listener:" + network + ":" + addr
+
+	var ln net.Listener
+	if f := u.inherited[key]; f != nil {
+		delete(u.inherited, key)
+		l, err := net.FileListener(f)
+		f.Close() // FileListener dup'd it
+		if err != nil {
+			return nil, err
+		}
+		ln = l
+	} else {
+		l, err := net.Listen(network, addr)
+		if err != nil {
+			return nil, err
+		}
+		ln = l
+	}
+	tl, ok := ln.(*net.TCPListener)
+	if !ok {
+		ln.Close()
+		return nil, fmt.Errorf("handoff supports TCP listeners only, got %T", ln)
+	}
+	u.listeners[key] = tl
+	return ln, nil
+}
+
+// File returns the inherited file registered under name, or nil.
+func (u *handoffUpgrader) File(name string) (*os.File, error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	key := "file:" + name
+	f := u.inherited[key]
+	if f == nil {
+		return nil, nil
+	}
+	delete(u.inherited, key)
+	u.files[key] = f
+	return f, nil
+}
+
+// AddFile registers file to be passed to the next generation under name.
+func (u *handoffUpgrader) AddFile(name string, file *os.File) error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.files["file:"+name] = file
+	return nil
+}
+
+// Ready writes the pidfile and, in a child, tells the parent it can stop accepting.
+func (u *handoffUpgrader) Ready() error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.ready {
+		return errors.New("already ready")
+	}
+	for key, f := range u.inherited {
+		// Anything the parent passed that we did not ask for.
+		f.Close()
+		delete(u.inherited, key)
+	}
+	if u.pidFile != "" {
+		if err := writePIDFile(u.pidFile, os.Getpid()); err != nil {
+			return err
+		}
+	}
+	if u.readyFD != 0 {
+		pipe := os.NewFile(uintptr(u.readyFD), "ready-pipe")
+		_, err := pipe.Write([]byte("ready\n"))
+		pipe.Close()
+		if err != nil {
+			return fmt.Errorf("write ready pipe: %v", err)
+		}
+	}
+	u.ready = true
+	return nil
+}
+
+// Upgrade execs a new copy of ourselves with every registered FD and waits up
+// to readyTimeout for it to report ready; on success Exit() fires.
+func (u *handoffUpgrader) Upgrade() error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	switch {
+	case !u.ready:
+		return errors.New("can't upgrade before Ready")
+	case u.upgraded:
+		return errors.New("already upgraded")
+	}
+
+	var extra []*os.File
+	var spec []string
+	defer func() {
+		for _, f := range extra {
+			f.Close()
+		}
+	}()
+	for key, l := range u.listeners {
+		f, err := l.File() // dup of the listening socket
+		if err != nil {
+			return fmt.Errorf("%s: %v", key, err)
+		}
+		extra = append(extra, f)
+		spec = append(spec, fmt.Sprintf("%s=%d", key, 2+len(extra)))
+	}
+	for key, f := range u.files {
+		dup, err := dupFile(f)
+		if err != nil {
+			return fmt.Errorf("%s: %v", key, err)
+		}
+		extra = append(extra, dup)
+		spec = append(spec, fmt.Sprintf("%s=%d", key, 2+len(extra)))
+	}
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	extra = append(extra, w)
+	readyFD := 2 + len(extra)
+
+	// Exec the same binary or override with NEW_BINARY_PATH if provided.
+	bin := strings.TrimSpace(os.Getenv("NEW_BINARY_PATH"))
+	if bin == "" {
+		if bin, err = os.Executable(); err != nil {
+			return err
+		}
+	}
+	cmd := exec.Command(bin, os.Args[1:]...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.ExtraFiles = extra
+	cmd.Env = append(os.Environ(),
+		handoffFdsEnv+"="+strings.Join(spec, ","),
+		fmt.Sprintf("%s=%d", handoffReadyEnv, readyFD),
+	)
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("start child: %v", err)
+	}
+	w.Close() // the child holds the only write end now
+
+	readyCh := make(chan error, 1)
+	go func() {
+		line, err := bufio.NewReader(r).ReadString('\n')
+		if strings.TrimSpace(line) == "ready" {
+			readyCh <- nil
+			return
+		}
+		readyCh <- fmt.Errorf("child exited before ready: %v", err)
+	}()
+	go cmd.Wait() // reap the child whenever it exits
+
+	select {
+	case err := <-readyCh:
+		if err != nil {
+			return err
+		}
+	case <-time.After(u.readyTimeout):
+		cmd.Process.Kill()
+		return fmt.Errorf("child pid=%d not ready after %s", cmd.Process.Pid, u.readyTimeout)
+	}
+	u.upgraded = true
+	u.Stop()
+	return nil
+}
+
+// dupFile returns an independent *os.File for the same open file description.
+func dupFile(f *os.File) (*os.File, error) {
+	sc, err := f.SyscallConn()
+	if err != nil {
+		return nil, err
+	}
+	var dup *os.File
+	var dupErr error
+	err = sc.Control(func(fd uintptr) {
+		var nfd int
+		if nfd, dupErr = syscall.Dup(int(fd)); dupErr == nil {
+			syscall.CloseOnExec(nfd)
+			dup = os.NewFile(uintptr(nfd), f.Name())
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return dup, dupErr
+}
+
+// writePIDFile atomically replaces path with pid, like tableflip does.
+func writePIDFile(path string, pid int) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(tmp, "%d\n", pid); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
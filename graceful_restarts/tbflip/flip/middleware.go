@@ -0,0 +1,46 @@
+package flip
+
+import (
+	"net/http"
+	"time"
+
+	"goexp/internal/demoload"
+)
+
+// statusRecorder captures the status code written by the wrapped handler.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(code int) {
+	r.status = code
+	r.ResponseWriter.WriteHeader(code)
+}
+
+// trackInFlight logs one line per request, with load's in-flight gauge as
+// the request leaves the chain.
+func trackInFlight(next http.Handler, load *demoload.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+		reqLogger.Logf("%s %s status=%d duration=%s in-flight=%d", r.Method, r.URL.Path,
+			rec.status, time.Since(start).Truncate(time.Millisecond), load.InFlight())
+	})
+}
+
+// logInFlightUntil logs load's in-flight gauge every second until done is
+// closed.
+func logInFlightUntil(done <-chan struct{}, load *demoload.Handler) {
+	t := time.NewTicker(time.Second)
+	defer t.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-t.C:
+			logger.Logf("draining... in-flight=%d", load.InFlight())
+		}
+	}
+}
@@ -0,0 +1,45 @@
+package flip
+
+import (
+	"errors"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// readPIDFile returns the pid stored in path, or 0 if the file is missing or unparsable.
+func readPIDFile(path string) int {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return 0
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(string(b)))
+	if err != nil {
+		return 0
+	}
+	return n
+}
+
+// processAlive reports whether a process with the given pid exists.
+// EPERM means it exists but belongs to someone else, which still counts.
+func processAlive(pid int) bool {
+	if pid <= 0 {
+		return false
+	}
+	err := syscall.Kill(pid, 0)
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
+
+// removeStalePIDFile deletes path if it names a process that is no longer
+// running (or holds garbage), so a crashed run doesn't leave `kill -HUP $(cat ...)`
+// pointing at nothing or, worse, at an unrelated recycled pid.
+func removeStalePIDFile(path string) (stale bool, err error) {
+	if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	if processAlive(readPIDFile(path)) {
+		return false, nil
+	}
+	return true, os.Remove(path)
+}
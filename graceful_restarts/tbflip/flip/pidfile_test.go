@@ -0,0 +1,134 @@
+package flip
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// pidfileRoleEnv makes the test binary play one side of
+// TestUpgradeRewritesPIDFile instead of running the tests: tableflip allows one
+// Upgrader per process, so both generations get a process of their own.
+const (
+	pidfileRoleEnv = "TBFLIP_TEST_PIDFILE_ROLE"
+	pidfilePathEnv = "TBFLIP_TEST_PIDFILE"
+)
+
+func TestMain(m *testing.M) {
+	switch os.Getenv(pidfileRoleEnv) {
+	case "parent":
+		os.Exit(pidfileParent(os.Getenv(pidfilePathEnv)))
+	case "child":
+		*pidFile = os.Getenv(pidfilePathEnv)
+		upg, err := newUpgrader("tableflip")
+		if err != nil || upg.Ready() != nil {
+			os.Exit(1)
+		}
+		// The test kills us once it has looked at the pidfile.
+		time.Sleep(30 * time.Second)
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+// pidfileParent becomes ready and upgrades once, reporting failures on stderr.
+func pidfileParent(path string) int {
+	*pidFile = path
+	upg, err := newUpgrader("tableflip")
+	if err == nil {
+		err = upg.Ready()
+	}
+	if err == nil && readPIDFile(path) != os.Getpid() {
+		err = fmt.Errorf("pidfile before upgrade = %d, want %d", readPIDFile(path), os.Getpid())
+	}
+	if err == nil {
+		os.Setenv(pidfileRoleEnv, "child")
+		// Ready is picked up asynchronously; until then Upgrade refuses.
+		for i := 0; i < 100; i++ {
+			if err = upg.Upgrade(); err == nil || err.Error() != "process is not ready yet" {
+				break
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	<-upg.Exit()
+	return 0
+}
+
+// deadPID returns the pid of a process that has already been reaped.
+func deadPID(t *testing.T) int {
+	t.Helper()
+	cmd := exec.Command("true")
+	if err := cmd.Run(); err != nil {
+		t.Skipf("cannot run true: %v", err)
+	}
+	return cmd.Process.Pid
+}
+
+func TestRemoveStalePIDFile(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		contents  *string
+		wantStale bool
+	}{
+		{name: "missing"},
+		{name: "live pid", contents: ptr(strconv.Itoa(os.Getpid()) + "\n")},
+		{name: "stale pid", contents: ptr(strconv.Itoa(deadPID(t)) + "\n"), wantStale: true},
+		{name: "garbage", contents: ptr("not a pid\n"), wantStale: true},
+		{name: "empty", contents: ptr(""), wantStale: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "tbflip.pid")
+			if tc.contents != nil {
+				if err := os.WriteFile(path, []byte(*tc.contents), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			stale, err := removeStalePIDFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if stale != tc.wantStale {
+				t.Errorf("stale = %v, want %v", stale, tc.wantStale)
+			}
+			_, statErr := os.Stat(path)
+			if exists := statErr == nil; exists != (tc.contents != nil && !tc.wantStale) {
+				t.Errorf("pidfile exists = %v after removeStalePIDFile", exists)
+			}
+		})
+	}
+}
+
+func TestUpgradeRewritesPIDFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tbflip.pid")
+	parent := exec.Command(os.Args[0])
+	parent.Env = append(os.Environ(), pidfileRoleEnv+"=parent", pidfilePathEnv+"="+path)
+	// A file rather than a pipe: the child inherits it and outlives the parent.
+	stderr, err := os.Create(filepath.Join(t.TempDir(), "stderr"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stderr.Close()
+	parent.Stderr = stderr
+	if err := parent.Run(); err != nil {
+		out, _ := os.ReadFile(stderr.Name())
+		t.Fatalf("parent: %v\n%s", err, out)
+	}
+
+	child := readPIDFile(path)
+	defer syscall.Kill(child, syscall.SIGKILL)
+	if child == parent.Process.Pid || !processAlive(child) {
+		t.Fatalf("pidfile after upgrade = %d, want the running child (parent was %d)", child, parent.Process.Pid)
+	}
+}
+
+func ptr(s string) *string { return &s }
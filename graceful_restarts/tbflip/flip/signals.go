@@ -0,0 +1,53 @@
+package flip
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+)
+
+// signalNames are the names accepted by -upgrade-signals.
+var signalNames = map[string]syscall.Signal{
+	"hup":  syscall.SIGHUP,
+	"usr1": syscall.SIGUSR1,
+	"usr2": syscall.SIGUSR2,
+}
+
+// parseSignals turns a comma separated list such as "hup,usr2" into signals.
+func parseSignals(list string) ([]os.Signal, error) {
+	var sigs []os.Signal
+	for _, name := range strings.Split(list, ",") {
+		name = strings.TrimPrefix(strings.ToLower(strings.TrimSpace(name)), "sig")
+		if name == "" {
+			continue
+		}
+		s, ok := signalNames[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown signal %q", name)
+		}
+		sigs = append(sigs, s)
+	}
+	if len(sigs) == 0 {
+		return nil, fmt.Errorf("no signals in %q", list)
+	}
+	return sigs, nil
+}
+
+// signalActions maps each signal to what it should do, so upgrade, reload or
+// anything else can share one signal goroutine.
+type signalActions map[os.Signal]func(os.Signal)
+
+// run registers every signal in the map and dispatches until the process exits.
+func (a signalActions) run() {
+	sigs := make([]os.Signal, 0, len(a))
+	for s := range a {
+		sigs = append(sigs, s)
+	}
+	ch := make(chan os.Signal, len(sigs))
+	signal.Notify(ch, sigs...)
+	for s := range ch {
+		a[s](s)
+	}
+}
@@ -0,0 +1,73 @@
+package flip
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// stateFdName is the key the state file is registered under in upg.Fds.
+const stateFdName = "request-state"
+
+// fileFds is the part of tableflip's Fds used for passing the state file on.
+type fileFds interface {
+	File(name string) (*os.File, error)
+	AddFile(name string, file *os.File) error
+}
+
+// openStateFile returns the request-count state file. A child gets the very
+// handle its parent had open (same inode and offset, even if path was rotated
+// away meanwhile); on a cold start path is opened and registered so the next
+// generation inherits it.
+func openStateFile(fds fileFds, path string) (f *os.File, inherited bool, err error) {
+	// File() also marks the inherited handle to be passed on at the next upgrade.
+	f, err = fds.File(stateFdName)
+	if err != nil {
+		return nil, false, err
+	}
+	if f != nil {
+		return f, true, nil
+	}
+
+	f, err = os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, false, err
+	}
+	if err := fds.AddFile(stateFdName, f); err != nil {
+		f.Close()
+		return nil, false, err
+	}
+	return f, false, nil
+}
+
+// readStateTotal sums the request counts recorded by earlier generations.
+// It reads with ReadAt so the shared offset used for appends is untouched.
+func readStateTotal(f *os.File) (total uint64, generations int, err error) {
+	fi, err := f.Stat()
+	if err != nil {
+		return 0, 0, err
+	}
+	sc := bufio.NewScanner(io.NewSectionReader(f, 0, fi.Size()))
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" {
+			continue
+		}
+		var pid int
+		var n uint64
+		if _, err := fmt.Sscanf(line, "pid=%d requests=%d", &pid, &n); err != nil {
+			return total, generations, fmt.Errorf("bad state line %q: %v", line, err)
+		}
+		total += n
+		generations++
+	}
+	return total, generations, sc.Err()
+}
+
+// appendState records this process's final request count.
+func appendState(f *os.File, pid int, requests uint64) error {
+	_, err := fmt.Fprintf(f, "pid=%d requests=%d\n", pid, requests)
+	return err
+}
@@ -0,0 +1,98 @@
+package flip
+
+import (
+	"errors"
+	"os"
+	"strconv"
+	"time"
+)
+
+// upgradeRequest asks the upgrade loop for a new generation. done, if non-nil,
+// receives the final outcome once the loop succeeds or gives up.
+type upgradeRequest struct {
+	reason string
+	done   chan error
+}
+
+var errUpgradeAbandoned = errors.New("upgrade abandoned")
+
+// runUpgrades serializes upgrade attempts from every trigger (signals, admin).
+// A failed Upgrade is retried up to retries times with exponential backoff
+// starting at base; a new request arriving while we back off resets the
+// backoff and retries immediately. Requests arriving while an attempt is in
+// flight join it and get its outcome rather than queueing a second upgrade.
+func runUpgrades(reqs <-chan upgradeRequest, upgrade func() error, retries int, base time.Duration) {
+	for req := range reqs {
+		waiting := []chan error{req.done}
+		reason := req.reason
+		backoff := base
+		attempt := 0
+		in := reqs // nil once closed, so the selects below stop seeing it
+		var err error
+		for {
+			attempt++
+			logger.Phasef("pid=%d upgrade attempt %d/%d (%s)", os.Getpid(), attempt, retries+1, reason)
+			result := make(chan error, 1)
+			go func() { result <- upgrade() }()
+			for pending := true; pending; {
+				select {
+				case err = <-result:
+					pending = false
+				case next, ok := <-in:
+					if !ok {
+						in = nil
+						continue
+					}
+					logger.Logf("upgrade request (%s) joins attempt %d in flight", next.reason, attempt)
+					waiting = append(waiting, next.done)
+				}
+			}
+			if err == nil {
+				break
+			}
+			logger.Logf("Upgrade attempt %d error: %v", attempt, err)
+			if attempt > retries {
+				logger.Phasef("pid=%d upgrade abandoned after %d attempts", os.Getpid(), attempt)
+				err = errors.Join(errUpgradeAbandoned, err)
+				break
+			}
+
+			logger.Logf("retrying upgrade in %s", backoff)
+			timer := time.NewTimer(backoff)
+			select {
+			case <-timer.C:
+				backoff *= 2
+			case next, ok := <-in:
+				if !ok {
+					in = nil
+					<-timer.C
+					backoff *= 2
+					break
+				}
+				timer.Stop()
+				logger.Logf("new upgrade request (%s) during backoff, retrying now", next.reason)
+				waiting = append(waiting, next.done)
+				reason = next.reason
+				backoff = base
+				attempt = 0
+			}
+		}
+		for _, done := range waiting {
+			if done != nil {
+				done <- err
+			}
+		}
+	}
+}
+
+// upgradeNextGeneration exports generation+1 for the child and runs upgrade.
+// The value is set, not incremented, so retries hand out the same number; on
+// failure the variable is put back so nothing leaks into a later attempt.
+func upgradeNextGeneration(upgrade func() error) error {
+	os.Setenv(generationEnv, strconv.Itoa(generation+1))
+	err := upgrade()
+	if err != nil {
+		os.Setenv(generationEnv, strconv.Itoa(generation))
+	}
+	return err
+}
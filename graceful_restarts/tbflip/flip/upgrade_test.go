@@ -0,0 +1,110 @@
+package flip
+
+import (
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// await returns what runUpgrades reports on done.
+func await(t *testing.T, done <-chan error) error {
+	t.Helper()
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(5 * time.Second):
+		t.Fatal("no upgrade outcome reported")
+		return nil
+	}
+}
+
+// send hands req to the loop, failing if the loop does not take it promptly.
+func send(t *testing.T, reqs chan<- upgradeRequest, req upgradeRequest) {
+	t.Helper()
+	select {
+	case reqs <- req:
+	case <-time.After(time.Second):
+		t.Fatal("upgrade request blocked")
+	}
+}
+
+func TestRunUpgradesAbandonsAfterRetries(t *testing.T) {
+	var attempts atomic.Int32
+	failing := func() error {
+		attempts.Add(1)
+		return errors.New("exec: no such file")
+	}
+	reqs := make(chan upgradeRequest)
+	defer close(reqs)
+	go runUpgrades(reqs, failing, 2, time.Millisecond)
+
+	done := make(chan error, 1)
+	send(t, reqs, upgradeRequest{reason: "test", done: done})
+	err := await(t, done)
+	if !errors.Is(err, errUpgradeAbandoned) {
+		t.Fatalf("err = %v, want errUpgradeAbandoned", err)
+	}
+	if n := attempts.Load(); n != 3 {
+		t.Errorf("attempts = %d, want 1 + 2 retries", n)
+	}
+}
+
+func TestRunUpgradesNewRequestResetsBackoff(t *testing.T) {
+	var attempts atomic.Int32
+	failed := make(chan struct{}, 10)
+	upgrade := func() error {
+		if attempts.Add(1) == 1 {
+			failed <- struct{}{}
+			return errors.New("binary missing")
+		}
+		return nil
+	}
+	reqs := make(chan upgradeRequest)
+	defer close(reqs)
+	// A backoff this long only ends early if the second request cuts it short.
+	go runUpgrades(reqs, upgrade, 3, time.Hour)
+
+	first, second := make(chan error, 1), make(chan error, 1)
+	send(t, reqs, upgradeRequest{reason: "first", done: first})
+	<-failed
+	send(t, reqs, upgradeRequest{reason: "second", done: second})
+	if err := await(t, first); err != nil {
+		t.Errorf("first request: %v", err)
+	}
+	if err := await(t, second); err != nil {
+		t.Errorf("second request: %v", err)
+	}
+	if n := attempts.Load(); n != 2 {
+		t.Errorf("attempts = %d, want 2", n)
+	}
+}
+
+func TestRunUpgradesJoinsAttemptInFlight(t *testing.T) {
+	var attempts atomic.Int32
+	started, release := make(chan struct{}), make(chan struct{})
+	upgrade := func() error {
+		attempts.Add(1)
+		close(started)
+		<-release
+		return nil
+	}
+	reqs := make(chan upgradeRequest)
+	defer close(reqs)
+	go runUpgrades(reqs, upgrade, 3, time.Millisecond)
+
+	first, second := make(chan error, 1), make(chan error, 1)
+	send(t, reqs, upgradeRequest{reason: "SIGHUP", done: first})
+	<-started
+	send(t, reqs, upgradeRequest{reason: "SIGHUP again", done: second})
+	close(release)
+	if err := await(t, first); err != nil {
+		t.Errorf("first request: %v", err)
+	}
+	if err := await(t, second); err != nil {
+		t.Errorf("second request: %v", err)
+	}
+	if n := attempts.Load(); n != 1 {
+		t.Errorf("attempts = %d, want the second request to ride on the first", n)
+	}
+}
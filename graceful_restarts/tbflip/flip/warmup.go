@@ -0,0 +1,69 @@
+package flip
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// warmupFunc prepares the process to take traffic; Ready() is only called once it returns nil.
+type warmupFunc func(ctx context.Context) error
+
+// getenvInt retrieves an environment variable as int, falling back to def if unset or invalid.
+func getenvInt(key string, def int) int {
+	if v := strings.TrimSpace(os.Getenv(key)); v != "" {
+		if n, err := strconv.Atoi(v); err == nil {
+			return n
+		}
+	}
+	return def
+}
+
+// getenvDur retrieves an environment variable as seconds and returns a time.Duration, fallback def.
+func getenvDur(key string, def time.Duration) time.Duration {
+	if v := strings.TrimSpace(os.Getenv(key)); v != "" {
+		if n, err := strconv.Atoi(v); err == nil {
+			return time.Duration(n) * time.Second
+		}
+	}
+	return def
+}
+
+// demoWarmup stands in for cache loads or config fetches: it sleeps for d and
+// then does a loopback GET / against addr, the inherited listener's address,
+// expecting 200. The listener is shared with the parent while it is still
+// accepting, so the probe may be answered, and counted as served, by either
+// generation; it still proves ours is serving whenever the kernel hands the
+// connection to us.
+func demoWarmup(addr net.Addr, d time.Duration) warmupFunc {
+	return func(ctx context.Context) error {
+		select {
+		case <-time.After(d):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+
+		_, port, err := net.SplitHostPort(addr.String())
+		if err != nil {
+			return err
+		}
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://"+net.JoinHostPort("127.0.0.1", port)+"/", nil)
+		if err != nil {
+			return err
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("warmup GET / returned %s", resp.Status)
+		}
+		return nil
+	}
+}
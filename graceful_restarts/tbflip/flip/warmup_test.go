@@ -0,0 +1,32 @@
+package flip
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"goexp/internal/demoload"
+)
+
+func TestDemoWarmup(t *testing.T) {
+	healthy := httptest.NewServer(demoload.NewHandler(demoload.Options{}))
+	defer healthy.Close()
+	if err := demoWarmup(healthy.Listener.Addr(), 0)(context.Background()); err != nil {
+		t.Fatalf("warmup against a healthy server: %v", err)
+	}
+
+	broken := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "cold", http.StatusServiceUnavailable)
+	}))
+	defer broken.Close()
+	if err := demoWarmup(broken.Listener.Addr(), 0)(context.Background()); err == nil {
+		t.Error("warmup against a 503 server succeeded")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := demoWarmup(healthy.Listener.Addr(), 0)(ctx); err == nil {
+		t.Error("warmup with a cancelled context succeeded")
+	}
+}
@@ -1,5 +1,11 @@
-module tbflip
+module goexp/graceful_restarts/tbflip
 
 go 1.24.3
 
-require github.com/cloudflare/tableflip v1.2.3
+require (
+	github.com/cloudflare/tableflip v1.2.3
+	goexp/internal v0.0.0
+)
+
+// The colored PID-prefixed logger is shared with the other restart demos.
+replace goexp/internal => ../../internal
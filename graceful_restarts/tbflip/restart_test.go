@@ -0,0 +1,57 @@
+//go:build restarttest && (linux || darwin)
+
+package main
+
+import (
+	"syscall"
+	"testing"
+	"time"
+
+	"goexp/internal/restarttest"
+)
+
+// TestRestart upgrades the server with each -mode under a steady stream of
+// requests, some of them slow, and checks that none of them noticed.
+func TestRestart(t *testing.T) {
+	bin := restarttest.Build(t, ".")
+	for _, mode := range []string{"tableflip", "handoff"} {
+		mode := mode
+		t.Run(mode, func(t *testing.T) {
+			t.Parallel()
+			addr := restarttest.FreeAddr(t)
+			p := restarttest.Start(t, bin, []string{"LOG_FORMAT=plain"},
+				"-mode", mode, "-addr", addr, "-admin-addr", restarttest.FreeAddr(t),
+				"-slow-every", "3", "-slow", "2s", "-heartbeat", "500ms")
+			if err := p.WaitOutput("signaled Ready()", 10*time.Second); err != nil {
+				t.Fatal(err)
+			}
+
+			loop := restarttest.StartLoop(restarttest.HTTPGet("http://"+addr+"/"), 20*time.Millisecond)
+			// Long enough for slow requests to be in flight at the upgrade.
+			time.Sleep(time.Second)
+			hup := time.Now()
+			p.Signal(syscall.SIGHUP)
+			// The old generation exits once its slow requests are done,
+			// and the new one carries on alone for a while.
+			exitErr := p.WaitExit(15 * time.Second)
+			time.Sleep(500 * time.Millisecond)
+			results := loop.Stop()
+
+			if exitErr != nil {
+				t.Errorf("generation 0, pid %d: %v", p.PID, exitErr)
+			}
+			if err := restarttest.NoFailures(results); err != nil {
+				t.Error(err)
+			}
+			next, err := restarttest.Switched(results, p.PID, hup, 5*time.Second)
+			if err != nil {
+				t.Error(err)
+			} else {
+				t.Logf("pid %d upgraded to pid %d", p.PID, next)
+			}
+			if err := restarttest.InFlightCompleted(results, p.PID, hup); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
@@ -0,0 +1,82 @@
+// Package bench measures runs of an experiment, and summarizes samples of
+// measurements the same way for every experiment: mean, standard deviation
+// and interpolated percentiles, rendered as a table, CSV or JSON.
+package bench
+
+import (
+	"runtime"
+	"time"
+)
+
+// Runner times runs of a function.
+type Runner struct {
+	// Settle is how long to pause after the garbage collection that starts
+	// every run, for the system to quiet down.
+	Settle time.Duration
+}
+
+// Result is what one run measured. The CPU and context switch counts are
+// deltas of getrusage(RUSAGE_SELF), so they cover the whole process, and
+// are zero where getrusage is not available.
+type Result struct {
+	Name  string
+	Start time.Time
+	Wall  time.Duration
+	Bytes int64 // as fn returned
+	Err   error // as fn returned
+
+	HeapBefore, HeapAfter uint64 // Go heap in use
+
+	UserCPU, SystemCPU                           time.Duration
+	VoluntaryCtxSwitches, InvoluntaryCtxSwitches int64
+}
+
+// HeapDelta is how much the Go heap grew over the run, or shrank if
+// negative.
+func (r Result) HeapDelta() int64 {
+	return int64(r.HeapAfter) - int64(r.HeapBefore)
+}
+
+// Run collects garbage, waits r.Settle, and then runs fn, which returns how
+// many bytes it moved.
+func (r Runner) Run(name string, fn func() (int64, error)) Result {
+	runtime.GC()
+	time.Sleep(r.Settle)
+
+	heapBefore := heapInUse()
+	cpuBefore := getRusage()
+	start := time.Now()
+
+	n, err := fn()
+
+	wall := time.Since(start)
+	heapAfter := heapInUse()
+	cpuAfter := getRusage()
+	return Result{
+		Name:       name,
+		Start:      start,
+		Wall:       wall,
+		Bytes:      n,
+		Err:        err,
+		HeapBefore: heapBefore,
+		HeapAfter:  heapAfter,
+
+		UserCPU:                cpuAfter.user - cpuBefore.user,
+		SystemCPU:              cpuAfter.system - cpuBefore.system,
+		VoluntaryCtxSwitches:   cpuAfter.nvcsw - cpuBefore.nvcsw,
+		InvoluntaryCtxSwitches: cpuAfter.nivcsw - cpuBefore.nivcsw,
+	}
+}
+
+// heapInUse is the bytes of allocated heap objects.
+func heapInUse() uint64 {
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	return m.Alloc
+}
+
+// cpuUsage is one getrusage sample.
+type cpuUsage struct {
+	user, system  time.Duration
+	nvcsw, nivcsw int64
+}
@@ -0,0 +1,30 @@
+package bench
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+var sink []byte
+
+func TestRunnerRun(t *testing.T) {
+	errShort := errors.New("short")
+	r := Runner{Settle: time.Millisecond}
+	before := time.Now()
+	res := r.Run("alloc", func() (int64, error) {
+		sink = make([]byte, 8<<20)
+		time.Sleep(20 * time.Millisecond)
+		return 42, errShort
+	})
+	if res.Name != "alloc" || res.Bytes != 42 || res.Err != errShort {
+		t.Errorf("got %s, %d, %v; want what fn returned", res.Name, res.Bytes, res.Err)
+	}
+	if res.Start.Before(before) || res.Wall < 20*time.Millisecond {
+		t.Errorf("Start %v, Wall %s; want after %v, at least 20ms", res.Start, res.Wall, before)
+	}
+	if res.HeapDelta() < 4<<20 {
+		t.Errorf("HeapDelta = %d after allocating 8MiB", res.HeapDelta())
+	}
+	sink = nil
+}
@@ -0,0 +1,6 @@
+//go:build !linux && !darwin
+
+package bench
+
+// getRusage is only implemented on linux and darwin; elsewhere it reports zeros.
+func getRusage() cpuUsage { return cpuUsage{} }
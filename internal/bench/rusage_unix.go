@@ -0,0 +1,22 @@
+//go:build linux || darwin
+
+package bench
+
+import (
+	"syscall"
+	"time"
+)
+
+// getRusage samples getrusage(RUSAGE_SELF).
+func getRusage() cpuUsage {
+	var ru syscall.Rusage
+	if err := syscall.Getrusage(syscall.RUSAGE_SELF, &ru); err != nil {
+		return cpuUsage{}
+	}
+	return cpuUsage{
+		user:   time.Duration(ru.Utime.Nano()),
+		system: time.Duration(ru.Stime.Nano()),
+		nvcsw:  int64(ru.Nvcsw),
+		nivcsw: int64(ru.Nivcsw),
+	}
+}
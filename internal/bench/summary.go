@@ -0,0 +1,170 @@
+package bench
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"text/tabwriter"
+	"time"
+)
+
+// Summary describes a sample of measurements. Durations are summarized in
+// nanoseconds; see SummarizeDurations.
+type Summary struct {
+	N      int     `json:"n"`
+	Sum    float64 `json:"sum"`
+	Min    float64 `json:"min"`
+	Max    float64 `json:"max"`
+	Mean   float64 `json:"mean"`
+	StdDev float64 `json:"stddev"` // sample standard deviation (n-1); 0 for a single value
+	P50    float64 `json:"p50"`
+	P95    float64 `json:"p95"`
+	P99    float64 `json:"p99"`
+}
+
+// Summarize summarizes samples; it does not modify them. An empty sample
+// gives the zero Summary.
+func Summarize(samples []float64) Summary {
+	if len(samples) == 0 {
+		return Summary{}
+	}
+	sorted := append([]float64(nil), samples...)
+	sort.Float64s(sorted)
+
+	var sum float64
+	for _, v := range sorted {
+		sum += v
+	}
+	mean := sum / float64(len(sorted))
+	var sq float64
+	for _, v := range sorted {
+		sq += (v - mean) * (v - mean)
+	}
+	stddev := 0.0
+	if len(sorted) > 1 {
+		stddev = math.Sqrt(sq / float64(len(sorted)-1))
+	}
+	return Summary{
+		N:      len(sorted),
+		Sum:    sum,
+		Min:    sorted[0],
+		Max:    sorted[len(sorted)-1],
+		Mean:   mean,
+		StdDev: stddev,
+		P50:    Percentile(sorted, 50),
+		P95:    Percentile(sorted, 95),
+		P99:    Percentile(sorted, 99),
+	}
+}
+
+// SummarizeDurations summarizes ds in nanoseconds.
+func SummarizeDurations(ds []time.Duration) Summary {
+	f := make([]float64, len(ds))
+	for i, d := range ds {
+		f[i] = float64(d)
+	}
+	return Summarize(f)
+}
+
+// Percentile interpolates linearly between the closest ranks of an
+// ascending, non-empty sample, so p50 of an even-sized sample is the mean
+// of the middle two.
+func Percentile(sorted []float64, p float64) float64 {
+	rank := p / 100 * float64(len(sorted)-1)
+	lo := int(math.Floor(rank))
+	hi := int(math.Ceil(rank))
+	return sorted[lo] + (sorted[hi]-sorted[lo])*(rank-float64(lo))
+}
+
+// Unit formats a Summary's values for the table and one-line formats.
+type Unit func(v float64) string
+
+// Durations formats nanoseconds as a time.Duration, rounded to the
+// microsecond once they reach a millisecond.
+func Durations(v float64) string {
+	d := time.Duration(v)
+	if d >= time.Millisecond {
+		d = d.Round(time.Microsecond)
+	}
+	return d.String()
+}
+
+// Float formats a value with two decimals, as for MB/s.
+func Float(v float64) string {
+	return strconv.FormatFloat(v, 'f', 2, 64)
+}
+
+// Format renders s on one line, or "none" for an empty sample.
+func (s Summary) Format(unit Unit) string {
+	if s.N == 0 {
+		return "none"
+	}
+	return fmt.Sprintf("n=%d min=%s mean=%s p50=%s p95=%s p99=%s max=%s",
+		s.N, unit(s.Min), unit(s.Mean), unit(s.P50), unit(s.P95), unit(s.P99), unit(s.Max))
+}
+
+// Row is a named Summary, such as one phase's or one method's.
+type Row struct {
+	Name    string  `json:"name"`
+	Summary Summary `json:"summary"`
+}
+
+// tableColumns are the columns of WriteTable and WriteCSV after the name.
+var tableColumns = []string{"n", "total", "min", "mean", "p50", "p95", "p99", "max", "stddev"}
+
+func (s Summary) columns() []float64 {
+	return []float64{s.Sum, s.Min, s.Mean, s.P50, s.P95, s.P99, s.Max, s.StdDev}
+}
+
+// WriteTable writes rows as a right-aligned table under a header whose
+// first column is called name.
+func WriteTable(w io.Writer, name string, rows []Row, unit Unit) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintf(tw, "%s\t", name)
+	for _, c := range tableColumns {
+		fmt.Fprintf(tw, "%s\t", c)
+	}
+	fmt.Fprintln(tw)
+	for _, r := range rows {
+		fmt.Fprintf(tw, "%s\t%d\t", r.Name, r.Summary.N)
+		for _, v := range r.Summary.columns() {
+			if r.Summary.N == 0 {
+				fmt.Fprint(tw, "-\t")
+				continue
+			}
+			fmt.Fprintf(tw, "%s\t", unit(v))
+		}
+		fmt.Fprintln(tw)
+	}
+	return tw.Flush()
+}
+
+// WriteCSV writes rows with a header line, the values as plain numbers in
+// the Summary's own unit.
+func WriteCSV(w io.Writer, name string, rows []Row) error {
+	cw := csv.NewWriter(w)
+	cw.Write(append([]string{name}, tableColumns...))
+	for _, r := range rows {
+		rec := []string{r.Name, strconv.Itoa(r.Summary.N)}
+		for _, v := range r.Summary.columns() {
+			rec = append(rec, strconv.FormatFloat(v, 'f', -1, 64))
+		}
+		cw.Write(rec)
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// WriteJSON writes rows as an indented JSON array.
+func WriteJSON(w io.Writer, rows []Row) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if rows == nil {
+		rows = []Row{}
+	}
+	return enc.Encode(rows)
+}
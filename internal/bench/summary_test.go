@@ -0,0 +1,155 @@
+package bench
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestSummarize(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		samples []float64
+		want    Summary
+	}{
+		{"empty", nil, Summary{}},
+		{"single", []float64{7}, Summary{N: 1, Sum: 7, Min: 7, Max: 7, Mean: 7, P50: 7, P95: 7, P99: 7}},
+		{"even count interpolates p50", []float64{4, 1, 3, 2}, Summary{N: 4, Sum: 10, Min: 1, Max: 4, Mean: 2.5, StdDev: math.Sqrt(5.0 / 3), P50: 2.5, P95: 3.85, P99: 3.97}},
+		// 1..11: rank for p95 is 9.5, halfway between 10 and 11.
+		{"one to eleven", []float64{11, 10, 9, 8, 7, 6, 5, 4, 3, 2, 1}, Summary{N: 11, Sum: 66, Min: 1, Max: 11, Mean: 6, StdDev: math.Sqrt(11), P50: 6, P95: 10.5, P99: 10.9}},
+		{"constant", []float64{5, 5, 5}, Summary{N: 3, Sum: 15, Min: 5, Max: 5, Mean: 5, P50: 5, P95: 5, P99: 5}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got := Summarize(tc.samples)
+			if got.N != tc.want.N {
+				t.Errorf("n = %d, want %d", got.N, tc.want.N)
+			}
+			for _, f := range []struct {
+				name      string
+				got, want float64
+			}{
+				{"sum", got.Sum, tc.want.Sum},
+				{"min", got.Min, tc.want.Min},
+				{"max", got.Max, tc.want.Max},
+				{"mean", got.Mean, tc.want.Mean},
+				{"stddev", got.StdDev, tc.want.StdDev},
+				{"p50", got.P50, tc.want.P50},
+				{"p95", got.P95, tc.want.P95},
+				{"p99", got.P99, tc.want.P99},
+			} {
+				if math.Abs(f.got-f.want) > 1e-9 {
+					t.Errorf("%s = %v, want %v", f.name, f.got, f.want)
+				}
+			}
+		})
+	}
+}
+
+func TestSummarizeLeavesSamples(t *testing.T) {
+	samples := []float64{3, 1, 2}
+	Summarize(samples)
+	if samples[0] != 3 || samples[1] != 1 || samples[2] != 2 {
+		t.Errorf("Summarize reordered its input: %v", samples)
+	}
+}
+
+func TestPercentile(t *testing.T) {
+	sorted := []float64{10, 20, 30, 40, 50}
+	for _, tc := range []struct{ p, want float64 }{
+		{0, 10},
+		{25, 20},
+		{50, 30},
+		{60, 34},
+		{90, 46},
+		{100, 50},
+	} {
+		if got := Percentile(sorted, tc.p); math.Abs(got-tc.want) > 1e-9 {
+			t.Errorf("p%v = %v, want %v", tc.p, got, tc.want)
+		}
+	}
+}
+
+// rows are a phase table's worth of durations, one of them without samples.
+func rows() []Row {
+	ms := time.Millisecond
+	return []Row{
+		{"write", SummarizeDurations([]time.Duration{1 * ms, 2 * ms, 3 * ms, 4 * ms})},
+		{"fsync", SummarizeDurations(nil)},
+	}
+}
+
+func TestFormat(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		s    Summary
+		unit Unit
+		want string
+	}{
+		{"durations", rows()[0].Summary, Durations, "n=4 min=1ms mean=2.5ms p50=2.5ms p95=3.85ms p99=3.97ms max=4ms"},
+		{"sub-millisecond", SummarizeDurations([]time.Duration{1500, 2500}), Durations, "n=2 min=1.5µs mean=2µs p50=2µs p95=2.45µs p99=2.49µs max=2.5µs"},
+		{"floats", Summarize([]float64{100, 50}), Float, "n=2 min=50.00 mean=75.00 p50=75.00 p95=97.50 p99=99.50 max=100.00"},
+		{"empty", Summary{}, Durations, "none"},
+	} {
+		if got := tc.s.Format(tc.unit); got != tc.want {
+			t.Errorf("%s: got %q, want %q", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestWriteTable(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WriteTable(&buf, "phase", rows(), Durations); err != nil {
+		t.Fatal(err)
+	}
+	want := "" +
+		"  phase  n  total  min   mean    p50     p95     p99  max   stddev\n" +
+		"  write  4   10ms  1ms  2.5ms  2.5ms  3.85ms  3.97ms  4ms  1.291ms\n" +
+		"  fsync  0      -    -      -      -       -       -    -        -\n"
+	if got := buf.String(); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestWriteCSV(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WriteCSV(&buf, "phase", rows()); err != nil {
+		t.Fatal(err)
+	}
+	want := "" +
+		"phase,n,total,min,mean,p50,p95,p99,max,stddev\n" +
+		"write,4,10000000,1000000,2500000,2500000,3849999.9999999995,3970000,4000000,1290994.4487358057\n" +
+		"fsync,0,0,0,0,0,0,0,0,0\n"
+	if got := buf.String(); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestWriteJSON(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WriteJSON(&buf, rows()[:1]); err != nil {
+		t.Fatal(err)
+	}
+	var got []map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || got[0]["name"] != "write" {
+		t.Fatalf("got %v, want one row named write", got)
+	}
+	s, _ := got[0]["summary"].(map[string]any)
+	for key, want := range map[string]float64{"n": 4, "sum": 1e7, "min": 1e6, "max": 4e6, "mean": 2.5e6, "p50": 2.5e6, "p95": 3.85e6, "p99": 3.97e6} {
+		if v, ok := s[key].(float64); !ok || math.Abs(v-want) > 1e-3 {
+			t.Errorf("summary %s = %v, want %v", key, s[key], want)
+		}
+	}
+	if _, ok := s["stddev"]; !ok {
+		t.Error("summary has no stddev")
+	}
+
+	buf.Reset()
+	if err := WriteJSON(&buf, nil); err != nil || buf.String() != "[]\n" {
+		t.Errorf("no rows: %q, %v; want an empty array", buf.String(), err)
+	}
+}
@@ -0,0 +1,198 @@
+// Package clog is the logger the graceful-restart experiments share: every
+// line carries the process's pid, and, for programs that hand over to new
+// generations of themselves, the generation, in a color that tells the
+// processes apart when their logs interleave.
+//
+// LOG_FORMAT picks the format:
+//
+//	color (the default)  [pid] message, in the process's color, with the
+//	                     program's own Layout for generations and banners
+//	tagged               [pid] gen=N message, in the process's color
+//	plain                prog [pid] gen=N message, with no color
+//	json                 one object per line; see Record
+//
+// The color format is how the programs logged before they shared clog, so
+// it is always in color. The tagged format leaves the color out when the
+// output is not a terminal.
+package clog
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// ansiColors are the colors a process's lines can be in.
+var ansiColors = []string{"\033[31m", "\033[32m", "\033[33m", "\033[34m", "\033[35m", "\033[37m"}
+
+const ansiReset = "\033[0m"
+
+// phaseBar frames the message of a Phasef line.
+const phaseBar = "===================="
+
+// Formats, for LOG_FORMAT.
+const (
+	FormatColor  = "color"
+	FormatTagged = "tagged"
+	FormatPlain  = "plain"
+	FormatJSON   = "json"
+)
+
+// Logger writes a process's log lines. It is safe for concurrent use.
+type Logger struct {
+	prog   string
+	pid    int
+	gen    *int
+	layout Layout
+	format string
+	color  string // ANSI code, or "" for none
+	out    *log.Logger
+	now    func() time.Time
+}
+
+// New returns a Logger for the program prog, in the format LOG_FORMAT
+// names. Its color and plain lines go through the standard logger, so
+// log.SetFlags and log.SetOutput apply to them.
+func New(prog string) *Logger {
+	format := os.Getenv("LOG_FORMAT")
+	switch format {
+	case FormatTagged, FormatPlain, FormatJSON:
+	default:
+		format = FormatColor
+	}
+	return newLogger(prog, os.Getpid(), format, log.Default(), isTerminal(log.Writer()))
+}
+
+// newLogger is New with everything it reads from the process given.
+func newLogger(prog string, pid int, format string, out *log.Logger, tty bool) *Logger {
+	l := &Logger{prog: prog, pid: pid, format: format, out: out, now: time.Now}
+	if format == FormatColor || format == FormatTagged && tty {
+		// Stable for the process, and likely to differ from its parent's
+		l.color = ansiColors[pid%len(ansiColors)]
+	}
+	return l
+}
+
+// isTerminal reports whether w is a character device, such as a terminal.
+func isTerminal(w io.Writer) bool {
+	f, ok := w.(*os.File)
+	if !ok {
+		return false
+	}
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+// WithGeneration returns a copy of l whose lines carry gen.
+func (l *Logger) WithGeneration(gen int) *Logger {
+	c := *l
+	c.gen = &gen
+	return &c
+}
+
+// Layout is where the color format puts the pid and the generation, which
+// differed between the programs before they shared clog. Logf lines always
+// start with [pid].
+type Layout struct {
+	PhasePID bool // start Phasef lines with [pid] too
+	PhaseGen bool // put gen=N inside the bars of Phasef lines
+	LogGen   bool // put gen=N after the [pid] of Logf lines
+}
+
+// WithLayout returns a copy of l that lays out its color lines with lay.
+// The zero Layout leaves the generation out and frames Phasef messages in
+// bars alone.
+func (l *Logger) WithLayout(lay Layout) *Logger {
+	c := *l
+	c.layout = lay
+	return &c
+}
+
+// Logf logs a line.
+func (l *Logger) Logf(format string, args ...any) {
+	l.output(false, fmt.Sprintf(format, args...))
+}
+
+// Phasef logs a banner for a milestone such as a restart starting.
+func (l *Logger) Phasef(format string, args ...any) {
+	l.output(true, fmt.Sprintf(format, args...))
+}
+
+// Fatalf logs a line and exits with status 1.
+func (l *Logger) Fatalf(format string, args ...any) {
+	l.Logf(format, args...)
+	os.Exit(1)
+}
+
+// Record is a line in the json format.
+type Record struct {
+	Time  time.Time `json:"time"`
+	Prog  string    `json:"prog"`
+	PID   int       `json:"pid"`
+	Gen   *int      `json:"gen,omitempty"`
+	Phase bool      `json:"phase,omitempty"`
+	Msg   string    `json:"msg"`
+}
+
+// jsonMu keeps json lines whole when several Loggers share a writer.
+var jsonMu sync.Mutex
+
+func (l *Logger) output(phase bool, msg string) {
+	if l.format == FormatJSON {
+		b, _ := json.Marshal(Record{Time: l.now(), Prog: l.prog, PID: l.pid, Gen: l.gen, Phase: phase, Msg: msg})
+		jsonMu.Lock()
+		defer jsonMu.Unlock()
+		l.out.Writer().Write(append(b, '\n'))
+		return
+	}
+
+	if l.format == FormatColor {
+		l.out.Print(l.color + l.layoutLine(phase, msg) + ansiReset)
+		return
+	}
+
+	line := fmt.Sprintf("[%d] ", l.pid)
+	if l.format == FormatPlain {
+		line = l.prog + " " + line
+	}
+	if phase {
+		line += phaseBar + " "
+	}
+	if l.gen != nil {
+		line += fmt.Sprintf("gen=%d ", *l.gen)
+	}
+	line += msg
+	if phase {
+		line += " " + phaseBar
+	}
+	if l.color != "" {
+		line = l.color + line + ansiReset
+	}
+	l.out.Print(line)
+}
+
+// layoutLine is msg laid out as l.layout says, for the color format.
+func (l *Logger) layoutLine(phase bool, msg string) string {
+	pid := fmt.Sprintf("[%d] ", l.pid)
+	gen := ""
+	if l.gen != nil {
+		gen = fmt.Sprintf("gen=%d ", *l.gen)
+	}
+	if !phase {
+		if !l.layout.LogGen {
+			gen = ""
+		}
+		return pid + gen + msg
+	}
+	if !l.layout.PhasePID {
+		pid = ""
+	}
+	if !l.layout.PhaseGen {
+		gen = ""
+	}
+	return pid + phaseBar + " " + gen + msg + " " + phaseBar
+}
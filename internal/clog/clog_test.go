@@ -0,0 +1,104 @@
+package clog
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFormat(t *testing.T) {
+	gen := func(l *Logger) *Logger { return l.WithGeneration(2) }
+	same := func(l *Logger) *Logger { return l }
+	layout := func(lay Layout) func(*Logger) *Logger {
+		return func(l *Logger) *Logger { return l.WithGeneration(2).WithLayout(lay) }
+	}
+	tests := []struct {
+		name   string
+		format string
+		tty    bool
+		with   func(*Logger) *Logger
+		phase  bool
+		want   string
+	}{
+		// The color format's layouts are those of SocketHandoff, the systemd
+		// demo and tbflip before they shared clog.
+		{"color", FormatColor, true, same, false, "\033[35m[1234] hello 7\033[0m\n"},
+		{"color phase", FormatColor, true, same, true, "\033[35m==================== hello 7 ====================\033[0m\n"},
+		{"color gen", FormatColor, true, gen, false, "\033[35m[1234] hello 7\033[0m\n"},
+		{"color gen phase", FormatColor, true, gen, true, "\033[35m==================== hello 7 ====================\033[0m\n"},
+		{"color pid phase", FormatColor, true, layout(Layout{PhasePID: true}), true, "\033[35m[1234] ==================== hello 7 ====================\033[0m\n"},
+		{"color gen in phase", FormatColor, true, layout(Layout{PhaseGen: true}), true, "\033[35m==================== gen=2 hello 7 ====================\033[0m\n"},
+		{"color gen in log", FormatColor, true, layout(Layout{LogGen: true}), false, "\033[35m[1234] gen=2 hello 7\033[0m\n"},
+		{"color not a tty", FormatColor, false, same, false, "\033[35m[1234] hello 7\033[0m\n"},
+		{"tagged", FormatTagged, true, same, false, "\033[35m[1234] hello 7\033[0m\n"},
+		{"tagged phase", FormatTagged, true, same, true, "\033[35m[1234] ==================== hello 7 ====================\033[0m\n"},
+		{"tagged gen", FormatTagged, true, gen, false, "\033[35m[1234] gen=2 hello 7\033[0m\n"},
+		{"tagged gen phase", FormatTagged, true, gen, true, "\033[35m[1234] ==================== gen=2 hello 7 ====================\033[0m\n"},
+		{"tagged not a tty", FormatTagged, false, layout(Layout{PhasePID: true}), false, "[1234] gen=2 hello 7\n"},
+		{"plain", FormatPlain, true, same, false, "demo [1234] hello 7\n"},
+		{"plain gen phase", FormatPlain, true, gen, true, "demo [1234] ==================== gen=2 hello 7 ====================\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			l := tt.with(newLogger("demo", 1234, tt.format, log.New(&buf, "", 0), tt.tty))
+			if tt.phase {
+				l.Phasef("hello %d", 7)
+			} else {
+				l.Logf("hello %d", 7)
+			}
+			if got := buf.String(); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestJSON(t *testing.T) {
+	var buf bytes.Buffer
+	// The standard logger's prefix and flags do not apply to json lines.
+	l := newLogger("demo", 1234, FormatJSON, log.New(&buf, "prefix ", log.LstdFlags), true)
+	l.now = func() time.Time { return time.Date(2024, 5, 6, 7, 8, 9, 10, time.UTC) }
+	l.Logf("hello %q", "world")
+	l.WithGeneration(0).Phasef("restart")
+
+	want := []string{
+		`{"time":"2024-05-06T07:08:09.00000001Z","prog":"demo","pid":1234,"msg":"hello \"world\""}`,
+		`{"time":"2024-05-06T07:08:09.00000001Z","prog":"demo","pid":1234,"gen":0,"phase":true,"msg":"restart"}`,
+	}
+	got := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	if len(got) != len(want) {
+		t.Fatalf("got %d lines, want %d:\n%s", len(got), len(want), buf.String())
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("line %d = %s, want %s", i, got[i], want[i])
+		}
+		var r Record
+		if err := json.Unmarshal([]byte(got[i]), &r); err != nil {
+			t.Errorf("line %d does not decode as a Record: %v", i, err)
+		}
+	}
+}
+
+func TestNewFormat(t *testing.T) {
+	for env, want := range map[string]string{"": FormatColor, "tagged": FormatTagged, "json": FormatJSON, "plain": FormatPlain, "JSON": FormatColor, "xml": FormatColor} {
+		t.Setenv("LOG_FORMAT", env)
+		if got := New("demo").format; got != want {
+			t.Errorf("LOG_FORMAT=%q: format %q, want %q", env, got, want)
+		}
+	}
+}
+
+func TestWithGenerationCopies(t *testing.T) {
+	var buf bytes.Buffer
+	l := newLogger("demo", 1, FormatPlain, log.New(&buf, "", 0), false)
+	l.WithGeneration(3)
+	l.Logf("x")
+	if got := buf.String(); got != "demo [1] x\n" {
+		t.Errorf("WithGeneration changed the original: %q", got)
+	}
+}
@@ -0,0 +1,132 @@
+// Package demoload is the workload every graceful-restart demo serves, so
+// that restart strategies are compared on the same one: requests are
+// answered at once, except every Nth, which works for a while and logs
+// heartbeats as it goes, and every answer names the process and generation
+// that gave it. Handler serves it over HTTP and SessionHandler as a line
+// protocol; both keep a Gauge of the work in flight for drain logic to
+// consult.
+package demoload
+
+import (
+	"context"
+	"flag"
+	"sync/atomic"
+	"time"
+)
+
+// Options shape the workload. A handler's options can be changed while it
+// serves; each request or command keeps the ones it started with.
+type Options struct {
+	// SlowEvery makes every Nth request slow, or with SessionHandler every
+	// Nth command of a session; 0 makes none slow.
+	SlowEvery int
+	// Slow is how long a slow request works.
+	Slow time.Duration
+	// Heartbeat is how often a slow request logs that it is still working;
+	// 0 logs only its start and end.
+	Heartbeat time.Duration
+
+	// PID and Generation name the process in answers.
+	PID, Generation int
+
+	// IdleTimeout closes a session after that long without a command; 0
+	// never does. HTTP requests ignore it.
+	IdleTimeout time.Duration
+	// WriteTimeout bounds each write of a session's replies; 0 does not.
+	// HTTP requests ignore it.
+	WriteTimeout time.Duration
+
+	// Logf logs a line; nil logs nothing.
+	Logf func(format string, args ...any)
+}
+
+// DefaultOptions are the demos' defaults: every 3rd request works for 10
+// seconds, with a heartbeat every second.
+func DefaultOptions() Options {
+	return Options{SlowEvery: 3, Slow: 10 * time.Second, Heartbeat: time.Second}
+}
+
+// RegisterFlags defines -slow-every, -slow and -heartbeat in fs, which set
+// o's fields and default to their current values.
+func (o *Options) RegisterFlags(fs *flag.FlagSet) {
+	fs.IntVar(&o.SlowEvery, "slow-every", o.SlowEvery, "make every Nth request slow (0 = none)")
+	fs.DurationVar(&o.Slow, "slow", o.Slow, "how long a slow request takes")
+	fs.DurationVar(&o.Heartbeat, "heartbeat", o.Heartbeat, "how often a slow request logs a heartbeat (0 = never)")
+}
+
+// slow reports whether the nth request or command is a slow one.
+func (o *Options) slow(n uint64) bool {
+	return o.SlowEvery > 0 && n%uint64(o.SlowEvery) == 0
+}
+
+func (o *Options) logf(format string, args ...any) {
+	if o.Logf != nil {
+		o.Logf(format, args...)
+	}
+}
+
+// work works for o.Slow, calling heartbeat every o.Heartbeat with the time
+// spent so far. It reports whether it finished, rather than stopping early
+// because ctx was done.
+func (o *Options) work(ctx context.Context, heartbeat func(elapsed time.Duration)) bool {
+	start := time.Now()
+	done := time.NewTimer(o.Slow)
+	defer done.Stop()
+	var tick <-chan time.Time
+	if o.Heartbeat > 0 {
+		t := time.NewTicker(o.Heartbeat)
+		defer t.Stop()
+		tick = t.C
+	}
+	for {
+		select {
+		case <-done.C:
+			return true
+		case <-ctx.Done():
+			return false
+		case <-tick:
+			heartbeat(time.Since(start).Truncate(time.Millisecond))
+		}
+	}
+}
+
+// Gauge counts the work a handler is in the middle of, and has finished.
+// It is safe for concurrent use.
+type Gauge struct {
+	inFlight  atomic.Int64
+	completed atomic.Uint64
+}
+
+// InFlight is the number of requests, or sessions, being served now.
+func (g *Gauge) InFlight() int64 { return g.inFlight.Load() }
+
+// Completed is the number of requests, or sessions, served to the end.
+func (g *Gauge) Completed() uint64 { return g.completed.Load() }
+
+// enter counts the start of a request and returns the number in flight.
+func (g *Gauge) enter() int64 { return g.inFlight.Add(1) }
+
+// leave counts the end of a request.
+func (g *Gauge) leave() {
+	g.inFlight.Add(-1)
+	g.completed.Add(1)
+}
+
+// load is what both handlers have: their options, their Gauge and the
+// count of requests, or sessions, that numbers them.
+type load struct {
+	Gauge
+	opts atomic.Pointer[Options]
+	seq  atomic.Uint64
+}
+
+// Options returns the handler's current options.
+func (l *load) Options() Options { return *l.opts.Load() }
+
+// SetOptions replaces the handler's options, for requests that start from
+// now on.
+func (l *load) SetOptions(o Options) { l.opts.Store(&o) }
+
+// Requests is the number of requests, or sessions, the handler has taken;
+// the last one's id.
+func (l *load) Requests() uint64 { return l.seq.Load() }
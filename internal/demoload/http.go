@@ -0,0 +1,49 @@
+package demoload
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Handler serves the workload over HTTP. Every request, whatever its method
+// and path, takes the next id and is answered with
+//
+//	hello world pid=P gen=G req=ID slow=BOOL
+//
+// and an X-Generation header, after working for Options.Slow if it is a
+// slow one.
+type Handler struct {
+	load
+}
+
+// NewHandler returns a Handler serving with o.
+func NewHandler(o Options) *Handler {
+	h := new(Handler)
+	h.SetOptions(o)
+	return h
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	o := h.Options()
+	n := h.enter()
+	defer h.leave()
+	start := time.Now()
+	id := h.seq.Add(1)
+	slow := o.slow(id)
+	o.logf("req=%d %s %s slow=%v in-flight=%d", id, r.Method, r.URL.Path, slow, n)
+
+	if slow {
+		finished := o.work(r.Context(), func(elapsed time.Duration) {
+			o.logf("req=%d heartbeat: %s elapsed", id, elapsed)
+		})
+		if !finished {
+			o.logf("req=%d client gone after %s, abandoning slow work", id, time.Since(start).Truncate(time.Millisecond))
+			return
+		}
+		o.logf("req=%d slow work finished after %s", id, o.Slow)
+	}
+	w.Header().Set("X-Generation", strconv.Itoa(o.Generation))
+	fmt.Fprintf(w, "hello world pid=%d gen=%d req=%d slow=%v\n", o.PID, o.Generation, id, slow)
+}
@@ -0,0 +1,199 @@
+package demoload
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// logBuffer collects log lines from several goroutines.
+type logBuffer struct {
+	mu    sync.Mutex
+	lines []string
+}
+
+func (l *logBuffer) Logf(format string, args ...any) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.lines = append(l.lines, fmt.Sprintf(format, args...))
+}
+
+func (l *logBuffer) String() string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return strings.Join(l.lines, "\n")
+}
+
+func get(t *testing.T, url string) (string, http.Header) {
+	t.Helper()
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status %s: %s", resp.Status, body)
+	}
+	return string(body), resp.Header
+}
+
+func TestHandlerSlowEvery(t *testing.T) {
+	var logs logBuffer
+	const slowFor = 50 * time.Millisecond
+	h := NewHandler(Options{SlowEvery: 3, Slow: slowFor, Heartbeat: 10 * time.Millisecond, PID: 7, Generation: 2, Logf: logs.Logf})
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	for id := 1; id <= 6; id++ {
+		start := time.Now()
+		body, header := get(t, srv.URL+"/anything")
+		took := time.Since(start)
+
+		slow := id%3 == 0
+		if want := fmt.Sprintf("hello world pid=7 gen=2 req=%d slow=%v\n", id, slow); body != want {
+			t.Errorf("body = %q, want %q", body, want)
+		}
+		if header.Get("X-Generation") != "2" {
+			t.Errorf("req=%d: X-Generation = %q, want 2", id, header.Get("X-Generation"))
+		}
+		if slow && took < slowFor {
+			t.Errorf("slow req=%d took %s, want at least %s", id, took, slowFor)
+		}
+		if !slow && took >= slowFor {
+			t.Errorf("fast req=%d took %s", id, took)
+		}
+	}
+	if h.Requests() != 6 || h.Completed() != 6 || h.InFlight() != 0 {
+		t.Errorf("Requests, Completed, InFlight = %d, %d, %d; want 6, 6, 0", h.Requests(), h.Completed(), h.InFlight())
+	}
+	out := logs.String()
+	for _, want := range []string{"req=3 heartbeat: ", "req=6 slow work finished after 50ms"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("no %q in the log:\n%s", want, out)
+		}
+	}
+	if strings.Contains(out, "req=2 heartbeat") {
+		t.Errorf("fast request logged a heartbeat:\n%s", out)
+	}
+}
+
+func TestHandlerInFlight(t *testing.T) {
+	h := NewHandler(Options{SlowEvery: 1, Slow: 200 * time.Millisecond})
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		get(t, srv.URL)
+	}()
+	for deadline := time.Now().Add(time.Second); h.InFlight() != 1; time.Sleep(5 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("InFlight = %d during a slow request, want 1", h.InFlight())
+		}
+	}
+	if h.Completed() != 0 {
+		t.Errorf("Completed = %d before the request finished", h.Completed())
+	}
+	<-done
+	if h.InFlight() != 0 || h.Completed() != 1 {
+		t.Errorf("InFlight, Completed = %d, %d after the request; want 0, 1", h.InFlight(), h.Completed())
+	}
+}
+
+func TestHandlerClientGone(t *testing.T) {
+	var logs logBuffer
+	h := NewHandler(Options{SlowEvery: 1, Slow: time.Minute, Logf: logs.Logf})
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	rec := httptest.NewRecorder()
+
+	start := time.Now()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil).WithContext(ctx))
+	if took := time.Since(start); took > 5*time.Second {
+		t.Errorf("slow request went on for %s after its client left", took)
+	}
+	if rec.Body.Len() != 0 {
+		t.Errorf("answered a client that left: %q", rec.Body)
+	}
+	if !strings.Contains(logs.String(), "req=1 client gone") {
+		t.Errorf("no client gone line in the log:\n%s", logs.String())
+	}
+	if h.InFlight() != 0 {
+		t.Errorf("InFlight = %d", h.InFlight())
+	}
+}
+
+func TestHandlerIDsUnderConcurrency(t *testing.T) {
+	h := NewHandler(Options{SlowEvery: 3, PID: 1})
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	const n = 100
+	ids := make(chan uint64, n)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := http.Get(srv.URL + "/")
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			defer resp.Body.Close()
+			body, err := io.ReadAll(resp.Body)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			var pid, gen int
+			var id uint64
+			var slow bool
+			if _, err := fmt.Sscanf(string(body), "hello world pid=%d gen=%d req=%d slow=%t", &pid, &gen, &id, &slow); err != nil {
+				t.Errorf("body %q: %v", body, err)
+				return
+			}
+			if slow != (id%3 == 0) {
+				t.Errorf("req=%d slow=%v", id, slow)
+			}
+			ids <- id
+		}()
+	}
+	wg.Wait()
+	close(ids)
+
+	if got := h.Requests(); got != n {
+		t.Errorf("Requests() = %d, want %d", got, n)
+	}
+	seen := make(map[uint64]bool)
+	for id := range ids {
+		if seen[id] {
+			t.Errorf("req=%d handed out twice", id)
+		}
+		seen[id] = true
+	}
+	if len(seen) != n {
+		t.Errorf("got %d distinct ids, want %d", len(seen), n)
+	}
+}
+
+func TestSetOptions(t *testing.T) {
+	h := NewHandler(Options{SlowEvery: 1, Slow: time.Minute, Generation: 1})
+	h.SetOptions(Options{Generation: 2})
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if want := "hello world pid=0 gen=2 req=1 slow=false\n"; rec.Body.String() != want {
+		t.Errorf("body = %q, want %q", rec.Body, want)
+	}
+}
@@ -0,0 +1,145 @@
+package demoload
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// SessionHandler serves the workload as a line protocol: a session takes
+// the next id when it starts, and each line the client sends is a command,
+// answered with
+//
+//	fast reply pid=P gen=G req=ID cmd=N: LINE
+//
+// or, for every Options.SlowEvery-th command of the session, with a slow
+// reply once it has worked for Options.Slow. The session reads on while a
+// slow command works, so replies can come back out of order. "exit" or
+// "quit" ends the session with a goodbye.
+//
+// Its Gauge counts sessions, which stay in flight until their slow commands
+// have replied.
+type SessionHandler struct {
+	load
+}
+
+// NewSessionHandler returns a SessionHandler serving with o.
+func NewSessionHandler(o Options) *SessionHandler {
+	s := new(SessionHandler)
+	s.SetOptions(o)
+	return s
+}
+
+// session is one connection. Writes from the read loop and from slow
+// commands are serialized through write.
+type session struct {
+	id     uint64
+	c      net.Conn
+	mu     sync.Mutex
+	broken atomic.Bool        // set after the first failed write; later writes are dropped
+	cancel context.CancelFunc // stops slow commands once broken
+}
+
+// write sends msg, with a deadline if timeout is positive. On failure it
+// logs the error and marks the session broken so that slow commands give up.
+func (s *session) write(o *Options, cmd int, msg string) bool {
+	if s.broken.Load() {
+		return false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.broken.Load() {
+		return false
+	}
+	if o.WriteTimeout > 0 {
+		if err := s.c.SetWriteDeadline(time.Now().Add(o.WriteTimeout)); err != nil {
+			o.logf("req=%d cmd=%d set write deadline: %v", s.id, cmd, err)
+		}
+	}
+	if _, err := s.c.Write([]byte(msg)); err != nil {
+		o.logf("req=%d cmd=%d write error, marking session broken: %v", s.id, cmd, err)
+		s.broken.Store(true)
+		s.cancel()
+		return false
+	}
+	return true
+}
+
+// ServeConn serves a session on c until the client ends it, hangs up or
+// stays idle for too long, waits for its slow commands, and closes c.
+func (h *SessionHandler) ServeConn(c net.Conn) {
+	defer c.Close()
+	h.enter()
+	defer h.leave()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sess := &session{id: h.seq.Add(1), c: c, cancel: cancel}
+	var slow sync.WaitGroup
+	defer slow.Wait()
+
+	o := h.Options()
+	o.logf("req=%d new interactive session from %s", sess.id, c.RemoteAddr())
+	scanner := bufio.NewScanner(c)
+	cmd := 0 // per-session command counter
+	for {
+		// Snapshot per command: new options only affect commands read after them.
+		o = h.Options()
+		if o.IdleTimeout > 0 {
+			c.SetReadDeadline(time.Now().Add(o.IdleTimeout))
+		} else {
+			c.SetReadDeadline(time.Time{})
+		}
+		if !scanner.Scan() {
+			break
+		}
+		line := scanner.Text()
+		if line == "" {
+			continue
+		}
+		cmd++
+		o.logf("req=%d got command #%d: %q", sess.id, cmd, line)
+
+		if line == "exit" || line == "quit" {
+			o.logf("req=%d client requested to close connection", sess.id)
+			slow.Wait()
+			sess.write(&o, cmd, "goodbye 👋\n")
+			return
+		}
+
+		reply := fmt.Sprintf("pid=%d gen=%d req=%d cmd=%d: %s\n", o.PID, o.Generation, sess.id, cmd, line)
+		if !o.slow(uint64(cmd)) {
+			if !sess.write(&o, cmd, "fast reply "+reply) {
+				return
+			}
+			continue
+		}
+		o.logf("req=%d cmd=%d slow mode (%v simulated work)", sess.id, cmd, o.Slow)
+		slow.Add(1)
+		go func(o Options, cmd int) {
+			defer slow.Done()
+			finished := o.work(ctx, func(elapsed time.Duration) {
+				o.logf("req=%d cmd=%d heartbeat: %v elapsed", sess.id, cmd, elapsed)
+			})
+			if !finished {
+				o.logf("req=%d cmd=%d session broken, abandoning slow work", sess.id, cmd)
+				return
+			}
+			o.logf("req=%d cmd=%d finished simulated work", sess.id, cmd)
+			sess.write(&o, cmd, "slow reply "+reply)
+		}(o, cmd)
+	}
+
+	if err := scanner.Err(); errors.Is(err, os.ErrDeadlineExceeded) {
+		o.logf("req=%d idle timeout, closing", sess.id)
+	} else if err != nil {
+		o.logf("req=%d scanner error: %v", sess.id, err)
+	}
+	o.logf("req=%d connection closed", sess.id)
+}
@@ -0,0 +1,125 @@
+package demoload
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// startSession serves a session of h on one end of a pipe and returns the
+// other, and a channel closed when ServeConn returns.
+func startSession(t *testing.T, h *SessionHandler) (*bufio.ReadWriter, net.Conn, chan struct{}) {
+	t.Helper()
+	client, server := net.Pipe()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		h.ServeConn(server)
+	}()
+	t.Cleanup(func() { client.Close() })
+	return bufio.NewReadWriter(bufio.NewReader(client), bufio.NewWriter(client)), client, done
+}
+
+func send(t *testing.T, rw *bufio.ReadWriter, line string) {
+	t.Helper()
+	if _, err := rw.WriteString(line + "\n"); err != nil {
+		t.Fatal(err)
+	}
+	if err := rw.Flush(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func readLine(t *testing.T, rw *bufio.ReadWriter) string {
+	t.Helper()
+	line, err := rw.ReadString('\n')
+	if err != nil {
+		t.Fatalf("reading a reply: %v", err)
+	}
+	return line
+}
+
+func TestSessionReplies(t *testing.T) {
+	var logs logBuffer
+	h := NewSessionHandler(Options{SlowEvery: 2, Slow: 100 * time.Millisecond, Heartbeat: 20 * time.Millisecond, PID: 7, Generation: 1, Logf: logs.Logf})
+	rw, _, done := startSession(t, h)
+
+	send(t, rw, "one")
+	if got, want := readLine(t, rw), "fast reply pid=7 gen=1 req=1 cmd=1: one\n"; got != want {
+		t.Errorf("reply = %q, want %q", got, want)
+	}
+	// The second command is slow, and the third is answered before it.
+	send(t, rw, "two")
+	send(t, rw, "three")
+	if got, want := readLine(t, rw), "fast reply pid=7 gen=1 req=1 cmd=3: three\n"; got != want {
+		t.Errorf("reply = %q, want %q", got, want)
+	}
+	if got, want := readLine(t, rw), "slow reply pid=7 gen=1 req=1 cmd=2: two\n"; got != want {
+		t.Errorf("reply = %q, want %q", got, want)
+	}
+	send(t, rw, "exit")
+	if got := readLine(t, rw); !strings.HasPrefix(got, "goodbye") {
+		t.Errorf("reply to exit = %q", got)
+	}
+	<-done
+	if h.Requests() != 1 || h.Completed() != 1 || h.InFlight() != 0 {
+		t.Errorf("Requests, Completed, InFlight = %d, %d, %d; want 1, 1, 0", h.Requests(), h.Completed(), h.InFlight())
+	}
+	if !strings.Contains(logs.String(), "req=1 cmd=2 heartbeat: ") {
+		t.Errorf("no heartbeat in the log:\n%s", logs.String())
+	}
+}
+
+func TestSessionExitWaitsForSlowCommands(t *testing.T) {
+	h := NewSessionHandler(Options{SlowEvery: 1, Slow: 100 * time.Millisecond})
+	rw, _, done := startSession(t, h)
+
+	send(t, rw, "work")
+	send(t, rw, "quit")
+	time.Sleep(20 * time.Millisecond)
+	if h.InFlight() != 1 {
+		t.Errorf("InFlight = %d while a slow command works, want 1", h.InFlight())
+	}
+	if got := readLine(t, rw); got != "slow reply pid=0 gen=0 req=1 cmd=1: work\n" {
+		t.Errorf("first reply = %q, want the slow reply", got)
+	}
+	if got := readLine(t, rw); !strings.HasPrefix(got, "goodbye") {
+		t.Errorf("second reply = %q, want goodbye", got)
+	}
+	<-done
+}
+
+func TestSessionIdleTimeout(t *testing.T) {
+	var logs logBuffer
+	h := NewSessionHandler(Options{IdleTimeout: 50 * time.Millisecond, Logf: logs.Logf})
+	_, _, done := startSession(t, h)
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("idle session still open")
+	}
+	if !strings.Contains(logs.String(), "req=1 idle timeout") {
+		t.Errorf("no idle timeout in the log:\n%s", logs.String())
+	}
+}
+
+func TestSessionClientGone(t *testing.T) {
+	var logs logBuffer
+	h := NewSessionHandler(Options{SlowEvery: 2, Slow: 50 * time.Millisecond, Logf: logs.Logf})
+	rw, client, done := startSession(t, h)
+
+	send(t, rw, "one")
+	readLine(t, rw)
+	send(t, rw, "two")
+	client.Close()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("session still open after its client hung up")
+	}
+	if !strings.Contains(logs.String(), "req=1 cmd=2 write error, marking session broken") {
+		t.Errorf("slow reply to a closed session not logged as a write error:\n%s", logs.String())
+	}
+}
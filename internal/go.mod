@@ -0,0 +1,3 @@
+module goexp/internal
+
+go 1.21
@@ -0,0 +1,98 @@
+package restarttest
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// maxListed is how many offending results an error lists.
+const maxListed = 5
+
+// NoFailures returns an error listing the requests that failed, if any did.
+func NoFailures(results []Result) error {
+	if len(results) == 0 {
+		return errors.New("no requests were sent")
+	}
+	var failed []Result
+	for _, r := range results {
+		if r.Err != nil {
+			failed = append(failed, r)
+		}
+	}
+	if len(failed) == 0 {
+		return nil
+	}
+	return fmt.Errorf("%d of %d requests failed:%s", len(failed), len(results), list(failed))
+}
+
+// Switched checks that requests moved from the process from to others at
+// most within after at, when it was told to restart: every request that
+// started from some point no later than at+within on was answered by
+// another process, and one was. It returns the pid that answered the first
+// of them.
+func Switched(results []Result, from int, at time.Time, within time.Duration) (int, error) {
+	// The switch is just after the last request from answered.
+	last := -1
+	for i, r := range results {
+		if r.PID == from {
+			last = i
+		}
+	}
+	if last < 0 {
+		return 0, fmt.Errorf("pid %d answered no request", from)
+	}
+	var after []Result
+	for _, r := range results[last+1:] {
+		if r.Err == nil {
+			after = append(after, r)
+		}
+	}
+	if len(after) == 0 {
+		return 0, fmt.Errorf("no request was answered by a process other than pid %d", from)
+	}
+	if switched := after[0].Start.Sub(at); switched > within {
+		return 0, fmt.Errorf("requests still went to pid %d %s after the restart, more than %s:%s", from, switched, within, list(results[last:last+1]))
+	}
+	return after[0].PID, nil
+}
+
+// InFlightCompleted checks that the requests pid was answering at at, such
+// as slow ones when it was told to restart, completed: at least one was in
+// flight, and every request in flight then succeeded.
+func InFlightCompleted(results []Result, pid int, at time.Time) error {
+	var answered int
+	var failed []Result
+	for _, r := range results {
+		if !r.Start.Before(at) || !r.End().After(at) {
+			continue
+		}
+		switch {
+		case r.Err != nil:
+			failed = append(failed, r)
+		case r.PID == pid:
+			answered++
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("%d requests in flight at %s failed:%s", len(failed), at.Format("15:04:05.000"), list(failed))
+	}
+	if answered == 0 {
+		return fmt.Errorf("pid %d answered no request that was in flight at %s", pid, at.Format("15:04:05.000"))
+	}
+	return nil
+}
+
+// list formats results for an error, one per line.
+func list(results []Result) string {
+	var b strings.Builder
+	for i, r := range results {
+		if i == maxListed {
+			fmt.Fprintf(&b, "\n\t... and %d more", len(results)-maxListed)
+			break
+		}
+		fmt.Fprintf(&b, "\n\t%s", r)
+	}
+	return b.String()
+}
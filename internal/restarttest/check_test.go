@@ -0,0 +1,76 @@
+package restarttest
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// results builds a sequence of results 100ms apart from specs of the pid
+// that answered (0 for a failure) and the latency in units of 100ms.
+func results(t0 time.Time, specs ...[2]int) []Result {
+	var rs []Result
+	for i, s := range specs {
+		r := Result{Start: t0.Add(time.Duration(i) * 100 * time.Millisecond), Latency: time.Duration(s[1]) * 100 * time.Millisecond, PID: s[0]}
+		if s[0] == 0 {
+			r.Err = errors.New("connection refused")
+		} else {
+			r.Status = 200
+		}
+		rs = append(rs, r)
+	}
+	return rs
+}
+
+func TestNoFailures(t *testing.T) {
+	t0 := time.Now()
+	if err := NoFailures(results(t0, [2]int{10, 1}, [2]int{11, 1})); err != nil {
+		t.Errorf("all succeeded: %v", err)
+	}
+	err := NoFailures(results(t0, [2]int{10, 1}, [2]int{0, 1}, [2]int{11, 1}))
+	if err == nil || !strings.Contains(err.Error(), "1 of 3 requests failed") || !strings.Contains(err.Error(), "connection refused") {
+		t.Errorf("one failed: %v", err)
+	}
+	if err := NoFailures(nil); err == nil {
+		t.Error("no requests: no error")
+	}
+}
+
+func TestSwitched(t *testing.T) {
+	t0 := time.Now()
+	// A slow request to 10 starts at 200ms and overlaps the switch.
+	rs := results(t0, [2]int{10, 1}, [2]int{10, 1}, [2]int{10, 30}, [2]int{10, 1}, [2]int{11, 1}, [2]int{11, 1})
+	at := t0.Add(250 * time.Millisecond)
+
+	to, err := Switched(rs, 10, at, time.Second)
+	if err != nil || to != 11 {
+		t.Errorf("Switched = %d, %v; want 11, nil", to, err)
+	}
+	// The first request to 11 starts 150ms after at.
+	if _, err := Switched(rs, 10, at, 100*time.Millisecond); err == nil {
+		t.Error("switch later than within: no error")
+	}
+	if _, err := Switched(rs[:4], 10, at, time.Second); err == nil {
+		t.Error("no switch: no error")
+	}
+	if _, err := Switched(rs, 12, at, time.Second); err == nil {
+		t.Error("from answered nothing: no error")
+	}
+}
+
+func TestInFlightCompleted(t *testing.T) {
+	t0 := time.Now()
+	rs := results(t0, [2]int{10, 1}, [2]int{10, 30}, [2]int{11, 1})
+	at := t0.Add(time.Second)
+	if err := InFlightCompleted(rs, 10, at); err != nil {
+		t.Errorf("slow request completed: %v", err)
+	}
+	if err := InFlightCompleted(rs, 11, at); err == nil {
+		t.Error("11 had nothing in flight: no error")
+	}
+	rs = results(t0, [2]int{10, 1}, [2]int{10, 30}, [2]int{0, 30})
+	if err := InFlightCompleted(rs, 10, at); err == nil || !strings.Contains(err.Error(), "1 requests in flight") {
+		t.Errorf("in-flight request failed: %v", err)
+	}
+}
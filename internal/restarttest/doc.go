@@ -0,0 +1,12 @@
+// Package restarttest drives a graceful-restart demo as a process: it
+// builds the demo, starts it, sends it signals, keeps requests flowing
+// through it from the side, and checks what those requests saw, such as
+// that none of them failed while it restarted.
+//
+// The processes a test starts, and whatever they start in turn, are killed
+// when the test ends, passed or failed. That needs process groups, so the
+// package is only built on linux and darwin, and the suites that use it
+// carry a build tag:
+//
+//	go test -tags restarttest .
+package restarttest
@@ -0,0 +1,155 @@
+package restarttest
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A Request sends one request and returns the response: its HTTP status,
+// 0 for protocols without one, and its body.
+type Request func(ctx context.Context) (status int, body string, err error)
+
+// requestTimeout bounds a request of the loop, slow ones included.
+const requestTimeout = 30 * time.Second
+
+// HTTPGet returns a Request that GETs url on a new connection each time,
+// so that every request goes through the listener that is being handed
+// over rather than riding a kept-alive connection to an old process.
+func HTTPGet(url string) Request {
+	client := &http.Client{Transport: &http.Transport{DisableKeepAlives: true}}
+	return func(ctx context.Context) (int, string, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+		if err != nil {
+			return 0, "", err
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			return 0, "", err
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return resp.StatusCode, "", err
+		}
+		if resp.StatusCode != http.StatusOK {
+			return resp.StatusCode, string(body), fmt.Errorf("status %s", resp.Status)
+		}
+		return resp.StatusCode, string(body), nil
+	}
+}
+
+// TCPLine returns a Request that dials addr, sends line and reads one line
+// back.
+func TCPLine(addr, line string) Request {
+	return func(ctx context.Context) (int, string, error) {
+		var d net.Dialer
+		conn, err := d.DialContext(ctx, "tcp", addr)
+		if err != nil {
+			return 0, "", err
+		}
+		defer conn.Close()
+		if deadline, ok := ctx.Deadline(); ok {
+			conn.SetDeadline(deadline)
+		}
+		if _, err := io.WriteString(conn, line+"\n"); err != nil {
+			return 0, "", err
+		}
+		reply, err := bufio.NewReader(conn).ReadString('\n')
+		if err != nil {
+			return 0, reply, err
+		}
+		return 0, strings.TrimSuffix(reply, "\n"), nil
+	}
+}
+
+// Result is what one request of a Loop saw.
+type Result struct {
+	Start   time.Time
+	Latency time.Duration
+	Status  int // as the Request returned it
+	Body    string
+	Err     error
+	PID     int // the pid=N in Body, or 0 if it has none
+}
+
+// End is when the request finished.
+func (r Result) End() time.Time { return r.Start.Add(r.Latency) }
+
+func (r Result) String() string {
+	if r.Err != nil {
+		return fmt.Sprintf("%s +%s failed: %v", r.Start.Format("15:04:05.000"), r.Latency, r.Err)
+	}
+	return fmt.Sprintf("%s +%s status=%d pid=%d", r.Start.Format("15:04:05.000"), r.Latency, r.Status, r.PID)
+}
+
+// pidRE finds the pid in a response of the demos, which all say pid=N.
+var pidRE = regexp.MustCompile(`\bpid=(\d+)`)
+
+// Loop sends requests in the background until it is stopped.
+type Loop struct {
+	stop chan struct{}
+	wg   sync.WaitGroup
+
+	mu      sync.Mutex
+	results []Result
+}
+
+// StartLoop starts a request every interval, without waiting for earlier
+// ones to finish, so that slow requests do not hold back the ones that
+// would show a restart.
+func StartLoop(req Request, interval time.Duration) *Loop {
+	l := &Loop{stop: make(chan struct{})}
+	l.wg.Add(1)
+	go func() {
+		defer l.wg.Done()
+		tick := time.NewTicker(interval)
+		defer tick.Stop()
+		for {
+			l.wg.Add(1)
+			go l.send(req)
+			select {
+			case <-l.stop:
+				return
+			case <-tick.C:
+			}
+		}
+	}()
+	return l
+}
+
+// send sends one request and records its result.
+func (l *Loop) send(req Request) {
+	defer l.wg.Done()
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+	start := time.Now()
+	status, body, err := req(ctx)
+	r := Result{Start: start, Latency: time.Since(start), Status: status, Body: body, Err: err}
+	if m := pidRE.FindStringSubmatch(body); m != nil {
+		r.PID, _ = strconv.Atoi(m[1])
+	}
+	l.mu.Lock()
+	l.results = append(l.results, r)
+	l.mu.Unlock()
+}
+
+// Stop stops sending requests, waits for those in flight to finish, and
+// returns every result in the order the requests started.
+func (l *Loop) Stop() []Result {
+	close(l.stop)
+	l.wg.Wait()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	sort.Slice(l.results, func(i, j int) bool { return l.results[i].Start.Before(l.results[j].Start) })
+	return l.results
+}
@@ -0,0 +1,84 @@
+package restarttest
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestLoopHTTP(t *testing.T) {
+	var n int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := atomic.AddInt64(&n, 1)
+		if id == 3 {
+			http.Error(w, "overloaded", http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprintf(w, "hello world pid=42 req=%d\n", id)
+	}))
+	defer srv.Close()
+
+	l := StartLoop(HTTPGet(srv.URL), 10*time.Millisecond)
+	time.Sleep(100 * time.Millisecond)
+	rs := l.Stop()
+
+	if len(rs) < 4 {
+		t.Fatalf("%d results, want at least 4", len(rs))
+	}
+	var failed int
+	for i, r := range rs {
+		if i > 0 && r.Start.Before(rs[i-1].Start) {
+			t.Errorf("result %d started before result %d", i, i-1)
+		}
+		switch {
+		case r.Err != nil:
+			failed++
+			if r.Status != http.StatusServiceUnavailable {
+				t.Errorf("failed request: status %d, want 503", r.Status)
+			}
+		case r.PID != 42 || r.Status != http.StatusOK:
+			t.Errorf("result %v: want status 200 from pid 42", r)
+		}
+	}
+	if failed != 1 {
+		t.Errorf("%d failed requests, want 1", failed)
+	}
+}
+
+func TestLoopTCPLine(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			line, _ := bufio.NewReader(c).ReadString('\n')
+			fmt.Fprintf(c, "pid=7 echo %s", line)
+			c.Close()
+		}
+	}()
+
+	l := StartLoop(TCPLine(ln.Addr().String(), "ping"), 10*time.Millisecond)
+	time.Sleep(50 * time.Millisecond)
+	rs := l.Stop()
+
+	if len(rs) == 0 {
+		t.Fatal("no results")
+	}
+	for _, r := range rs {
+		if r.Err != nil || r.PID != 7 || !strings.HasSuffix(r.Body, "echo ping") {
+			t.Errorf("result %v body %q: want pid 7 echoing ping", r, r.Body)
+		}
+	}
+}
@@ -0,0 +1,182 @@
+//go:build linux || darwin
+
+package restarttest
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// Build compiles the main package in dir into a binary in a temporary
+// directory, and returns the binary's path.
+func Build(t testing.TB, dir string) string {
+	t.Helper()
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bin := filepath.Join(t.TempDir(), filepath.Base(abs))
+	cmd := exec.Command("go", "build", "-o", bin, ".")
+	cmd.Dir = abs
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("building %s: %v\n%s", dir, err, out)
+	}
+	return bin
+}
+
+// FreeAddr returns a loopback address with a port nothing listens on.
+func FreeAddr(t testing.TB) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	return l.Addr().String()
+}
+
+// Process is a started binary, along with every process it starts, such
+// as the generations that replace it.
+type Process struct {
+	// PID is the process Start started.
+	PID int
+
+	t      testing.TB
+	cmd    *exec.Cmd
+	exited chan struct{} // closed once the started process has exited
+	err    error         // what cmd.Wait returned, once exited is closed
+
+	mu     sync.Mutex
+	out    bytes.Buffer
+	outEOF chan struct{} // closed once every process has closed its output
+}
+
+// Start starts bin with args in a temporary working directory, with env
+// added to the test's environment. Everything the process writes, and its
+// children write, to stdout and stderr is captured, and logged if the test
+// fails. When the test ends, the process and its children are killed.
+func Start(t testing.TB, bin string, env []string, args ...string) *Process {
+	t.Helper()
+	// Children inherit a pipe's write end, so its read end only ends once
+	// every generation has exited, not just the first.
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmd := exec.Command(bin, args...)
+	cmd.Dir = t.TempDir()
+	cmd.Env = append(os.Environ(), env...)
+	cmd.Stdout = w
+	cmd.Stderr = w
+	// Its own process group, which its children join, so one kill reaches
+	// them all.
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	if err := cmd.Start(); err != nil {
+		r.Close()
+		w.Close()
+		t.Fatalf("starting %s: %v", bin, err)
+	}
+	w.Close()
+
+	p := &Process{
+		PID:    cmd.Process.Pid,
+		t:      t,
+		cmd:    cmd,
+		exited: make(chan struct{}),
+		outEOF: make(chan struct{}),
+	}
+	go func() {
+		io.Copy(p, r)
+		r.Close()
+		close(p.outEOF)
+	}()
+	go func() {
+		p.err = cmd.Wait()
+		close(p.exited)
+	}()
+	t.Cleanup(p.kill)
+	return p
+}
+
+// Write captures output.
+func (p *Process) Write(b []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.out.Write(b)
+}
+
+// Output is what the processes have written so far.
+func (p *Process) Output() string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.out.String()
+}
+
+// Signal sends sig to the started process.
+func (p *Process) Signal(sig syscall.Signal) {
+	p.t.Helper()
+	Signal(p.t, p.PID, sig)
+}
+
+// Signal sends sig to pid, such as a generation that replaced the process
+// a test started.
+func Signal(t testing.TB, pid int, sig syscall.Signal) {
+	t.Helper()
+	if err := syscall.Kill(pid, sig); err != nil {
+		t.Fatalf("kill -%d %d: %v", sig, pid, err)
+	}
+}
+
+// WaitExit waits up to timeout for the started process to exit. It returns
+// nil if the process exited with status 0, and an *exec.ExitError if it
+// exited otherwise.
+func (p *Process) WaitExit(timeout time.Duration) error {
+	select {
+	case <-p.exited:
+		return p.err
+	case <-time.After(timeout):
+		return fmt.Errorf("pid %d still running after %s", p.PID, timeout)
+	}
+}
+
+// WaitOutput waits up to timeout for substr to appear in the output.
+func (p *Process) WaitOutput(substr string, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for !strings.Contains(p.Output(), substr) {
+		if time.Now().After(deadline) {
+			return fmt.Errorf("no %q in the output after %s", substr, timeout)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	return nil
+}
+
+// kill kills the process group, and logs the output if the test failed.
+func (p *Process) kill() {
+	// The group outlives its leader while a child is in it; ESRCH means it
+	// is already gone.
+	if err := syscall.Kill(-p.PID, syscall.SIGKILL); err != nil && !errors.Is(err, syscall.ESRCH) {
+		p.t.Errorf("killing process group %d: %v", p.PID, err)
+	}
+	<-p.exited
+	select {
+	case <-p.outEOF:
+	case <-time.After(5 * time.Second):
+		// A child that left the group still holds the pipe.
+		p.t.Errorf("output of pid %d still open after killing its group", p.PID)
+	}
+	if p.t.Failed() {
+		p.t.Logf("output of pid %d and its children:\n%s", p.PID, p.Output())
+	}
+}
@@ -0,0 +1,9 @@
+module goexp/iowait
+
+go 1.22
+
+require goexp/internal v0.0.0
+
+// The phase and write latency distributions use the Summary shared with
+// sendfl and tcpqueue.
+replace goexp/internal => ../internal
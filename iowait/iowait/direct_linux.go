@@ -0,0 +1,18 @@
+//go:build linux
+
+package iowait
+
+import (
+	"os"
+	"syscall"
+)
+
+// openDirect opens path with O_DIRECT added to flag. Filesystems without
+// direct I/O support fail with EINVAL.
+func openDirect(path string, flag int) (*os.File, error) {
+	fd, err := syscall.Open(path, flag|syscall.O_DIRECT|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, &os.PathError{Op: "open", Path: path, Err: err}
+	}
+	return os.NewFile(uintptr(fd), path), nil
+}
@@ -0,0 +1,13 @@
+//go:build !linux
+
+package iowait
+
+import (
+	"errors"
+	"os"
+)
+
+// openDirect is only implemented on linux.
+func openDirect(path string, flag int) (*os.File, error) {
+	return nil, &os.PathError{Op: "open", Path: path, Err: errors.New("O_DIRECT is not supported on this platform")}
+}
@@ -0,0 +1,67 @@
+package iowait
+
+import (
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Modes for -assignment.
+const (
+	assignModulo = "modulo" // goroutine n appends to file n%N
+	assignRandom = "random" // each goroutine picks a file at random once
+)
+
+// target is one of the -files files and what serializes the appends to it:
+// its own locker, or its own writer under -strategy=channel.
+type target struct {
+	index  int
+	path   string
+	lk     locker
+	w      *fileWriter
+	offset int64 // where this run's lines start, for verify
+}
+
+// filePaths names the n files based on path: path itself for one file, and
+// otherwise path with -0, -1, ... before the extension.
+func filePaths(path string, n int) []string {
+	if n <= 1 {
+		return []string{path}
+	}
+	ext := filepath.Ext(path)
+	base := strings.TrimSuffix(path, ext)
+	paths := make([]string, n)
+	for i := range paths {
+		paths[i] = fmt.Sprintf("%s-%d%s", base, i, ext)
+	}
+	return paths
+}
+
+// newTargets returns a target with its own locker for each of cfg's files.
+func newTargets(cfg *config) []*target {
+	paths := filePaths(cfg.file, cfg.files)
+	targets := make([]*target, len(paths))
+	for i, p := range paths {
+		targets[i] = &target{index: i, path: p, lk: newStrategyLocker(cfg.strategy, cfg.lock)}
+	}
+	return targets
+}
+
+// assign returns the index of the file goroutine n appends to.
+func assign(cfg *config, n, files int) int {
+	if cfg.assignment == assignRandom {
+		return rand.Intn(files)
+	}
+	return n % files
+}
+
+// removeFiles deletes the targets' files for -rm-on-exit.
+func removeFiles(targets []*target) {
+	for _, t := range targets {
+		if err := os.Remove(t.path); err != nil {
+			fmt.Printf("Error removing file: %v\n", err)
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package iowait
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFilePaths(t *testing.T) {
+	for _, tc := range []struct {
+		path string
+		n    int
+		want []string
+	}{
+		{"mydir/myfile.txt", 1, []string{"mydir/myfile.txt"}},
+		{"mydir/myfile.txt", 3, []string{"mydir/myfile-0.txt", "mydir/myfile-1.txt", "mydir/myfile-2.txt"}},
+		{"out/log", 2, []string{"out/log-0", "out/log-1"}},
+	} {
+		if got := filePaths(tc.path, tc.n); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("filePaths(%q, %d) = %q, want %q", tc.path, tc.n, got, tc.want)
+		}
+	}
+}
+
+func TestSpreadOverFiles(t *testing.T) {
+	for _, strategy := range []string{strategyMutex, strategyChannel} {
+		for _, assignment := range []string{assignModulo, assignRandom} {
+			t.Run(strategy+"/"+assignment, func(t *testing.T) {
+				cfg := config{
+					workers:    6,
+					file:       filepath.Join(t.TempDir(), "f.txt"),
+					files:      3,
+					assignment: assignment,
+					strategy:   strategy,
+					lock:       lockMutex,
+					readMode:   readFull,
+					holdLock:   true,
+					rounds:     3,
+				}
+				targets := newTargets(&cfg)
+				for _, tg := range targets {
+					createFile(tg.path, 0)
+				}
+				stats := runWorkers(context.Background(), &cfg, targets)
+
+				var out bytes.Buffer
+				report(&out, &cfg, targets, stats, time.Second)
+				if err := verify(&out, &cfg, targets, stats); err != nil {
+					t.Fatal(err)
+				}
+				if want := "(strategy=" + strategy + ", files=3): 18 lines, 0 torn, every goroutine's appends accounted for"; !strings.Contains(out.String(), want) {
+					t.Errorf("output lacks %q:\n%s", want, out.String())
+				}
+				// Each file's line count matches the rounds the report gives it.
+				rounds, goroutines := make([]int, len(targets)), make([]int, len(targets))
+				for _, s := range stats[1:] {
+					rounds[s.file] += s.rounds
+					goroutines[s.file]++
+				}
+				for i, tg := range targets {
+					data, err := os.ReadFile(tg.path)
+					if err != nil {
+						t.Fatal(err)
+					}
+					if got := bytes.Count(data, []byte("\n")); got != rounds[i] {
+						t.Errorf("%s has %d lines, want %d", tg.path, got, rounds[i])
+					}
+					if assignment == assignModulo && rounds[i] != 2*cfg.rounds {
+						t.Errorf("%s got %d rounds, want 2 goroutines' worth", tg.path, rounds[i])
+					}
+					row := fmt.Sprintf("%s %d %d", tg.path, goroutines[i], rounds[i])
+					if !hasRow(out.String(), row) {
+						t.Errorf("report lacks the row %q:\n%s", row, out.String())
+					}
+				}
+
+				removeFiles(targets)
+				for _, tg := range targets {
+					if _, err := os.Stat(tg.path); !os.IsNotExist(err) {
+						t.Errorf("%s still there after removeFiles: %v", tg.path, err)
+					}
+				}
+			})
+		}
+	}
+}
+
+// hasRow reports whether a line of out starts with the fields of row, with
+// any padding between them.
+func hasRow(out, row string) bool {
+	want := strings.Fields(row)
+	for _, l := range strings.Split(out, "\n") {
+		if f := strings.Fields(l); len(f) >= len(want) && reflect.DeepEqual(f[:len(want)], want) {
+			return true
+		}
+	}
+	return false
+}
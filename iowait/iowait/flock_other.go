@@ -0,0 +1,17 @@
+//go:build !unix
+
+package iowait
+
+import (
+	"errors"
+	"os"
+)
+
+var errNoFlock = errors.New("flock is not supported on this platform")
+
+type flockLocker struct{}
+
+func (flockLocker) lock(f *os.File) error {
+	return &os.PathError{Op: "flock", Path: f.Name(), Err: errNoFlock}
+}
+func (flockLocker) unlock(f *os.File) error { return nil }
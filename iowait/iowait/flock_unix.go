@@ -0,0 +1,30 @@
+//go:build unix
+
+package iowait
+
+import (
+	"os"
+	"syscall"
+)
+
+// flockLocker takes an exclusive flock on each round's descriptor. flock
+// locks belong to the open file description, so goroutines in one process
+// exclude each other as long as each opens the file itself, which every
+// round does.
+type flockLocker struct{}
+
+func (flockLocker) lock(f *os.File) error   { return flock(f, syscall.LOCK_EX) }
+func (flockLocker) unlock(f *os.File) error { return flock(f, syscall.LOCK_UN) }
+
+// flock retries when a signal interrupts the wait.
+func flock(f *os.File, how int) error {
+	for {
+		err := syscall.Flock(int(f.Fd()), how)
+		if err != syscall.EINTR {
+			if err != nil {
+				return &os.PathError{Op: "flock", Path: f.Name(), Err: err}
+			}
+			return nil
+		}
+	}
+}
@@ -0,0 +1,84 @@
+//go:build unix
+
+package iowait
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestFlockExcludesOtherDescriptors(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "f.txt")
+	createFile(path, 0)
+	f1, err := openAppend(path, syncNone)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f1.Close()
+	f2, err := openAppend(path, syncNone)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f2.Close()
+
+	lk := newLocker(lockFlock)
+	if err := lk.lock(f1); err != nil {
+		t.Fatal(err)
+	}
+	if err := flock(f2, syscall.LOCK_EX|syscall.LOCK_NB); !errors.Is(err, syscall.EWOULDBLOCK) {
+		t.Fatalf("second descriptor locked while the first holds the lock: %v", err)
+	}
+	if err := lk.unlock(f1); err != nil {
+		t.Fatal(err)
+	}
+	if err := flock(f2, syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		t.Fatalf("second descriptor still locked out after unlock: %v", err)
+	}
+}
+
+// Two pools in one process stand in for two copies of the program.
+func TestFlockPoolsBothProgress(t *testing.T) {
+	cfg := config{
+		workers:  3,
+		sleep:    time.Millisecond,
+		file:     filepath.Join(t.TempDir(), "f.txt"),
+		strategy: strategyMutex,
+		lock:     lockFlock,
+		readMode: readFull,
+		rounds:   5,
+	}
+	createFile(cfg.file, 0)
+
+	var wg sync.WaitGroup
+	pools := make([][]*workerStats, 2)
+	for i := range pools {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			pools[i] = runWorkers(context.Background(), &cfg, newTargets(&cfg))
+		}()
+	}
+	wg.Wait()
+
+	for i, stats := range pools {
+		for n := 1; n <= cfg.workers; n++ {
+			if s := stats[n]; s == nil || s.rounds != cfg.rounds || s.count[phaseLock] != cfg.rounds {
+				t.Errorf("pool %d goroutine %d: %+v, want %d rounds", i, n, s, cfg.rounds)
+			}
+		}
+	}
+	data, err := os.ReadFile(cfg.file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := bytes.Count(data, []byte("\n")), 2*cfg.workers*cfg.rounds; got != want {
+		t.Errorf("file has %d lines, want %d", got, want)
+	}
+}
@@ -0,0 +1,86 @@
+package iowait
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Modes for -fsync, besides every-N.
+const (
+	fsyncNever      = "never"
+	fsyncEveryWrite = "every-write"
+)
+
+// parseFsync turns a -fsync value into how many appends go between
+// fsyncs: 0 for never, 1 for every-write, N for every-N.
+func parseFsync(s string) (int, error) {
+	switch s {
+	case fsyncNever:
+		return 0, nil
+	case fsyncEveryWrite:
+		return 1, nil
+	}
+	if v, ok := strings.CutPrefix(s, "every-"); ok {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			return n, nil
+		}
+	}
+	return 0, fmt.Errorf("-fsync must be never, every-write or every-N with N at least 1")
+}
+
+// syncIfDue fsyncs f if writes, the count of appends made through it so far,
+// is a multiple of every, and records that in res.
+func syncIfDue(f *os.File, every, writes int, res *writeResult) {
+	if every <= 0 || writes%every != 0 {
+		return
+	}
+	start := time.Now()
+	res.syncErr = f.Sync()
+	res.synced, res.syncTook = true, time.Since(start)
+}
+
+// fsyncBounds are the upper bounds of the fsync latency buckets; the last
+// bucket takes everything slower.
+var fsyncBounds = [...]time.Duration{time.Millisecond, 5 * time.Millisecond, 20 * time.Millisecond, 100 * time.Millisecond}
+
+const numFsyncBuckets = len(fsyncBounds) + 1
+
+var fsyncBucketNames = [numFsyncBuckets]string{"<1ms", "1-5ms", "5-20ms", "20-100ms", ">100ms"}
+
+// fsyncBucket returns the bucket d falls in.
+func fsyncBucket(d time.Duration) int {
+	for i, b := range fsyncBounds {
+		if d < b {
+			return i
+		}
+	}
+	return len(fsyncBounds)
+}
+
+// fsyncHistogram counts fsyncs per latency bucket. It is atomic so the
+// telemetry reporter can read it while the workers add to it.
+type fsyncHistogram [numFsyncBuckets]atomic.Int64
+
+func (h *fsyncHistogram) record(d time.Duration) {
+	h[fsyncBucket(d)].Add(1)
+}
+
+func (h *fsyncHistogram) counts() (c [numFsyncBuckets]int64) {
+	for i := range h {
+		c[i] = h[i].Load()
+	}
+	return c
+}
+
+// formatBuckets prints bucket counts as "<1ms=3 1-5ms=0 ...".
+func formatBuckets(c [numFsyncBuckets]int64) string {
+	parts := make([]string, len(c))
+	for i, n := range c {
+		parts[i] = fmt.Sprintf("%s=%d", fsyncBucketNames[i], n)
+	}
+	return strings.Join(parts, " ")
+}
@@ -0,0 +1,114 @@
+package iowait
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseFsync(t *testing.T) {
+	for _, tc := range []struct {
+		in    string
+		every int
+		ok    bool
+	}{
+		{"never", 0, true},
+		{"every-write", 1, true},
+		{"every-1", 1, true},
+		{"every-50", 50, true},
+		{"every-0", 0, false},
+		{"every--3", 0, false},
+		{"every-", 0, false},
+		{"always", 0, false},
+		{"", 0, false},
+	} {
+		every, err := parseFsync(tc.in)
+		if (err == nil) != tc.ok || every != tc.every {
+			t.Errorf("parseFsync(%q) = %d, %v; want %d, ok=%v", tc.in, every, err, tc.every, tc.ok)
+		}
+	}
+}
+
+func TestFsyncBucket(t *testing.T) {
+	for d, want := range map[time.Duration]int{
+		0:                      0,
+		999 * time.Microsecond: 0,
+		time.Millisecond:       1,
+		19 * time.Millisecond:  2,
+		20 * time.Millisecond:  3,
+		100 * time.Millisecond: 4,
+		time.Minute:            4,
+	} {
+		if got := fsyncBucket(d); got != want {
+			t.Errorf("fsyncBucket(%s) = %s, want %s", d, fsyncBucketNames[got], fsyncBucketNames[want])
+		}
+	}
+}
+
+func TestFsyncEveryN(t *testing.T) {
+	for _, tc := range []struct {
+		strategy string
+		want     int // fsyncs across all workers
+	}{
+		// Each of the 3 goroutines counts its own 4 appends.
+		{strategyMutex, 3 * 2},
+		// The writer counts all 12.
+		{strategyChannel, 12 / 2},
+	} {
+		t.Run(tc.strategy, func(t *testing.T) {
+			cfg := config{
+				workers:    3,
+				file:       filepath.Join(t.TempDir(), "f.txt"),
+				strategy:   tc.strategy,
+				lock:       lockMutex,
+				fsync:      "every-2",
+				fsyncEvery: 2,
+				readMode:   readNone,
+				rounds:     4,
+			}
+			createFile(cfg.file, 0)
+			stats := runWorkers(context.Background(), &cfg, newTargets(&cfg))
+			fsyncs := 0
+			for _, s := range stats[1:] {
+				fsyncs += s.count[phaseFsync]
+				if s.fsyncErrors != 0 {
+					t.Errorf("goroutine %d: fsync failed: %v", s.worker, s.lastFsyncErr)
+				}
+			}
+			if fsyncs != tc.want {
+				t.Errorf("%d fsyncs, want %d", fsyncs, tc.want)
+			}
+
+			var out bytes.Buffer
+			report(&out, &cfg, nil, stats, time.Second)
+			if !strings.Contains(out.String(), "Fsyncs (fsync=every-2): n=") {
+				t.Errorf("report has no fsync histogram:\n%s", out.String())
+			}
+		})
+	}
+}
+
+func TestReportFsyncErrors(t *testing.T) {
+	ok, bad := &workerStats{worker: 1}, &workerStats{worker: 2}
+	ok.addFsync(2*time.Millisecond, nil)
+	bad.addFsync(time.Microsecond, nil)
+	bad.addFsync(200*time.Millisecond, errors.New("input/output error"))
+
+	var out bytes.Buffer
+	report(&out, &config{fsync: fsyncEveryWrite, fsyncEvery: 1}, nil, []*workerStats{nil, ok, bad}, time.Second)
+	for _, want := range []string{
+		"Fsyncs (fsync=every-write): n=3 <1ms=1 1-5ms=1 5-20ms=0 20-100ms=0 >100ms=1\n",
+		"  goroutine 2: 1 of 2 fsyncs failed, last: input/output error\n",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("report lacks %q:\n%s", want, out.String())
+		}
+	}
+	if strings.Contains(out.String(), "goroutine 1:") {
+		t.Errorf("report blames goroutine 1:\n%s", out.String())
+	}
+}
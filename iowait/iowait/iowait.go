@@ -0,0 +1,451 @@
+// Package iowait makes goroutines contend for appending to a file, to show
+// where the time goes: lock waits, writes, fsyncs and read-backs.
+package iowait
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"math/rand"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// flags are the command line of Run.
+var flags = flag.NewFlagSet("iowait", flag.ExitOnError)
+
+var (
+	workers     = flags.Int("workers", 3000, "goroutines contending for the file")
+	sleep       = flags.Duration("sleep", 50*time.Second, "how long each round sleeps, holding the lock unless -hold-lock-during-sleep=false")
+	file        = flags.String("file", "mydir/myfile.txt", "file to append to; its directory is created if missing")
+	files       = flags.Int("files", 1, "spread the goroutines over this many files, named after -file with -0, -1, ... before the extension")
+	assignment  = flags.String("assignment", assignModulo, "which file a goroutine appends to: modulo (goroutine n gets file n%N) or random")
+	rmOnExit    = flags.Bool("rm-on-exit", false, "remove the files at the end of the run")
+	initialSize = flags.Int("initial-size", 1024, "bytes of random text the file starts with")
+	lineSize    = flags.Int("line-size", 0, "pad each appended line with random text to this many bytes (0 = just \"Goroutine N\")")
+	strategy    = flags.String("strategy", strategyMutex, "how appends are serialized: mutex (rounds take turns through -lock), channel (one writer goroutine owns the file) or append (O_APPEND, no locking)")
+	lockMode    = flags.String("lock", lockMutex, "how rounds take turns: mutex (this process only) or flock (LOCK_EX on the file, across processes)")
+	syncMode    = flags.String("sync-mode", syncNone, "how appends reach the disk: none (page cache), osync (O_SYNC) or odirect (O_DIRECT; sizes are rounded up to 4096)")
+	readMode    = flags.String("read-mode", readFull, "what each round reads back: full (the whole file), head (the first -read-buf bytes) or none")
+	fsyncMode   = flags.String("fsync", fsyncNever, "fsync after appends: never, every-write or every-N (after every Nth append of a goroutine, or of the writer with -strategy=channel)")
+	readBuf     = flags.Int("read-buf", 64<<10, "bytes per read when reading back; 0 makes -read-mode=full load the file with os.ReadFile")
+
+	holdLock       = flags.Bool("hold-lock-during-sleep", true, "with -strategy=mutex, keep the lock through the sleep rather than releasing it after the read-back")
+	reportInterval = flags.Duration("report-interval", 10*time.Second, "print a telemetry line this often while running (0 = never)")
+
+	iterations = flags.Int("iterations", 1, "rounds each goroutine runs before it finishes")
+	forever    = flags.Bool("forever", false, "keep running rounds until interrupted, ignoring -iterations")
+)
+
+// config is one run's settings, taken from the flags.
+type config struct {
+	workers     int
+	sleep       time.Duration
+	file        string
+	files       int
+	assignment  string
+	initialSize int
+	lineSize    int
+	strategy    string
+	lock        string
+	syncMode    string
+	fsync       string
+	fsyncEvery  int // appends between fsyncs, from fsync; 0 means never
+	readMode    string
+	readBuf     int
+	holdLock    bool
+	reportEvery time.Duration
+	rounds      int // per worker; 0 means until interrupted
+}
+
+func (c config) String() string {
+	rounds := fmt.Sprint(c.rounds)
+	if c.rounds == 0 {
+		rounds = "forever"
+	}
+	return fmt.Sprintf("workers=%d sleep=%s file=%s files=%d assignment=%s initial-size=%d line-size=%d strategy=%s lock=%s sync-mode=%s fsync=%s read-mode=%s read-buf=%d hold-lock-during-sleep=%t report-interval=%s iterations=%s",
+		c.workers, c.sleep, c.file, c.files, c.assignment, c.initialSize, c.lineSize, c.strategy, c.lock, c.syncMode, c.fsync, c.readMode, c.readBuf, c.holdLock, c.reportEvery, rounds)
+}
+
+// Run runs the workers until they have done their rounds, or are
+// interrupted, and prints the report.
+func Run(args []string) error {
+	flags.Parse(args)
+	cfg := config{
+		workers:     *workers,
+		sleep:       *sleep,
+		file:        *file,
+		files:       *files,
+		assignment:  *assignment,
+		initialSize: *initialSize,
+		lineSize:    *lineSize,
+		strategy:    *strategy,
+		lock:        *lockMode,
+		syncMode:    *syncMode,
+		fsync:       *fsyncMode,
+		readMode:    *readMode,
+		readBuf:     *readBuf,
+		holdLock:    *holdLock,
+		reportEvery: *reportInterval,
+		rounds:      *iterations,
+	}
+	if *forever {
+		cfg.rounds = 0
+	}
+	if err := cfg.validate(*forever); err != nil {
+		return err
+	}
+	cfg.fsyncEvery, _ = parseFsync(cfg.fsync)
+	if cfg.syncMode == syncODirect {
+		// Every write, and so the file size, has to stay block aligned.
+		cfg.initialSize = roundUp(cfg.initialSize)
+		cfg.lineSize = roundUp(max(cfg.lineSize, 1))
+	}
+
+	// Ctrl+C or SIGTERM ends the run, including -forever ones, with the
+	// usual summary. A second one exits on the spot.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sigs := make(chan os.Signal, 2)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sigs
+		fmt.Printf("Stopping: goroutines finish their current round (waiting up to %s). Interrupt again to exit now.\n", stopGrace)
+		cancel()
+		<-sigs
+		fmt.Println("Interrupted again, exiting without a summary.")
+		os.Exit(130)
+	}()
+
+	// Create the directory if it doesn't exist
+	if err := os.MkdirAll(filepath.Dir(cfg.file), os.ModePerm); err != nil {
+		return fmt.Errorf("creating directory: %w", err)
+	}
+
+	// Create the files with some random text, unless other copies of the
+	// program may already be appending to them
+	targets := newTargets(&cfg)
+	for _, t := range targets {
+		if _, err := os.Stat(t.path); err == nil && cfg.lock == lockFlock {
+			fmt.Printf("Appending to the existing %s, which -lock=flock shares with other processes\n", t.path)
+		} else {
+			createFile(t.path, cfg.initialSize)
+		}
+		// This run's lines start here, which is where verify looks for them.
+		if fi, err := os.Stat(t.path); err == nil {
+			t.offset = fi.Size()
+		}
+	}
+	if cfg.syncMode == syncODirect {
+		f, err := openAppend(targets[0].path, cfg.syncMode)
+		if err != nil {
+			fmt.Printf("O_DIRECT unavailable (%v), falling back to -sync-mode=%s\n", err, syncOSync)
+			cfg.syncMode = syncOSync
+		} else {
+			f.Close()
+		}
+	}
+	fmt.Println("Config:", cfg)
+
+	start := time.Now()
+	stats := runWorkers(ctx, &cfg, targets)
+	report(os.Stdout, &cfg, targets, stats, time.Since(start))
+	if err := verify(os.Stdout, &cfg, targets, stats); err != nil {
+		fmt.Printf("Error verifying the files: %v\n", err)
+	}
+	if *rmOnExit {
+		removeFiles(targets)
+	}
+
+	if ctx.Err() != nil {
+		for _, s := range stats[1:] {
+			if s == nil {
+				// Exiting abandons them mid-round.
+				fmt.Println("Interrupted, some goroutines were still busy.")
+				return nil
+			}
+		}
+		fmt.Println("Interrupted, all goroutines stopped.")
+		return nil
+	}
+	fmt.Println("All goroutines finished.")
+	return nil
+}
+
+func (c config) validate(forever bool) error {
+	switch {
+	case c.workers < 1:
+		return fmt.Errorf("-workers must be at least 1")
+	case c.sleep < 0:
+		return fmt.Errorf("-sleep must not be negative")
+	case c.file == "":
+		return fmt.Errorf("-file must not be empty")
+	case c.files < 1:
+		return fmt.Errorf("-files must be at least 1")
+	case c.assignment != assignModulo && c.assignment != assignRandom:
+		return fmt.Errorf("-assignment must be modulo or random")
+	case c.initialSize < 0:
+		return fmt.Errorf("-initial-size must not be negative")
+	case c.lineSize < 0:
+		return fmt.Errorf("-line-size must not be negative")
+	case c.strategy != strategyMutex && c.strategy != strategyChannel && c.strategy != strategyAppend:
+		return fmt.Errorf("-strategy must be mutex, channel or append")
+	case c.lock != lockMutex && c.lock != lockFlock:
+		return fmt.Errorf("-lock must be mutex or flock")
+	case c.lock == lockFlock && c.strategy != strategyMutex:
+		return fmt.Errorf("-lock=flock needs -strategy=mutex")
+	case c.syncMode != syncNone && c.syncMode != syncOSync && c.syncMode != syncODirect:
+		return fmt.Errorf("-sync-mode must be none, osync or odirect")
+	case c.readMode != readFull && c.readMode != readHead && c.readMode != readNone:
+		return fmt.Errorf("-read-mode must be full, head or none")
+	case c.readBuf < 0:
+		return fmt.Errorf("-read-buf must not be negative")
+	case c.reportEvery < 0:
+		return fmt.Errorf("-report-interval must not be negative")
+	case c.readMode == readHead && c.readBuf == 0:
+		return fmt.Errorf("-read-mode=head needs a -read-buf")
+	case !forever && c.rounds < 1:
+		return fmt.Errorf("-iterations must be at least 1")
+	}
+	_, err := parseFsync(c.fsync)
+	return err
+}
+
+func createFile(path string, size int) {
+	file, err := os.Create(path)
+	if err != nil {
+		fmt.Printf("Error creating file: %v\n", err)
+		return
+	}
+	defer file.Close()
+
+	randomText := generateRandomText(size)
+	_, err = file.WriteString(randomText)
+	if err != nil {
+		fmt.Printf("Error writing to file: %v\n", err)
+	}
+}
+
+func generateRandomText(size int) string {
+	const letters = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ"
+	b := make([]byte, size)
+	for i := range b {
+		b[i] = letters[rand.Intn(len(letters))]
+	}
+	return string(b)
+}
+
+// line is what goroutineNumber appends each round: "Goroutine N", padded
+// with a space and random text to size bytes when it is longer than that,
+// and a newline.
+func line(goroutineNumber, size int) string {
+	label := fmt.Sprintf("Goroutine %d", goroutineNumber)
+	if pad := size - len(label) - 2; pad > 0 {
+		label += " " + generateRandomText(pad)
+	}
+	return label + "\n"
+}
+
+// stopGrace is how long a cancelled run waits for the goroutines to finish
+// their current round. A sleep ends at once, but one blocked on flock or a
+// slow write may not.
+const stopGrace = 5 * time.Second
+
+// runWorkers starts cfg.workers goroutines, each assigned to one of the
+// targets and taking turns through its locker, or through its fileWriter for
+// the channel strategy. It waits for all of them to finish and returns their
+// stats by goroutine number. Once ctx is done it waits at most stopGrace;
+// goroutines that have not finished by then are left nil. Meanwhile a
+// telemetry line is printed every cfg.reportEvery.
+func runWorkers(ctx context.Context, cfg *config, targets []*target) []*workerStats {
+	stats := make([]*workerStats, cfg.workers+1)
+	if cfg.strategy == strategyChannel {
+		for _, t := range targets {
+			var err error
+			if t.w, err = startWriter(t.path, cfg.syncMode, cfg.lineSize, cfg.fsyncEvery); err != nil {
+				fmt.Printf("Error opening file: %v\n", err)
+				return stats
+			}
+		}
+	}
+	live := &telemetry{start: time.Now(), fsync: cfg.fsyncEvery > 0}
+	if cfg.reportEvery > 0 {
+		paths := make([]string, len(targets))
+		for i, t := range targets {
+			paths[i] = t.path
+		}
+		stop, stopped := make(chan struct{}), make(chan struct{})
+		go func() {
+			defer close(stopped)
+			live.report(os.Stdout, paths, cfg.reportEvery, stop)
+		}()
+		defer func() {
+			close(stop)
+			<-stopped
+		}()
+	}
+
+	type result struct {
+		n     int
+		stats *workerStats
+	}
+	// Buffered, so a goroutine finishing after the deadline does not block.
+	done := make(chan result, cfg.workers)
+	for i := 1; i <= cfg.workers; i++ {
+		t := targets[assign(cfg, i, len(targets))]
+		go func(n int) {
+			done <- result{n, modifyFile(ctx, cfg, t, live, n)}
+		}(i)
+	}
+
+	stopping := ctx.Done()
+	var deadline <-chan time.Time
+	for left := cfg.workers; left > 0; {
+		select {
+		case r := <-done:
+			stats[r.n] = r.stats
+			left--
+		case <-stopping:
+			stopping = nil
+			deadline = time.After(stopGrace)
+		case <-deadline:
+			// The writers stay open for them; the program is about to exit.
+			fmt.Printf("%d goroutines still busy after %s, reporting without them\n", left, stopGrace)
+			return stats
+		}
+	}
+	for _, t := range targets {
+		if t.w != nil {
+			t.w.close()
+		}
+	}
+	return stats
+}
+
+// modifyFile runs cfg.rounds rounds on t's file, or until ctx is done if
+// that is 0, counting them in live, and returns how long each part of them
+// took.
+func modifyFile(ctx context.Context, cfg *config, t *target, live *telemetry, goroutineNumber int) *workerStats {
+	stats := &workerStats{worker: goroutineNumber, file: t.index, live: live}
+	var writeBuf []byte // O_DIRECT needs an aligned one
+	if cfg.syncMode == syncODirect {
+		writeBuf = alignedBuffer(cfg.lineSize)
+	}
+	readBuf := make([]byte, cfg.readBuf) // reused by every round's read-back
+	for ; cfg.rounds == 0 || stats.rounds < cfg.rounds; stats.rounds++ {
+		if !round(ctx, cfg, t, stats, writeBuf, readBuf, goroutineNumber) {
+			break
+		}
+		stats.live.rounds.Add(1)
+	}
+	return stats
+}
+
+// round appends a line, reads the file back and sleeps. With the mutex
+// strategy it holds the lock for the append and the read-back, and for the
+// sleep too unless -hold-lock-during-sleep is off; the lock is released
+// however the round ends. A cancelled ctx cuts the sleep short. It reports
+// whether the worker should go on.
+func round(ctx context.Context, cfg *config, t *target, stats *workerStats, writeBuf, readBuf []byte, goroutineNumber int) bool {
+	fmt.Println("waiting go routine ", goroutineNumber)
+	release, ok := appendLine(ctx, cfg, t, stats, writeBuf, goroutineNumber)
+	if !ok {
+		return false
+	}
+	defer release()
+
+	// Simulate I/O wait: Read the file's contents
+	if cfg.readMode != readNone {
+		start := time.Now()
+		read, err := readBack(t.path, cfg.readMode, readBuf)
+		stats.add(phaseRead, time.Since(start))
+		stats.bytesRead += read
+		if err != nil {
+			fmt.Printf("Error reading from file: %v\n", err)
+		}
+	}
+
+	if !cfg.holdLock {
+		release()
+	}
+	// Sleep for the specified duration
+	start := time.Now()
+	select {
+	case <-time.After(cfg.sleep):
+	case <-ctx.Done():
+	}
+	stats.add(phaseSleep, time.Since(start))
+	return true
+}
+
+// appendLine waits for the round's turn and appends its line to t's file,
+// through t's writer for the channel strategy or else under t's locker. It
+// returns a func that gives the turn back, which is safe to call more than
+// once, and false if the round should not go on.
+func appendLine(ctx context.Context, cfg *config, t *target, stats *workerStats, writeBuf []byte, goroutineNumber int) (release func(), ok bool) {
+	var res writeResult
+	release = func() {}
+	if t.w != nil {
+		if ctx.Err() != nil {
+			return nil, false
+		}
+		fmt.Println("go routine: ", goroutineNumber)
+		// Hand the goroutine number to the writer
+		res = t.w.write(line(goroutineNumber, cfg.lineSize))
+		stats.add(phaseLock, res.wait)
+	} else {
+		file, err := openAppend(t.path, cfg.syncMode)
+		if err != nil {
+			fmt.Printf("Error opening file: %v\n", err)
+			return nil, false
+		}
+		start := time.Now()
+		if err := t.lk.lock(file); err != nil {
+			fmt.Printf("Error locking file: %v\n", err)
+			file.Close()
+			return nil, false
+		}
+		var once sync.Once
+		release = func() {
+			once.Do(func() {
+				if err := t.lk.unlock(file); err != nil {
+					fmt.Printf("Error unlocking file: %v\n", err)
+				}
+				file.Close()
+			})
+		}
+		stats.add(phaseLock, time.Since(start))
+		if ctx.Err() != nil {
+			release()
+			return nil, false
+		}
+
+		fmt.Println("go routine: ", goroutineNumber)
+		// Append the goroutine number to the file
+		start = time.Now()
+		res.n, res.err = writeLine(file, writeBuf, line(goroutineNumber, cfg.lineSize))
+		res.took = time.Since(start)
+		if res.err == nil {
+			// Each round opens the file anew, so count this worker's appends.
+			syncIfDue(file, cfg.fsyncEvery, stats.lines+1, &res)
+		}
+	}
+	stats.add(phaseWrite, res.took)
+	stats.bytes += int64(res.n)
+	if res.err != nil {
+		fmt.Printf("Error writing to file: %v\n", res.err)
+	} else {
+		stats.lines++
+	}
+	if res.synced {
+		stats.addFsync(res.syncTook, res.syncErr)
+		if res.syncErr != nil {
+			fmt.Printf("Error syncing file: %v\n", res.syncErr)
+		}
+	}
+	return release, true
+}
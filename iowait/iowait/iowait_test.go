@@ -0,0 +1,94 @@
+package iowait
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCancelStopsSleepingWorkers(t *testing.T) {
+	cfg := config{
+		workers:  3,
+		sleep:    time.Hour,
+		file:     filepath.Join(t.TempDir(), "f.txt"),
+		strategy: strategyMutex,
+		lock:     lockMutex,
+		readMode: readFull,
+		holdLock: true,
+	}
+	createFile(cfg.file, 0)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	start := time.Now()
+	stats := runWorkers(ctx, &cfg, newTargets(&cfg))
+	if took := time.Since(start); took > stopGrace {
+		t.Fatalf("runWorkers took %s after the cancel", took)
+	}
+
+	// Only the goroutine that got the lock first was in a round, asleep.
+	rounds := 0
+	var appended int64
+	for n := 1; n <= cfg.workers; n++ {
+		if stats[n] == nil {
+			t.Fatalf("goroutine %d did not report back", n)
+		}
+		rounds += stats[n].rounds
+		appended += stats[n].bytes
+	}
+	if rounds != 1 || appended != int64(len("Goroutine 1\n")) {
+		t.Errorf("got %d rounds and %d bytes, want 1 round of one line", rounds, appended)
+	}
+
+	var out bytes.Buffer
+	report(&out, &cfg, nil, stats, time.Since(start))
+	for _, want := range []string{"Rounds completed: 1 (per goroutine: min 0", "Bytes appended: 12\n", "Total lock wait: "} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("report lacks %q:\n%s", want, out.String())
+		}
+	}
+}
+
+func TestHoldLockDuringSleep(t *testing.T) {
+	const sleep = 50 * time.Millisecond
+	for _, tc := range []struct {
+		hold bool
+		// The longest lock wait: a whole sleep when the holder sleeps on
+		// the lock, next to nothing when it lets go first.
+		minWait, maxWait time.Duration
+	}{
+		{true, sleep * 8 / 10, time.Hour},
+		{false, 0, sleep / 2},
+	} {
+		t.Run(fmt.Sprintf("hold=%t", tc.hold), func(t *testing.T) {
+			cfg := config{
+				workers:  2,
+				sleep:    sleep,
+				file:     filepath.Join(t.TempDir(), "f.txt"),
+				strategy: strategyMutex,
+				lock:     lockMutex,
+				readMode: readFull,
+				holdLock: tc.hold,
+				rounds:   2,
+			}
+			createFile(cfg.file, 0)
+			stats := runWorkers(context.Background(), &cfg, newTargets(&cfg))
+
+			var longest time.Duration
+			for n := 1; n <= cfg.workers; n++ {
+				s := stats[n]
+				if s.rounds != cfg.rounds || s.lines != cfg.rounds || s.count[phaseRead] != cfg.rounds {
+					t.Fatalf("goroutine %d: %+v, want %d full rounds", n, s, cfg.rounds)
+				}
+				longest = max(longest, s.max[phaseLock])
+			}
+			if longest < tc.minWait || longest > tc.maxWait {
+				t.Errorf("longest lock wait %s, want between %s and %s", longest, tc.minWait, tc.maxWait)
+			}
+		})
+	}
+}
@@ -0,0 +1,38 @@
+package iowait
+
+import (
+	"os"
+	"sync"
+)
+
+// Modes for -lock.
+const (
+	lockMutex = "mutex" // one sync.Mutex: serializes the goroutines of this process only
+	lockFlock = "flock" // flock(LOCK_EX) on the file: serializes every process using it
+)
+
+// locker serializes the rounds. f is the round's own descriptor for the
+// file, which flock locks; the mutex ignores it.
+type locker interface {
+	lock(f *os.File) error
+	unlock(f *os.File) error
+}
+
+func newLocker(mode string) locker {
+	if mode == lockFlock {
+		return flockLocker{}
+	}
+	return &mutexLocker{}
+}
+
+type mutexLocker struct{ mu sync.Mutex }
+
+func (m *mutexLocker) lock(*os.File) error {
+	m.mu.Lock()
+	return nil
+}
+
+func (m *mutexLocker) unlock(*os.File) error {
+	m.mu.Unlock()
+	return nil
+}
@@ -0,0 +1,52 @@
+package iowait
+
+import (
+	"io"
+	"os"
+)
+
+// Modes for -read-mode.
+const (
+	readFull = "full" // stream the whole file
+	readHead = "head" // read only the first -read-buf bytes
+	readNone = "none" // skip the read-back
+)
+
+// readBack reads path the way mode asks and returns how many bytes it read.
+// The data goes through buf and is thrown away, so memory stays at one
+// buffer however large the file grows. Without a buf, full mode falls back
+// to os.ReadFile.
+func readBack(path, mode string, buf []byte) (int64, error) {
+	if mode == readNone {
+		return 0, nil
+	}
+	if mode != readHead && len(buf) == 0 {
+		data, err := os.ReadFile(path)
+		return int64(len(data)), err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	if mode == readHead {
+		n, err := io.ReadFull(f, buf)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			err = nil // the file is shorter than buf
+		}
+		return int64(n), err
+	}
+	// Not io.CopyBuffer: io.Discard would read through its own buffers.
+	var total int64
+	for {
+		n, err := f.Read(buf)
+		total += int64(n)
+		if err == io.EOF {
+			return total, nil
+		}
+		if err != nil {
+			return total, err
+		}
+	}
+}
@@ -0,0 +1,35 @@
+package iowait
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestReadBack(t *testing.T) {
+	dir := t.TempDir()
+	big, small := filepath.Join(dir, "big.txt"), filepath.Join(dir, "small.txt")
+	createFile(big, 100_000)
+	createFile(small, 10)
+
+	for _, tc := range []struct {
+		name, path, mode string
+		buf              int
+		want             int64
+	}{
+		{"full streams everything", big, readFull, 4096, 100_000},
+		{"full without a buffer uses ReadFile", big, readFull, 0, 100_000},
+		{"head stops after one buffer", big, readHead, 4096, 4096},
+		{"head of a short file", small, readHead, 4096, 10},
+		{"none reads nothing", big, readNone, 4096, 0},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := readBack(tc.path, tc.mode, make([]byte, tc.buf))
+			if err != nil || got != tc.want {
+				t.Errorf("readBack = %d, %v; want %d bytes", got, err, tc.want)
+			}
+		})
+	}
+	if _, err := readBack(filepath.Join(dir, "missing"), readFull, make([]byte, 16)); err == nil {
+		t.Error("reading a missing file succeeded")
+	}
+}
@@ -0,0 +1,206 @@
+package iowait
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"goexp/internal/bench"
+)
+
+// phase is one timed part of a worker's round.
+type phase int
+
+const (
+	phaseLock phase = iota // waiting to acquire the lock
+	phaseWrite
+	phaseFsync
+	phaseRead
+	phaseSleep
+	numPhases
+)
+
+var phaseNames = [numPhases]string{"lock wait", "write", "fsync", "read", "sleep"}
+
+// topLockWaits is how many of the slowest lock acquisitions are reported.
+const topLockWaits = 5
+
+// workerStats accumulates one worker's timings. Only that worker touches it
+// until it returns, so recording costs no synchronization and does not
+// disturb the contention being measured; the totals are merged at the end.
+// The exception is live, the atomics shared with the telemetry reporter.
+type workerStats struct {
+	live *telemetry
+
+	worker int
+	file   int // index of the target it appends to
+	rounds int
+	bytes  int64 // appended to the file
+	lines  int   // appends that succeeded, for verify
+
+	bytesRead int64 // by the read-backs
+
+	count      [numPhases]int
+	total, max [numPhases]time.Duration
+	samples    [numPhases][]time.Duration // every timing, for the distributions
+
+	slowLocks []lockWait // this worker's slowest, longest first
+
+	fsyncs       [numFsyncBuckets]int64
+	fsyncErrors  int
+	lastFsyncErr error
+}
+
+// lockWait is one acquisition of the lock.
+type lockWait struct {
+	worker, round int
+	d             time.Duration
+}
+
+func (s *workerStats) add(p phase, d time.Duration) {
+	s.count[p]++
+	s.total[p] += d
+	if d > s.max[p] {
+		s.max[p] = d
+	}
+	s.samples[p] = append(s.samples[p], d)
+	if p == phaseLock {
+		s.slowLocks = keepSlowest(append(s.slowLocks, lockWait{s.worker, s.rounds + 1, d}))
+	}
+}
+
+// addFsync records an fsync that took d and failed with err, if not nil.
+func (s *workerStats) addFsync(d time.Duration, err error) {
+	s.add(phaseFsync, d)
+	s.fsyncs[fsyncBucket(d)]++
+	if s.live != nil {
+		s.live.fsyncs.record(d)
+	}
+	if err != nil {
+		s.fsyncErrors++
+		s.lastFsyncErr = err
+	}
+}
+
+// keepSlowest sorts waits longest first and trims them to topLockWaits.
+func keepSlowest(waits []lockWait) []lockWait {
+	sort.Slice(waits, func(i, j int) bool { return waits[i].d > waits[j].d })
+	if len(waits) > topLockWaits {
+		waits = waits[:topLockWaits]
+	}
+	return waits
+}
+
+// report prints how long the run took and how far each worker got, every
+// phase's distribution across all workers, the slowest lock waits,
+// the write latency distribution and, with -fsync, the fsync histogram and
+// the workers whose fsyncs failed. With several files it ends with each
+// one's share. stats is indexed by goroutine number; nil entries are
+// goroutines that never reported back.
+func report(w io.Writer, cfg *config, targets []*target, stats []*workerStats, elapsed time.Duration) {
+	var all workerStats
+	var slow []lockWait
+	var fewest, most *workerStats
+	var failed []*workerStats
+	missing := 0
+	for n, s := range stats {
+		if s == nil {
+			if n > 0 {
+				missing++
+			}
+			continue
+		}
+		if fewest == nil || s.rounds < fewest.rounds {
+			fewest = s
+		}
+		if most == nil || s.rounds > most.rounds {
+			most = s
+		}
+		all.rounds += s.rounds
+		all.bytes += s.bytes
+		all.bytesRead += s.bytesRead
+		for p := phase(0); p < numPhases; p++ {
+			all.count[p] += s.count[p]
+			all.total[p] += s.total[p]
+			all.max[p] = max(all.max[p], s.max[p])
+			all.samples[p] = append(all.samples[p], s.samples[p]...)
+		}
+		for i, n := range s.fsyncs {
+			all.fsyncs[i] += n
+		}
+		if s.fsyncErrors > 0 {
+			failed = append(failed, s)
+		}
+		slow = append(slow, s.slowLocks...)
+	}
+
+	fmt.Fprintf(w, "Wall clock: %s\n", elapsed.Round(time.Millisecond))
+	fmt.Fprintf(w, "Rounds completed: %d", all.rounds)
+	if fewest != nil {
+		fmt.Fprintf(w, " (per goroutine: min %d by goroutine %d, mean %.1f, max %d by goroutine %d)",
+			fewest.rounds, fewest.worker, float64(all.rounds)/float64(len(stats)-1-missing), most.rounds, most.worker)
+	}
+	fmt.Fprintln(w)
+	if missing > 0 {
+		fmt.Fprintf(w, "Goroutines that did not stop in time: %d (left out below)\n", missing)
+	}
+	fmt.Fprintf(w, "Bytes appended: %d\n", all.bytes)
+	fmt.Fprintf(w, "Bytes read back: %d\n", all.bytesRead)
+	fmt.Fprintf(w, "Total lock wait: %s\n", all.total[phaseLock])
+	rows := make([]bench.Row, numPhases)
+	for p := range rows {
+		rows[p] = bench.Row{Name: phaseNames[p], Summary: bench.SummarizeDurations(all.samples[p])}
+	}
+	bench.WriteTable(w, "phase", rows, bench.Durations)
+
+	if slow = keepSlowest(slow); len(slow) > 0 {
+		fmt.Fprintf(w, "Slowest lock waits:\n")
+		for _, l := range slow {
+			fmt.Fprintf(w, "  %12s  goroutine %d, round %d\n", l.d, l.worker, l.round)
+		}
+	}
+	reportLatencies(w, cfg.syncMode, all.samples[phaseWrite])
+
+	if cfg.fsyncEvery > 0 {
+		fmt.Fprintf(w, "Fsyncs (fsync=%s): n=%d %s\n", cfg.fsync, all.count[phaseFsync], formatBuckets(all.fsyncs))
+	}
+	for i, s := range failed {
+		if i == maxMismatches {
+			fmt.Fprintf(w, "  ... and %d more goroutines with failed fsyncs\n", len(failed)-i)
+			break
+		}
+		fmt.Fprintf(w, "  goroutine %d: %d of %d fsyncs failed, last: %v\n", s.worker, s.fsyncErrors, s.count[phaseFsync], s.lastFsyncErr)
+	}
+	if len(targets) > 1 {
+		reportFiles(w, targets, stats)
+	}
+}
+
+// reportFiles prints the goroutines, rounds, bytes appended and lock wait
+// of each file.
+func reportFiles(w io.Writer, targets []*target, stats []*workerStats) {
+	per := make([]struct {
+		goroutines, rounds int
+		bytes              int64
+		lockWait           time.Duration
+	}, len(targets))
+	for _, s := range stats {
+		if s == nil {
+			continue
+		}
+		f := &per[s.file]
+		f.goroutines++
+		f.rounds += s.rounds
+		f.bytes += s.bytes
+		f.lockWait += s.total[phaseLock]
+	}
+	fmt.Fprintf(w, "Per file:\n")
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintf(tw, "file\tgoroutines\trounds\tbytes\tlock wait\t\n")
+	for i, t := range targets {
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%d\t%s\t\n", t.path, per[i].goroutines, per[i].rounds, per[i].bytes, per[i].lockWait)
+	}
+	tw.Flush()
+}
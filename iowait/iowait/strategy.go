@@ -0,0 +1,97 @@
+package iowait
+
+import (
+	"os"
+	"time"
+)
+
+// Modes for -strategy.
+const (
+	strategyMutex   = "mutex"   // rounds take turns through -lock
+	strategyChannel = "channel" // hand each line to the one goroutine that owns the file
+	strategyAppend  = "append"  // write with O_APPEND and no locking at all
+)
+
+// noLocker is the append strategy's: concurrent appends rely on the kernel
+// moving the offset and writing a small line as one step.
+type noLocker struct{}
+
+func (noLocker) lock(*os.File) error   { return nil }
+func (noLocker) unlock(*os.File) error { return nil }
+
+// newStrategyLocker returns the locker the rounds take turns through. The
+// channel strategy serializes in its writer, so it gets noLocker too.
+func newStrategyLocker(strategy, lock string) locker {
+	if strategy != strategyMutex {
+		return noLocker{}
+	}
+	return newLocker(lock)
+}
+
+// writeRequest asks the channel strategy's writer to append line.
+type writeRequest struct {
+	line   string
+	queued time.Time
+	done   chan<- writeResult
+}
+
+// writeResult is how a request went. wait is how long it sat in the queue,
+// which the report counts as lock wait. synced says whether an fsync
+// followed the append.
+type writeResult struct {
+	n          int
+	wait, took time.Duration
+	err        error
+
+	synced   bool
+	syncTook time.Duration
+	syncErr  error
+}
+
+// fileWriter is the channel strategy's single goroutine that owns the file
+// and appends every line the workers send it, one at a time.
+type fileWriter struct {
+	reqs chan writeRequest
+}
+
+// startWriter opens file the way syncMode asks and starts the writer, which
+// fsyncs after every fsyncEvery'th append (0 for never).
+func startWriter(file, syncMode string, lineSize, fsyncEvery int) (*fileWriter, error) {
+	f, err := openAppend(file, syncMode)
+	if err != nil {
+		return nil, err
+	}
+	var buf []byte
+	if syncMode == syncODirect {
+		buf = alignedBuffer(lineSize)
+	}
+	w := &fileWriter{reqs: make(chan writeRequest)}
+	go func() {
+		defer f.Close()
+		writes := 0
+		for req := range w.reqs {
+			start := time.Now()
+			res := writeResult{wait: start.Sub(req.queued)}
+			res.n, res.err = writeLine(f, buf, req.line)
+			res.took = time.Since(start)
+			if res.err == nil {
+				writes++
+				syncIfDue(f, fsyncEvery, writes, &res)
+			}
+			req.done <- res
+		}
+	}()
+	return w, nil
+}
+
+// write appends line through the writer and waits until it has.
+func (w *fileWriter) write(line string) writeResult {
+	done := make(chan writeResult, 1)
+	w.reqs <- writeRequest{line, time.Now(), done}
+	return <-done
+}
+
+// close stops the writer once the workers are done with it.
+func (w *fileWriter) close() {
+	close(w.reqs)
+}
@@ -0,0 +1,90 @@
+package iowait
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestStrategiesAccountForEveryLine(t *testing.T) {
+	for _, strategy := range []string{strategyMutex, strategyChannel, strategyAppend} {
+		for _, lineSize := range []int{0, 300} {
+			t.Run(fmt.Sprintf("%s/line-size=%d", strategy, lineSize), func(t *testing.T) {
+				cfg := config{
+					workers:     8,
+					file:        filepath.Join(t.TempDir(), "f.txt"),
+					initialSize: 100,
+					lineSize:    lineSize,
+					strategy:    strategy,
+					lock:        lockMutex,
+					readMode:    readNone,
+					rounds:      20,
+				}
+				createFile(cfg.file, cfg.initialSize)
+				targets := newTargets(&cfg)
+				targets[0].offset = int64(cfg.initialSize)
+				stats := runWorkers(context.Background(), &cfg, targets)
+				for n := 1; n <= cfg.workers; n++ {
+					if s := stats[n]; s == nil || s.lines != cfg.rounds {
+						t.Fatalf("goroutine %d: %+v, want %d lines", n, s, cfg.rounds)
+					}
+				}
+
+				var out bytes.Buffer
+				if err := verify(&out, &cfg, targets, stats); err != nil {
+					t.Fatal(err)
+				}
+				if want := "160 lines, 0 torn, every goroutine's appends accounted for"; !strings.Contains(out.String(), want) {
+					t.Errorf("verify said %q, want %q", out.String(), want)
+				}
+			})
+		}
+	}
+}
+
+func TestVerifyCatchesBadLines(t *testing.T) {
+	cfg := config{file: filepath.Join(t.TempDir(), "f.txt"), strategy: strategyAppend}
+	data := "Goroutine 1\n" + "Goroutine 2\n" + "GoroutGoroutine 1\nine 2\n" + "Goroutine 9\n" + "Goroutine 02\n"
+	if err := os.WriteFile(cfg.file, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	stats := []*workerStats{nil, {worker: 1, lines: 2}, {worker: 2, lines: 2}}
+
+	var out bytes.Buffer
+	if err := verify(&out, &cfg, newTargets(&cfg), stats); err != nil {
+		t.Fatal(err)
+	}
+	want := "Verification (strategy=append, files=1): 6 lines, 4 torn, counts off for:\n" +
+		"  goroutine 1 appended 2, found 1\n" +
+		"  goroutine 2 appended 2, found 1\n"
+	if out.String() != want {
+		t.Errorf("verify said\n%s\nwant\n%s", out.String(), want)
+	}
+}
+
+func TestParseLine(t *testing.T) {
+	padded := strings.TrimSuffix(line(42, 40), "\n")
+	for _, tc := range []struct {
+		line     string
+		lineSize int
+		n        int
+		ok       bool
+	}{
+		{"Goroutine 7", 0, 7, true},
+		{padded, 40, 42, true},
+		{padded[:30], 40, 42, false},
+		{padded + "x", 40, 42, false},
+		{"Goroutine 7 extra", 0, 7, false},
+		{"Goroutine x", 0, 0, false},
+		{"abcGoroutine 7", 0, 0, false},
+	} {
+		n, ok := parseLine(tc.line, tc.lineSize)
+		if ok != tc.ok || (ok && n != tc.n) {
+			t.Errorf("parseLine(%q, %d) = %d, %v; want %d, %v", tc.line, tc.lineSize, n, ok, tc.n, tc.ok)
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package iowait
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"time"
+	"unsafe"
+
+	"goexp/internal/bench"
+)
+
+// Modes for -sync-mode.
+const (
+	syncNone    = "none"    // writes land in the page cache
+	syncOSync   = "osync"   // O_SYNC: every write waits for the device
+	syncODirect = "odirect" // O_DIRECT: bypass the page cache altogether
+)
+
+// directAlign is the alignment O_DIRECT wants for buffer addresses, write
+// sizes and file offsets. 4096 satisfies every common block device.
+const directAlign = 4096
+
+// openAppend opens path for appending the way mode asks.
+func openAppend(path, mode string) (*os.File, error) {
+	switch mode {
+	case syncOSync:
+		return os.OpenFile(path, os.O_APPEND|os.O_WRONLY|os.O_SYNC, 0)
+	case syncODirect:
+		return openDirect(path, os.O_APPEND|os.O_WRONLY)
+	}
+	return os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0)
+}
+
+// roundUp rounds n up to a multiple of directAlign.
+func roundUp(n int) int {
+	return (n + directAlign - 1) / directAlign * directAlign
+}
+
+// alignedBuffer returns size bytes starting at a directAlign boundary.
+func alignedBuffer(size int) []byte {
+	buf := make([]byte, size+directAlign)
+	off := 0
+	if rem := int(uintptr(unsafe.Pointer(&buf[0])) % directAlign); rem != 0 {
+		off = directAlign - rem
+	}
+	return buf[off : off+size : off+size]
+}
+
+// writeLine appends s to file and returns how many bytes went in. With an
+// aligned buf (O_DIRECT), s is copied into it first; s is exactly len(buf)
+// long then.
+func writeLine(file *os.File, buf []byte, s string) (int, error) {
+	if buf == nil {
+		return file.WriteString(s)
+	}
+	copy(buf, s)
+	return file.Write(buf)
+}
+
+// reportLatencies prints the distribution of the write latencies recorded
+// under mode.
+func reportLatencies(w io.Writer, mode string, latencies []time.Duration) {
+	fmt.Fprintf(w, "Writes (sync-mode=%s): %s\n", mode, bench.SummarizeDurations(latencies).Format(bench.Durations))
+}
@@ -0,0 +1,103 @@
+package iowait
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"runtime/metrics"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// telemetry is what the workers share with the -report-interval reporter.
+// The counters are atomic, so the reporter never takes the lock the workers
+// contend for.
+type telemetry struct {
+	start  time.Time
+	rounds atomic.Int64 // completed, across all workers
+
+	fsync  bool // whether -fsync is on, so the line shows fsyncs
+	fsyncs fsyncHistogram
+}
+
+// report prints a snapshot line to w every interval until stop is closed.
+func (t *telemetry) report(w io.Writer, files []string, interval time.Duration, stop <-chan struct{}) {
+	tick := time.NewTicker(interval)
+	defer tick.Stop()
+	last, lastAt := int64(0), t.start
+	for {
+		select {
+		case <-stop:
+			return
+		case now := <-tick.C:
+			rounds := t.rounds.Load()
+			rate := float64(rounds-last) / now.Sub(lastAt).Seconds()
+			last, lastAt = rounds, now
+			fmt.Fprintln(w, t.snapshot(now, files, rate))
+		}
+	}
+}
+
+// snapshot is one telemetry line. rate is rounds per second since the
+// previous line, and file-bytes adds up the sizes of files.
+func (t *telemetry) snapshot(now time.Time, files []string, rate float64) string {
+	var total int64
+	size := ""
+	for _, f := range files {
+		fi, err := os.Stat(f)
+		if err != nil {
+			size = "?"
+			break
+		}
+		total += fi.Size()
+	}
+	if size == "" {
+		size = strconv.FormatInt(total, 10)
+	}
+	threads := "?"
+	if n, err := osThreads(); err == nil {
+		threads = strconv.Itoa(n)
+	}
+	s := fmt.Sprintf("[telemetry] elapsed=%s rounds=%d rounds/s=%.1f file-bytes=%s goroutines=%d threads=%s heap-bytes=%d",
+		now.Sub(t.start).Round(time.Second), t.rounds.Load(), rate, size, runtime.NumGoroutine(), threads, heapInUse())
+	if t.fsync {
+		s += " fsyncs: " + formatBuckets(t.fsyncs.counts())
+	}
+	return s
+}
+
+// osThreads reads the process's thread count from /proc/self/status, so it
+// works on linux only. Goroutines blocked in flock or a slow write each hold
+// one.
+func osThreads() (int, error) {
+	f, err := os.Open("/proc/self/status")
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		if v, ok := strings.CutPrefix(sc.Text(), "Threads:"); ok {
+			return strconv.Atoi(strings.TrimSpace(v))
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return 0, err
+	}
+	return 0, fmt.Errorf("no Threads line in /proc/self/status")
+}
+
+// heapInUse returns the bytes of live and not yet swept heap objects.
+// Unlike runtime.ReadMemStats it does not stop the world.
+func heapInUse() uint64 {
+	s := []metrics.Sample{{Name: "/memory/classes/heap/objects:bytes"}}
+	metrics.Read(s)
+	if s[0].Value.Kind() != metrics.KindUint64 {
+		return 0
+	}
+	return s[0].Value.Uint64()
+}
@@ -0,0 +1,47 @@
+package iowait
+
+import (
+	"bytes"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTelemetryReport(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "f.txt")
+	createFile(file, 123)
+	live := &telemetry{start: time.Now()}
+	live.rounds.Add(5)
+
+	var out bytes.Buffer
+	stop, stopped := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(stopped)
+		live.report(&out, []string{file, file}, 10*time.Millisecond, stop)
+	}()
+	time.Sleep(35 * time.Millisecond)
+	close(stop)
+	<-stopped
+
+	lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+	if len(lines) < 2 {
+		t.Fatalf("got %d telemetry lines in 35ms at a 10ms interval:\n%s", len(lines), out.String())
+	}
+	threads := `\d+`
+	if runtime.GOOS != "linux" {
+		threads = `\?`
+	}
+	want := regexp.MustCompile(`^\[telemetry\] elapsed=\S+ rounds=5 rounds/s=[\d.]+ file-bytes=246 goroutines=\d+ threads=` + threads + ` heap-bytes=[1-9]\d*$`)
+	for _, l := range lines {
+		if !want.MatchString(l) {
+			t.Errorf("telemetry line %q does not match %s", l, want)
+		}
+	}
+	// Only the first interval saw the rounds being done.
+	if !strings.Contains(lines[1], "rounds/s=0.0") {
+		t.Errorf("second line %q has a nonzero rate with no new rounds", lines[1])
+	}
+}
@@ -0,0 +1,100 @@
+package iowait
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// maxMismatches caps how many goroutines verify lists by name.
+const maxMismatches = 10
+
+// verify reads the lines this run appended to each target, from its offset
+// on, and checks each goroutine's count against the appends its stats say
+// succeeded. A line that is not a whole "Goroutine N" line of the expected
+// length is torn: two writes interleaved or one was cut short. Goroutines
+// that never reported back are left out of the comparison.
+func verify(w io.Writer, cfg *config, targets []*target, stats []*workerStats) error {
+	found := make([]int, len(stats))
+	lines, torn := 0, 0
+	for _, t := range targets {
+		l, tr, err := countLines(t, cfg.lineSize, found)
+		if err != nil {
+			return err
+		}
+		lines += l
+		torn += tr
+	}
+
+	var mismatches []string
+	for n, s := range stats {
+		if s == nil || found[n] == s.lines {
+			continue
+		}
+		if len(mismatches) < maxMismatches {
+			mismatches = append(mismatches, fmt.Sprintf("goroutine %d appended %d, found %d", n, s.lines, found[n]))
+		} else {
+			mismatches[maxMismatches-1] = "..."
+		}
+	}
+	fmt.Fprintf(w, "Verification (strategy=%s, files=%d): %d lines, %d torn", cfg.strategy, len(targets), lines, torn)
+	if len(mismatches) == 0 {
+		fmt.Fprintf(w, ", every goroutine's appends accounted for\n")
+		return nil
+	}
+	fmt.Fprintf(w, ", counts off for:\n")
+	for _, m := range mismatches {
+		fmt.Fprintf(w, "  %s\n", m)
+	}
+	return nil
+}
+
+// countLines adds the lines of t's file written by each goroutine to found,
+// indexed by goroutine number, and returns how many lines there were and
+// how many of them were torn.
+func countLines(t *target, lineSize int, found []int) (lines, torn int, err error) {
+	f, err := os.Open(t.path)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer f.Close()
+	if _, err := f.Seek(t.offset, io.SeekStart); err != nil {
+		return 0, 0, err
+	}
+
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 64<<10), max(64<<10, lineSize+1))
+	for sc.Scan() {
+		lines++
+		n, ok := parseLine(sc.Text(), lineSize)
+		if !ok || n < 1 || n >= len(found) {
+			torn++
+			continue
+		}
+		found[n]++
+	}
+	return lines, torn, sc.Err()
+}
+
+// parseLine returns the goroutine number of a line written by line(n,
+// lineSize), without its newline, and whether it is one.
+func parseLine(s string, lineSize int) (int, bool) {
+	rest, ok := strings.CutPrefix(s, "Goroutine ")
+	if !ok {
+		return 0, false
+	}
+	digits, pad, padded := strings.Cut(rest, " ")
+	n, err := strconv.Atoi(digits)
+	if err != nil || strconv.Itoa(n) != digits {
+		return 0, false
+	}
+	label := len("Goroutine ") + len(digits)
+	if lineSize-label-2 > 0 {
+		// Padded: exactly lineSize bytes with the newline, letters only.
+		return n, padded && len(s) == lineSize-1 && strings.Trim(pad, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ") == ""
+	}
+	return n, !padded
+}
@@ -0,0 +1,31 @@
+// Package demo runs the proxyproto package's two sides: a server that
+// accepts connections behind a PROXY-speaking load balancer, and a relay
+// (s1) that sends a PROXY header for each client on to a backend.
+package demo
+
+import (
+	"flag"
+	"fmt"
+)
+
+// Run runs the relay with "relay" as the first of args, and otherwise the
+// server, with the rest of them.
+func Run(args []string) error {
+	if len(args) > 0 {
+		switch args[0] {
+		case "relay":
+			return runRelay(args[1:])
+		case "server":
+			return runServer(args[1:])
+		}
+	}
+	return runServer(args)
+}
+
+// usage is the Usage of fs, one of the two sides' flags.
+func usage(fs *flag.FlagSet) func() {
+	return func() {
+		fmt.Fprintf(fs.Output(), "usage: proxyproto [server] [flags]\n       proxyproto relay [flags]\n%s flags:\n", fs.Name())
+		fs.PrintDefaults()
+	}
+}
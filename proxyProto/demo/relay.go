@@ -0,0 +1,189 @@
+package demo
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"strings"
+
+	"s1/proxyproto"
+)
+
+// What s1 does with a PROXY header on the connections it accepts, for
+// -accept-proxy.
+const (
+	acceptRequire  = "require"  // every connection must open with one
+	acceptOptional = "optional" // use it if there is one, else the connection's addresses
+	acceptReject   = "reject"   // clients connect directly, and a header is refused
+)
+
+// relay forwards the connections s1 accepts to S2, behind a PROXY header for
+// the client.
+type relay struct {
+	s2Address string
+	version   int            // of the header sent to S2; 0 sends none
+	accept    string         // acceptRequire, acceptOptional or acceptReject
+	trusted   []netip.Prefix // if any, only these must send a header
+}
+
+// parseSendVersion returns the version -send-version names, 0 for none.
+func parseSendVersion(s string) (int, error) {
+	switch s {
+	case "1":
+		return 1, nil
+	case "2":
+		return 2, nil
+	case "none":
+		return 0, nil
+	}
+	return 0, fmt.Errorf("-send-version %q: want 1, 2 or none", s)
+}
+
+// parseCIDRs parses -proxy-cidrs, a comma-separated list of networks.
+func parseCIDRs(s string) ([]netip.Prefix, error) {
+	var nets []netip.Prefix
+	for _, f := range strings.Split(s, ",") {
+		if f = strings.TrimSpace(f); f == "" {
+			continue
+		}
+		n, err := netip.ParsePrefix(f)
+		if err != nil {
+			return nil, fmt.Errorf("-proxy-cidrs: %w", err)
+		}
+		nets = append(nets, n.Masked())
+	}
+	return nets, nil
+}
+
+// policy is the proxyproto.Policy for r.accept and r.trusted. Rejecting
+// headers is up to handleConnection: the Listener lets them through as it
+// does for acceptOptional.
+func (r relay) policy() proxyproto.Policy {
+	switch {
+	case len(r.trusted) > 0:
+		return proxyproto.Policy{Mode: proxyproto.SkipUntrusted, Trusted: r.trusted}
+	case r.accept == acceptRequire:
+		return proxyproto.Policy{Mode: proxyproto.Require}
+	}
+	return proxyproto.Policy{Mode: proxyproto.Optional}
+}
+
+// handleConnection relays clientConn, a *proxyproto.Conn, to S2. The
+// client and the address it connected to are the inbound header's, if it
+// has them, and otherwise clientConn's own.
+func (r relay) handleConnection(clientConn net.Conn) {
+	defer clientConn.Close()
+
+	pc := clientConn.(*proxyproto.Conn)
+	h, err := pc.Header()
+	if err == nil && h != nil && r.accept == acceptReject {
+		err = fmt.Errorf("unexpected v%d PROXY header", h.Version)
+	}
+	if err != nil {
+		fmt.Printf("Closing %s: %v\n", pc.Conn.RemoteAddr(), err)
+		return
+	}
+	src, dst := clientConn.RemoteAddr(), clientConn.LocalAddr()
+
+	// Dial S2 with a Proxy Protocol header for the client in front of
+	// everything we relay
+	var s2Conn net.Conn
+	if r.version == 0 {
+		s2Conn, err = net.Dial("tcp", r.s2Address)
+	} else {
+		d := proxyproto.Dialer{Version: r.version}
+		s2Conn, err = d.Dial("tcp", r.s2Address, src, dst)
+	}
+	if err != nil {
+		fmt.Println("Error connecting to S2:", err)
+		return
+	}
+	defer s2Conn.Close()
+
+	// Send the header now rather than with the client's first bytes, as S2
+	// may speak first
+	if _, err := s2Conn.Write(nil); err != nil {
+		fmt.Printf("Error sending PPv%d header: %v\n", r.version, err)
+		return
+	}
+
+	// Relay data between client and S2, starting with whatever the client
+	// sent along with its header
+	go func() {
+		io.Copy(s2Conn, clientConn)
+	}()
+	io.Copy(clientConn, s2Conn)
+}
+
+// serve hands every connection l accepts to handleConnection, until l is
+// closed. It logs what r's policy makes of each one, with the running
+// counts.
+func (r relay) serve(l net.Listener) error {
+	var pl *proxyproto.Listener
+	logDecision := func(peer net.Addr, d proxyproto.Decision, err error) {
+		s := pl.Stats()
+		counts := fmt.Sprintf("proxied %d, direct %d, refused %d", s.Proxied, s.Direct, s.Refused)
+		if err != nil {
+			fmt.Printf("%s: %s: %v (%s)\n", peer, d, err, counts)
+			return
+		}
+		fmt.Printf("%s: %s (%s)\n", peer, d, counts)
+	}
+	pl = proxyproto.NewListener(l, proxyproto.Config{Policy: r.policy(), OnDecision: logDecision}).(*proxyproto.Listener)
+	l = pl
+	for {
+		clientConn, err := l.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			return err
+		}
+		if err != nil {
+			fmt.Println("Error accepting connection:", err)
+			continue
+		}
+
+		// Handle each connection in a separate goroutine
+		go r.handleConnection(clientConn)
+	}
+}
+
+// runRelay relays clients to S2 until the listener fails.
+func runRelay(args []string) error {
+	fs := flag.NewFlagSet("relay", flag.ExitOnError)
+	listenAddr := fs.String("listen", ":8080", "address to accept clients on")
+	s2Addr := fs.String("s2", "localhost:8081", "address of S2, the backend to relay to")
+	sendVersion := fs.String("send-version", "2", "PROXY header version to send S2 for each client: 1, 2 or none")
+	acceptProxy := fs.String("accept-proxy", acceptOptional, "PROXY header on accepted connections, when s1 sits behind another proxy: require, optional or reject")
+	proxyCIDRs := fs.String("proxy-cidrs", "", "comma-separated networks, such as the load balancers' subnet, whose connections must open with a PROXY header; others connect directly. Overrides -accept-proxy")
+	fs.Usage = usage(fs)
+	fs.Parse(args)
+
+	version, err := parseSendVersion(*sendVersion)
+	if err != nil {
+		return err
+	}
+	switch *acceptProxy {
+	case acceptRequire, acceptOptional, acceptReject:
+	default:
+		return fmt.Errorf("-accept-proxy %q: want require, optional or reject", *acceptProxy)
+	}
+	trusted, err := parseCIDRs(*proxyCIDRs)
+	if err != nil {
+		return err
+	}
+	if len(trusted) > 0 && *acceptProxy == acceptReject {
+		return errors.New("-proxy-cidrs expects headers, which -accept-proxy=reject refuses")
+	}
+
+	listener, err := net.Listen("tcp", *listenAddr)
+	if err != nil {
+		return err
+	}
+	defer listener.Close()
+
+	fmt.Println("S1 is listening on", listener.Addr())
+	r := relay{s2Address: *s2Addr, version: version, accept: *acceptProxy, trusted: trusted}
+	return r.serve(listener)
+}
@@ -0,0 +1,218 @@
+package demo
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/netip"
+	"reflect"
+	"testing"
+	"time"
+
+	"s1/proxyproto"
+)
+
+// received is what the recording backend got on one connection.
+type received struct {
+	header  *proxyproto.Header
+	payload string
+	err     error
+}
+
+// recordingBackend stands in for S2: it reads each connection's header and
+// n bytes after it, and reports them.
+func recordingBackend(t *testing.T, n int) (string, <-chan received) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	got := make(chan received, 1)
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+			br := bufio.NewReader(conn)
+			var rec received
+			rec.header, rec.err = proxyproto.DetectAndParse(br)
+			if rec.err == nil {
+				buf := make([]byte, n)
+				_, rec.err = io.ReadFull(br, buf)
+				rec.payload = string(buf)
+			}
+			conn.Close()
+			got <- rec
+		}
+	}()
+	return l.Addr().String(), got
+}
+
+// startRelay runs r in front of s2Address, and returns where it listens.
+func startRelay(t *testing.T, r relay, s2Address string) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	r.s2Address = s2Address
+	go r.serve(l)
+	return l.Addr().String()
+}
+
+// TestRelayChain puts two relays in front of the backend, the first as the
+// client's load balancer, and checks the client's address gets through both.
+func TestRelayChain(t *testing.T) {
+	const payload = "payload sent right behind the header"
+	client := &net.TCPAddr{IP: net.ParseIP("192.0.2.10"), Port: 40000}
+	inbound, err := proxyproto.V1(client, &net.TCPAddr{IP: net.ParseIP("198.51.100.1"), Port: 443})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name          string
+		first, second relay
+		header        string // what the client sends ahead of payload
+		wantClient    string // "" for the test's own end of the connection
+	}{
+		{"v1 then v2", relay{version: 1, accept: acceptReject}, relay{version: 2, accept: acceptRequire}, "", ""},
+		{"v2 then v1", relay{version: 2, accept: acceptOptional}, relay{version: 1, accept: acceptRequire}, "", ""},
+		{"v2 then v2", relay{version: 2, accept: acceptOptional}, relay{version: 2, accept: acceptOptional}, "", ""},
+		{"client behind a proxy", relay{version: 2, accept: acceptRequire}, relay{version: 1, accept: acceptRequire}, string(inbound), "192.0.2.10:40000"},
+		{"unknown", relay{version: 2, accept: acceptRequire}, relay{version: 2, accept: acceptRequire}, "PROXY UNKNOWN\r\n", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			backend, got := recordingBackend(t, len(payload))
+			addr := startRelay(t, tt.first, startRelay(t, tt.second, backend))
+
+			conn, err := net.Dial("tcp", addr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+			// The header and the payload go out in one Write, so the first
+			// relay reads the payload along with the header.
+			if _, err := conn.Write([]byte(tt.header + payload)); err != nil {
+				t.Fatal(err)
+			}
+			want := tt.wantClient
+			if want == "" {
+				want = conn.LocalAddr().String()
+			}
+
+			select {
+			case rec := <-got:
+				if rec.err != nil {
+					t.Fatal(rec.err)
+				}
+				if v := rec.header.Version; v != tt.second.version {
+					t.Errorf("backend got a v%d header, want v%d", v, tt.second.version)
+				}
+				if rec.header.Source == nil || rec.header.Source.String() != want {
+					t.Errorf("backend got client %v, want %s", rec.header.Source, want)
+				}
+				if rec.payload != payload {
+					t.Errorf("backend got %q, want %q", rec.payload, payload)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatal("nothing reached the backend")
+			}
+		})
+	}
+}
+
+// TestRelayAccept checks which connections each -accept-proxy mode, and
+// -proxy-cidrs, let through to S2.
+func TestRelayAccept(t *testing.T) {
+	header, err := proxyproto.V2(&net.TCPAddr{IP: net.ParseIP("192.0.2.10"), Port: 40000}, &net.TCPAddr{IP: net.ParseIP("198.51.100.1"), Port: 443})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sent := map[string]string{
+		"header":    string(header),
+		"none":      "",
+		"malformed": "PROXY TCP4 192.0.2.10\r\n",
+	}
+	loopback := []netip.Prefix{netip.MustParsePrefix("127.0.0.0/8")}
+	lb := []netip.Prefix{netip.MustParsePrefix("192.0.2.0/24")}
+	tests := []struct {
+		name   string
+		r      relay
+		passes map[string]bool
+	}{
+		{acceptRequire, relay{accept: acceptRequire}, map[string]bool{"header": true}},
+		{acceptOptional, relay{accept: acceptOptional}, map[string]bool{"header": true, "none": true}},
+		{acceptReject, relay{accept: acceptReject}, map[string]bool{"none": true}},
+		{"trusted", relay{trusted: loopback}, map[string]bool{"header": true}},
+		// Untrusted clients are direct, and whatever they send goes to S2.
+		{"untrusted", relay{trusted: lb}, map[string]bool{"header": true, "none": true, "malformed": true}},
+	}
+	for _, tt := range tests {
+		for kind, prefix := range sent {
+			t.Run(tt.name+"/"+kind, func(t *testing.T) {
+				backend, got := recordingBackend(t, 5)
+				tt.r.version = 2
+				addr := startRelay(t, tt.r, backend)
+				conn, err := net.Dial("tcp", addr)
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer conn.Close()
+				conn.SetDeadline(time.Now().Add(5 * time.Second))
+				if _, err := conn.Write([]byte(prefix + "hello")); err != nil {
+					t.Fatal(err)
+				}
+
+				// A refused client is closed before S2 is dialled.
+				io.ReadAll(conn)
+				select {
+				case rec := <-got:
+					if !tt.passes[kind] {
+						t.Errorf("relayed %+v", rec)
+					}
+				case <-time.After(100 * time.Millisecond):
+					if tt.passes[kind] {
+						t.Error("nothing reached the backend")
+					}
+				}
+			})
+		}
+	}
+}
+
+func TestParseSendVersion(t *testing.T) {
+	for s, want := range map[string]int{"1": 1, "2": 2, "none": 0} {
+		if got, err := parseSendVersion(s); got != want || err != nil {
+			t.Errorf("parseSendVersion(%q) = %d, %v; want %d", s, got, err, want)
+		}
+	}
+	for _, s := range []string{"", "3", "v2", "off"} {
+		if _, err := parseSendVersion(s); err == nil {
+			t.Errorf("parseSendVersion(%q) succeeded", s)
+		}
+	}
+}
+
+func TestParseCIDRs(t *testing.T) {
+	got, err := parseCIDRs(" 10.1.2.3/8, 2001:db8::/32,,192.0.2.0/24")
+	want := []netip.Prefix{
+		netip.MustParsePrefix("10.0.0.0/8"),
+		netip.MustParsePrefix("2001:db8::/32"),
+		netip.MustParsePrefix("192.0.2.0/24"),
+	}
+	if err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("parseCIDRs = %v, %v; want %v", got, err, want)
+	}
+	if got, err := parseCIDRs(""); got != nil || err != nil {
+		t.Errorf("parseCIDRs(\"\") = %v, %v", got, err)
+	}
+	for _, s := range []string{"10.0.0.0", "10.0.0.0/33", "lb.example.com/24"} {
+		if _, err := parseCIDRs(s); err == nil {
+			t.Errorf("parseCIDRs(%q) succeeded", s)
+		}
+	}
+}
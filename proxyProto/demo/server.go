@@ -53,91 +53,68 @@
 // Only the UNSPEC protocol byte (\x00) is mandatory to implement on the receiver provided it fallsback
 // 15th byte
 
-package main
+package demo
 
 import (
+	"errors"
+	"flag"
 	"fmt"
+	"io"
 	"net"
-	"os"
-	"strings"
-)
-
-func createPPV1Header(srcIP net.IP, dstIP net.IP, srcPort, dstPort uint16) ([]byte, error) {
-	// "PROXY TCP4 255.255.255.255 255.255.255.255 65535 65535\r\n"
-	var header string
-	var err error
-
-	// assuming we are proxying IPV4 only
-	header = fmt.Sprintf("%s %s %s %s %d %d\r\n", "PROXY", "TCP4", srcIP, dstIP, srcPort, dstPort)
-	return []byte(header), err
-}
 
-func parsePPv1Header(header []byte) (string, net.IP, net.IP, uint16, uint16, error) {
-	// Convert the header to a string
-	headerStr := string(header)
-
-	// Check that the header ends with \r\n
-	if !strings.HasPrefix(headerStr, "PROXY") {
-		return "", nil, nil, 0, 0, fmt.Errorf("Invalid PROXY PROTOCOL v1")
-	}
-
-	// Check that the header ends with \r\n
-	if !strings.HasSuffix(headerStr, "\r\n") {
-		return "", nil, nil, 0, 0, fmt.Errorf("Invalid PROXY PROTOCOL ENDING")
-	}
-
-	// Remove the trailing \r\n for further processing
-	headerStr = strings.TrimSuffix(headerStr, "\r\n")
-
-	// Split the header into parts
-	parts := strings.Fields(headerStr)
-	if len(parts) != 6 {
-		return "", nil, nil, 0, 0, fmt.Errorf("INVALID HEADER LENGTH")
-	}
-
-	// Check the protocol
-	protocol := strings.ToLower(parts[1])
-	if protocol != "tcp4" && protocol != "tcp6" && protocol != "unknown" {
-		return "", nil, nil, 0, 0, fmt.Errorf("protocol must be 'tcp4', 'tcp6', or 'unknown'")
-	}
+	"s1/proxyproto"
+)
 
-	// Parse IP addresses
-	srcIP := net.ParseIP(parts[2])
-	dstIP := net.ParseIP(parts[3])
-	if srcIP == nil {
-		return "", nil, nil, 0, 0, fmt.Errorf("Invalid source IP Address. Ignoring protocol")
-	}
-	if dstIP == nil {
-		return "", nil, nil, 0, 0, fmt.Errorf("Invalid dest IP Address. Ignoring protocol")
+// serveProxied logs the client that the PROXY header on conn describes and
+// then echoes back whatever the client sends. A connection without a valid
+// header is closed.
+func serveProxied(conn net.Conn) {
+	defer conn.Close()
+	pc := conn.(*proxyproto.Conn)
+	h, err := pc.Header()
+	if err != nil {
+		fmt.Printf("Closing %s: %v\n", pc.Conn.RemoteAddr(), err)
+		return
 	}
+	fmt.Printf("%s: v%d client %s, connected to %s\n", pc.Conn.RemoteAddr(), h.Version, conn.RemoteAddr(), conn.LocalAddr())
 
-	// Parse ports
-	var srcPort, dstPort uint16
-	if _, err := fmt.Sscanf(parts[4], "%d", &srcPort); err != nil || srcPort > 65535 {
-		return "", nil, nil, 0, 0, fmt.Errorf("invalid source port, must be between 0-65535")
-	}
-	if _, err := fmt.Sscanf(parts[5], "%d", &dstPort); err != nil || dstPort > 65535 {
-		return "", nil, nil, 0, 0, fmt.Errorf("invalid destination port, must be between 0-65535")
+	// Reads start right after the header, with whatever arrived in the same
+	// segment as it.
+	if _, err := io.Copy(conn, conn); err != nil {
+		fmt.Printf("%s: echo: %v\n", pc.Conn.RemoteAddr(), err)
 	}
-
-	return protocol, srcIP, dstIP, srcPort, dstPort, nil
 }
 
-func main() {
-	listener, err := net.Listen("tcp", ":8080")
-	if err != nil {
-		fmt.Println("Error:", err)
-		os.Exit(1)
-	}
-	defer listener.Close()
-
-	fmt.Println("S1 is listening on :8080")
+// serve hands every connection l accepts to serveProxied, until l is
+// closed.
+func serve(l net.Listener) error {
+	l = proxyproto.NewListener(l, proxyproto.Config{})
 	for {
-		clientConn, err := listener.Accept()
+		conn, err := l.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			return err
+		}
 		if err != nil {
 			fmt.Println("Error accepting connection:", err)
 			continue
 		}
+		go serveProxied(conn)
+	}
+}
+
+// runServer echoes for proxied clients until the listener fails.
+func runServer(args []string) error {
+	fs := flag.NewFlagSet("server", flag.ExitOnError)
+	listenAddr := fs.String("listen", ":8080", "address to accept proxied connections on; :8081 puts it behind the relay")
+	fs.Usage = usage(fs)
+	fs.Parse(args)
 
+	listener, err := net.Listen("tcp", *listenAddr)
+	if err != nil {
+		return err
 	}
+	defer listener.Close()
+
+	fmt.Println("S1 is listening on", listener.Addr())
+	return serve(listener)
 }
@@ -0,0 +1,61 @@
+package demo
+
+import (
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"s1/proxyproto"
+)
+
+func TestServeProxied(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go serve(l)
+	valid, err := proxyproto.V1(&net.TCPAddr{IP: net.ParseIP("192.0.2.10"), Port: 40000}, l.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name, header string
+		echoed       bool
+	}{
+		{"valid", string(valid), true},
+		{"unknown", "PROXY UNKNOWN\r\n", true},
+		{"unknown with junk", "PROXY UNKNOWN garbage here\r\n", true},
+		{"not proxy", "GET / HTTP/1.1\r\n", false},
+		{"no crlf", "PROXY " + strings.Repeat("x", 150), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn, err := net.Dial("tcp", l.Addr().String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+			conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+			// The header and the payload go out in one Write, so they
+			// arrive together.
+			payload := "payload sent right behind the header"
+			if _, err := conn.Write([]byte(tt.header + payload)); err != nil {
+				t.Fatal(err)
+			}
+			conn.(*net.TCPConn).CloseWrite()
+			got, _ := io.ReadAll(conn)
+			want := ""
+			if tt.echoed {
+				want = payload
+			}
+			if string(got) != want {
+				t.Errorf("echoed %q, want %q", got, want)
+			}
+		})
+	}
+}
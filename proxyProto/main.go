@@ -0,0 +1,15 @@
+// Command s1 runs the PROXY protocol server or relay; see package demo.
+package main
+
+import (
+	"log"
+	"os"
+
+	"s1/demo"
+)
+
+func main() {
+	if err := demo.Run(os.Args[1:]); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,94 @@
+package proxyproto
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+)
+
+// WrapConn returns conn with a PROXY header describing a connection from
+// src to dst, of the given version, 1 or 2, written ahead of the first
+// Write, in the same call. The header's form follows from the addresses as
+// for V1 and V2. An error building or writing it is returned from that
+// Write, and from every Write after it.
+func WrapConn(conn net.Conn, src, dst net.Addr, version int) net.Conn {
+	c := &headerConn{Conn: conn}
+	switch version {
+	case 1:
+		c.header, c.err = V1(src, dst)
+	case 2:
+		c.header, c.err = V2(src, dst)
+	default:
+		c.err = fmt.Errorf("proxyproto: unknown version %d", version)
+	}
+	return c
+}
+
+// headerConn is a connection that still owes its peer a PROXY header.
+type headerConn struct {
+	net.Conn
+
+	mu     sync.Mutex
+	header []byte // nil once written
+	err    error
+}
+
+// Write writes the header first if it has not gone out yet, along with b.
+func (c *headerConn) Write(b []byte) (int, error) {
+	c.mu.Lock()
+	if c.err != nil {
+		c.mu.Unlock()
+		return 0, c.err
+	}
+	if c.header == nil {
+		c.mu.Unlock()
+		return c.Conn.Write(b)
+	}
+	defer c.mu.Unlock()
+	hl := len(c.header)
+	n, err := c.Conn.Write(append(c.header, b...))
+	if n < hl {
+		c.err = fmt.Errorf("proxyproto: writing the header: %w", err)
+		return 0, c.err
+	}
+	c.header = nil
+	return n - hl, err
+}
+
+// CloseWrite shuts down the writing side once the header is out, so a peer
+// that gets no data still gets the header.
+func (c *headerConn) CloseWrite() error {
+	if _, err := c.Write(nil); err != nil {
+		return err
+	}
+	if cw, ok := c.Conn.(interface{ CloseWrite() error }); ok {
+		return cw.CloseWrite()
+	}
+	return nil
+}
+
+// Dialer dials connections that open with a PROXY header.
+type Dialer struct {
+	net.Dialer
+	Version int // 1 or 2
+}
+
+// DialContext dials address and wraps the connection with WrapConn. A nil
+// dst stands for the new connection's local address, for a relay whose
+// header names its own end as the server.
+func (d *Dialer) DialContext(ctx context.Context, network, address string, src, dst net.Addr) (net.Conn, error) {
+	conn, err := d.Dialer.DialContext(ctx, network, address)
+	if err != nil {
+		return nil, err
+	}
+	if dst == nil {
+		dst = conn.LocalAddr()
+	}
+	return WrapConn(conn, src, dst, d.Version), nil
+}
+
+// Dial is DialContext without a context.
+func (d *Dialer) Dial(network, address string, src, dst net.Addr) (net.Conn, error) {
+	return d.DialContext(context.Background(), network, address, src, dst)
+}
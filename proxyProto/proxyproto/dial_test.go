@@ -0,0 +1,111 @@
+package proxyproto
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestWrapConn(t *testing.T) {
+	unix := &net.UnixAddr{Name: "/run/s1.sock", Net: "unix"}
+	v1, _ := V1(tcp("192.0.2.10", 40000), tcp("198.51.100.1", 443))
+	v2, _ := V2(tcp("2001:db8::10", 40000), tcp("2001:db8::1", 443))
+	local, _ := V2(nil, nil)
+	tests := []struct {
+		name     string
+		src, dst net.Addr
+		version  int
+		writes   []string
+		want     string
+	}{
+		{"v1", tcp("192.0.2.10", 40000), tcp("198.51.100.1", 443), 1, []string{"hello", " world"}, string(v1) + "hello world"},
+		{"v2", tcp("2001:db8::10", 40000), tcp("2001:db8::1", 443), 2, []string{"hello", " world"}, string(v2) + "hello world"},
+		{"empty first write", tcp("192.0.2.10", 40000), tcp("198.51.100.1", 443), 1, []string{"", "hello"}, string(v1) + "hello"},
+		{"only an empty write", tcp("192.0.2.10", 40000), tcp("198.51.100.1", 443), 1, []string{""}, string(v1)},
+		{"no writes", tcp("192.0.2.10", 40000), tcp("198.51.100.1", 443), 1, nil, string(v1)},
+		{"v1 unix", unix, tcp("198.51.100.1", 443), 1, []string{"hello"}, "PROXY UNKNOWN\r\nhello"},
+		{"v2 unix", unix, tcp("198.51.100.1", 443), 2, []string{"hello"}, string(local) + "hello"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, server := tcpPipe(t)
+			c := WrapConn(client, tt.src, tt.dst, tt.version)
+			for _, w := range tt.writes {
+				if n, err := c.Write([]byte(w)); n != len(w) || err != nil {
+					t.Fatalf("Write(%q) = %d, %v", w, n, err)
+				}
+			}
+			// CloseWrite sends the header if no Write has.
+			if err := c.(interface{ CloseWrite() error }).CloseWrite(); err != nil {
+				t.Fatal(err)
+			}
+			server.SetReadDeadline(time.Now().Add(5 * time.Second))
+			got, err := io.ReadAll(server)
+			if err != nil || string(got) != tt.want {
+				t.Errorf("peer read %q, %v; want %q", got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestWrapConnErrors(t *testing.T) {
+	client, _ := tcpPipe(t)
+	for _, tt := range []struct {
+		name string
+		c    net.Conn
+		err  error
+	}{
+		{"mixed families", WrapConn(client, tcp("192.0.2.10", 1), tcp("2001:db8::1", 2), 2), ErrMixedFamilies},
+		{"version 3", WrapConn(client, tcp("192.0.2.10", 1), tcp("198.51.100.1", 2), 3), nil},
+	} {
+		for i := 0; i < 2; i++ {
+			n, err := tt.c.Write([]byte("hello"))
+			if n != 0 || err == nil || tt.err != nil && !errors.Is(err, tt.err) {
+				t.Errorf("%s: write %d = %d, %v; want an error", tt.name, i, n, err)
+			}
+		}
+	}
+}
+
+// TestDialer checks a header the Dialer writes against the Listener that
+// reads it.
+func TestDialer(t *testing.T) {
+	inner, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := NewListener(inner, Config{HeaderReadTimeout: 5 * time.Second})
+	defer l.Close()
+
+	for _, version := range []int{1, 2} {
+		d := Dialer{Version: version}
+		c, err := d.Dial("tcp", l.Addr().String(), tcp("192.0.2.10", 40000), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer c.Close()
+		if _, err := c.Write([]byte("hello")); err != nil {
+			t.Fatal(err)
+		}
+
+		conn, err := l.Accept()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		buf := make([]byte, 5)
+		if _, err := io.ReadFull(conn, buf); err != nil || !bytes.Equal(buf, []byte("hello")) {
+			t.Errorf("v%d: read %q, %v", version, buf, err)
+		}
+		// The header names the dialer's end of the connection as the server.
+		if got := conn.RemoteAddr().String(); got != "192.0.2.10:40000" {
+			t.Errorf("v%d: RemoteAddr = %s", version, got)
+		}
+		if got, want := conn.LocalAddr().String(), c.LocalAddr().String(); got != want {
+			t.Errorf("v%d: LocalAddr = %s, want %s", version, got, want)
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package proxyproto
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"testing"
+)
+
+// The seeds are the spec's examples and what the builders write. Inputs the
+// fuzzers have turned up are kept in testdata/fuzz, so plain go test runs
+// them too.
+
+func FuzzParsePPv1(f *testing.F) {
+	for _, s := range []string{
+		"PROXY TCP4 255.255.255.255 255.255.255.255 65535 65535\r\n",
+		"PROXY TCP6 ffff:ffff:ffff:ffff:ffff:ffff:ffff:ffff ffff:ffff:ffff:ffff:ffff:ffff:ffff:ffff 65535 65535\r\n",
+		"PROXY UNKNOWN\r\n",
+		"PROXY UNKNOWN ffff:f...f:ffff ffff:f...f:ffff 65535 65535\r\n",
+		"PROXY TCP4 192.168.0.1 192.168.0.11 56324 443\r\nGET / HTTP/1.1\r\n",
+		"PROXY TCP4 192.0.2.10\x00 198.51.100.1 1 2\r\n",
+		"PROXY TCP4 \xff\xfe 198.51.100.1 1 2\r\n",
+	} {
+		f.Add([]byte(s))
+	}
+	for _, pair := range fuzzPairs() {
+		if h, err := V1(pair[0], pair[1]); err == nil {
+			f.Add(h)
+		}
+	}
+	f.Fuzz(func(t *testing.T, in []byte) {
+		r := bufio.NewReader(bytes.NewReader(in))
+		line, err := ReadV1(r)
+		if len(line) > MaxV1Len {
+			t.Fatalf("ReadV1 returned %d bytes", len(line))
+		}
+		if err != nil {
+			return
+		}
+		h, err := ParseV1(line)
+		if err != nil {
+			return
+		}
+		if !bytes.Equal(h.Raw, in[:len(line)]) {
+			t.Fatalf("Raw = %q, want %q", h.Raw, in[:len(line)])
+		}
+		if h.Source == nil {
+			return
+		}
+		// Whatever parses, the builder writes back and the parser reads as
+		// the same addresses.
+		again, err := V1(h.Source, h.Destination)
+		if err != nil {
+			t.Fatalf("V1(%s, %s): %v", h.Source, h.Destination, err)
+		}
+		h2, err := ParseV1(again)
+		if err != nil || h2.Source.String() != h.Source.String() || h2.Destination.String() != h.Destination.String() {
+			t.Fatalf("%q rebuilt as %q, parsed as %+v, %v", line, again, h2, err)
+		}
+	})
+}
+
+func FuzzParsePPv2(f *testing.F) {
+	for _, s := range fuzzV2Seeds() {
+		f.Add(s)
+	}
+	f.Fuzz(func(t *testing.T, in []byte) {
+		r := bytes.NewReader(in)
+		h, err := ParseV2(r)
+		if err != nil {
+			return
+		}
+		consumed := len(in) - r.Len()
+		if len(h.Raw) != consumed || len(h.Raw) > v2FixedLen+0xFFFF {
+			t.Fatalf("Raw is %d bytes, read %d", len(h.Raw), consumed)
+		}
+		if h.Command == CommandLocal && h.Source != nil {
+			t.Fatalf("LOCAL header with addresses %+v", h)
+		}
+	})
+}
+
+func FuzzDetectAndParse(f *testing.F) {
+	for _, s := range fuzzV2Seeds() {
+		f.Add(s)
+	}
+	f.Add([]byte("PROXY TCP4 192.0.2.10 198.51.100.1 40000 443\r\nhello"))
+	f.Add([]byte("GET / HTTP/1.1\r\nHost: example.com\r\n\r\n"))
+	f.Add([]byte("\r\n\r\n\x00\r\nQU"))
+	f.Add([]byte("PROX"))
+	f.Fuzz(func(t *testing.T, in []byte) {
+		r := bufio.NewReader(bytes.NewReader(in))
+		_, err := DetectAndParse(r)
+		if errors.Is(err, ErrNoProxyHeader) {
+			// Nothing was consumed.
+			if rest, _ := io.ReadAll(r); !bytes.Equal(rest, in) {
+				t.Fatalf("no header, but left %q of %q", rest, in)
+			}
+		}
+	})
+}
+
+// fuzzPairs are address pairs of every form the builders handle.
+func fuzzPairs() [][2]net.Addr {
+	return [][2]net.Addr{
+		{tcp("192.0.2.10", 40000), tcp("198.51.100.1", 443)},
+		{tcp("2001:db8::10", 40000), tcp("2001:db8::1", 443)},
+		{tcp("192.0.2.10", 1), tcp("2001:db8::1", 2)},
+		{&net.UnixAddr{Name: "/run/s1.sock", Net: "unix"}, tcp("127.0.0.1", 2)},
+		{nil, nil},
+	}
+}
+
+// fuzzV2Seeds are the builders' headers, with and without TLVs, and the
+// edge cases of the fixed part.
+func fuzzV2Seeds() [][]byte {
+	var seeds [][]byte
+	for _, pair := range fuzzPairs() {
+		for _, tlvs := range [][]TLV{nil, {Authority("example.com"), UniqueID([]byte{1, 2, 3}), CRC32C()}} {
+			if h, err := V2(pair[0], pair[1], tlvs...); err == nil {
+				seeds = append(seeds, h)
+			}
+		}
+	}
+	sig := string(v2Signature)
+	for _, s := range []string{
+		sig + "\x21\x11\x00\x00", // PROXY with a zero length
+		sig + "\x21\x00\x00\x00", // PROXY, AF_UNSPEC
+		sig + "\x20\x00\x00\x00", // LOCAL
+		sig + "\x21\x11\xff\xff", // a length it never sends
+		sig + "\x21\x31\x00\xd8", // AF_UNIX with its block missing
+		sig + "\x21\x11\x00\x0f" + "\x7f\x00\x00\x01\x7f\x00\x00\x01\x00\x35\x00\x35" + "\x03\x00\x04", // a CRC32C TLV cut short
+		sig[:7],
+	} {
+		seeds = append(seeds, []byte(s))
+	}
+	return seeds
+}
@@ -0,0 +1,120 @@
+// Package proxyproto builds PROXY protocol headers, for programs that relay
+// connections and want the next hop to see the original client address, and
+// parses them, for the servers behind such programs.
+// Reference: https://www.haproxy.org/download/1.8/doc/proxy-protocol.txt
+package proxyproto
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+)
+
+// MaxV1Len is the longest a version 1 line may be, CRLF included, so that
+// a receiver can read it into a fixed buffer.
+const MaxV1Len = 107
+
+// ErrV1TooLong is returned for a version 1 line over MaxV1Len bytes. The
+// longest line valid addresses make is 104 bytes, so it means a bug.
+var ErrV1TooLong = fmt.Errorf("proxyproto: v1 header longer than %d bytes", MaxV1Len)
+
+// ErrMixedFamilies is returned by V2 for an IPv4 address paired with an
+// IPv6 one, which a v2 address block cannot hold.
+var ErrMixedFamilies = errors.New("proxyproto: source and destination are of different address families")
+
+// v2Signature opens every version 2 header.
+var v2Signature = []byte{0x0D, 0x0A, 0x0D, 0x0A, 0x00, 0x0D, 0x0A, 0x51, 0x55, 0x49, 0x54, 0x0A}
+
+// v2FixedLen is the part of a version 2 header ahead of the address block:
+// the signature, two bytes of version, command, family and transport, and
+// the length of the rest.
+const v2FixedLen = 16
+
+// Byte 13 of a v2 header: version 2 in the high nibble, command in the low.
+// A receiver drops any other version, and takes LOCAL for a health check.
+const (
+	v2Version = 0x20
+	v2Local   = v2Version | 0x0 // no proxied client; the receiver uses the real addresses
+	v2Proxy   = v2Version | 0x1 // the address block describes the proxied client
+)
+
+// Byte 14 of a v2 header: address family in the high nibble, transport in
+// the low.
+const (
+	v2Unspec     = 0x00
+	v2TCPOverIP4 = 0x11
+	v2TCPOverIP6 = 0x21
+)
+
+// V1 returns the version 1 line for a connection from src to dst: TCP4 or
+// TCP6 when both are TCP addresses of the same family, "PROXY UNKNOWN"
+// otherwise (unix sockets, mixed families, missing addresses). IPv6
+// addresses go without their zone, which the format has no room for.
+func V1(src, dst net.Addr) ([]byte, error) {
+	s, d, ip4, ok := tcpPair(src, dst)
+	if !ok {
+		return []byte("PROXY UNKNOWN\r\n"), nil
+	}
+	family := "TCP6"
+	if ip4 {
+		family = "TCP4"
+	}
+	line := fmt.Appendf(nil, "PROXY %s %s %s %d %d\r\n", family, s.IP, d.IP, s.Port, d.Port)
+	if len(line) > MaxV1Len {
+		return nil, ErrV1TooLong
+	}
+	return line, nil
+}
+
+// V2 returns the binary version 2 header for a connection from src to dst:
+// a PROXY command with a 12-byte AF_INET or 36-byte AF_INET6 stream address
+// block when both are TCP addresses of the same family, and a LOCAL command
+// with AF_UNSPEC and no addresses when either is not a TCP address. A TCP
+// pair of mixed families is an error. Any tlvs follow the address block.
+func V2(src, dst net.Addr, tlvs ...TLV) ([]byte, error) {
+	header := append([]byte(nil), v2Signature...)
+	s, d, ip4, ok := tcpPair(src, dst)
+	if !ok {
+		if isTCP(src) && isTCP(dst) {
+			return nil, ErrMixedFamilies
+		}
+		header = append(header, v2Local, v2Unspec, 0, 0)
+		return finishV2(appendTLVs(header, tlvs))
+	}
+
+	family, srcIP, dstIP := byte(v2TCPOverIP6), s.IP.To16(), d.IP.To16()
+	if ip4 {
+		family, srcIP, dstIP = v2TCPOverIP4, s.IP.To4(), d.IP.To4()
+	}
+	// Address block: source and destination IPs, then the two ports. The
+	// length goes in once the TLVs are on.
+	header = append(header, v2Proxy, family, 0, 0)
+	header = append(header, srcIP...)
+	header = append(header, dstIP...)
+	header = binary.BigEndian.AppendUint16(header, uint16(s.Port))
+	header = binary.BigEndian.AppendUint16(header, uint16(d.Port))
+	return finishV2(appendTLVs(header, tlvs))
+}
+
+// isTCP reports whether a is a TCP address with an IP.
+func isTCP(a net.Addr) bool {
+	t, ok := a.(*net.TCPAddr)
+	return ok && t.IP.To16() != nil
+}
+
+// tcpPair reports whether src and dst are both TCP addresses of one IP
+// family, and if so whether that family is IPv4. An IPv4-mapped IPv6 address
+// counts as IPv4.
+func tcpPair(src, dst net.Addr) (s, d *net.TCPAddr, ip4, ok bool) {
+	s, sok := src.(*net.TCPAddr)
+	d, dok := dst.(*net.TCPAddr)
+	if !sok || !dok || s.IP.To16() == nil || d.IP.To16() == nil {
+		return nil, nil, false, false
+	}
+	sip4, dip4 := s.IP.To4() != nil, d.IP.To4() != nil
+	if sip4 != dip4 {
+		return nil, nil, false, false
+	}
+	return s, d, sip4, true
+}
@@ -0,0 +1,103 @@
+package proxyproto
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"net"
+	"testing"
+)
+
+func tcp(ip string, port int) *net.TCPAddr {
+	return &net.TCPAddr{IP: net.ParseIP(ip), Port: port}
+}
+
+func TestV1(t *testing.T) {
+	unix := &net.UnixAddr{Name: "/run/milter.sock", Net: "unix"}
+	tests := []struct {
+		name     string
+		src, dst net.Addr
+		want     string
+	}{
+		{"ipv4", tcp("192.0.2.10", 40000), tcp("198.51.100.1", 1234), "PROXY TCP4 192.0.2.10 198.51.100.1 40000 1234\r\n"},
+		{"ipv4 maximum", tcp("255.255.255.255", 65535), tcp("255.255.255.255", 65535), "PROXY TCP4 255.255.255.255 255.255.255.255 65535 65535\r\n"},
+		{"ipv6", tcp("2001:db8::10", 40000), tcp("2001:db8::1", 1234), "PROXY TCP6 2001:db8::10 2001:db8::1 40000 1234\r\n"},
+		{"ipv6 zone dropped", &net.TCPAddr{IP: net.ParseIP("fe80::1"), Port: 1, Zone: "eth0"}, tcp("fe80::2", 2), "PROXY TCP6 fe80::1 fe80::2 1 2\r\n"},
+		{"mixed families", tcp("192.0.2.10", 1), tcp("2001:db8::1", 2), "PROXY UNKNOWN\r\n"},
+		{"unix client", unix, tcp("127.0.0.1", 1234), "PROXY UNKNOWN\r\n"},
+		{"nil", nil, nil, "PROXY UNKNOWN\r\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := V1(tt.src, tt.dst)
+			if err != nil || string(got) != tt.want {
+				t.Errorf("V1 = %q, %v; want %q", got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestV2(t *testing.T) {
+	sig := string(v2Signature)
+	tests := []struct {
+		name     string
+		src, dst net.Addr
+		want     string
+		err      error
+	}{
+		{
+			"ipv4", tcp("192.0.2.10", 40000), tcp("198.51.100.1", 1234),
+			sig + "\x21\x11\x00\x0c" + "\xc0\x00\x02\x0a" + "\xc6\x33\x64\x01" + "\x9c\x40" + "\x04\xd2", nil,
+		},
+		{
+			"ipv4-mapped ipv6", &net.TCPAddr{IP: net.IPv4(192, 0, 2, 10), Port: 40000}, tcp("::ffff:198.51.100.1", 1234),
+			sig + "\x21\x11\x00\x0c" + "\xc0\x00\x02\x0a" + "\xc6\x33\x64\x01" + "\x9c\x40" + "\x04\xd2", nil,
+		},
+		{
+			"ipv6", tcp("2001:db8::10", 40000), tcp("2001:db8::1", 1234),
+			sig + "\x21\x21\x00\x24" +
+				"\x20\x01\x0d\xb8\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x10" +
+				"\x20\x01\x0d\xb8\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x01" +
+				"\x9c\x40" + "\x04\xd2", nil,
+		},
+		{"mixed families", tcp("192.0.2.10", 1), tcp("2001:db8::1", 2), "", ErrMixedFamilies},
+		{"mixed families reversed", tcp("2001:db8::1", 2), tcp("192.0.2.10", 1), "", ErrMixedFamilies},
+		{"unix client", &net.UnixAddr{Name: "@milter", Net: "unix"}, tcp("127.0.0.1", 1234), sig + "\x20\x00\x00\x00", nil},
+		{"no ip", &net.TCPAddr{Port: 1}, tcp("127.0.0.1", 1234), sig + "\x20\x00\x00\x00", nil},
+		{"nil", nil, nil, sig + "\x20\x00\x00\x00", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := V2(tt.src, tt.dst)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("V2 error = %v, want %v", err, tt.err)
+			}
+			if !bytes.Equal(got, []byte(tt.want)) {
+				t.Errorf("V2 =\n% x\nwant\n% x", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestV2Golden spells out, field by field as the spec lays them out, the
+// header for TCP over IPv4 from 127.0.0.1:56324 to 127.0.0.1:443.
+func TestV2Golden(t *testing.T) {
+	want, err := hex.DecodeString("" +
+		"0d0a0d0a000d0a515549540a" + // signature: \r\n\r\n\0\r\nQUIT\n
+		"21" + // version 2, PROXY command
+		"11" + // AF_INET, STREAM
+		"000c" + // 12 bytes of addresses follow
+		"7f000001" + // source address
+		"7f000001" + // destination address
+		"dc04" + // source port 56324
+		"01bb") // destination port 443
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := V2(tcp("127.0.0.1", 56324), tcp("127.0.0.1", 443)); err != nil || !bytes.Equal(got, want) {
+		t.Errorf("V2 =\n% x, %v\nwant\n% x", got, err, want)
+	}
+	if local, _ := V2(nil, nil); local[12] != 0x20 {
+		t.Errorf("LOCAL version/command byte = %#x, want 0x20", local[12])
+	}
+}
@@ -0,0 +1,229 @@
+package proxyproto
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// DefaultHeaderReadTimeout is how long a connection has to send its header
+// unless Config says otherwise.
+const DefaultHeaderReadTimeout = 5 * time.Second
+
+// ErrHeaderTimeout is returned for a connection that did not send its
+// whole header in time, which is then closed. It also matches
+// os.ErrDeadlineExceeded.
+var ErrHeaderTimeout = errors.New("proxyproto: timed out reading the PROXY header")
+
+// Config is how a Listener treats the headers of the connections it
+// accepts.
+type Config struct {
+	// Eager reads the header in Accept, which then closes and skips any
+	// connection without a valid one. Otherwise the header is read on the
+	// connection's first Read, RemoteAddr or LocalAddr, in the
+	// application's goroutine, and a bad one fails that Read.
+	Eager bool
+
+	// HeaderReadTimeout bounds the reading of the header, so a client that
+	// sends nothing, or half a header, cannot hold Accept, or a Read, for
+	// long. Zero means DefaultHeaderReadTimeout, and a negative value no
+	// limit.
+	HeaderReadTimeout time.Duration
+
+	// Policy is which connections must open with a header. The zero Policy
+	// requires one on all of them.
+	Policy Policy
+
+	// OnDecision, if set, is called with the connection's own remote
+	// address once the Policy has been applied to it, for logging. err is
+	// why a Refused connection was refused.
+	OnDecision func(peer net.Addr, d Decision, err error)
+}
+
+// Listener wraps a net.Listener whose connections open with a PROXY
+// header, of either version as DetectAndParse tells, and hands them out as
+// *Conn. A connection without one fails with ErrNoProxyHeader, unless
+// Config.Policy lets it through as a direct one.
+type Listener struct {
+	net.Listener
+	cfg    Config
+	counts counters
+}
+
+// NewListener returns inner wrapped to read the PROXY header of every
+// connection it accepts.
+func NewListener(inner net.Listener, cfg Config) net.Listener {
+	return &Listener{Listener: inner, cfg: cfg}
+}
+
+// Accept returns the next connection as a *Conn. With Config.Eager, it has
+// already read the header.
+func (l *Listener) Accept() (net.Conn, error) {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+		c := NewConn(conn, l.cfg.HeaderReadTimeout)
+		c.policy, c.onDecision, c.counts = l.cfg.Policy, l.cfg.OnDecision, &l.counts
+		if !l.cfg.Eager {
+			return c, nil
+		}
+		if _, err := c.Header(); err != nil {
+			conn.Close()
+			continue
+		}
+		return c, nil
+	}
+}
+
+// Stats returns how many of the connections l accepted have been decided
+// each way so far. In lazy mode, that is those whose header has been read.
+func (l *Listener) Stats() Stats {
+	return l.counts.stats()
+}
+
+// Conn is a connection that opens with a PROXY header. Its RemoteAddr and
+// LocalAddr are those the header gives, and Read returns only what follows
+// the header, including anything that arrived along with it.
+type Conn struct {
+	net.Conn
+	r       *bufio.Reader
+	timeout time.Duration
+
+	policy     Policy
+	onDecision func(net.Addr, Decision, error)
+	counts     *counters
+
+	once     sync.Once
+	header   *Header
+	err      error
+	decision Decision
+
+	mu           sync.Mutex
+	readDeadline time.Time // the application's, put back after the header
+}
+
+// NewConn wraps conn, whose header is read on first use, giving up after
+// timeout as Config.HeaderReadTimeout does. It requires a header, as the
+// zero Policy does.
+func NewConn(conn net.Conn, timeout time.Duration) *Conn {
+	if timeout == 0 {
+		timeout = DefaultHeaderReadTimeout
+	}
+	return &Conn{Conn: conn, r: bufio.NewReader(conn), timeout: timeout}
+}
+
+// Header reads the header if it has not been read yet, and returns it. A
+// direct connection has no header, and no error.
+func (c *Conn) Header() (*Header, error) {
+	c.once.Do(c.readHeader)
+	return c.header, c.err
+}
+
+// Decision reads the header if it has not been read yet, and returns what
+// the Policy made of it.
+func (c *Conn) Decision() Decision {
+	c.once.Do(c.readHeader)
+	return c.decision
+}
+
+// readHeader reads the header under the timeout: both versions' reads, the
+// byte-at-a-time scan for v1's CRLF and v2's fixed part and then its
+// length, happen within it.
+func (c *Conn) readHeader() {
+	if !c.policy.expects(c.Conn.RemoteAddr()) {
+		c.decide(Direct)
+		return
+	}
+	if c.timeout > 0 {
+		// Take the earlier of the application's deadline and the timeout,
+		// and put the application's back afterwards.
+		c.mu.Lock()
+		deadline := time.Now().Add(c.timeout)
+		if d := c.readDeadline; !d.IsZero() && d.Before(deadline) {
+			deadline = d
+		}
+		c.Conn.SetReadDeadline(deadline)
+		c.mu.Unlock()
+	}
+
+	c.header, c.err = DetectAndParse(c.r)
+
+	if c.timeout > 0 {
+		c.mu.Lock()
+		c.Conn.SetReadDeadline(c.readDeadline)
+		c.mu.Unlock()
+	}
+	if errors.Is(c.err, os.ErrDeadlineExceeded) {
+		c.err = fmt.Errorf("%w after %s: %w", ErrHeaderTimeout, c.timeout, c.err)
+		c.Conn.Close()
+	}
+
+	switch {
+	case errors.Is(c.err, ErrNoProxyHeader) && c.policy.Mode == Optional:
+		c.err = nil
+		c.decide(Direct)
+	case c.err != nil:
+		c.decide(Refused)
+	default:
+		c.decide(Proxied)
+	}
+}
+
+// decide records d as the connection's Decision, and reports it.
+func (c *Conn) decide(d Decision) {
+	c.decision = d
+	if c.counts != nil {
+		c.counts.add(d)
+	}
+	if c.onDecision != nil {
+		c.onDecision(c.Conn.RemoteAddr(), d, c.err)
+	}
+}
+
+// Read reads the header first, and fails with its error if it is not
+// valid.
+func (c *Conn) Read(b []byte) (int, error) {
+	if _, err := c.Header(); err != nil {
+		return 0, err
+	}
+	return c.r.Read(b)
+}
+
+// RemoteAddr returns the client's address from the header, or the
+// connection's own for a direct connection, a header without addresses or a
+// bad header.
+func (c *Conn) RemoteAddr() net.Addr {
+	if h, _ := c.Header(); h != nil && h.Source != nil {
+		return h.Source
+	}
+	return c.Conn.RemoteAddr()
+}
+
+// LocalAddr returns the address the client connected to from the header,
+// or the connection's own as RemoteAddr does.
+func (c *Conn) LocalAddr() net.Addr {
+	if h, _ := c.Header(); h != nil && h.Destination != nil {
+		return h.Destination
+	}
+	return c.Conn.LocalAddr()
+}
+
+func (c *Conn) SetDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.readDeadline = t
+	return c.Conn.SetDeadline(t)
+}
+
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.readDeadline = t
+	return c.Conn.SetReadDeadline(t)
+}
@@ -0,0 +1,279 @@
+package proxyproto
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+)
+
+// proxiedServer starts an HTTP server behind NewListener whose handler
+// answers with the addresses the request came from and to.
+func proxiedServer(t *testing.T, cfg Config) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		local := r.Context().Value(http.LocalAddrContextKey).(net.Addr)
+		fmt.Fprintf(w, "%s %s", r.RemoteAddr, local)
+	}))
+	srv.Listener = NewListener(srv.Listener, cfg)
+	srv.Start()
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// lbRequest plays a load balancer: it dials srv, writes header and a GET in
+// a single Write, so they arrive together, and returns the response body.
+// It fails if the server closed the connection or answered with an error,
+// as net/http does when a Read fails on a bad header.
+func lbRequest(t *testing.T, srv *httptest.Server, header []byte) (string, error) {
+	t.Helper()
+	conn, err := net.Dial("tcp", srv.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	req := "GET / HTTP/1.1\r\nHost: example.com\r\nConnection: close\r\n\r\n"
+	if _, err := conn.Write(append(header, req...)); err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("%s", resp.Status)
+	}
+	body, err := io.ReadAll(resp.Body)
+	return string(body), err
+}
+
+func TestListenerHTTP(t *testing.T) {
+	for _, eager := range []bool{false, true} {
+		t.Run(fmt.Sprintf("eager=%v", eager), func(t *testing.T) {
+			srv := proxiedServer(t, Config{Eager: eager, HeaderReadTimeout: time.Second})
+			real := srv.Listener.Addr().String()
+			v1, _ := V1(tcp("192.0.2.10", 40000), tcp("198.51.100.1", 443))
+			v2, _ := V2(tcp("2001:db8::10", 40000), tcp("2001:db8::1", 443), Authority("example.com"))
+			local, _ := V2(nil, nil)
+			// A health check that fills in the block anyway, which the
+			// receiver must ignore.
+			localAddrs, _ := V2(tcp("192.0.2.10", 40000), tcp("198.51.100.1", 443))
+			localAddrs[12] = v2Local
+			tests := []struct {
+				name, header   string
+				client, server string // what the handler sees; empty for the real ones
+			}{
+				{"v1", string(v1), "192.0.2.10:40000", "198.51.100.1:443"},
+				{"v2", string(v2), "[2001:db8::10]:40000", "[2001:db8::1]:443"},
+				{"v1 unknown", "PROXY UNKNOWN\r\n", "", ""},
+				{"v2 local", string(local), "", ""},
+				{"v2 local with addresses", string(localAddrs), "", ""},
+			}
+			for _, tt := range tests {
+				t.Run(tt.name, func(t *testing.T) {
+					got, err := lbRequest(t, srv, []byte(tt.header))
+					if err != nil {
+						t.Fatal(err)
+					}
+					var client, server string
+					fmt.Sscan(got, &client, &server)
+					// The real client port is whatever the dial got.
+					if host, _, _ := net.SplitHostPort(client); tt.client == "" && host != "127.0.0.1" || tt.client != "" && client != tt.client {
+						t.Errorf("handler saw client %s, want %q", client, tt.client)
+					}
+					want := tt.server
+					if want == "" {
+						want = real
+					}
+					if server != want {
+						t.Errorf("handler saw server %s, want %s", server, want)
+					}
+				})
+			}
+
+			for name, header := range map[string]string{
+				"bad v1":     "PROXY TCP4 192.0.2.10\r\n",
+				"bad v2":     string(v2Signature) + "\x31\x11\x00\x00",
+				"truncated":  "PROXY TCP4 192.0.2.10 198.51.100.1 40000",
+				"wrong case": "proxy UNKNOWN\r\n",
+			} {
+				t.Run(name, func(t *testing.T) {
+					if got, err := lbRequest(t, srv, []byte(header)); err == nil {
+						t.Errorf("handler answered %q, want the connection refused", got)
+					}
+				})
+			}
+		})
+	}
+}
+
+// TestListenerNoHeader checks that a plain HTTP request, with no header in
+// front, is refused, and that the partial v2 signature a client sends
+// before stalling times out rather than being taken for no header.
+func TestListenerNoHeader(t *testing.T) {
+	srv := proxiedServer(t, Config{HeaderReadTimeout: 200 * time.Millisecond})
+	if got, err := lbRequest(t, srv, nil); err == nil {
+		t.Errorf("handler answered %q to a request without a header", got)
+	}
+
+	client, server := tcpPipe(t)
+	c := NewConn(server, 200*time.Millisecond)
+	if _, err := client.Write(v2Signature[:5]); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Header(); !errors.Is(err, ErrHeaderTimeout) {
+		t.Errorf("Header after part of the signature: %v, want a timeout", err)
+	}
+}
+
+// TestListenerEagerSilentClient checks that, with Eager, a client that sends
+// nothing holds Accept up for no longer than the header timeout.
+func TestListenerEagerSilentClient(t *testing.T) {
+	srv := proxiedServer(t, Config{Eager: true, HeaderReadTimeout: 200 * time.Millisecond})
+	silent, err := net.Dial("tcp", srv.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer silent.Close()
+
+	start := time.Now()
+	v1, _ := V1(tcp("192.0.2.10", 40000), tcp("198.51.100.1", 443))
+	got, err := lbRequest(t, srv, v1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != "192.0.2.10:40000 198.51.100.1:443" {
+		t.Errorf("handler saw %q", got)
+	}
+	if waited := time.Since(start); waited > 3*time.Second {
+		t.Errorf("waited %s behind the silent client", waited)
+	}
+
+	// The silent client was closed.
+	silent.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := silent.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("silent client read: %v, want EOF", err)
+	}
+}
+
+// tcpPipe returns the two ends of a loopback TCP connection.
+func tcpPipe(t *testing.T) (client, server net.Conn) {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	client, err = net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, err = l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close(); server.Close() })
+	return client, server
+}
+
+func TestConnLazy(t *testing.T) {
+	client, server := tcpPipe(t)
+	c := NewConn(server, time.Second)
+	v2, _ := V2(tcp("192.0.2.10", 40000), tcp("198.51.100.1", 443))
+	if _, err := client.Write(append(v2, "hello"...)); err != nil {
+		t.Fatal(err)
+	}
+	if got := c.RemoteAddr().String(); got != "192.0.2.10:40000" {
+		t.Errorf("RemoteAddr = %s", got)
+	}
+	if got := c.LocalAddr().String(); got != "198.51.100.1:443" {
+		t.Errorf("LocalAddr = %s", got)
+	}
+	buf := make([]byte, 16)
+	n, err := c.Read(buf)
+	if err != nil || string(buf[:n]) != "hello" {
+		t.Errorf("Read = %q, %v; want the bytes after the header", buf[:n], err)
+	}
+}
+
+// TestConnKeepsDeadline checks that a read deadline the application set
+// before the header was read still applies afterwards.
+func TestConnKeepsDeadline(t *testing.T) {
+	client, server := tcpPipe(t)
+	c := NewConn(server, time.Minute)
+	c.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+	if _, err := client.Write([]byte("PROXY UNKNOWN\r\n")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Header(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Read(make([]byte, 1)); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Errorf("Read after the header: %v, want the application's deadline", err)
+	}
+}
+
+// TestConnHeaderTimeout plays a load balancer that writes the start of a
+// header and stalls, at each stage of reading either version.
+func TestConnHeaderTimeout(t *testing.T) {
+	sig := string(v2Signature)
+	for _, tt := range []struct{ name, sent string }{
+		{"v1 prefix", "PROXY"},
+		{"v1 line", "PROXY TCP4 192.0.2.10"},
+		{"v2 signature", sig[:5]},
+		{"v2 address block", sig + "\x21\x11\x00\x0c" + "\xc0\x00\x02\x0a\xc6"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			client, server := tcpPipe(t)
+			c := NewConn(server, 100*time.Millisecond)
+			if _, err := client.Write([]byte(tt.sent)); err != nil {
+				t.Fatal(err)
+			}
+			start := time.Now()
+			_, err := c.Read(make([]byte, 1))
+			if !errors.Is(err, ErrHeaderTimeout) || !errors.Is(err, os.ErrDeadlineExceeded) {
+				t.Errorf("Read with part of a header: %v, want ErrHeaderTimeout", err)
+			}
+			if waited := time.Since(start); waited > 2*time.Second {
+				t.Errorf("gave up after %s", waited)
+			}
+			// The connection was closed.
+			client.SetReadDeadline(time.Now().Add(5 * time.Second))
+			if _, err := client.Read(make([]byte, 1)); err != io.EOF {
+				t.Errorf("LB read after the timeout: %v, want EOF", err)
+			}
+			if got, want := c.RemoteAddr(), server.RemoteAddr(); got != want {
+				t.Errorf("RemoteAddr after a bad header = %s, want the connection's own %s", got, want)
+			}
+		})
+	}
+}
+
+// TestConnClearsHeaderDeadline checks that the header's deadline is gone
+// once the header is in.
+func TestConnClearsHeaderDeadline(t *testing.T) {
+	client, server := tcpPipe(t)
+	c := NewConn(server, 100*time.Millisecond)
+	if _, err := client.Write([]byte("PROXY UNKNOWN\r\n")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Header(); err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		time.Sleep(300 * time.Millisecond)
+		client.Write([]byte("late"))
+	}()
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(c, buf); err != nil || string(buf) != "late" {
+		t.Errorf("Read after the header = %q, %v; want no deadline", buf, err)
+	}
+}
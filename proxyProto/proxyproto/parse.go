@@ -0,0 +1,302 @@
+package proxyproto
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+)
+
+// Why a version 1 header was rejected. The parsers wrap them with the
+// details, so match them with errors.Is. A line over MaxV1Len bytes gives
+// ErrV1TooLong.
+var (
+	ErrNotV1      = errors.New("proxyproto: not a v1 header: the first bytes are not \"PROXY\"")
+	ErrNoCRLF     = fmt.Errorf("proxyproto: no CRLF within the first %d bytes", MaxV1Len)
+	ErrBadFormat  = errors.New("proxyproto: malformed v1 header")
+	ErrBadFamily  = errors.New("proxyproto: bad address family")
+	ErrBadAddress = errors.New("proxyproto: bad address")
+	ErrBadPort    = errors.New("proxyproto: bad port")
+)
+
+// Why a version 2 header was rejected, wrapped with the details.
+var (
+	ErrNotV2         = errors.New("proxyproto: not a v2 header: bad signature")
+	ErrBadVersion    = errors.New("proxyproto: unsupported protocol version")
+	ErrBadCommand    = errors.New("proxyproto: unknown v2 command")
+	ErrUnknownFamily = errors.New("proxyproto: unknown address family or transport protocol")
+	ErrShortAddress  = errors.New("proxyproto: length does not cover the address block")
+)
+
+// Commands, the low nibble of byte 13 of a version 2 header.
+const (
+	CommandLocal = 0x0 // no proxied client, e.g. the proxy's health check
+	CommandProxy = 0x1 // the addresses describe the proxied client
+)
+
+// Address families, the high nibble of byte 14 of a version 2 header.
+const (
+	AFUnspec = 0x0
+	AFInet   = 0x1
+	AFInet6  = 0x2
+	AFUnix   = 0x3
+)
+
+// v2BlockLens are the address block sizes of the families.
+var v2BlockLens = map[byte]int{AFUnspec: 0, AFInet: 4 + 4 + 2 + 2, AFInet6: 16 + 16 + 2 + 2, AFUnix: 108 + 108}
+
+// Header is a parsed PROXY protocol header, of either version.
+type Header struct {
+	Version  int  // 1 or 2
+	Command  byte // CommandLocal or CommandProxy; a v1 header is always PROXY
+	Family   byte // AFUnspec, AFInet, ...; AFUnspec for a v1 UNKNOWN
+	Protocol byte // 0x0 UNSPEC, 0x1 STREAM, 0x2 DGRAM
+
+	// The client and the address it connected to: TCP addresses for TCP4,
+	// TCP6 and STREAM over AF_INET or AF_INET6, UDP ones for DGRAM, and nil
+	// otherwise. AF_UNIX paths are only in Raw for now.
+	Source, Destination net.Addr
+
+	TLVs []TLV  // version 2 only: what the length covers after the addresses
+	Raw  []byte // the whole header as received
+}
+
+// ReadV1 reads a version 1 header from r up to and including its CRLF, one
+// byte at a time, so whatever the client sent after the header stays in r.
+// It gives up as soon as the bytes stop matching "PROXY", or after
+// MaxV1Len bytes without a CRLF.
+func ReadV1(r *bufio.Reader) ([]byte, error) {
+	const prefix = "PROXY"
+	line := make([]byte, 0, MaxV1Len)
+	for len(line) < MaxV1Len {
+		b, err := r.ReadByte()
+		if err != nil {
+			return line, err
+		}
+		if len(line) < len(prefix) && b != prefix[len(line)] {
+			return line, ErrNotV1
+		}
+		line = append(line, b)
+		if bytes.HasSuffix(line, []byte("\r\n")) {
+			return line, nil
+		}
+	}
+	return line, ErrNoCRLF
+}
+
+// ParseV1 parses a whole version 1 line, CRLF included. Keywords must be
+// upper case and fields separated by single spaces; TCP4 takes dotted-quad
+// addresses and TCP6 IPv6 ones. Anything after UNKNOWN is ignored, and the
+// header has no addresses.
+func ParseV1(line []byte) (*Header, error) {
+	fail := func(err error, format string, args ...any) (*Header, error) {
+		return nil, fmt.Errorf("%w: "+format, append([]any{err}, args...)...)
+	}
+
+	// The spec caps the whole line, CRLF included
+	if len(line) > MaxV1Len {
+		return nil, ErrV1TooLong
+	}
+	s := string(line)
+	if !strings.HasPrefix(s, "PROXY") {
+		return nil, ErrNotV1
+	}
+
+	// Check that the line ends with \r\n, and that it is the only CR or LF
+	// in it
+	if !strings.HasSuffix(s, "\r\n") {
+		return nil, ErrNoCRLF
+	}
+	s = strings.TrimSuffix(s, "\r\n")
+	if i := strings.IndexAny(s, "\r\n"); i >= 0 {
+		return fail(ErrBadFormat, "bare CR or LF at byte %d", i)
+	}
+
+	parts := strings.Split(s, " ")
+	if parts[0] != "PROXY" || len(parts) < 2 || parts[1] == "" {
+		return fail(ErrBadFormat, "no space and protocol after PROXY")
+	}
+	h := &Header{Version: 1, Command: CommandProxy, Raw: line}
+
+	// UNKNOWN: the proxy cannot describe the client, and the receiver uses
+	// the connection's own addresses.
+	if parts[1] == "UNKNOWN" {
+		return h, nil
+	}
+
+	switch parts[1] {
+	case "TCP4":
+		h.Family = AFInet
+	case "TCP6":
+		h.Family = AFInet6
+	default:
+		return fail(ErrBadFamily, "%q, want TCP4, TCP6 or UNKNOWN", parts[1])
+	}
+	if len(parts) != 6 {
+		return fail(ErrBadFormat, "%d fields, want 6 separated by single spaces", len(parts))
+	}
+
+	srcIP, err := parseV1Addr(parts[1], parts[2])
+	if err != nil {
+		return fail(err, "source %q for %s", parts[2], parts[1])
+	}
+	dstIP, err := parseV1Addr(parts[1], parts[3])
+	if err != nil {
+		return fail(err, "destination %q for %s", parts[3], parts[1])
+	}
+
+	// Ports: plain decimal, no sign, no hex
+	srcPort, err := strconv.ParseUint(parts[4], 10, 16)
+	if err != nil {
+		return fail(ErrBadPort, "source port %q, must be between 0-65535", parts[4])
+	}
+	dstPort, err := strconv.ParseUint(parts[5], 10, 16)
+	if err != nil {
+		return fail(ErrBadPort, "destination port %q, must be between 0-65535", parts[5])
+	}
+
+	h.Protocol = 0x1 // STREAM
+	h.Source = &net.TCPAddr{IP: srcIP, Port: int(srcPort)}
+	h.Destination = &net.TCPAddr{IP: dstIP, Port: int(dstPort)}
+	return h, nil
+}
+
+// parseV1Addr parses one of a version 1 header's addresses, which must be
+// of family: dotted-quad IPv4 for TCP4, IPv6 without a zone for TCP6.
+func parseV1Addr(family, s string) (net.IP, error) {
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return nil, ErrBadAddress
+	}
+	switch {
+	case family == "TCP4" && !addr.Is4(), family == "TCP6" && !addr.Is6():
+		return nil, ErrBadFamily
+	case addr.Zone() != "":
+		return nil, ErrBadAddress
+	}
+	return net.IP(addr.AsSlice()), nil
+}
+
+// ParseV2 reads a version 2 header from r: the fixed part, then exactly the
+// length it declares, so r is left at the first byte after the header. It
+// checks the fixed part before reading any further, rejects a TLV that runs
+// past the declared length with ErrTLVTruncated, and a header whose CRC32C
+// TLV does not match it with ErrBadChecksum.
+//
+// A LOCAL header, which a proxy sends for its own connections such as
+// health checks, has no addresses whatever its family: the receiver uses
+// the connection's own. Its family is not checked, and its TLVs are only
+// read when the family is one whose address block they can follow.
+func ParseV2(r io.Reader) (*Header, error) {
+	raw := make([]byte, v2FixedLen)
+	if _, err := io.ReadFull(r, raw); err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(raw[:len(v2Signature)], v2Signature) {
+		return nil, ErrNotV2
+	}
+	if v := raw[12] >> 4; v != 2 {
+		return nil, fmt.Errorf("%w: %d", ErrBadVersion, v)
+	}
+	h := &Header{Version: 2, Command: raw[12] & 0xF, Family: raw[13] >> 4, Protocol: raw[13] & 0xF}
+	if h.Command > CommandProxy {
+		return nil, fmt.Errorf("%w: %#x", ErrBadCommand, h.Command)
+	}
+	local := h.Command == CommandLocal
+	blockLen, known := v2BlockLens[h.Family]
+	known = known && h.Protocol <= 0x2
+	if !known && !local {
+		return nil, fmt.Errorf("%w: %#02x", ErrUnknownFamily, raw[13])
+	}
+	length := int(binary.BigEndian.Uint16(raw[14:v2FixedLen]))
+	if length < blockLen && !local {
+		return nil, fmt.Errorf("%w: %d bytes for a %d byte block", ErrShortAddress, length, blockLen)
+	}
+
+	// Grow raw as the bytes arrive rather than by the declared length, which
+	// a peer can claim and never send.
+	rest, err := io.ReadAll(io.LimitReader(r, int64(length)))
+	if err == nil && len(rest) < length {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return nil, err
+	}
+	raw = append(raw, rest...)
+	block := raw[v2FixedLen:]
+	h.Raw = raw
+	switch {
+	case local:
+		if !known || length < blockLen {
+			return h, nil
+		}
+	case h.Family == AFInet, h.Family == AFInet6:
+		ipLen := (blockLen - 4) / 2
+		srcIP, dstIP := net.IP(block[:ipLen]), net.IP(block[ipLen:2*ipLen])
+		srcPort := int(binary.BigEndian.Uint16(block[2*ipLen:]))
+		dstPort := int(binary.BigEndian.Uint16(block[2*ipLen+2:]))
+		switch h.Protocol {
+		case 0x1:
+			h.Source, h.Destination = &net.TCPAddr{IP: srcIP, Port: srcPort}, &net.TCPAddr{IP: dstIP, Port: dstPort}
+		case 0x2:
+			h.Source, h.Destination = &net.UDPAddr{IP: srcIP, Port: srcPort}, &net.UDPAddr{IP: dstIP, Port: dstPort}
+		}
+	}
+	tlvs, err := ParseTLVs(block[blockLen:])
+	if err != nil {
+		return nil, err
+	}
+	if err := VerifyCRC32C(raw); err != nil {
+		return nil, err
+	}
+	h.TLVs = tlvs
+	return h, nil
+}
+
+// ErrNoProxyHeader is what DetectAndParse returns for a connection that
+// opens with neither version of the header.
+var ErrNoProxyHeader = errors.New("proxyproto: connection does not open with a PROXY header")
+
+// v1Prefix opens every version 1 header.
+const v1Prefix = "PROXY "
+
+// DetectAndParse reads a header of either version from r, telling them
+// apart by how it opens: version 2's 12-byte signature or "PROXY " for
+// version 1. Anything else gives ErrNoProxyHeader and leaves r as it was,
+// for the application to read. It only peeks until it knows, so a peer that
+// sends part of a prefix and goes quiet holds it up until the connection's
+// read deadline.
+func DetectAndParse(r *bufio.Reader) (*Header, error) {
+	for n := 1; ; n++ {
+		b, err := r.Peek(n)
+		switch {
+		case len(b) == 0:
+		case bytes.HasPrefix(v2Signature, b):
+			if len(b) == len(v2Signature) {
+				return ParseV2(r)
+			}
+		case strings.HasPrefix(v1Prefix, string(b)):
+			if len(b) == len(v1Prefix) {
+				line, err := ReadV1(r)
+				if err != nil {
+					return nil, err
+				}
+				return ParseV1(line)
+			}
+		default:
+			return nil, ErrNoProxyHeader
+		}
+		if err == io.EOF && len(b) > 0 {
+			return nil, io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
@@ -0,0 +1,307 @@
+package proxyproto
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestReadV1(t *testing.T) {
+	tests := []struct {
+		name, in   string
+		header     string
+		err        error
+		restOfData string
+	}{
+		{"tcp4", "PROXY TCP4 192.0.2.10 198.51.100.1 40000 1234\r\nhello", "PROXY TCP4 192.0.2.10 198.51.100.1 40000 1234\r\n", nil, "hello"},
+		{"unknown", "PROXY UNKNOWN\r\n", "PROXY UNKNOWN\r\n", nil, ""},
+		{"not proxy", "GET / HTTP/1.1\r\n", "", ErrNotV1, "ET / HTTP/1.1\r\n"},
+		{"proxy prefix only", "PROXZ TCP4\r\n", "PROX", ErrNotV1, " TCP4\r\n"},
+		{"no crlf", "PROXY " + strings.Repeat("1", 200), "PROXY " + strings.Repeat("1", MaxV1Len-6), ErrNoCRLF, strings.Repeat("1", 200-(MaxV1Len-6))},
+		{"crlf at the limit", "PROXY " + strings.Repeat("1", MaxV1Len-8) + "\r\n", "PROXY " + strings.Repeat("1", MaxV1Len-8) + "\r\n", nil, ""},
+		{"truncated", "PROXY TCP4", "PROXY TCP4", io.EOF, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := bufio.NewReader(strings.NewReader(tt.in))
+			line, err := ReadV1(r)
+			if string(line) != tt.header || !errors.Is(err, tt.err) {
+				t.Errorf("ReadV1 = %q, %v; want %q, %v", line, err, tt.header, tt.err)
+			}
+			if rest, _ := io.ReadAll(r); string(rest) != tt.restOfData {
+				t.Errorf("left %q unread, want %q", rest, tt.restOfData)
+			}
+		})
+	}
+}
+
+func TestParseV1(t *testing.T) {
+	const max6 = "ffff:ffff:ffff:ffff:ffff:ffff:ffff:ffff"
+	tests := []struct {
+		name, header string
+		family       byte
+		src, dst     string
+		err          error
+	}{
+		// The examples from the spec.
+		{"tcp4", "PROXY TCP4 192.0.2.10 198.51.100.1 40000 1234\r\n", AFInet, "192.0.2.10:40000", "198.51.100.1:1234", nil},
+		{"tcp4 longest", "PROXY TCP4 255.255.255.255 255.255.255.255 65535 65535\r\n", AFInet, "255.255.255.255:65535", "255.255.255.255:65535", nil},
+		{"tcp6 longest", "PROXY TCP6 " + max6 + " " + max6 + " 65535 65535\r\n", AFInet6, "[" + max6 + "]:65535", "[" + max6 + "]:65535", nil},
+		{"unknown short form", "PROXY UNKNOWN\r\n", AFUnspec, "<nil>", "<nil>", nil},
+		{"unknown longest", "PROXY UNKNOWN " + max6 + " " + max6 + " 65535 65535\r\n", AFUnspec, "<nil>", "<nil>", nil},
+		{"unknown with junk", "PROXY UNKNOWN whatever  the proxy\tfelt like ~!@#\r\n", AFUnspec, "<nil>", "<nil>", nil},
+		{"tcp6 with mapped ipv4", "PROXY TCP6 ::ffff:192.0.2.10 ::1 1 2\r\n", AFInet6, "192.0.2.10:1", "[::1]:2", nil},
+		{"port zero", "PROXY TCP4 192.0.2.10 198.51.100.1 0 0\r\n", AFInet, "192.0.2.10:0", "198.51.100.1:0", nil},
+
+		// Framing.
+		{"not proxy", "GET / HTTP/1.1\r\n", AFUnspec, "<nil>", "<nil>", ErrNotV1},
+		{"no crlf", "PROXY TCP4 192.0.2.10 198.51.100.1 40000 1234", AFUnspec, "<nil>", "<nil>", ErrNoCRLF},
+		{"lf only", "PROXY UNKNOWN\n", AFUnspec, "<nil>", "<nil>", ErrNoCRLF},
+		{"too long", "PROXY UNKNOWN " + strings.Repeat("x", MaxV1Len) + "\r\n", AFUnspec, "<nil>", "<nil>", ErrV1TooLong},
+		{"bare cr", "PROXY TCP4 192.0.2.10\r198.51.100.1 40000 1234\r\n", AFUnspec, "<nil>", "<nil>", ErrBadFormat},
+		{"bare lf", "PROXY UNKNOWN\nGET / HTTP/1.1\r\n", AFUnspec, "<nil>", "<nil>", ErrBadFormat},
+		{"proxy alone", "PROXY\r\n", AFUnspec, "<nil>", "<nil>", ErrBadFormat},
+		{"no space after proxy", "PROXYTCP4 192.0.2.10 198.51.100.1 40000 1234\r\n", AFUnspec, "<nil>", "<nil>", ErrBadFormat},
+		{"double space", "PROXY TCP4 192.0.2.10  198.51.100.1 40000 1234\r\n", AFUnspec, "<nil>", "<nil>", ErrBadFormat},
+		{"trailing space", "PROXY TCP4 192.0.2.10 198.51.100.1 40000 1234 \r\n", AFUnspec, "<nil>", "<nil>", ErrBadFormat},
+		{"too few fields", "PROXY TCP4 192.0.2.10\r\n", AFUnspec, "<nil>", "<nil>", ErrBadFormat},
+
+		// Families and addresses.
+		{"lower case family", "PROXY tcp4 192.0.2.10 198.51.100.1 40000 1234\r\n", AFUnspec, "<nil>", "<nil>", ErrBadFamily},
+		{"udp4", "PROXY UDP4 192.0.2.10 198.51.100.1 40000 1234\r\n", AFUnspec, "<nil>", "<nil>", ErrBadFamily},
+		{"tcp4 with ipv6", "PROXY TCP4 ::1 ::1 1 2\r\n", AFUnspec, "<nil>", "<nil>", ErrBadFamily},
+		{"tcp4 with mapped ipv4", "PROXY TCP4 ::ffff:192.0.2.10 198.51.100.1 1 2\r\n", AFUnspec, "<nil>", "<nil>", ErrBadFamily},
+		{"tcp6 with ipv4", "PROXY TCP6 192.0.2.10 ::1 1 2\r\n", AFUnspec, "<nil>", "<nil>", ErrBadFamily},
+		{"mixed families", "PROXY TCP6 ::1 192.0.2.10 1 2\r\n", AFUnspec, "<nil>", "<nil>", ErrBadFamily},
+		{"octet out of range", "PROXY TCP4 256.0.2.10 198.51.100.1 1 2\r\n", AFUnspec, "<nil>", "<nil>", ErrBadAddress},
+		{"leading zero octet", "PROXY TCP4 192.0.2.010 198.51.100.1 1 2\r\n", AFUnspec, "<nil>", "<nil>", ErrBadAddress},
+		{"hostname", "PROXY TCP4 localhost 198.51.100.1 1 2\r\n", AFUnspec, "<nil>", "<nil>", ErrBadAddress},
+		{"ipv6 zone", "PROXY TCP6 fe80::1%eth0 ::1 1 2\r\n", AFUnspec, "<nil>", "<nil>", ErrBadAddress},
+
+		// Ports.
+		{"port too big", "PROXY TCP4 192.0.2.10 198.51.100.1 70000 1234\r\n", AFUnspec, "<nil>", "<nil>", ErrBadPort},
+		{"plus sign", "PROXY TCP4 192.0.2.10 198.51.100.1 +80 1234\r\n", AFUnspec, "<nil>", "<nil>", ErrBadPort},
+		{"negative", "PROXY TCP4 192.0.2.10 198.51.100.1 -1 1234\r\n", AFUnspec, "<nil>", "<nil>", ErrBadPort},
+		{"hex", "PROXY TCP4 192.0.2.10 198.51.100.1 40000 0x4d2\r\n", AFUnspec, "<nil>", "<nil>", ErrBadPort},
+		{"trailing junk", "PROXY TCP4 192.0.2.10 198.51.100.1 40000 1234x\r\n", AFUnspec, "<nil>", "<nil>", ErrBadPort},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, err := ParseV1([]byte(tt.header))
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("ParseV1 error = %v, want %v", err, tt.err)
+			}
+			if err != nil {
+				if h != nil {
+					t.Errorf("ParseV1 = %+v with an error", h)
+				}
+				return
+			}
+			if h.Version != 1 || h.Command != CommandProxy || h.Family != tt.family || fmt.Sprint(h.Source) != tt.src || fmt.Sprint(h.Destination) != tt.dst {
+				t.Errorf("ParseV1 = v%d %#x %#x %s %s, want v1 PROXY %#x %s %s", h.Version, h.Command, h.Family, h.Source, h.Destination, tt.family, tt.src, tt.dst)
+			}
+			if string(h.Raw) != tt.header {
+				t.Errorf("Raw = %q, want %q", h.Raw, tt.header)
+			}
+		})
+	}
+}
+
+// TestV1RoundTrip checks that what the builder writes, the parser reads
+// back as the same addresses.
+func TestV1RoundTrip(t *testing.T) {
+	const max6 = "ffff:ffff:ffff:ffff:ffff:ffff:ffff:ffff"
+	tests := []struct {
+		name     string
+		src, dst net.Addr
+		family   byte
+	}{
+		{"ipv4", tcp("192.0.2.10", 40000), tcp("198.51.100.1", 1234), AFInet},
+		{"ipv4 longest", tcp("255.255.255.255", 65535), tcp("255.255.255.255", 65535), AFInet},
+		{"ipv4 as 16 bytes", &net.TCPAddr{IP: net.IPv4(192, 0, 2, 10), Port: 1}, tcp("198.51.100.1", 2), AFInet},
+		{"ipv6", tcp("2001:db8::10", 40000), tcp("2001:db8::1", 1234), AFInet6},
+		{"ipv6 longest", tcp(max6, 65535), tcp(max6, 65535), AFInet6},
+		{"ipv6 zone", &net.TCPAddr{IP: net.ParseIP("fe80::1"), Port: 1, Zone: "eth0"}, tcp("fe80::2", 2), AFInet6},
+		{"mixed families", tcp("192.0.2.10", 1), tcp("2001:db8::1", 2), AFUnspec},
+		{"unix", &net.UnixAddr{Name: "/run/s1.sock", Net: "unix"}, tcp("127.0.0.1", 2), AFUnspec},
+		{"nil ip", &net.TCPAddr{Port: 1}, tcp("127.0.0.1", 2), AFUnspec},
+		{"nil", nil, nil, AFUnspec},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header, err := V1(tt.src, tt.dst)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(header) > MaxV1Len {
+				t.Errorf("%d bytes, over the %d byte budget", len(header), MaxV1Len)
+			}
+			h, err := ParseV1(header)
+			if err != nil || h.Family != tt.family {
+				t.Fatalf("ParseV1(%q) = %+v, %v; want family %#x", header, h, err, tt.family)
+			}
+			if h.Family == AFUnspec {
+				return
+			}
+			src, dst := tt.src.(*net.TCPAddr), tt.dst.(*net.TCPAddr)
+			gotSrc, gotDst := h.Source.(*net.TCPAddr), h.Destination.(*net.TCPAddr)
+			if !gotSrc.IP.Equal(src.IP) || !gotDst.IP.Equal(dst.IP) || gotSrc.Port != src.Port || gotDst.Port != dst.Port {
+				t.Errorf("%q parsed as %s %s, want %s %s", header, gotSrc, gotDst, src, dst)
+			}
+		})
+	}
+}
+
+func TestParseV2(t *testing.T) {
+	build := func(src, dst net.Addr, tlvs ...TLV) []byte {
+		h, err := V2(src, dst, tlvs...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return h
+	}
+	tests := []struct {
+		name, in        string
+		command, family byte
+		src, dst        string
+		tlvs            []TLV
+	}{
+		{"ipv4", string(build(tcp("192.0.2.10", 40000), tcp("198.51.100.1", 1234))), 0x1, AFInet, "192.0.2.10:40000", "198.51.100.1:1234", nil},
+		{"ipv6", string(build(tcp("2001:db8::10", 40000), tcp("2001:db8::1", 1234))), 0x1, AFInet6, "[2001:db8::10]:40000", "[2001:db8::1]:1234", nil},
+		{"local", string(build(nil, nil)), 0x0, AFUnspec, "<nil>", "<nil>", nil},
+		{"local, unspec, no length", string(v2Signature) + "\x20\x00\x00\x00", CommandLocal, AFUnspec, "<nil>", "<nil>", nil},
+		// A LOCAL header's address block is skipped, whatever is in it.
+		{
+			"local with an ipv4 block", string(v2Signature) + "\x20\x11\x00\x0c" + "\xc0\x00\x02\x0a\xc6\x33\x64\x01\x9c\x40\x04\xd2",
+			CommandLocal, AFInet, "<nil>", "<nil>", nil,
+		},
+		{"local with a short block", string(v2Signature) + "\x20\x21\x00\x04\x7f\x00\x00\x01", CommandLocal, AFInet6, "<nil>", "<nil>", nil},
+		{"local with an unknown family", string(v2Signature) + "\x20\x41\x00\x03abc", CommandLocal, 0x4, "<nil>", "<nil>", nil},
+		{
+			"ipv4 with authority and unique id",
+			string(build(tcp("192.0.2.10", 40000), tcp("198.51.100.1", 1234), Authority("example.com"), UniqueID([]byte{1, 2, 3}))),
+			0x1, AFInet, "192.0.2.10:40000", "198.51.100.1:1234",
+			[]TLV{{Type: TypeAuthority, Value: []byte("example.com")}, {Type: TypeUniqueID, Value: []byte{1, 2, 3}}},
+		},
+		{
+			"ipv4 with crc32c", string(build(tcp("192.0.2.10", 40000), tcp("198.51.100.1", 1234), CRC32C())),
+			0x1, AFInet, "192.0.2.10:40000", "198.51.100.1:1234", []TLV{{Type: TypeCRC32C, Value: []byte{0xb2, 0x59, 0x26, 0xa0}}},
+		},
+		{
+			"local with a tlv", string(build(nil, nil, Noop(5))),
+			0x0, AFUnspec, "<nil>", "<nil>", []TLV{{Type: TypeNoop, Value: []byte{0, 0}}},
+		},
+		{
+			"ipv4 with tlvs", string(v2Signature) + "\x21\x11\x00\x11" + "\xc0\x00\x02\x0a\xc6\x33\x64\x01\x9c\x40\x04\xd2" + "\x04\x00\x02hi",
+			0x1, AFInet, "192.0.2.10:40000", "198.51.100.1:1234", []TLV{{Type: TypeNoop, Value: []byte("hi")}},
+		},
+		{"unix", string(v2Signature) + "\x21\x31\x00\xd8" + strings.Repeat("\x00", 216), 0x1, AFUnix, "<nil>", "<nil>", nil},
+		{"udp over ipv4", string(v2Signature) + "\x21\x12\x00\x0c" + "\x7f\x00\x00\x01\x7f\x00\x00\x01\x00\x35\x00\x35", 0x1, AFInet, "127.0.0.1:53", "127.0.0.1:53", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Application data right behind the header stays unread.
+			r := strings.NewReader(tt.in + "EHLO")
+			h, err := ParseV2(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if h.Command != tt.command || h.Family != tt.family || fmt.Sprint(h.Source) != tt.src || fmt.Sprint(h.Destination) != tt.dst ||
+				!reflect.DeepEqual(h.TLVs, tt.tlvs) {
+				t.Errorf("ParseV2 = %+v", h)
+			}
+			if string(h.Raw) != tt.in {
+				t.Errorf("Raw = % x, want % x", h.Raw, tt.in)
+			}
+			if rest, _ := io.ReadAll(r); string(rest) != "EHLO" {
+				t.Errorf("left %q unread, want the application data", rest)
+			}
+		})
+	}
+}
+
+func TestParseV2Malformed(t *testing.T) {
+	sig := string(v2Signature)
+	inet := "\xc0\x00\x02\x0a\xc6\x33\x64\x01\x9c\x40\x04\xd2"
+	// A good header with a CRC32C TLV, then one address byte flipped.
+	corrupt, err := V2(tcp("192.0.2.10", 40000), tcp("198.51.100.1", 1234), CRC32C())
+	if err != nil {
+		t.Fatal(err)
+	}
+	corrupt[v2FixedLen+3] ^= 0x01
+	tests := []struct {
+		name, in string
+		err      error
+	}{
+		{"empty", "", io.EOF},
+		{"short fixed part", sig + "\x21", io.ErrUnexpectedEOF},
+		{"v1 header", "PROXY TCP4 192.0.2.10 198.51.100.1 40000 1234\r\n", ErrNotV2},
+		{"one bad signature byte", "\x0d\x0a\x0d\x0a\x00\x0d\x0a\x51\x55\x49\x54\x0b" + "\x21\x11\x00\x0c" + inet, ErrNotV2},
+		{"version 1", sig + "\x11\x11\x00\x0c" + inet, ErrBadVersion},
+		{"version 3", sig + "\x31\x11\x00\x0c" + inet, ErrBadVersion},
+		{"command 2", sig + "\x22\x11\x00\x0c" + inet, ErrBadCommand},
+		{"family 4", sig + "\x21\x41\x00\x0c" + inet, ErrUnknownFamily},
+		{"command 15", sig + "\x2f\x11\x00\x0c" + inet, ErrBadCommand},
+		{"protocol 3", sig + "\x21\x13\x00\x0c" + inet, ErrUnknownFamily},
+		{"ipv4 length 11", sig + "\x21\x11\x00\x0b" + inet, ErrShortAddress},
+		{"ipv6 with an ipv4 block", sig + "\x21\x21\x00\x0c" + inet, ErrShortAddress},
+		{"unix length 0", sig + "\x21\x31\x00\x00", ErrShortAddress},
+		{"truncated block", sig + "\x21\x11\x00\x0c" + inet[:6], io.ErrUnexpectedEOF},
+		{"truncated tlvs", sig + "\x21\x11\x00\x20" + inet + "\x04\x00", io.ErrUnexpectedEOF},
+		// The stream holds all the length declares, but the last TLV claims
+		// more than that.
+		{"tlv past the length", sig + "\x21\x11\x00\x13" + inet + "\x02\x00\x01a" + "\x05\x00\x09", ErrTLVTruncated},
+		{"bad crc32c", string(corrupt), ErrBadChecksum},
+		{"tlv header past the length", sig + "\x21\x11\x00\x0e" + inet + "\x04\x00", ErrTLVTruncated},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, err := ParseV2(strings.NewReader(tt.in))
+			if !errors.Is(err, tt.err) || h != nil {
+				t.Errorf("ParseV2 = %+v, %v; want %v", h, err, tt.err)
+			}
+		})
+	}
+}
+
+func TestDetectAndParse(t *testing.T) {
+	v2, _ := V2(tcp("192.0.2.10", 40000), tcp("198.51.100.1", 443))
+	tests := []struct {
+		name, in string
+		version  int
+		err      error
+		rest     string // what is left for the application
+	}{
+		{"v1", "PROXY TCP4 192.0.2.10 198.51.100.1 40000 443\r\nhello", 1, nil, "hello"},
+		{"v2", string(v2) + "hello", 2, nil, "hello"},
+		{"http", "GET / HTTP/1.1\r\nHost: example.com\r\n\r\n", 0, ErrNoProxyHeader, "GET / HTTP/1.1\r\nHost: example.com\r\n\r\n"},
+		{"blank line", "\r\nhello", 0, ErrNoProxyHeader, "\r\nhello"},
+		{"v2 signature, one byte off", "\r\n\r\n\x00\r\nQUIT\x0bhello", 0, ErrNoProxyHeader, "\r\n\r\n\x00\r\nQUIT\x0bhello"},
+		{"proxy without a space", "PROXYTCP4\r\n", 0, ErrNoProxyHeader, "PROXYTCP4\r\n"},
+		{"lower case", "proxy UNKNOWN\r\n", 0, ErrNoProxyHeader, "proxy UNKNOWN\r\n"},
+		{"empty", "", 0, io.EOF, ""},
+		{"part of a v1 prefix", "PROX", 0, io.ErrUnexpectedEOF, "PROX"},
+		{"part of the v2 signature", "\r\n\r\n\x00", 0, io.ErrUnexpectedEOF, "\r\n\r\n\x00"},
+		{"bad v1", "PROXY TCP4 192.0.2.10\r\nhello", 0, ErrBadFormat, "hello"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := bufio.NewReader(strings.NewReader(tt.in))
+			h, err := DetectAndParse(r)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("DetectAndParse error = %v, want %v", err, tt.err)
+			}
+			if err == nil && h.Version != tt.version {
+				t.Errorf("DetectAndParse = v%d, want v%d", h.Version, tt.version)
+			}
+			if rest, _ := io.ReadAll(r); string(rest) != tt.rest {
+				t.Errorf("left %q, want %q", rest, tt.rest)
+			}
+		})
+	}
+}
@@ -0,0 +1,97 @@
+package proxyproto
+
+import (
+	"net"
+	"net/netip"
+	"sync/atomic"
+)
+
+// Mode is how a Policy treats a connection without a PROXY header.
+type Mode int
+
+const (
+	// Require refuses a connection without a valid header.
+	Require Mode = iota
+	// Optional uses the header if there is one, and otherwise the
+	// connection's own addresses. A malformed header is still refused.
+	Optional
+	// SkipUntrusted requires a header from the Policy's Trusted networks,
+	// such as the load balancers' subnet, and reads none from anyone else:
+	// their connections are direct, and whatever they send is the
+	// application's, header or not.
+	SkipUntrusted
+)
+
+// Policy is which connections a Listener expects a PROXY header on. The
+// zero Policy requires one on every connection.
+type Policy struct {
+	Mode    Mode
+	Trusted []netip.Prefix // for SkipUntrusted
+}
+
+// expects reports whether p has the header of a connection from peer read.
+// An IPv4 peer matches IPv4 networks whether its address is in IPv4 or
+// IPv4-mapped IPv6 form.
+func (p Policy) expects(peer net.Addr) bool {
+	if p.Mode != SkipUntrusted {
+		return true
+	}
+	tcp, ok := peer.(*net.TCPAddr)
+	if !ok {
+		return false
+	}
+	ip := tcp.AddrPort().Addr().Unmap()
+	for _, n := range p.Trusted {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Decision is what a Listener made of a connection's header.
+type Decision int
+
+const (
+	Undecided Decision = iota // the header has not been read yet
+	Proxied                   // it had a valid header
+	Direct                    // it had none, and the Policy allowed that
+	Refused                   // it had a bad header, or none when one was required
+)
+
+func (d Decision) String() string {
+	switch d {
+	case Proxied:
+		return "proxied"
+	case Direct:
+		return "direct"
+	case Refused:
+		return "refused"
+	}
+	return "undecided"
+}
+
+// Stats counts a Listener's connections by the Decision taken on each.
+type Stats struct {
+	Proxied, Direct, Refused int64
+}
+
+// counters are a Listener's Stats as they are counted.
+type counters struct {
+	proxied, direct, refused atomic.Int64
+}
+
+func (c *counters) add(d Decision) {
+	switch d {
+	case Proxied:
+		c.proxied.Add(1)
+	case Direct:
+		c.direct.Add(1)
+	case Refused:
+		c.refused.Add(1)
+	}
+}
+
+func (c *counters) stats() Stats {
+	return Stats{Proxied: c.proxied.Load(), Direct: c.direct.Load(), Refused: c.refused.Load()}
+}
@@ -0,0 +1,152 @@
+package proxyproto
+
+import (
+	"io"
+	"net"
+	"net/netip"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestPolicy(t *testing.T) {
+	header, _ := V1(tcp("192.0.2.10", 40000), tcp("198.51.100.1", 443))
+	const malformed = "PROXY TCP4 192.0.2.10\r\n"
+	loopback := []netip.Prefix{netip.MustParsePrefix("127.0.0.0/8"), netip.MustParsePrefix("::1/128")}
+	lb := []netip.Prefix{netip.MustParsePrefix("192.0.2.0/24"), netip.MustParsePrefix("2001:db8::/32")}
+
+	// Each connection sends one of these, then "hello".
+	sent := map[string]string{"header": string(header), "none": "", "malformed": malformed}
+	type want struct {
+		d      Decision
+		client string // "" for the connection's own
+		read   string // what the application reads, if not refused
+	}
+	proxied := want{Proxied, "192.0.2.10:40000", "hello"}
+	refused := want{d: Refused}
+	tests := []struct {
+		name   string
+		policy Policy
+		want   map[string]want
+	}{
+		{"require", Policy{}, map[string]want{"header": proxied, "none": refused, "malformed": refused}},
+		{"optional", Policy{Mode: Optional}, map[string]want{
+			"header": proxied, "none": {Direct, "", "hello"}, "malformed": refused,
+		}},
+		{"trusted peer", Policy{Mode: SkipUntrusted, Trusted: loopback}, map[string]want{
+			"header": proxied, "none": refused, "malformed": refused,
+		}},
+		{"untrusted peer", Policy{Mode: SkipUntrusted, Trusted: lb}, map[string]want{
+			"header":    {Direct, "", string(header) + "hello"},
+			"none":      {Direct, "", "hello"},
+			"malformed": {Direct, "", malformed + "hello"},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var mu sync.Mutex
+			logged := map[Decision]int{}
+			inner, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			l := NewListener(inner, Config{
+				HeaderReadTimeout: time.Second,
+				Policy:            tt.policy,
+				OnDecision: func(peer net.Addr, d Decision, err error) {
+					mu.Lock()
+					defer mu.Unlock()
+					logged[d]++
+					if (d == Refused) != (err != nil) {
+						t.Errorf("%s: %s with error %v", peer, d, err)
+					}
+				},
+			}).(*Listener)
+			defer l.Close()
+
+			var counted Stats
+			for kind, prefix := range sent {
+				w := tt.want[kind]
+				client, err := net.Dial("tcp", l.Addr().String())
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer client.Close()
+				if _, err := client.Write([]byte(prefix + "hello")); err != nil {
+					t.Fatal(err)
+				}
+				conn, err := l.Accept()
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer conn.Close()
+				c := conn.(*Conn)
+				conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+				if d := c.Decision(); d != w.d {
+					t.Errorf("%s: decided %s, want %s", kind, d, w.d)
+				}
+				switch w.d {
+				case Proxied:
+					counted.Proxied++
+				case Direct:
+					counted.Direct++
+				case Refused:
+					counted.Refused++
+					if _, err := conn.Read(make([]byte, 1)); err == nil {
+						t.Errorf("%s: Read on a refused connection succeeded", kind)
+					}
+					continue
+				}
+				from := w.client
+				if from == "" {
+					from = c.Conn.RemoteAddr().String()
+				}
+				if got := conn.RemoteAddr().String(); got != from {
+					t.Errorf("%s: RemoteAddr = %s, want %s", kind, got, from)
+				}
+				buf := make([]byte, len(w.read))
+				if _, err := io.ReadFull(conn, buf); err != nil || string(buf) != w.read {
+					t.Errorf("%s: read %q, %v; want %q", kind, buf, err, w.read)
+				}
+			}
+
+			if got := l.Stats(); got != counted {
+				t.Errorf("Stats = %+v, want %+v", got, counted)
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			if logged[Proxied] != int(counted.Proxied) || logged[Direct] != int(counted.Direct) || logged[Refused] != int(counted.Refused) {
+				t.Errorf("OnDecision saw %v, want %+v", logged, counted)
+			}
+		})
+	}
+}
+
+func TestPolicyExpects(t *testing.T) {
+	p := Policy{Mode: SkipUntrusted, Trusted: []netip.Prefix{
+		netip.MustParsePrefix("10.0.0.0/8"),
+		netip.MustParsePrefix("2001:db8::/32"),
+	}}
+	for _, tt := range []struct {
+		peer net.Addr
+		want bool
+	}{
+		{tcp("10.1.2.3", 40000), true},
+		{tcp("::ffff:10.1.2.3", 40000), true},
+		{tcp("11.1.2.3", 40000), false},
+		{tcp("2001:db8::10", 40000), true},
+		{tcp("2001:db9::10", 40000), false},
+		{tcp("::ffff:11.1.2.3", 40000), false},
+		{&net.UnixAddr{Name: "/run/lb.sock", Net: "unix"}, false},
+	} {
+		if got := p.expects(tt.peer); got != tt.want {
+			t.Errorf("expects(%s) = %v, want %v", tt.peer, got, tt.want)
+		}
+	}
+	for _, mode := range []Mode{Require, Optional} {
+		if !(Policy{Mode: mode, Trusted: p.Trusted}).expects(tcp("11.1.2.3", 1)) {
+			t.Errorf("mode %d skipped the header of an untrusted peer", mode)
+		}
+	}
+}
@@ -0,0 +1,182 @@
+package proxyproto
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+)
+
+// TLV types of version 2 headers.
+const (
+	TypeALPN      = 0x01 // the application protocol, as in TLS ALPN
+	TypeAuthority = 0x02 // the host name the client asked for, as in TLS SNI
+	TypeCRC32C    = 0x03 // a checksum of the whole header, see CRC32C
+	TypeNoop      = 0x04 // ignored; padding
+	TypeUniqueID  = 0x05 // an opaque connection ID, at most 128 bytes
+	TypeSSL       = 0x20
+	TypeNetNS     = 0x30
+)
+
+// tlvHeaderLen is what each TLV takes ahead of its value: the type and a
+// two-byte length.
+const tlvHeaderLen = 3
+
+// ErrTLVTruncated is returned for a TLV that runs past the end of the
+// header.
+var ErrTLVTruncated = errors.New("proxyproto: TLV runs past the header's length")
+
+// ErrV2TooLong is returned for a version 2 header whose address block and
+// TLVs do not fit its 16-bit length field.
+var ErrV2TooLong = errors.New("proxyproto: v2 header longer than its length field allows")
+
+// ErrBadChecksum is returned for a version 2 header whose CRC32C TLV does
+// not match its contents. The spec has receivers drop the connection.
+var ErrBadChecksum = errors.New("proxyproto: v2 header fails its CRC32C check")
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// TLV is a type-length-value field that follows the address block of a
+// version 2 header.
+type TLV struct {
+	Type  byte
+	Value []byte
+}
+
+// Authority returns an AUTHORITY TLV for host.
+func Authority(host string) TLV {
+	return TLV{Type: TypeAuthority, Value: []byte(host)}
+}
+
+// UniqueID returns a UNIQUE_ID TLV for id, which the spec caps at 128
+// bytes.
+func UniqueID(id []byte) TLV {
+	return TLV{Type: TypeUniqueID, Value: id}
+}
+
+// CRC32C returns a CRC32C TLV for V2 to fill in: the checksum, with the
+// Castagnoli polynomial, of the finished header with this field zeroed.
+// PadV2 keeps it up to date.
+func CRC32C() TLV {
+	return TLV{Type: TypeCRC32C, Value: make([]byte, 4)}
+}
+
+// Noop returns a NOOP TLV that takes up n bytes in all, n-3 of them zero
+// value. n must be at least 3.
+func Noop(n int) TLV {
+	return TLV{Type: TypeNoop, Value: make([]byte, max(n-tlvHeaderLen, 0))}
+}
+
+// appendTLVs encodes tlvs onto b.
+func appendTLVs(b []byte, tlvs []TLV) ([]byte, error) {
+	for _, t := range tlvs {
+		if len(t.Value) > 0xFFFF {
+			return nil, fmt.Errorf("%w: TLV %#x has %d bytes", ErrV2TooLong, t.Type, len(t.Value))
+		}
+		b = append(b, t.Type)
+		b = binary.BigEndian.AppendUint16(b, uint16(len(t.Value)))
+		b = append(b, t.Value...)
+	}
+	return b, nil
+}
+
+// ParseTLVs splits what follows a version 2 header's address block into
+// TLVs. The values alias b.
+func ParseTLVs(b []byte) ([]TLV, error) {
+	var tlvs []TLV
+	for len(b) > 0 {
+		if len(b) < tlvHeaderLen {
+			return nil, fmt.Errorf("%w: %d bytes left for a TLV header", ErrTLVTruncated, len(b))
+		}
+		n := int(binary.BigEndian.Uint16(b[1:3]))
+		if len(b) < tlvHeaderLen+n {
+			return nil, fmt.Errorf("%w: TLV %#x wants %d bytes, %d left", ErrTLVTruncated, b[0], n, len(b)-tlvHeaderLen)
+		}
+		tlvs = append(tlvs, TLV{Type: b[0], Value: b[tlvHeaderLen : tlvHeaderLen+n]})
+		b = b[tlvHeaderLen+n:]
+	}
+	return tlvs, nil
+}
+
+// PadV2 appends a NOOP TLV to header, a version 2 header, to make it
+// exactly size bytes, and updates its length field. A TLV needs at least 3
+// bytes, so size must be len(header), or len(header)+3 or more.
+func PadV2(header []byte, size int) ([]byte, error) {
+	if len(header) < v2FixedLen {
+		return nil, fmt.Errorf("%w: %d bytes", ErrNotV2, len(header))
+	}
+	pad := size - len(header)
+	switch {
+	case pad == 0:
+		return header, nil
+	case pad < tlvHeaderLen:
+		return nil, fmt.Errorf("proxyproto: cannot pad a %d byte header to %d bytes", len(header), size)
+	}
+	return finishV2(appendTLVs(header, []TLV{Noop(pad)}))
+}
+
+// finishV2 sets the length field of header, a version 2 header with its
+// address block and TLVs appended.
+func finishV2(header []byte, err error) ([]byte, error) {
+	if err != nil {
+		return nil, err
+	}
+	n := len(header) - v2FixedLen
+	if n > 0xFFFF {
+		return nil, ErrV2TooLong
+	}
+	binary.BigEndian.PutUint16(header[14:v2FixedLen], uint16(n))
+	off, err := crc32cOffset(header)
+	if err != nil {
+		return nil, err
+	}
+	if off >= 0 {
+		clear(header[off : off+4])
+		binary.BigEndian.PutUint32(header[off:], crc32.Checksum(header, castagnoli))
+	}
+	return header, nil
+}
+
+// VerifyCRC32C checks header, a whole version 2 header, against its CRC32C
+// TLV, and returns ErrBadChecksum if they do not match. A header without
+// the TLV passes.
+func VerifyCRC32C(header []byte) error {
+	off, err := crc32cOffset(header)
+	if err != nil || off < 0 {
+		return err
+	}
+	want := binary.BigEndian.Uint32(header[off:])
+	zeroed := append([]byte(nil), header...)
+	clear(zeroed[off : off+4])
+	if got := crc32.Checksum(zeroed, castagnoli); got != want {
+		return fmt.Errorf("%w: got %08x, header says %08x", ErrBadChecksum, got, want)
+	}
+	return nil
+}
+
+// crc32cOffset returns where the value of header's CRC32C TLV starts, or
+// -1 if it has none.
+func crc32cOffset(header []byte) (int, error) {
+	if len(header) < v2FixedLen {
+		return -1, fmt.Errorf("%w: %d bytes", ErrNotV2, len(header))
+	}
+	off := v2FixedLen + v2BlockLens[header[13]>>4]
+	if off > len(header) {
+		return -1, nil
+	}
+	tlvs, err := ParseTLVs(header[off:])
+	if err != nil {
+		return -1, err
+	}
+	for _, t := range tlvs {
+		off += tlvHeaderLen
+		if t.Type == TypeCRC32C {
+			if len(t.Value) != 4 {
+				return -1, fmt.Errorf("%w: CRC32C TLV has %d bytes, not 4", ErrBadChecksum, len(t.Value))
+			}
+			return off, nil
+		}
+		off += len(t.Value)
+	}
+	return -1, nil
+}
@@ -0,0 +1,164 @@
+package proxyproto
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestTLVRoundTrip(t *testing.T) {
+	tests := []struct {
+		name string
+		tlvs []TLV
+	}{
+		{"none", nil},
+		{"authority", []TLV{Authority("example.com")}},
+		{"several", []TLV{Authority("example.com"), UniqueID([]byte{0xde, 0xad, 0xbe, 0xef}), Noop(3), {Type: TypeALPN, Value: []byte("h2")}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, err := appendTLVs(nil, tt.tlvs)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := ParseTLVs(b)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(got) != len(tt.tlvs) || (len(got) > 0 && !reflect.DeepEqual(got, tt.tlvs)) {
+				t.Errorf("ParseTLVs = %v, want %v", got, tt.tlvs)
+			}
+		})
+	}
+}
+
+func TestParseTLVsTruncated(t *testing.T) {
+	for _, b := range [][]byte{
+		{TypeNoop},
+		{TypeNoop, 0x00},
+		{TypeAuthority, 0x00, 0x04, 'a', 'b', 'c'},
+		{TypeAuthority, 0x00, 0x01, 'a', TypeUniqueID, 0x00, 0x02, 0x01},
+	} {
+		if tlvs, err := ParseTLVs(b); !errors.Is(err, ErrTLVTruncated) {
+			t.Errorf("ParseTLVs(% x) = %v, %v; want ErrTLVTruncated", b, tlvs, err)
+		}
+	}
+}
+
+func TestV2WithTLVs(t *testing.T) {
+	src, dst := tcp("192.0.2.10", 40000), tcp("198.51.100.1", 1234)
+	h, err := V2(src, dst, Authority("a.example"), UniqueID([]byte{7}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 12 bytes of address block, then 3+9 and 3+1 of TLVs.
+	if got := int(h[14])<<8 | int(h[15]); got != 12+12+4 {
+		t.Errorf("length field = %d, want 28", got)
+	}
+	want := []byte{TypeAuthority, 0x00, 0x09, 'a', '.', 'e', 'x', 'a', 'm', 'p', 'l', 'e', TypeUniqueID, 0x00, 0x01, 0x07}
+	if !bytes.Equal(h[v2FixedLen+12:], want) {
+		t.Errorf("TLVs = % x, want % x", h[v2FixedLen+12:], want)
+	}
+
+	if _, err := V2(src, dst, TLV{Type: TypeNoop, Value: make([]byte, 0x10000)}); !errors.Is(err, ErrV2TooLong) {
+		t.Errorf("V2 with a 64KiB TLV: err = %v, want ErrV2TooLong", err)
+	}
+}
+
+func TestPadV2(t *testing.T) {
+	src, dst := tcp("192.0.2.10", 40000), tcp("198.51.100.1", 1234)
+	for _, size := range []int{28, 31, 64, 512} {
+		h, err := V2(src, dst)
+		if err != nil {
+			t.Fatal(err)
+		}
+		h, err = PadV2(h, size)
+		if err != nil {
+			t.Fatalf("PadV2 to %d: %v", size, err)
+		}
+		if len(h) != size {
+			t.Errorf("PadV2 to %d: got %d bytes", size, len(h))
+		}
+		if got := int(h[14])<<8 | int(h[15]); got != size-v2FixedLen {
+			t.Errorf("PadV2 to %d: length field = %d, want %d", size, got, size-v2FixedLen)
+		}
+		tlvs, err := ParseTLVs(h[v2FixedLen+12:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if size > 28 && (len(tlvs) != 1 || tlvs[0].Type != TypeNoop) {
+			t.Errorf("PadV2 to %d: TLVs = %v, want one NOOP", size, tlvs)
+		}
+	}
+
+	h, _ := V2(src, dst)
+	for _, size := range []int{27, 29, 30} {
+		if _, err := PadV2(h, size); err == nil {
+			t.Errorf("PadV2 of a 28 byte header to %d: no error", size)
+		}
+	}
+}
+
+func TestCRC32C(t *testing.T) {
+	src, dst := tcp("192.0.2.10", 40000), tcp("198.51.100.1", 1234)
+	h, err := V2(src, dst, CRC32C())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The checksum was worked out separately, bit by bit, over this header
+	// with its last four bytes zeroed.
+	want, err := hex.DecodeString("" +
+		"0d0a0d0a000d0a515549540a" + // signature
+		"21" + // version 2, PROXY command
+		"11" + // AF_INET, STREAM
+		"0013" + // 12 bytes of addresses and 7 of TLV follow
+		"c000020a" + // source address 192.0.2.10
+		"c6336401" + // destination address 198.51.100.1
+		"9c40" + // source port 40000
+		"04d2" + // destination port 1234
+		"030004" + // CRC32C, 4 bytes
+		"b25926a0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(h, want) {
+		t.Errorf("V2 with CRC32C:\n got % x\nwant % x", h, want)
+	}
+	if err := VerifyCRC32C(h); err != nil {
+		t.Errorf("VerifyCRC32C of a good header: %v", err)
+	}
+
+	bad := bytes.Clone(h)
+	bad[v2FixedLen+3] ^= 0x01 // 192.0.2.11
+	if err := VerifyCRC32C(bad); !errors.Is(err, ErrBadChecksum) {
+		t.Errorf("VerifyCRC32C with a corrupted address: err = %v, want ErrBadChecksum", err)
+	}
+
+	plain, err := V2(src, dst, Authority("example.com"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	plain[v2FixedLen+3] ^= 0x01
+	if err := VerifyCRC32C(plain); err != nil {
+		t.Errorf("VerifyCRC32C without the TLV: %v, want it skipped", err)
+	}
+
+	for _, short := range [][]byte{nil, h[:13]} {
+		if err := VerifyCRC32C(short); !errors.Is(err, ErrNotV2) {
+			t.Errorf("VerifyCRC32C of %d bytes: %v, want ErrNotV2", len(short), err)
+		}
+		if _, err := PadV2(short, 64); !errors.Is(err, ErrNotV2) {
+			t.Errorf("PadV2 of %d bytes: %v, want ErrNotV2", len(short), err)
+		}
+	}
+
+	padded, err := PadV2(h, 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := VerifyCRC32C(padded); err != nil {
+		t.Errorf("VerifyCRC32C after PadV2: %v", err)
+	}
+}
@@ -1,3 +1,11 @@
-module sendf
+module goexp/sendf
 
-go 1.16
+go 1.21
+
+require (
+	goexp/internal v0.0.0
+	golang.org/x/sys v0.8.0
+)
+
+// The measurement harness and Summary are shared with iowait and tcpqueue.
+replace goexp/internal => ../internal
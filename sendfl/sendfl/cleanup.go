@@ -0,0 +1,53 @@
+package sendfl
+
+import (
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+)
+
+// tempPaths tracks files and directories the benchmark creates so they are
+// removed on a normal exit and on SIGINT/SIGTERM alike; the test file and
+// file-target copies can be gigabytes.
+type tempPaths struct {
+	mu    sync.Mutex
+	paths map[string]bool
+}
+
+var temps = &tempPaths{paths: make(map[string]bool)}
+
+func (t *tempPaths) add(path string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.paths[path] = true
+}
+
+// remove deletes path now and stops tracking it.
+func (t *tempPaths) remove(path string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	os.RemoveAll(path)
+	delete(t.paths, path)
+}
+
+func (t *tempPaths) removeAll() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for path := range t.paths {
+		os.RemoveAll(path)
+		delete(t.paths, path)
+	}
+}
+
+// removeTempsOnSignal cleans up and exits when the run is interrupted.
+func removeTempsOnSignal() {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		sig := <-sigs
+		temps.removeAll()
+		os.Stderr.WriteString("interrupted by " + sig.String() + ", temp files removed\n")
+		os.Exit(130)
+	}()
+}
@@ -0,0 +1,91 @@
+package sendfl
+
+import (
+	crand "crypto/rand"
+	"fmt"
+	"math/rand"
+	"time"
+)
+
+// DataSpec says what the test file is filled with. It is part of every
+// report so runs are reproducible and comparable: the same mode and seed
+// always produce the same file (except with CryptoRand).
+type DataSpec struct {
+	Mode       string `json:"mode"` // zeros, random or text
+	Seed       int64  `json:"seed"`
+	CryptoRand bool   `json:"crypto_rand,omitempty"`
+}
+
+func (d DataSpec) String() string {
+	switch {
+	case d.Mode == "zeros":
+		return "zeros"
+	case d.Mode == "random" && d.CryptoRand:
+		return "random (crypto/rand)"
+	default:
+		return fmt.Sprintf("%s (seed %d)", d.Mode, d.Seed)
+	}
+}
+
+func (d DataSpec) validate() error {
+	switch d.Mode {
+	case "zeros", "random", "text":
+		return nil
+	}
+	return fmt.Errorf("unknown -data %q (want zeros, random or text)", d.Mode)
+}
+
+// RunInfo describes the setup shared by every result of one invocation.
+type RunInfo struct {
+	Data         DataSpec      `json:"data"`
+	FileCreation time.Duration `json:"file_creation_ns"` // 0 when an existing file was reused
+}
+
+// textWords is the vocabulary for -data=text: compressible, but not as
+// trivially as zeros.
+var textWords = []string{
+	"the", "quick", "brown", "fox", "jumps", "over", "lazy", "dog", "sendfile",
+	"splice", "socket", "buffer", "kernel", "page", "cache", "copy", "zero",
+	"bytes", "file", "offset", "length", "network", "throughput", "latency",
+}
+
+// filler returns a function that fills each successive chunk of the file.
+// Random data is drawn fresh per chunk, so the file never repeats.
+func (d DataSpec) filler() func(p []byte) {
+	switch d.Mode {
+	case "random":
+		if d.CryptoRand {
+			return func(p []byte) {
+				if _, err := crand.Read(p); err != nil {
+					panic(err)
+				}
+			}
+		}
+		rng := rand.New(rand.NewSource(d.Seed))
+		return func(p []byte) { rng.Read(p) }
+	case "text":
+		rng := rand.New(rand.NewSource(d.Seed))
+		var pending []byte // a word that did not fit in the previous chunk
+		return func(p []byte) {
+			n := copy(p, pending)
+			pending = pending[n:]
+			for n < len(p) {
+				word := textWords[rng.Intn(len(textWords))]
+				sep := " "
+				if rng.Intn(12) == 0 {
+					sep = "\n"
+				}
+				pending = append(append(pending[:0], word...), sep...)
+				m := copy(p[n:], pending)
+				pending = pending[m:]
+				n += m
+			}
+		}
+	default:
+		return func(p []byte) {
+			for i := range p {
+				p[i] = 0
+			}
+		}
+	}
+}
@@ -0,0 +1,7 @@
+//go:build !linux && !darwin
+// +build !linux,!darwin
+
+package sendfl
+
+// freeSpace is unknown on this OS; -1 skips the check.
+func freeSpace(path string) (int64, error) { return -1, nil }
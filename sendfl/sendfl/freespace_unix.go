@@ -0,0 +1,19 @@
+//go:build linux || darwin
+// +build linux darwin
+
+package sendfl
+
+import (
+	"path/filepath"
+
+	"golang.org/x/sys/unix"
+)
+
+// freeSpace returns the bytes available to us on the filesystem holding path.
+func freeSpace(path string) (int64, error) {
+	var st unix.Statfs_t
+	if err := unix.Statfs(filepath.Dir(path), &st); err != nil {
+		return 0, err
+	}
+	return int64(st.Bavail) * int64(st.Bsize), nil
+}
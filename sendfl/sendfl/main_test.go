@@ -0,0 +1,184 @@
+package sendfl
+
+import (
+	"errors"
+	"math/rand"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"goexp/sendf/transfer"
+)
+
+// checksumFile writes size random bytes and returns them as a testFile.
+func checksumFile(t *testing.T, size int) testFile {
+	t.Helper()
+	tf, _, err := newTestFile(randomFile(t, size), 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tf
+}
+
+func randomFile(t *testing.T, size int) string {
+	t.Helper()
+	data := make([]byte, size)
+	rand.New(rand.NewSource(1)).Read(data)
+	path := filepath.Join(t.TempDir(), "checksum.dat")
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// truncated sends all but the last byte, the way a lost offset update would.
+func truncated(fn transfer.Func) transfer.Func {
+	return func(dst transfer.Dest, src *os.File, offset, length int64, opts transfer.Options) (int64, error) {
+		return fn(dst, src, offset, length-1, opts)
+	}
+}
+
+func TestChecksumDetectsTruncation(t *testing.T) {
+	*cooldown = 0
+	tf := checksumFile(t, 1<<20+17)
+
+	for _, tc := range []struct {
+		name string
+		fn   transfer.Func
+	}{
+		{"sendfile", transfer.Sendfile},
+		{"splice", transfer.Splice},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			full, err := benchmarkMethod(tc.name, tf, tc.fn, transfer.Options{}, 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if full.Skipped != "" {
+				t.Skipf("%s unavailable here: %s", tc.name, full.Skipped)
+			}
+			if !full.ChecksumOK {
+				t.Fatalf("full transfer: checksum mismatch %s != %s", full.ReceivedSHA256, full.ExpectedSHA256)
+			}
+
+			short, err := benchmarkMethod(tc.name, tf, truncated(tc.fn), transfer.Options{}, 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if short.ChecksumOK {
+				t.Fatal("truncated transfer passed the checksum")
+			}
+			if short.ExpectedSHA256 != tf.SHA256 || short.ReceivedSHA256 == "" {
+				t.Fatalf("digests not recorded: expected=%q received=%q", short.ExpectedSHA256, short.ReceivedSHA256)
+			}
+		})
+	}
+}
+
+func TestRangeTransfer(t *testing.T) {
+	*cooldown = 0
+	// An offset that is not page aligned exercises the mmap reslicing too.
+	tf, clamped, err := newTestFile(randomFile(t, 1<<20+17), 4096+3, 300000)
+	if err != nil || clamped {
+		t.Fatalf("newTestFile: clamped=%v err=%v", clamped, err)
+	}
+
+	for _, tc := range []struct {
+		name string
+		fn   transfer.Func
+		opts transfer.Options
+	}{
+		{"buffer", transfer.Buffer, transfer.Options{BufferSize: 4096}},
+		{"sendfile", transfer.Sendfile, transfer.Options{}},
+		{"splice", transfer.Splice, transfer.Options{}},
+		{"mmap", transfer.Mmap, transfer.Options{BufferSize: 4096, AdviseSequential: true}},
+		{"ReadFrom", transfer.ReadFrom, transfer.Options{}},
+		{"io.Copy", transfer.IOCopy, transfer.Options{}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			r, err := benchmarkMethod(tc.name, tf, tc.fn, tc.opts, 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if r.Skipped != "" {
+				t.Skip(r.Skipped)
+			}
+			if r.Mismatch != "" || !r.ChecksumOK {
+				t.Fatalf("mismatch=%q checksum ok=%v", r.Mismatch, r.ChecksumOK)
+			}
+		})
+	}
+}
+
+func TestRangeEdges(t *testing.T) {
+	path := randomFile(t, 1000)
+
+	if _, _, err := newTestFile(path, 1000, 0); !errors.Is(err, errOffsetPastEOF) {
+		t.Fatalf("offset at EOF: err = %v, want errOffsetPastEOF", err)
+	}
+	tf, clamped, err := newTestFile(path, 900, 500)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !clamped || tf.Length != 100 {
+		t.Fatalf("length past EOF: clamped=%v length=%d, want true 100", clamped, tf.Length)
+	}
+}
+
+func TestConcurrentStreams(t *testing.T) {
+	*cooldown = 0
+	tf := checksumFile(t, 256<<10)
+
+	r, err := benchmarkMethod("sendfile", tf, transfer.Sendfile, transfer.Options{}, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Skipped != "" {
+		t.Skip(r.Skipped)
+	}
+	if r.Concurrency != 4 || r.BytesReceived != 4*tf.Length || r.Mismatch != "" || !r.ChecksumOK {
+		t.Fatalf("concurrency=%d received=%d mismatch=%q checksum ok=%v", r.Concurrency, r.BytesReceived, r.Mismatch, r.ChecksumOK)
+	}
+	if r.StreamMinMBps <= 0 || r.StreamMinMBps > r.StreamMaxMBps {
+		t.Fatalf("stream min/max = %.2f/%.2f", r.StreamMinMBps, r.StreamMaxMBps)
+	}
+}
+
+func TestRemoteReceiver(t *testing.T) {
+	*cooldown = 0
+	tf := checksumFile(t, 256<<10)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go handleStream(conn)
+		}
+	}()
+	*connect = ln.Addr().String()
+	defer func() { *connect = "" }()
+
+	r, err := benchmarkMethod("io.Copy", tf, transfer.IOCopy, transfer.Options{}, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.BytesReceived != 2*tf.Length || r.Mismatch != "" || !r.ChecksumOK {
+		t.Fatalf("received=%d mismatch=%q checksum ok=%v", r.BytesReceived, r.Mismatch, r.ChecksumOK)
+	}
+
+	short, err := benchmarkMethod("io.Copy", tf, truncated(transfer.IOCopy), transfer.Options{}, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if short.ChecksumOK || short.BytesReceived != tf.Length-1 || short.ReceivedSHA256 == "" {
+		t.Fatalf("truncated: received=%d checksum ok=%v sha256=%q", short.BytesReceived, short.ChecksumOK, short.ReceivedSHA256)
+	}
+}
@@ -0,0 +1,65 @@
+package sendfl
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// The sendfl serve/bench wire protocol, one connection per stream:
+//
+//	bench -> serve  "SENDFL/1 <size> <method>\n" then the payload, then CloseWrite
+//	serve -> bench  "RESULT <received> <sha256>\n" once it has read to EOF
+//
+// The method is the rest of the header line, so it may contain spaces.
+const protoMagic = "SENDFL/1"
+
+// benchHeader announces a stream to the receiver.
+type benchHeader struct {
+	Method string
+	Size   int64 // bytes the sender intends to send
+}
+
+func writeHeader(w io.Writer, h benchHeader) error {
+	if strings.ContainsAny(h.Method, "\r\n") {
+		return fmt.Errorf("method name %q contains a newline", h.Method)
+	}
+	_, err := fmt.Fprintf(w, "%s %d %s\n", protoMagic, h.Size, h.Method)
+	return err
+}
+
+func readHeader(r *bufio.Reader) (benchHeader, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return benchHeader{}, fmt.Errorf("reading header: %v", err)
+	}
+	parts := strings.SplitN(strings.TrimSuffix(line, "\n"), " ", 3)
+	if len(parts) != 3 || parts[0] != protoMagic {
+		return benchHeader{}, fmt.Errorf("bad header %q", line)
+	}
+	size, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil || size < 0 {
+		return benchHeader{}, fmt.Errorf("bad size in header %q", line)
+	}
+	return benchHeader{Method: parts[2], Size: size}, nil
+}
+
+// writeVerdict reports what the receiver got.
+func writeVerdict(w io.Writer, got drained) error {
+	_, err := fmt.Fprintf(w, "RESULT %d %s\n", got.n, got.sum)
+	return err
+}
+
+func readVerdict(r io.Reader) (drained, error) {
+	line, err := bufio.NewReader(r).ReadString('\n')
+	if err != nil {
+		return drained{}, fmt.Errorf("reading verdict: %v", err)
+	}
+	var got drained
+	if _, err := fmt.Sscanf(line, "RESULT %d %s\n", &got.n, &got.sum); err != nil {
+		return drained{}, fmt.Errorf("bad verdict %q: %v", line, err)
+	}
+	return got, nil
+}
@@ -0,0 +1,327 @@
+package sendfl
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"time"
+
+	"goexp/internal/bench"
+)
+
+// noisyCV is the coefficient of variation (stddev / mean of throughput) above
+// which a method's runs are flagged as too noisy to compare.
+const noisyCV = 0.20
+
+// MethodSummary is the per-method aggregate over all iterations; every
+// output format is rendered from the same summary.
+type MethodSummary struct {
+	Method            string        `json:"method"`
+	Transport         string        `json:"transport"`
+	Concurrency       int           `json:"concurrency"`
+	Runs              int           `json:"runs"`
+	AvgDuration       time.Duration `json:"avg_duration_ns"`
+	AvgMemoryIncrease int64         `json:"avg_mem_increase_bytes"`
+	AvgRSSIncrease    int64         `json:"avg_rss_increase_bytes"`
+	AvgThroughputMBps float64       `json:"avg_throughput_mbps"`
+	AvgUserCPU        time.Duration `json:"avg_user_cpu_ns"`
+	AvgSystemCPU      time.Duration `json:"avg_sys_cpu_ns"`
+	SysCPUPerGB       time.Duration `json:"sys_cpu_per_gb_ns"`
+	AvgVoluntaryCtx   int64         `json:"avg_voluntary_ctx_switches"`
+	AvgInvoluntaryCtx int64         `json:"avg_involuntary_ctx_switches"`
+	MinStreamMBps     float64       `json:"min_stream_mbps"` // slowest single stream seen
+	MaxStreamMBps     float64       `json:"max_stream_mbps"`
+	Mismatches        int           `json:"mismatches"`
+	ChecksumFailures  int           `json:"checksum_failures"`
+
+	Duration   bench.Summary `json:"duration_ns"`
+	Throughput bench.Summary `json:"throughput_mbps"`
+	Noisy      bool          `json:"noisy"` // throughput stddev above noisyCV of the mean
+}
+
+// throughputMBps is bytes written per second, in MiB/s.
+func throughputMBps(r BenchmarkResult) float64 {
+	if r.Duration <= 0 {
+		return 0
+	}
+	return float64(r.BytesWritten) / r.Duration.Seconds() / 1024 / 1024
+}
+
+// sysCPUPerGB scales system CPU time to one GiB moved, the number that tells
+// copying variants from zero-copy ones.
+func sysCPUPerGB(sys time.Duration, bytes int64) time.Duration {
+	if bytes <= 0 {
+		return 0
+	}
+	return time.Duration(float64(sys) * float64(1<<30) / float64(bytes))
+}
+
+// SummarizeResults aggregates each (method, concurrency) pair across
+// iterations, keeping the order in which they were first run: averages for
+// the resource columns, and min/max/mean/stddev/p50/p95 of duration and
+// throughput. Different concurrency levels are never aggregated together and
+// skipped runs are left out.
+func SummarizeResults(results [][]BenchmarkResult) []MethodSummary {
+	type totals struct {
+		duration   time.Duration
+		memory     int64
+		rss        int64
+		throughput float64
+		user       time.Duration
+		sys        time.Duration
+		bytes      int64
+		nvcsw      int64
+		nivcsw     int64
+		durations  []time.Duration
+		mbps       []float64
+	}
+	type key struct {
+		method      string
+		concurrency int
+	}
+	index := make(map[key]int)
+	var out []MethodSummary
+	var sums []totals
+
+	for _, iteration := range results {
+		for _, r := range iteration {
+			if r.Skipped != "" {
+				continue
+			}
+			k := key{r.Method, r.Concurrency}
+			i, ok := index[k]
+			if !ok {
+				i = len(out)
+				index[k] = i
+				out = append(out, MethodSummary{Method: r.Method, Transport: r.Transport, Concurrency: r.Concurrency,
+					MinStreamMBps: r.StreamMinMBps, MaxStreamMBps: r.StreamMaxMBps})
+				sums = append(sums, totals{})
+			}
+			out[i].MinStreamMBps = math.Min(out[i].MinStreamMBps, r.StreamMinMBps)
+			out[i].MaxStreamMBps = math.Max(out[i].MaxStreamMBps, r.StreamMaxMBps)
+			out[i].Runs++
+			if r.Mismatch != "" {
+				out[i].Mismatches++
+			}
+			if !r.ChecksumOK {
+				out[i].ChecksumFailures++
+			}
+			sums[i].duration += r.Duration
+			sums[i].memory += r.MemoryIncrease
+			sums[i].rss += r.RSSIncrease
+			sums[i].throughput += throughputMBps(r)
+			sums[i].user += r.UserCPUTime
+			sums[i].sys += r.SystemCPUTime
+			sums[i].bytes += r.BytesWritten
+			sums[i].nvcsw += r.VoluntaryCtxSwitches
+			sums[i].nivcsw += r.InvoluntaryCtxSwitches
+			sums[i].durations = append(sums[i].durations, r.Duration)
+			sums[i].mbps = append(sums[i].mbps, throughputMBps(r))
+		}
+	}
+
+	for i := range out {
+		n := out[i].Runs
+		out[i].AvgDuration = sums[i].duration / time.Duration(n)
+		out[i].AvgMemoryIncrease = sums[i].memory / int64(n)
+		out[i].AvgRSSIncrease = sums[i].rss / int64(n)
+		out[i].AvgThroughputMBps = sums[i].throughput / float64(n)
+		out[i].AvgUserCPU = sums[i].user / time.Duration(n)
+		out[i].AvgSystemCPU = sums[i].sys / time.Duration(n)
+		out[i].SysCPUPerGB = sysCPUPerGB(sums[i].sys, sums[i].bytes)
+		out[i].AvgVoluntaryCtx = sums[i].nvcsw / int64(n)
+		out[i].AvgInvoluntaryCtx = sums[i].nivcsw / int64(n)
+		out[i].Duration = bench.SummarizeDurations(sums[i].durations)
+		out[i].Throughput = bench.Summarize(sums[i].mbps)
+		out[i].Noisy = out[i].Throughput.StdDev > noisyCV*out[i].Throughput.Mean
+	}
+	return out
+}
+
+// writeResults renders results in the requested format: table, csv or json.
+func writeResults(w io.Writer, format string, info RunInfo, results [][]BenchmarkResult) error {
+	switch format {
+	case "table":
+		return writeTable(w, info, results, SummarizeResults(results))
+	case "csv":
+		return writeCSV(w, info, results)
+	case "json":
+		return writeJSON(w, info, results, SummarizeResults(results))
+	default:
+		return fmt.Errorf("unknown output format %q (want table, csv or json)", format)
+	}
+}
+
+func writeTable(w io.Writer, info RunInfo, results [][]BenchmarkResult, summary []MethodSummary) error {
+	fmt.Fprintf(w, "\nBenchmark Results (averaged over %d runs):\n", len(results))
+	fmt.Fprintln(w, "==========================================")
+	fmt.Fprintf(w, "Data: %s", info.Data)
+	if info.FileCreation > 0 {
+		fmt.Fprintf(w, ", test file created in %s (not included below)", info.FileCreation.Round(time.Millisecond))
+	}
+	fmt.Fprintln(w)
+	if len(results) > 0 && len(results[0]) > 0 && results[0][0].Offset > 0 {
+		fmt.Fprintf(w, "Range: from offset %d\n", results[0][0].Offset)
+	}
+	if transport := firstTransport(results); transport != "" {
+		fmt.Fprintf(w, "Transport: %s\n", transport)
+	}
+	if sock := firstSocketOptions(results); sock != nil {
+		fmt.Fprintf(w, "Socket: nodelay=%v sndbuf=%d rcvbuf=%d (effective, as reported by getsockopt)\n",
+			sock.NoDelay, sock.SndBuf, sock.RcvBuf)
+	}
+	fmt.Fprintf(w, "%-25s | %-4s | %-15s | %-20s | %-15s | %-15s | %-19s | %-12s | %-11s\n",
+		"Method", "Conc", "Duration", "Memory Increase", "RSS Increase", "Throughput", "Stream min/max MB/s", "Sys CPU/GB", "Ctx vol/inv")
+	fmt.Fprintln(w, "------------------------------------------------------------------------------------------------------------------------------------------------------")
+
+	// Methods this platform lacks are noted once and left out of the averages.
+	noted := make(map[string]bool)
+	for _, iteration := range results {
+		for _, result := range iteration {
+			if result.Skipped != "" && !noted[result.Method] {
+				noted[result.Method] = true
+				fmt.Fprintf(w, "NOTE: %s skipped: %s\n", result.Method, result.Skipped)
+			}
+		}
+	}
+
+	// Transfers that did not deliver the whole file make the numbers meaningless.
+	for i, iteration := range results {
+		for _, result := range iteration {
+			if result.Skipped != "" {
+				continue
+			}
+			if result.Mismatch != "" {
+				fmt.Fprintf(w, "WARNING: iteration %d %s: %s\n", i, result.Method, result.Mismatch)
+			}
+			if !result.ChecksumOK {
+				fmt.Fprintf(w, "!!! CHECKSUM MISMATCH: iteration %d %s: expected sha256 %s, received %s — throughput for this run is meaningless\n",
+					i, result.Method, result.ExpectedSHA256, result.ReceivedSHA256)
+			}
+		}
+	}
+
+	for _, s := range summary {
+		fmt.Fprintf(w, "%-25s | %4d | %13v | %18d | %13d | %13.2f MB/s | %9.1f/%-9.1f | %12v | %5d/%-5d\n",
+			s.Method,
+			s.Concurrency,
+			s.AvgDuration.Round(time.Millisecond),
+			s.AvgMemoryIncrease,
+			s.AvgRSSIncrease,
+			s.AvgThroughputMBps,
+			s.MinStreamMBps,
+			s.MaxStreamMBps,
+			s.SysCPUPerGB.Round(time.Millisecond),
+			s.AvgVoluntaryCtx,
+			s.AvgInvoluntaryCtx)
+	}
+
+	fmt.Fprintf(w, "\nStatistics over %d runs (duration in ms, throughput in MB/s):\n", len(results))
+	fmt.Fprintf(w, "%-25s | %-4s | %8s %8s %8s %8s %8s | %8s %8s %8s %8s %8s %8s |\n",
+		"Method", "Conc", "min", "p50", "p95", "max", "stddev", "min", "p50", "p95", "max", "mean", "stddev")
+	fmt.Fprintln(w, "-------------------------------------------------------------------------------------------------------------------------------------")
+	ms := func(ns float64) float64 { return ns / float64(time.Millisecond) }
+	for _, s := range summary {
+		d, t := s.Duration, s.Throughput
+		noisy := ""
+		if s.Noisy {
+			noisy = fmt.Sprintf(" NOISY (stddev %.0f%% of mean)", 100*t.StdDev/t.Mean)
+		}
+		fmt.Fprintf(w, "%-25s | %4d | %8.1f %8.1f %8.1f %8.1f %8.1f | %8.1f %8.1f %8.1f %8.1f %8.1f %8.1f |%s\n",
+			s.Method, s.Concurrency,
+			ms(d.Min), ms(d.P50), ms(d.P95), ms(d.Max), ms(d.StdDev),
+			t.Min, t.P50, t.P95, t.Max, t.Mean, t.StdDev, noisy)
+	}
+	return nil
+}
+
+// writeCSV emits one row per (iteration, method); skipped methods have no row.
+func writeCSV(w io.Writer, info RunInfo, results [][]BenchmarkResult) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"iteration", "method", "transport", "concurrency", "duration_ns", "bytes", "throughput_mbps", "stream_min_mbps", "stream_max_mbps", "mem_increase_bytes", "checksum_ok",
+		"user_cpu_ns", "sys_cpu_ns", "sys_cpu_per_gb_ns", "vol_ctx_switches", "invol_ctx_switches",
+		"nodelay", "sndbuf", "rcvbuf", "data", "seed"})
+	for i, iteration := range results {
+		for _, r := range iteration {
+			if r.Skipped != "" {
+				continue
+			}
+			cw.Write(append([]string{
+				strconv.Itoa(i),
+				r.Method,
+				r.Transport,
+				strconv.Itoa(r.Concurrency),
+				strconv.FormatInt(int64(r.Duration), 10),
+				strconv.FormatInt(r.BytesWritten, 10),
+				strconv.FormatFloat(throughputMBps(r), 'f', 2, 64),
+				strconv.FormatFloat(r.StreamMinMBps, 'f', 2, 64),
+				strconv.FormatFloat(r.StreamMaxMBps, 'f', 2, 64),
+				strconv.FormatInt(r.MemoryIncrease, 10),
+				strconv.FormatBool(r.ChecksumOK),
+				strconv.FormatInt(int64(r.UserCPUTime), 10),
+				strconv.FormatInt(int64(r.SystemCPUTime), 10),
+				strconv.FormatInt(int64(sysCPUPerGB(r.SystemCPUTime, r.BytesWritten)), 10),
+				strconv.FormatInt(r.VoluntaryCtxSwitches, 10),
+				strconv.FormatInt(r.InvoluntaryCtxSwitches, 10),
+			}, append(socketColumns(r.Socket), info.Data.Mode, dataSeedColumn(info.Data))...))
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// dataSeedColumn is the seed, or blank where it does not apply.
+func dataSeedColumn(d DataSpec) string {
+	if d.Mode == "zeros" || d.CryptoRand {
+		return ""
+	}
+	return strconv.FormatInt(d.Seed, 10)
+}
+
+// socketColumns renders the effective socket options, blank for file runs.
+func socketColumns(sock *SocketOptions) []string {
+	if sock == nil {
+		return []string{"", "", ""}
+	}
+	return []string{strconv.FormatBool(sock.NoDelay), strconv.Itoa(sock.SndBuf), strconv.Itoa(sock.RcvBuf)}
+}
+
+// firstSocketOptions returns the conditions of the first socket run; every
+// run uses the same flags, so the table states them once.
+func firstSocketOptions(results [][]BenchmarkResult) *SocketOptions {
+	for _, iteration := range results {
+		for _, r := range iteration {
+			if r.Socket != nil {
+				return r.Socket
+			}
+		}
+	}
+	return nil
+}
+
+// firstTransport returns what the runs were sent over; it is the same for
+// the whole invocation.
+func firstTransport(results [][]BenchmarkResult) string {
+	for _, iteration := range results {
+		for _, r := range iteration {
+			if r.Transport != "" {
+				return r.Transport
+			}
+		}
+	}
+	return ""
+}
+
+// writeJSON emits the run setup, every iteration's raw results and the averages.
+func writeJSON(w io.Writer, info RunInfo, results [][]BenchmarkResult, summary []MethodSummary) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(struct {
+		Run        RunInfo             `json:"run"`
+		Iterations [][]BenchmarkResult `json:"iterations"`
+		Summary    []MethodSummary     `json:"summary"`
+	}{info, results, summary})
+}
@@ -0,0 +1,170 @@
+package sendfl
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"math"
+	"testing"
+	"time"
+
+	"goexp/internal/bench"
+)
+
+// result builds a BenchmarkResult moving 1 MiB in d, so 10ms is 100 MB/s.
+func result(method string, concurrency int, d time.Duration) BenchmarkResult {
+	return BenchmarkResult{Method: method, Concurrency: concurrency, Duration: d, BytesWritten: 1 << 20, ChecksumOK: true}
+}
+
+func TestSummarizeResults(t *testing.T) {
+	ms := time.Millisecond
+	msf := float64(ms)
+	for _, tc := range []struct {
+		name    string
+		results [][]BenchmarkResult
+		want    []MethodSummary // only the fields checked below
+	}{
+		{
+			name: "steady",
+			results: [][]BenchmarkResult{
+				{result("sendfile", 1, 10*ms)},
+				{result("sendfile", 1, 10*ms)},
+			},
+			want: []MethodSummary{{Method: "sendfile", Concurrency: 1, Runs: 2,
+				Duration:   bench.Summary{Min: 10 * msf, Max: 10 * msf, Mean: 10 * msf, P50: 10 * msf, P95: 10 * msf},
+				Throughput: bench.Summary{Min: 100, Max: 100, Mean: 100, P50: 100, P95: 100}}},
+		},
+		{
+			name: "noisy",
+			results: [][]BenchmarkResult{
+				{result("buffer", 1, 10*ms)},
+				{result("buffer", 1, 20*ms)},
+				{result("buffer", 1, 40*ms)},
+			},
+			want: []MethodSummary{{Method: "buffer", Concurrency: 1, Runs: 3, Noisy: true,
+				Duration:   bench.Summary{Min: 10 * msf, Max: 40 * msf, Mean: 70 * msf / 3, P50: 20 * msf, P95: 38 * msf},
+				Throughput: bench.Summary{Min: 25, Max: 100, Mean: 175.0 / 3, P50: 50, P95: 95}}},
+		},
+		{
+			name: "concurrency levels stay apart, skipped runs ignored, order kept",
+			results: [][]BenchmarkResult{
+				{result("splice", 4, 40*ms), result("splice", 1, 10*ms), {Method: "mmap", Concurrency: 1, Skipped: "unsupported"}},
+				{result("splice", 4, 40*ms), result("splice", 1, 10*ms)},
+			},
+			want: []MethodSummary{
+				{Method: "splice", Concurrency: 4, Runs: 2,
+					Duration:   bench.Summary{Min: 40 * msf, Max: 40 * msf, Mean: 40 * msf, P50: 40 * msf, P95: 40 * msf},
+					Throughput: bench.Summary{Min: 25, Max: 25, Mean: 25, P50: 25, P95: 25}},
+				{Method: "splice", Concurrency: 1, Runs: 2,
+					Duration:   bench.Summary{Min: 10 * msf, Max: 10 * msf, Mean: 10 * msf, P50: 10 * msf, P95: 10 * msf},
+					Throughput: bench.Summary{Min: 100, Max: 100, Mean: 100, P50: 100, P95: 100}},
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got := SummarizeResults(tc.results)
+			if len(got) != len(tc.want) {
+				t.Fatalf("got %d summaries, want %d: %+v", len(got), len(tc.want), got)
+			}
+			for i, w := range tc.want {
+				g := got[i]
+				if g.Method != w.Method || g.Concurrency != w.Concurrency || g.Runs != w.Runs || g.Noisy != w.Noisy {
+					t.Errorf("[%d] = %s/%d runs=%d noisy=%v, want %s/%d runs=%d noisy=%v",
+						i, g.Method, g.Concurrency, g.Runs, g.Noisy, w.Method, w.Concurrency, w.Runs, w.Noisy)
+				}
+				for _, f := range []struct {
+					name      string
+					got, want float64
+				}{
+					{"duration min", g.Duration.Min, w.Duration.Min},
+					{"duration max", g.Duration.Max, w.Duration.Max},
+					{"duration mean", g.Duration.Mean, w.Duration.Mean},
+					{"duration p50", g.Duration.P50, w.Duration.P50},
+					{"duration p95", g.Duration.P95, w.Duration.P95},
+					{"throughput min", g.Throughput.Min, w.Throughput.Min},
+					{"throughput max", g.Throughput.Max, w.Throughput.Max},
+					{"throughput mean", g.Throughput.Mean, w.Throughput.Mean},
+					{"throughput p50", g.Throughput.P50, w.Throughput.P50},
+					{"throughput p95", g.Throughput.P95, w.Throughput.P95},
+				} {
+					if math.Abs(f.got-f.want) > 1e-6 {
+						t.Errorf("[%d] %s = %v, want %v", i, f.name, f.got, f.want)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestWriteResultsColumns(t *testing.T) {
+	r := result("sendfile", 1, 10*time.Millisecond)
+	r.MemoryIncrease = 4096
+	results := [][]BenchmarkResult{
+		{r, {Method: "mmap", Concurrency: 1, Skipped: "unsupported"}},
+		{r},
+	}
+
+	t.Run("csv", func(t *testing.T) {
+		var buf bytes.Buffer
+		if err := writeResults(&buf, "csv", RunInfo{}, results); err != nil {
+			t.Fatal(err)
+		}
+		rows, err := csv.NewReader(&buf).ReadAll()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(rows) != 3 {
+			t.Fatalf("got %d rows, want a header and one per non-skipped (iteration, method): %q", len(rows), rows)
+		}
+		col := make(map[string]int)
+		for i, name := range rows[0] {
+			col[name] = i
+		}
+		for _, want := range []struct{ name, value string }{
+			{"iteration", "1"},
+			{"method", "sendfile"},
+			{"duration_ns", "10000000"},
+			{"bytes", "1048576"},
+			{"throughput_mbps", "100.00"},
+			{"mem_increase_bytes", "4096"},
+		} {
+			i, ok := col[want.name]
+			if !ok {
+				t.Errorf("no %s column in %q", want.name, rows[0])
+				continue
+			}
+			if got := rows[2][i]; got != want.value {
+				t.Errorf("%s = %q, want %q", want.name, got, want.value)
+			}
+		}
+	})
+
+	t.Run("json", func(t *testing.T) {
+		var buf bytes.Buffer
+		if err := writeResults(&buf, "json", RunInfo{}, results); err != nil {
+			t.Fatal(err)
+		}
+		var out struct {
+			Iterations [][]map[string]interface{} `json:"iterations"`
+			Summary    []map[string]interface{}   `json:"summary"`
+		}
+		if err := json.Unmarshal(buf.Bytes(), &out); err != nil {
+			t.Fatal(err)
+		}
+		if len(out.Iterations) != 2 || len(out.Iterations[0]) != 2 {
+			t.Errorf("iterations = %v, want every raw result including the skipped one", out.Iterations)
+		}
+		if len(out.Summary) != 1 {
+			t.Fatalf("summary = %v, want one entry", out.Summary)
+		}
+		for key, want := range map[string]float64{
+			"avg_duration_ns":        1e7,
+			"avg_mem_increase_bytes": 4096,
+			"avg_throughput_mbps":    100,
+		} {
+			if got, ok := out.Summary[0][key].(float64); !ok || math.Abs(got-want) > 1e-9 {
+				t.Errorf("summary %s = %v, want %v", key, out.Summary[0][key], want)
+			}
+		}
+	})
+}
@@ -0,0 +1,23 @@
+//go:build linux
+// +build linux
+
+package sendfl
+
+import (
+	"fmt"
+	"os"
+)
+
+// getRSS returns the resident set size in bytes from /proc/self/statm. Unlike
+// the Go heap it includes mapped file pages, which is where mmap's memory goes.
+func getRSS() int64 {
+	b, err := os.ReadFile("/proc/self/statm")
+	if err != nil {
+		return 0
+	}
+	var size, resident int64
+	if _, err := fmt.Sscan(string(b), &size, &resident); err != nil {
+		return 0
+	}
+	return resident * int64(os.Getpagesize())
+}
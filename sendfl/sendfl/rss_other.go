@@ -0,0 +1,7 @@
+//go:build !linux
+// +build !linux
+
+package sendfl
+
+// getRSS is only implemented on linux (/proc/self/statm); elsewhere it reports 0.
+func getRSS() int64 { return 0 }
@@ -0,0 +1,756 @@
+// Package sendfl benchmarks the ways of sending a file over a socket, or
+// copying it to another file: buffered copies, sendfile, splice, mmap and
+// the standard library's paths.
+package sendfl
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"goexp/internal/bench"
+	"goexp/sendf/transfer"
+)
+
+// Benchmark structure to hold results
+type BenchmarkResult struct {
+	Method        string
+	Transport     string // tcp, unix or pipe; file for -target=file
+	Concurrency   int    // parallel streams, each sending the whole range
+	Offset        int64  // start of the transferred range in the test file
+	Duration      time.Duration
+	BytesWritten  int64 // as reported by the sender
+	BytesReceived int64 // as counted by the drain goroutine on the peer
+	// Slowest and fastest single stream; equal to the aggregate when Concurrency is 1.
+	StreamMinMBps  float64
+	StreamMaxMBps  float64
+	Mismatch       string
+	Skipped        string         `json:",omitempty"` // why the method could not run on this platform
+	Socket         *SocketOptions `json:",omitempty"` // effective options; nil for -target=file
+	ChecksumOK     bool
+	ExpectedSHA256 string `json:",omitempty"` // only set when ChecksumOK is false
+	ReceivedSHA256 string `json:",omitempty"`
+	MemoryBefore   uint64
+	MemoryAfter    uint64
+	MemoryIncrease int64 // Go heap only; negative if a GC shrank it
+	RSSBefore      int64
+	RSSAfter       int64
+	RSSIncrease    int64 // resident set, includes mapped page-cache pages
+
+	// getrusage deltas over the run; system time is where copies show up.
+	UserCPUTime            time.Duration
+	SystemCPUTime          time.Duration
+	VoluntaryCtxSwitches   int64
+	InvoluntaryCtxSwitches int64
+}
+
+// drained is what the receiving side saw: a byte count and the SHA-256 of
+// those bytes.
+type drained struct {
+	n   int64
+	sum string
+	at  time.Time // when the last byte arrived
+}
+
+// drain reads conn to EOF on its own goroutine, hashing everything it reads.
+// Without a reader the sender stalls as soon as the socket buffers fill up.
+func drain(conn io.Reader) <-chan drained {
+	done := make(chan drained, 1)
+	go func() {
+		h := sha256.New()
+		n, err := io.Copy(h, conn)
+		if err != nil {
+			log.Printf("drain error after %d bytes: %v", n, err)
+		}
+		done <- drained{n: n, sum: hex.EncodeToString(h.Sum(nil)), at: time.Now()}
+	}()
+	return done
+}
+
+// closeWrite half-closes conn so the drain goroutine sees EOF; a pipe's
+// write end is simply closed.
+func closeWrite(conn io.Closer) {
+	if cw, ok := conn.(interface{ CloseWrite() error }); ok {
+		cw.CloseWrite()
+		return
+	}
+	conn.Close()
+}
+
+// Run benchmark for a transfer method. transferFn must close the sending
+// sides when done; the duration covers every receiver having drained (and
+// hashed) every byte. Each stream is checked against want on its own, and
+// the total against len(received)×want.Length.
+func runBenchmark(method string, want testFile, received []<-chan drained, transferFn func() (int64, error)) BenchmarkResult {
+	var (
+		total               int64
+		badSum              string
+		minMBps, maxMBps    = math.Inf(1), 0.0
+		rssBefore, rssAfter int64
+	)
+	run := bench.Runner{Settle: *cooldown}.Run(method, func() (int64, error) {
+		rssBefore = getRSS()
+		startTime := time.Now()
+		written, err := transferFn()
+		for _, ch := range received {
+			got := <-ch
+			total += got.n
+			if got.sum != want.SHA256 && badSum == "" {
+				badSum = got.sum
+			}
+			mbps := 0.0
+			if d := got.at.Sub(startTime); d > 0 {
+				mbps = float64(got.n) / d.Seconds() / 1024 / 1024
+			}
+			minMBps = math.Min(minMBps, mbps)
+			maxMBps = math.Max(maxMBps, mbps)
+		}
+		rssAfter = getRSS()
+		return written, err
+	})
+	skipped := errors.Is(run.Err, transfer.ErrUnsupported)
+	if run.Err != nil && !skipped {
+		log.Printf("Error in %s: %v", method, run.Err)
+	}
+
+	result := BenchmarkResult{
+		Method:         method,
+		Concurrency:    len(received),
+		Offset:         want.Offset,
+		Duration:       run.Wall,
+		BytesWritten:   run.Bytes,
+		BytesReceived:  total,
+		StreamMinMBps:  minMBps,
+		StreamMaxMBps:  maxMBps,
+		ChecksumOK:     badSum == "",
+		MemoryBefore:   run.HeapBefore,
+		MemoryAfter:    run.HeapAfter,
+		MemoryIncrease: run.HeapDelta(),
+		RSSBefore:      rssBefore,
+		RSSAfter:       rssAfter,
+		RSSIncrease:    rssAfter - rssBefore,
+
+		UserCPUTime:            run.UserCPU,
+		SystemCPUTime:          run.SystemCPU,
+		VoluntaryCtxSwitches:   run.VoluntaryCtxSwitches,
+		InvoluntaryCtxSwitches: run.InvoluntaryCtxSwitches,
+	}
+	if skipped {
+		result.Skipped = run.Err.Error()
+		log.Printf("Skipping %s: %v", method, run.Err)
+		return result
+	}
+	expected := int64(len(received)) * want.Length
+	if run.Bytes != expected || total != expected {
+		result.Mismatch = fmt.Sprintf("expected %d bytes (%d×%d), sent %d, received %d", expected, len(received), want.Length, run.Bytes, total)
+		log.Printf("MISMATCH in %s: %s", method, result.Mismatch)
+	}
+	if !result.ChecksumOK {
+		result.ExpectedSHA256 = want.SHA256
+		result.ReceivedSHA256 = badSum
+		log.Printf("CHECKSUM MISMATCH in %s: expected sha256 %s, received %s", method, want.SHA256, badSum)
+	}
+	return result
+}
+
+// flags are the command line of `sendfl bench`.
+var flags = flag.NewFlagSet("sendfl", flag.ExitOnError)
+
+func init() {
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "usage: sendfl [bench] [flags]\n       sendfl serve [-addr host:port]\n")
+		flags.PrintDefaults()
+	}
+}
+
+var (
+	outputFormat = flags.String("output", "table", "result format: table, csv or json")
+	outFile      = flags.String("out-file", "", "write results to this file instead of stdout")
+
+	sizeFlag    = flags.String("size", "100MB", "test file size, e.g. 512KB, 100MB, 1GiB")
+	buffersFlag = flags.String("buffers", "4K,8K,32K,64K", "buffer/chunk sizes for the buffered and mmap copies")
+	iterations  = flags.Int("iterations", 10, "number of benchmark iterations (percentiles need a few)")
+	cooldown    = flags.Duration("cooldown", time.Second, "pause before each run and between iterations")
+	keepFile    = flags.Bool("keep-file", false, "reuse an existing test file of the right size and data and leave it in place")
+	dataMode    = flags.String("data", "random", "test file contents: zeros, random (incompressible) or text (compressible)")
+	dataSeed    = flags.Int64("seed", 1, "seed for -data=random and -data=text, so runs are reproducible")
+	cryptoRand  = flags.Bool("crypto-rand", false, "fill -data=random from crypto/rand instead of the seeded generator")
+	noDelay     = flags.Bool("nodelay", true, "TCP_NODELAY on both ends of the benchmark connection")
+	sndBuf      = flags.String("sndbuf", "", "SO_SNDBUF for both ends, e.g. 16K (empty = kernel default)")
+	rcvBuf      = flags.String("rcvbuf", "", "SO_RCVBUF for both ends, e.g. 16K (empty = kernel default)")
+	target      = flags.String("target", "socket", "copy destination: socket (file -> TCP) or file (file -> file on the same filesystem)")
+	offsetFlag  = flags.String("offset", "0", "start the transfer this far into the file, e.g. 50MB")
+	lengthFlag  = flags.String("length", "", "bytes to transfer from -offset (empty = to end of file)")
+	concurrency = flags.String("concurrency", "1", "parallel streams per socket run; a list like 1,4,16 runs each level separately")
+	connect     = flags.String("connect", "", "send to a remote receiver started with sendfl serve at `host:port` instead of an in-process one")
+	transport   = flags.String("transport", "tcp", "what -target=socket sends over: tcp (loopback), unix (domain socket) or pipe")
+)
+
+// Run runs the command line args: `sendfl serve` is the remote receiver;
+// `sendfl bench` (or no subcommand at all) runs the method matrix.
+func Run(args []string) error {
+	if len(args) > 0 {
+		switch args[0] {
+		case "serve":
+			return serve(args[1:])
+		case "bench":
+			args = args[1:]
+		}
+	}
+	flags.Parse(args)
+	switch *outputFormat {
+	case "table", "csv", "json":
+	default:
+		return fmt.Errorf("unknown -output %q (want table, csv or json)", *outputFormat)
+	}
+	switch *target {
+	case "socket", "file":
+	default:
+		return fmt.Errorf("unknown -target %q (want socket or file)", *target)
+	}
+	switch *transport {
+	case "tcp", "unix", "pipe":
+	default:
+		return fmt.Errorf("unknown -transport %q (want tcp, unix or pipe)", *transport)
+	}
+	if *connect != "" && (*transport != "tcp" || *target != "socket") {
+		return errors.New("-connect needs -target=socket -transport=tcp")
+	}
+
+	fileSize, err := parseSize(*sizeFlag)
+	if err != nil {
+		return fmt.Errorf("-size: %w", err)
+	}
+	bufferSizes, err := parseSizeList(*buffersFlag)
+	if err != nil {
+		return fmt.Errorf("-buffers: %w", err)
+	}
+	var rangeOffset, rangeLength int64
+	if *offsetFlag != "0" {
+		if rangeOffset, err = parseSize(*offsetFlag); err != nil {
+			return fmt.Errorf("-offset: %w", err)
+		}
+	}
+	if *lengthFlag != "" {
+		if rangeLength, err = parseSize(*lengthFlag); err != nil {
+			return fmt.Errorf("-length: %w", err)
+		}
+	}
+	requestedSocket.NoDelay = *noDelay
+	for _, b := range []struct {
+		name string
+		val  string
+		dst  *int
+	}{{"-sndbuf", *sndBuf, &requestedSocket.SndBuf}, {"-rcvbuf", *rcvBuf, &requestedSocket.RcvBuf}} {
+		if b.val == "" {
+			continue
+		}
+		n, err := parseSize(b.val)
+		if err != nil {
+			return fmt.Errorf("%s: %w", b.name, err)
+		}
+		*b.dst = int(n)
+	}
+	if err := (DataSpec{Mode: *dataMode}).validate(); err != nil {
+		return err
+	}
+	levels, err := parseCountList(*concurrency)
+	if err != nil {
+		return fmt.Errorf("-concurrency: %w", err)
+	}
+	if *iterations < 1 {
+		return errors.New("-iterations must be at least 1")
+	}
+	const filename = "testfile.dat"
+
+	removeTempsOnSignal()
+	defer temps.removeAll()
+	if !*keepFile {
+		temps.add(filename)
+	}
+	info := RunInfo{Data: DataSpec{Mode: *dataMode, Seed: *dataSeed, CryptoRand: *cryptoRand}}
+	if info.FileCreation, err = prepareTestFile(filename, fileSize, *keepFile, info.Data); err != nil {
+		return fmt.Errorf("failed to create test file: %w", err)
+	}
+	if info.FileCreation > 0 {
+		// Kept apart from the transfer numbers on purpose.
+		fmt.Fprintf(os.Stderr, "Test file created in %s (not part of any benchmark)\n", info.FileCreation.Round(time.Millisecond))
+	}
+	if *transport == "unix" {
+		dir, err := os.MkdirTemp("", "sendfl-")
+		if err != nil {
+			return fmt.Errorf("failed to create socket dir: %w", err)
+		}
+		temps.add(dir)
+		unixSocketDir = dir
+	}
+	tf, clamped, err := newTestFile(filename, rangeOffset, rangeLength)
+	if err != nil {
+		return fmt.Errorf("failed to checksum test file: %w", err)
+	}
+	if clamped {
+		fmt.Fprintf(os.Stderr, "NOTE: -length %s runs past the end of the file; transferring only the %s that exist after -offset\n",
+			formatSize(rangeLength), formatSize(tf.Length))
+	}
+	fmt.Fprintf(os.Stderr, "Test range %d+%d sha256 %s\n", tf.Offset, tf.Length, tf.SHA256)
+
+	// Run benchmarks multiple times
+	results := make([][]BenchmarkResult, 0)
+
+	for i := 0; i < *iterations; i++ {
+		fmt.Fprintln(os.Stderr, "Running iteration ", i)
+		var iterationResults []BenchmarkResult
+		if *target == "file" {
+			if iterationResults, err = runFileMethods(tf, bufferSizes); err != nil {
+				return err
+			}
+		} else {
+			for _, n := range levels {
+				levelResults, err := runSocketMethods(tf, bufferSizes, n)
+				if err != nil {
+					return err
+				}
+				iterationResults = append(iterationResults, levelResults...)
+			}
+		}
+		results = append(results, iterationResults)
+		time.Sleep(*cooldown) // Cool down between iterations
+	}
+
+	// Print results
+	out := io.Writer(os.Stdout)
+	if *outFile != "" {
+		f, err := os.Create(*outFile)
+		if err != nil {
+			return fmt.Errorf("failed to create %s: %w", *outFile, err)
+		}
+		defer f.Close()
+		out = f
+	}
+	if err := writeResults(out, *outputFormat, info, results); err != nil {
+		return fmt.Errorf("failed to write results: %w", err)
+	}
+	return nil
+}
+
+// runSocketMethods runs every file -> socket strategy once over -transport,
+// with streams parallel connections each.
+func runSocketMethods(tf testFile, bufferSizes []int, streams int) ([]BenchmarkResult, error) {
+	iterationResults := make([]BenchmarkResult, 0)
+	add := func(result BenchmarkResult, err error) error {
+		if err != nil {
+			return err
+		}
+		iterationResults = append(iterationResults, result)
+		return nil
+	}
+
+	// Test traditional copy with different buffer sizes
+	for _, bufSize := range bufferSizes {
+		fmt.Fprintln(os.Stderr, "Testing traditional copy for buffer size ", formatSize(int64(bufSize)))
+		if err := add(benchmarkTraditionalCopy(tf, bufSize, streams)); err != nil {
+			return nil, err
+		}
+	}
+
+	// Test sendfile
+	fmt.Fprintln(os.Stderr, "Testing sendfile way")
+	if err := add(benchmarkSendFile(tf, streams)); err != nil {
+		return nil, err
+	}
+
+	// Test splice
+	fmt.Fprintln(os.Stderr, "Testing splice way")
+	if err := add(benchmarkSplice(tf, streams)); err != nil {
+		return nil, err
+	}
+
+	// Test mmap with the same chunk sizes as the buffered copy
+	for _, bufSize := range bufferSizes {
+		fmt.Fprintln(os.Stderr, "Testing mmap for chunk size ", formatSize(int64(bufSize)))
+		if err := add(benchmarkMmap(tf, bufSize, streams)); err != nil {
+			return nil, err
+		}
+	}
+
+	// Test the stdlib paths as a baseline
+	fmt.Fprintln(os.Stderr, "Testing ReadFrom way")
+	if err := add(benchmarkMethod("ReadFrom", tf, transfer.ReadFrom, transfer.Options{}, streams)); err != nil {
+		return nil, err
+	}
+
+	fmt.Fprintln(os.Stderr, "Testing io.Copy way")
+	if err := add(benchmarkMethod("io.Copy", tf, transfer.IOCopy, transfer.Options{}, streams)); err != nil {
+		return nil, err
+	}
+	return iterationResults, nil
+}
+
+// runFileMethods runs every file -> file strategy once, so -target=file
+// results line up with the socket ones in the same report.
+func runFileMethods(tf testFile, bufferSizes []int) ([]BenchmarkResult, error) {
+	iterationResults := make([]BenchmarkResult, 0)
+	add := func(result BenchmarkResult, err error) error {
+		if err != nil {
+			return err
+		}
+		iterationResults = append(iterationResults, result)
+		return nil
+	}
+
+	for _, bufSize := range bufferSizes {
+		fmt.Fprintln(os.Stderr, "Testing file read/write for buffer size ", formatSize(int64(bufSize)))
+		methodName := fmt.Sprintf("file r/w (buffer: %s)", formatSize(int64(bufSize)))
+		if err := add(benchmarkFileMethod(methodName, tf, transfer.FileBuffer, transfer.Options{BufferSize: bufSize})); err != nil {
+			return nil, err
+		}
+	}
+
+	fmt.Fprintln(os.Stderr, "Testing file io.Copy way")
+	if err := add(benchmarkFileMethod("file io.Copy", tf, transfer.FileIOCopy, transfer.Options{})); err != nil {
+		return nil, err
+	}
+
+	fmt.Fprintln(os.Stderr, "Testing copy_file_range way")
+	if err := add(benchmarkFileMethod("copy_file_range", tf, transfer.CopyFileRange, transfer.Options{})); err != nil {
+		return nil, err
+	}
+
+	return iterationResults, nil
+}
+
+// prepareTestFile makes sure filename holds size bytes of spec's data. With
+// keep, an existing file of exactly that size and data spec (recorded in a
+// ".data" file next to it) is reused instead of being rewritten; took is 0
+// then.
+func prepareTestFile(filename string, size int64, keep bool, spec DataSpec) (took time.Duration, err error) {
+	existing := int64(0)
+	if fi, err := os.Stat(filename); err == nil {
+		if keep && fi.Size() == size && readDataSpec(filename) == spec.String() {
+			fmt.Fprintln(os.Stderr, "Reusing existing file ", filename)
+			return 0, nil
+		}
+		existing = fi.Size()
+	}
+	free, err := freeSpace(filename)
+	if err != nil {
+		return 0, fmt.Errorf("checking free space: %v", err)
+	}
+	if free >= 0 && free+existing < size {
+		return 0, fmt.Errorf("need %s but only %s free", formatSize(size), formatSize(free+existing))
+	}
+	start := time.Now()
+	if err := createTestFile(filename, size, spec); err != nil {
+		return 0, err
+	}
+	took = time.Since(start)
+	if keep {
+		err = os.WriteFile(filename+".data", []byte(spec.String()+"\n"), 0o644)
+	}
+	return took, err
+}
+
+// readDataSpec returns what a kept test file was filled with, or "".
+func readDataSpec(filename string) string {
+	b, err := os.ReadFile(filename + ".data")
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(b))
+}
+
+// testFile is the payload every method sends: Length bytes of the file at
+// Path starting at Offset. SHA256 covers exactly that range, is computed once,
+// and is compared against what each receiver hashed.
+type testFile struct {
+	Path   string
+	Size   int64 // of the whole file
+	Offset int64
+	Length int64
+	SHA256 string
+}
+
+// errOffsetPastEOF rejects a range that starts beyond the end of the file.
+var errOffsetPastEOF = errors.New("offset is beyond end of file")
+
+// newTestFile describes the range [offset, offset+length) of path. A zero
+// length means the rest of the file; a length running past EOF is cut to
+// what exists, and clamped reports that it was.
+func newTestFile(path string, offset, length int64) (tf testFile, clamped bool, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return testFile{}, false, err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return testFile{}, false, err
+	}
+	size := fi.Size()
+	if offset < 0 || (offset >= size && size > 0) {
+		return testFile{}, false, fmt.Errorf("%w: offset %d, file is %d bytes", errOffsetPastEOF, offset, size)
+	}
+	remaining := size - offset
+	switch {
+	case length == 0:
+		length = remaining
+	case length > remaining:
+		length = remaining
+		clamped = true
+	}
+
+	h := sha256.New()
+	n, err := io.Copy(h, io.NewSectionReader(f, offset, length))
+	if err != nil {
+		return testFile{}, false, err
+	}
+	return testFile{Path: path, Size: size, Offset: offset, Length: n, SHA256: hex.EncodeToString(h.Sum(nil))}, clamped, nil
+}
+
+func createTestFile(filename string, size int64, spec DataSpec) error {
+	file, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	buffer := make([]byte, 1024*1024) // 1MB buffer, refilled for every write
+	fill := spec.filler()
+	fmt.Fprintln(os.Stderr, "Start creating file with size ", formatSize(size), "data", spec)
+	remaining := size
+	for remaining > 0 {
+		writeSize := int64(len(buffer))
+		if remaining < writeSize {
+			writeSize = remaining
+		}
+		fill(buffer[:writeSize])
+		if _, err := file.Write(buffer[:writeSize]); err != nil {
+			return err
+		}
+		remaining -= writeSize
+	}
+	fmt.Fprintln(os.Stderr, "Created file ", filename)
+	return file.Close()
+}
+
+func benchmarkTraditionalCopy(tf testFile, bufferSize, streams int) (BenchmarkResult, error) {
+	methodName := fmt.Sprintf("Traditional (buffer: %s)", formatSize(int64(bufferSize)))
+	return benchmarkMethod(methodName, tf, transfer.Buffer, transfer.Options{BufferSize: bufferSize}, streams)
+}
+
+func benchmarkSendFile(tf testFile, streams int) (BenchmarkResult, error) {
+	return benchmarkMethod("sendfile", tf, transfer.Sendfile, transfer.Options{}, streams)
+}
+
+func benchmarkSplice(tf testFile, streams int) (BenchmarkResult, error) {
+	return benchmarkMethod("splice", tf, transfer.Splice, transfer.Options{}, streams)
+}
+
+func benchmarkMmap(tf testFile, chunkSize, streams int) (BenchmarkResult, error) {
+	methodName := fmt.Sprintf("mmap (chunk: %s)", formatSize(int64(chunkSize)))
+	return benchmarkMethod(methodName, tf, transfer.Mmap, transfer.Options{BufferSize: chunkSize, AdviseSequential: true}, streams)
+}
+
+// benchmarkMethod runs transfer over streams fresh -transport pairs at once,
+// each with its own handle on the test file and its receiving side drained,
+// so every method is measured end to end. With -connect the receivers are a
+// remote `sendfl serve` and its verdicts stand in for the local drain.
+func benchmarkMethod(method string, tf testFile, fn transfer.Func, opts transfer.Options, streams int) (BenchmarkResult, error) {
+	sends := make([]sender, streams)
+	files := make([]*os.File, streams)
+	received := make([]<-chan drained, streams)
+	var sock *SocketOptions
+	for i := range sends {
+		file, err := os.Open(tf.Path)
+		if err != nil {
+			return BenchmarkResult{}, fmt.Errorf("%s: %w", method, err)
+		}
+		defer file.Close()
+
+		if *connect != "" {
+			send, verdict, err := dialReceiver(*connect, method, tf.Length)
+			if err != nil {
+				return BenchmarkResult{}, fmt.Errorf("%s: %w", method, err)
+			}
+			defer send.Close()
+			if i == 0 {
+				// The receiver's buffer lives on the other machine.
+				sock = &SocketOptions{NoDelay: getsockoptInt(send, optNoDelay) > 0, SndBuf: getsockoptInt(send, optSndBuf), RcvBuf: -1}
+			}
+			sends[i], files[i], received[i] = send, file, verdict
+			continue
+		}
+
+		recv, send, err := createPair(*transport)
+		if err != nil {
+			return BenchmarkResult{}, fmt.Errorf("%s: %s transport: %w", method, *transport, err)
+		}
+		defer recv.Close()
+		defer send.Close()
+
+		if i == 0 && *transport != "pipe" {
+			sock = effectiveSocketOptions(send, recv.(syscall.Conn))
+		}
+		sends[i], files[i], received[i] = send, file, drain(recv)
+	}
+
+	result := runBenchmark(method, tf, received, func() (int64, error) {
+		var wg sync.WaitGroup
+		var mu sync.Mutex
+		var total int64
+		var firstErr error
+		for i := range sends {
+			wg.Add(1)
+			go func(send sender, file *os.File) {
+				defer wg.Done()
+				defer closeWrite(send)
+				n, err := fn(send, file, tf.Offset, tf.Length, opts)
+				mu.Lock()
+				defer mu.Unlock()
+				total += n
+				if err != nil && firstErr == nil {
+					firstErr = err
+				}
+			}(sends[i], files[i])
+		}
+		wg.Wait()
+		return total, firstErr
+	})
+	result.Transport = *transport
+	if *connect != "" {
+		result.Transport = "tcp to " + *connect
+	}
+	result.Socket = sock
+	return result, nil
+}
+
+// sender is the writing end of a transport: a transfer.Dest we can close.
+type sender interface {
+	transfer.Dest
+	io.Closer
+}
+
+// createPair returns the receiving and sending ends for transport.
+func createPair(transport string) (io.ReadCloser, sender, error) {
+	switch transport {
+	case "unix":
+		server, client, err := createUnixSocketPair()
+		if err != nil {
+			return nil, nil, err
+		}
+		return server, client.(*net.UnixConn), nil
+	case "pipe":
+		r, w, err := os.Pipe()
+		if err != nil {
+			return nil, nil, err
+		}
+		return r, w, nil
+	default:
+		server, client, err := createSocketPairV2()
+		if err != nil {
+			return nil, nil, err
+		}
+		return server, client.(*net.TCPConn), nil
+	}
+}
+
+// benchmarkFileMethod copies the test file into a fresh temp file next to it
+// (same filesystem, so copy_file_range can share extents) and then hashes the
+// copy, which is how the file target verifies size and checksum.
+func benchmarkFileMethod(method string, tf testFile, fn transfer.FileFunc, opts transfer.Options) (BenchmarkResult, error) {
+	src, err := os.Open(tf.Path)
+	if err != nil {
+		return BenchmarkResult{}, fmt.Errorf("%s: %w", method, err)
+	}
+	defer src.Close()
+
+	dst, err := os.CreateTemp(filepath.Dir(tf.Path), "sendfl-copy-*.dat")
+	if err != nil {
+		return BenchmarkResult{}, fmt.Errorf("%s: create copy destination: %w", method, err)
+	}
+	temps.add(dst.Name())
+	defer temps.remove(dst.Name())
+	defer dst.Close()
+
+	received := make(chan drained, 1)
+	result := runBenchmark(method, tf, []<-chan drained{received}, func() (int64, error) {
+		defer func() { received <- hashFile(dst.Name()) }()
+		return fn(dst, src, tf.Offset, tf.Length, opts)
+	})
+	result.Transport = "file"
+	return result, nil
+}
+
+// hashFile reads path back for verification; errors show up as a short count.
+func hashFile(path string) drained {
+	f, err := os.Open(path)
+	if err != nil {
+		log.Printf("verify %s: %v", path, err)
+		return drained{}
+	}
+	defer f.Close()
+	h := sha256.New()
+	n, err := io.Copy(h, f)
+	if err != nil {
+		log.Printf("verify %s after %d bytes: %v", path, n, err)
+	}
+	return drained{n: n, sum: hex.EncodeToString(h.Sum(nil)), at: time.Now()}
+}
+
+// unixSocketDir holds the listening sockets for -transport=unix; main creates
+// it and the temp cleanup removes it.
+var unixSocketDir string
+
+// createUnixSocketPair is createSocketPairV2 over a unix domain socket in
+// unixSocketDir. Closing the listener unlinks the socket file.
+func createUnixSocketPair() (net.Conn, net.Conn, error) {
+	lc := net.ListenConfig{Control: presizeBuffers}
+	path := filepath.Join(unixSocketDir, "bench.sock")
+	return connectedPair(lc, "unix", path)
+}
+
+// createSocketPairV2 returns both ends of a loopback TCP connection, with
+// the -sndbuf/-rcvbuf sizes set before connecting.
+func createSocketPairV2() (net.Conn, net.Conn, error) {
+	lc := net.ListenConfig{Control: presizeBuffers}
+	return connectedPair(lc, "tcp", "127.0.0.1:0")
+}
+
+// connectedPair listens on address with lc, connects to it and returns the
+// accepted and the dialed end, both with the socket options applied.
+func connectedPair(lc net.ListenConfig, network, address string) (net.Conn, net.Conn, error) {
+	listener, err := lc.Listen(context.Background(), network, address)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer listener.Close()
+
+	dialer := net.Dialer{Control: presizeBuffers}
+	clientConn, err := dialer.Dial(network, listener.Addr().String())
+	if err != nil {
+		return nil, nil, err
+	}
+
+	serverConn, err := listener.Accept()
+	if err != nil {
+		clientConn.Close()
+		return nil, nil, err
+	}
+	applySocketOptions(clientConn)
+	applySocketOptions(serverConn)
+
+	return serverConn, clientConn, nil
+}
@@ -0,0 +1,96 @@
+package sendfl
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"time"
+)
+
+// serve runs `sendfl serve`: a receiver for `sendfl bench -connect` on
+// another machine. Each connection is one stream; it is drained and hashed
+// exactly like the in-process receiver and the verdict is sent back.
+func serve(args []string) error {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	addr := fs.String("addr", ":9000", "address to accept benchmark streams on")
+	fs.Parse(args)
+
+	lc := net.ListenConfig{Control: presizeBuffers}
+	ln, err := lc.Listen(context.Background(), "tcp", *addr)
+	if err != nil {
+		return err
+	}
+	defer ln.Close()
+	log.Printf("sendfl receiver listening on %s", ln.Addr())
+
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return err
+		}
+		go handleStream(conn)
+	}
+}
+
+// handleStream reads one header, drains the payload to EOF, and replies
+// with what arrived.
+func handleStream(conn net.Conn) {
+	defer conn.Close()
+	br := bufio.NewReader(conn)
+	h, err := readHeader(br)
+	if err != nil {
+		log.Printf("%s: %v", conn.RemoteAddr(), err)
+		return
+	}
+
+	start := time.Now()
+	sum := sha256.New()
+	n, err := io.Copy(sum, br)
+	if err != nil {
+		log.Printf("%s: %s: drain error after %d bytes: %v", conn.RemoteAddr(), h.Method, n, err)
+	}
+	got := drained{n: n, sum: hex.EncodeToString(sum.Sum(nil))}
+	if err := writeVerdict(conn, got); err != nil {
+		log.Printf("%s: %s: sending verdict: %v", conn.RemoteAddr(), h.Method, err)
+	}
+
+	note := ""
+	if n != h.Size {
+		note = fmt.Sprintf(" (expected %d)", h.Size)
+	}
+	log.Printf("%s: %s: %d bytes%s in %s, sha256 %s", conn.RemoteAddr(), h.Method, n, note,
+		time.Since(start).Round(time.Millisecond), got.sum)
+}
+
+// dialReceiver opens one stream to a `sendfl serve` at addr and announces
+// it. The returned channel yields the receiver's verdict once the sender
+// has half-closed the connection.
+func dialReceiver(addr, method string, size int64) (*net.TCPConn, <-chan drained, error) {
+	dialer := net.Dialer{Control: presizeBuffers}
+	conn, err := dialer.Dial("tcp", addr)
+	if err != nil {
+		return nil, nil, fmt.Errorf("connect %s: %w", addr, err)
+	}
+	applySocketOptions(conn)
+	if err := writeHeader(conn, benchHeader{Method: method, Size: size}); err != nil {
+		conn.Close()
+		return nil, nil, fmt.Errorf("%s: %w", addr, err)
+	}
+
+	done := make(chan drained, 1)
+	go func() {
+		got, err := readVerdict(conn)
+		if err != nil {
+			log.Printf("%s: %v", addr, err)
+		}
+		got.at = time.Now()
+		done <- got
+	}()
+	return conn.(*net.TCPConn), done, nil
+}
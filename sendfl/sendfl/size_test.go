@@ -0,0 +1,84 @@
+package sendfl
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseSize(t *testing.T) {
+	for _, tc := range []struct {
+		in      string
+		want    int64
+		wantErr bool
+	}{
+		{in: "512", want: 512},
+		{in: "512b", want: 512},
+		{in: "4K", want: 4 << 10},
+		{in: "4kb", want: 4 << 10},
+		{in: " 64 KiB ", want: 64 << 10},
+		{in: "100MB", want: 100 << 20},
+		{in: "1m", want: 1 << 20},
+		{in: "1GiB", want: 1 << 30},
+		{in: "8589934591G", want: 8589934591 << 30},
+		{in: "8589934592G", wantErr: true}, // 2^33 GiB is 2^63 bytes
+		{in: "9999999999G", wantErr: true},
+		{in: "9223372036854775807", want: 9223372036854775807},
+		{in: "99999999999999999999", wantErr: true},
+		{in: "0", wantErr: true},
+		{in: "-4K", wantErr: true},
+		{in: "K", wantErr: true},
+		{in: "4T", wantErr: true},
+		{in: "", wantErr: true},
+	} {
+		got, err := parseSize(tc.in)
+		if (err != nil) != tc.wantErr || got != tc.want {
+			t.Errorf("parseSize(%q) = %d, %v; want %d, error %v", tc.in, got, err, tc.want, tc.wantErr)
+		}
+	}
+}
+
+func TestParseSizeList(t *testing.T) {
+	for _, tc := range []struct {
+		in      string
+		want    []int
+		wantErr bool
+	}{
+		{in: "4K,64K,1M", want: []int{4 << 10, 64 << 10, 1 << 20}},
+		{in: " 8k , ,32k,", want: []int{8 << 10, 32 << 10}},
+		{in: "", wantErr: true},
+		{in: ",,", wantErr: true},
+		{in: "4K,bogus", wantErr: true},
+		{in: "4K,9999999999G", wantErr: true},
+	} {
+		got, err := parseSizeList(tc.in)
+		if (err != nil) != tc.wantErr || !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("parseSizeList(%q) = %v, %v; want %v, error %v", tc.in, got, err, tc.want, tc.wantErr)
+		}
+	}
+}
+
+func TestFormatSize(t *testing.T) {
+	for _, tc := range []struct {
+		in   int64
+		want string
+	}{
+		{0, "0B"},
+		{1, "1B"},
+		{1023, "1023B"},
+		{1 << 10, "1KB"},
+		{1536, "1536B"},
+		{3 << 20, "3MB"},
+		{(1 << 20) + (1 << 10), "1025KB"},
+		{100 << 30, "100GB"},
+	} {
+		if got := formatSize(tc.in); got != tc.want {
+			t.Errorf("formatSize(%d) = %q, want %q", tc.in, got, tc.want)
+		}
+		// Whatever formatSize prints, parseSize reads back.
+		if tc.in > 0 {
+			if back, err := parseSize(formatSize(tc.in)); err != nil || back != tc.in {
+				t.Errorf("parseSize(formatSize(%d)) = %d, %v", tc.in, back, err)
+			}
+		}
+	}
+}
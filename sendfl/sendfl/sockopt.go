@@ -0,0 +1,61 @@
+package sendfl
+
+import (
+	"log"
+	"net"
+	"syscall"
+)
+
+// SocketOptions are the conditions a socket run happened under. Requested
+// values come from -nodelay/-sndbuf/-rcvbuf; BenchmarkResult records what the
+// kernel actually applied (Linux reports twice the requested buffer size,
+// clamped to net.core.{w,r}mem_max).
+type SocketOptions struct {
+	NoDelay bool `json:"nodelay"`
+	SndBuf  int  `json:"sndbuf"` // 0 = kernel default when requesting
+	RcvBuf  int  `json:"rcvbuf"`
+}
+
+// requestedSocket is filled from the flags in main and applied to both ends
+// of every benchmark connection.
+var requestedSocket SocketOptions
+
+// applySocketOptions sets the requested options on an established conn,
+// leaving buffer sizes alone when none was asked for. On linux/darwin the
+// buffers were already sized before connect by presizeBuffers, so repeating
+// the same value here is a no-op there. Nagle only exists for TCP.
+func applySocketOptions(conn net.Conn) {
+	if tcp, ok := conn.(*net.TCPConn); ok {
+		if err := tcp.SetNoDelay(requestedSocket.NoDelay); err != nil {
+			log.Printf("SetNoDelay: %v", err)
+		}
+	}
+	bufs, ok := conn.(interface {
+		SetWriteBuffer(int) error
+		SetReadBuffer(int) error
+	})
+	if !ok {
+		return
+	}
+	if requestedSocket.SndBuf > 0 {
+		if err := bufs.SetWriteBuffer(requestedSocket.SndBuf); err != nil {
+			log.Printf("SetWriteBuffer: %v", err)
+		}
+	}
+	if requestedSocket.RcvBuf > 0 {
+		if err := bufs.SetReadBuffer(requestedSocket.RcvBuf); err != nil {
+			log.Printf("SetReadBuffer: %v", err)
+		}
+	}
+}
+
+// effectiveSocketOptions reads back what applies to a transfer from sender
+// to receiver: the sender's Nagle setting and send buffer, and the
+// receiver's receive buffer.
+func effectiveSocketOptions(sender, receiver syscall.Conn) *SocketOptions {
+	return &SocketOptions{
+		NoDelay: getsockoptInt(sender, optNoDelay) > 0,
+		SndBuf:  getsockoptInt(sender, optSndBuf),
+		RcvBuf:  getsockoptInt(receiver, optRcvBuf),
+	}
+}
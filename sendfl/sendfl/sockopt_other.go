@@ -0,0 +1,24 @@
+//go:build !linux && !darwin
+// +build !linux,!darwin
+
+package sendfl
+
+import "syscall"
+
+type sockopt int
+
+const (
+	optNoDelay sockopt = iota
+	optSndBuf
+	optRcvBuf
+)
+
+// getsockoptInt is only wired up for linux and darwin.
+func getsockoptInt(conn syscall.Conn, opt sockopt) int {
+	return -1
+}
+
+// presizeBuffers leaves sizing to applySocketOptions on other platforms.
+func presizeBuffers(network, address string, c syscall.RawConn) error {
+	return nil
+}
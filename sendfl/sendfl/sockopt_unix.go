@@ -0,0 +1,53 @@
+//go:build linux || darwin
+// +build linux darwin
+
+package sendfl
+
+import (
+	"syscall"
+)
+
+type sockopt struct{ level, name int }
+
+var (
+	optNoDelay = sockopt{syscall.IPPROTO_TCP, syscall.TCP_NODELAY}
+	optSndBuf  = sockopt{syscall.SOL_SOCKET, syscall.SO_SNDBUF}
+	optRcvBuf  = sockopt{syscall.SOL_SOCKET, syscall.SO_RCVBUF}
+)
+
+// getsockoptInt reads opt from conn's socket, or -1 if that is not possible.
+func getsockoptInt(conn syscall.Conn, opt sockopt) int {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return -1
+	}
+	v := -1
+	raw.Control(func(fd uintptr) {
+		if n, err := syscall.GetsockoptInt(int(fd), opt.level, opt.name); err == nil {
+			v = n
+		}
+	})
+	return v
+}
+
+// presizeBuffers is a Dialer/ListenConfig Control hook that sets the requested
+// buffer sizes before connect/listen. Shrinking SO_RCVBUF after the handshake
+// leaves a window smaller than the loopback MSS and the transfer stalls, and
+// accepted sockets inherit the listener's sizes.
+func presizeBuffers(network, address string, c syscall.RawConn) error {
+	var err error
+	ctlErr := c.Control(func(fd uintptr) {
+		if requestedSocket.SndBuf > 0 {
+			if err = syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_SNDBUF, requestedSocket.SndBuf); err != nil {
+				return
+			}
+		}
+		if requestedSocket.RcvBuf > 0 {
+			err = syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_RCVBUF, requestedSocket.RcvBuf)
+		}
+	})
+	if ctlErr != nil {
+		return ctlErr
+	}
+	return err
+}
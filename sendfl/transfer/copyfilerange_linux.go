@@ -0,0 +1,42 @@
+//go:build linux
+// +build linux
+
+package transfer
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// CopyFileRange copies length bytes from offset with copy_file_range(2), letting the
+// filesystem share or copy extents without the data entering user space.
+//
+// The kernel may copy less than asked (it caps each call, and some
+// filesystems stop at extent boundaries), so loop on short copies; n == 0
+// before length means src is shorter than we were told.
+func CopyFileRange(dst, src *os.File, offset, length int64, opts Options) (int64, error) {
+	roff, woff := offset, int64(0)
+	for woff < length {
+		chunk := length - woff
+		if chunk > maxSendfileChunk {
+			chunk = maxSendfileChunk
+		}
+		n, err := unix.CopyFileRange(int(src.Fd()), &roff, int(dst.Fd()), &woff, int(chunk), 0)
+		switch {
+		case errors.Is(err, syscall.EINTR):
+			continue
+		case errors.Is(err, syscall.ENOSYS):
+			return woff, fmt.Errorf("copy_file_range: %v: %w", err, ErrUnsupported)
+		case err != nil:
+			return woff, err
+		case n == 0:
+			return woff, fmt.Errorf("file ended after %d of %d bytes: %w", woff, length, io.ErrUnexpectedEOF)
+		}
+	}
+	return woff, nil
+}
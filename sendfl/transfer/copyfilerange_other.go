@@ -0,0 +1,15 @@
+//go:build !linux
+// +build !linux
+
+package transfer
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+)
+
+// CopyFileRange is Linux only.
+func CopyFileRange(dst, src *os.File, offset, length int64, opts Options) (int64, error) {
+	return 0, fmt.Errorf("copy_file_range on %s: %w", runtime.GOOS, ErrUnsupported)
+}
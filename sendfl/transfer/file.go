@@ -0,0 +1,52 @@
+package transfer
+
+import (
+	"fmt"
+	"io"
+	"os"
+)
+
+// FileFunc is the file-to-file counterpart of Func, used by -target=file.
+type FileFunc func(dst, src *os.File, offset, length int64, opts Options) (int64, error)
+
+// FileBuffer copies length bytes from offset through a user-space buffer of
+// opts.BufferSize.
+// It calls Read/Write directly: io.Copy between two *os.File would take the
+// copy_file_range fast path and defeat the comparison.
+func FileBuffer(dst, src *os.File, offset, length int64, opts Options) (int64, error) {
+	if _, err := src.Seek(offset, io.SeekStart); err != nil {
+		return 0, err
+	}
+	buffer := make([]byte, opts.BufferSize)
+	var written int64
+	for written < length {
+		chunk := int64(len(buffer))
+		if remaining := length - written; remaining < chunk {
+			chunk = remaining
+		}
+		n, err := src.Read(buffer[:chunk])
+		if n > 0 {
+			m, werr := dst.Write(buffer[:n])
+			written += int64(m)
+			if werr != nil {
+				return written, werr
+			}
+		}
+		if err == io.EOF {
+			return written, fmt.Errorf("file ended after %d of %d bytes: %w", written, length, io.ErrUnexpectedEOF)
+		}
+		if err != nil {
+			return written, err
+		}
+	}
+	return written, nil
+}
+
+// FileIOCopy is the standard-library baseline: *os.File.ReadFrom picks
+// copy_file_range (or sendfile/splice) on its own where the kernel allows.
+func FileIOCopy(dst, src *os.File, offset, length int64, opts Options) (int64, error) {
+	if _, err := src.Seek(offset, io.SeekStart); err != nil {
+		return 0, err
+	}
+	return io.Copy(dst, io.LimitReader(src, length))
+}
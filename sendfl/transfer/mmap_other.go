@@ -0,0 +1,15 @@
+//go:build !linux && !darwin
+// +build !linux,!darwin
+
+package transfer
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+)
+
+// Mmap is only wired up for linux and darwin.
+func Mmap(dst Dest, src *os.File, offset, length int64, opts Options) (int64, error) {
+	return 0, fmt.Errorf("mmap on %s: %w", runtime.GOOS, ErrUnsupported)
+}
@@ -0,0 +1,63 @@
+//go:build linux || darwin
+// +build linux darwin
+
+package transfer
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// Mmap maps the file read-only and writes the mapping to the socket in
+// opts.BufferSize pieces. The copy into the socket still happens, but the read(2)
+// into a user buffer does not; pages come straight from the page cache.
+//
+// mmap offsets must be page aligned, so the mapping starts at the page
+// holding offset and data is resliced to the requested range.
+func Mmap(dst Dest, src *os.File, offset, length int64, opts Options) (written int64, err error) {
+	if length == 0 {
+		return 0, nil
+	}
+	// Touching a mapped page past EOF is SIGBUS, not an error; check first.
+	fi, err := src.Stat()
+	if err != nil {
+		return 0, err
+	}
+	if offset+length > fi.Size() {
+		return 0, fmt.Errorf("range %d+%d past end of %d byte file: %w", offset, length, fi.Size(), io.ErrUnexpectedEOF)
+	}
+	start := offset &^ int64(os.Getpagesize()-1)
+	mapping, err := unix.Mmap(int(src.Fd()), start, int(offset-start+length), unix.PROT_READ, unix.MAP_SHARED)
+	if err != nil {
+		return 0, fmt.Errorf("mmap: %v", err)
+	}
+	data := mapping[offset-start:]
+	defer func() {
+		if uerr := unix.Munmap(mapping); uerr != nil && err == nil {
+			err = fmt.Errorf("munmap: %v", uerr)
+		}
+	}()
+
+	// madvise wants a page-aligned address, which data need not start at.
+	if opts.AdviseSequential {
+		if err := unix.Madvise(mapping, unix.MADV_SEQUENTIAL); err != nil {
+			return 0, fmt.Errorf("madvise: %v", err)
+		}
+	}
+
+	for written < length {
+		end := written + int64(opts.BufferSize)
+		if end > length {
+			end = length
+		}
+		n, err := dst.Write(data[written:end])
+		written += int64(n)
+		if err != nil {
+			return written, err
+		}
+	}
+	return written, nil
+}
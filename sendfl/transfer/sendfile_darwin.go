@@ -0,0 +1,29 @@
+//go:build darwin
+// +build darwin
+
+package transfer
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// sendfileChunk sends up to remaining bytes of file, starting at offset, to
+// the socket fd and returns how many went out.
+//
+// darwin's sendfile(2) takes the length by pointer: in, the bytes to send; out,
+// the bytes actually sent. It does not advance offset, and on a non-blocking
+// socket it returns EAGAIN *with* partial progress in the length, so n must be
+// honoured even when err is EAGAIN (the caller does). syscall.Sendfile wraps
+// exactly that: it passes count in and returns the written-back length.
+//
+// darwin only sends to sockets; a pipe destination reports ErrUnsupported.
+func sendfileChunk(fd int, file *os.File, offset, remaining int64) (int, error) {
+	off := offset
+	n, err := syscall.Sendfile(fd, int(file.Fd()), &off, int(remaining))
+	if err == syscall.ENOTSOCK {
+		return n, fmt.Errorf("sendfile to a non-socket on darwin: %w", ErrUnsupported)
+	}
+	return n, err
+}
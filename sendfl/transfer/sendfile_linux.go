@@ -0,0 +1,18 @@
+//go:build linux
+// +build linux
+
+package transfer
+
+import (
+	"os"
+	"syscall"
+)
+
+// sendfileChunk sends up to remaining bytes of file, starting at offset, to
+// the socket fd and returns how many went out. Linux takes the count by value
+// and updates the offset through the pointer; we pass a copy, so the file's
+// own position is never touched and the caller owns offset bookkeeping.
+func sendfileChunk(fd int, file *os.File, offset, remaining int64) (int, error) {
+	off := offset
+	return syscall.Sendfile(fd, int(file.Fd()), &off, int(remaining))
+}
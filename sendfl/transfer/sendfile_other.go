@@ -0,0 +1,16 @@
+//go:build !linux && !darwin
+// +build !linux,!darwin
+
+package transfer
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+)
+
+// sendfileChunk is only wired up for linux and darwin; Sendfile reports
+// ErrUnsupported elsewhere so the driver can skip it.
+func sendfileChunk(fd int, file *os.File, offset, remaining int64) (int, error) {
+	return 0, fmt.Errorf("sendfile on %s: %w", runtime.GOOS, ErrUnsupported)
+}
@@ -0,0 +1,94 @@
+//go:build linux || darwin
+// +build linux darwin
+
+package transfer
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+// chunkFixture returns a file with known contents and a blocking stream
+// socketpair; sendfileChunk writes to pair[0] and the test reads pair[1].
+func chunkFixture(t *testing.T, data []byte) (*os.File, [2]int) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "chunk.dat")
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { f.Close() })
+
+	pair, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_STREAM, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		syscall.Close(pair[0])
+		syscall.Close(pair[1])
+	})
+	return f, pair
+}
+
+func readN(t *testing.T, fd, n int) []byte {
+	t.Helper()
+	buf := make([]byte, n)
+	for got := 0; got < n; {
+		m, err := syscall.Read(fd, buf[got:])
+		if err != nil || m == 0 {
+			t.Fatalf("read %d of %d bytes: %v", got, n, err)
+		}
+		got += m
+	}
+	return buf
+}
+
+func TestSendfileChunkOffset(t *testing.T) {
+	data := []byte("0123456789abcdefghijklmnopqrstuvwxyz")
+	f, pair := chunkFixture(t, data)
+
+	var offset int64 = 4
+	for offset < int64(len(data)) {
+		n, err := sendfileChunk(pair[0], f, offset, 7)
+		if err != nil {
+			t.Fatalf("offset %d: %v", offset, err)
+		}
+		if n <= 0 {
+			t.Fatalf("offset %d: sent %d bytes", offset, n)
+		}
+		if got, want := readN(t, pair[1], n), data[offset:offset+int64(n)]; !bytes.Equal(got, want) {
+			t.Fatalf("offset %d: got %q, want %q", offset, got, want)
+		}
+		offset += int64(n)
+	}
+
+	// The helper must not move the file's own position.
+	if pos, err := f.Seek(0, io.SeekCurrent); err != nil || pos != 0 {
+		t.Fatalf("file position = %d, %v; want 0", pos, err)
+	}
+}
+
+func TestSendfileChunkEOF(t *testing.T) {
+	data := []byte("short file")
+	f, pair := chunkFixture(t, data)
+
+	n, err := sendfileChunk(pair[0], f, 6, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != len(data)-6 {
+		t.Fatalf("sent %d bytes past offset 6, want %d", n, len(data)-6)
+	}
+	readN(t, pair[1], n)
+
+	if n, err := sendfileChunk(pair[0], f, int64(len(data)), 10); n != 0 || err != nil {
+		t.Fatalf("at EOF: sent %d, err %v; want 0, nil", n, err)
+	}
+}
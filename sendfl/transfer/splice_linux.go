@@ -0,0 +1,85 @@
+//go:build linux
+// +build linux
+
+package transfer
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// spliceChunk is what we move per file->pipe splice; it matches the default
+// pipe capacity so that splice never blocks on a full pipe.
+const spliceChunk = 64 * 1024
+
+// Splice uses splice(2): file -> pipe -> dst, never touching user space.
+//
+// The file->pipe leg only runs when the pipe is empty; the pipe->socket leg
+// is non-blocking and hands EAGAIN back to the runtime poller just like the
+// sendfile loop does.
+func Splice(dst Dest, src *os.File, offset, length int64, opts Options) (int64, error) {
+	rawConn, err := dst.SyscallConn()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get raw connection: %v", err)
+	}
+
+	var p [2]int
+	if err := unix.Pipe2(p[:], unix.O_CLOEXEC); err != nil {
+		return 0, fmt.Errorf("pipe2: %v", err)
+	}
+	pr, pw := p[0], p[1]
+	defer unix.Close(pr)
+	defer unix.Close(pw)
+
+	fileFd := int(src.Fd())
+	var written int64
+	inPipe := 0
+	var sysErr error
+
+	err = rawConn.Write(func(fd uintptr) bool {
+		for written < length {
+			if inPipe == 0 {
+				chunk := length - written
+				if chunk > spliceChunk {
+					chunk = spliceChunk
+				}
+				n, err := unix.Splice(fileFd, &offset, pw, nil, int(chunk), unix.SPLICE_F_MOVE|unix.SPLICE_F_MORE)
+				switch {
+				case err == unix.EINTR:
+					continue
+				case err != nil:
+					sysErr = fmt.Errorf("splice file->pipe: %v", err)
+					return true
+				case n == 0:
+					sysErr = fmt.Errorf("file ended after %d of %d bytes: %w", written, length, io.ErrUnexpectedEOF)
+					return true
+				}
+				inPipe = int(n)
+			}
+
+			n, err := unix.Splice(pr, nil, int(fd), nil, inPipe, unix.SPLICE_F_MOVE|unix.SPLICE_F_MORE|unix.SPLICE_F_NONBLOCK)
+			if n > 0 {
+				inPipe -= int(n)
+				written += n
+			}
+			switch {
+			case err == unix.EAGAIN:
+				return false // socket full, wait for writability
+			case err == unix.EINTR:
+				continue
+			case err != nil:
+				sysErr = fmt.Errorf("splice pipe->socket: %v", err)
+				return true
+			}
+		}
+		return true
+	})
+
+	if err != nil {
+		return written, err
+	}
+	return written, sysErr
+}
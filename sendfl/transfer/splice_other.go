@@ -0,0 +1,15 @@
+//go:build !linux
+// +build !linux
+
+package transfer
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+)
+
+// Splice is Linux only.
+func Splice(dst Dest, src *os.File, offset, length int64, opts Options) (int64, error) {
+	return 0, fmt.Errorf("splice on %s: %w", runtime.GOOS, ErrUnsupported)
+}
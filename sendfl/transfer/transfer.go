@@ -0,0 +1,142 @@
+// Package transfer holds the file-to-socket copy strategies compared by sendfl.
+//
+// Every strategy has the same shape so the benchmark driver and `go test -bench`
+// can treat them interchangeably: it copies length bytes of src, starting at
+// offset, into dst and returns how many bytes it wrote.
+package transfer
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"syscall"
+)
+
+// Options tunes the strategies that have knobs; others ignore it.
+type Options struct {
+	BufferSize       int  // user-space buffer for Buffer, chunk size for Mmap
+	AdviseSequential bool // madvise(MADV_SEQUENTIAL) the mapping in Mmap
+}
+
+// ErrUnsupported is wrapped by strategies that do not exist on this GOOS or
+// for this kind of destination, so callers can skip them rather than report
+// a failed run.
+var ErrUnsupported = errors.New("unsupported")
+
+// Dest is where a strategy writes: a TCP or unix socket, or a pipe. All of
+// them expose their descriptor, which the zero-copy strategies need.
+type Dest interface {
+	io.Writer
+	syscall.Conn
+}
+
+// Func is the common signature of every strategy.
+type Func func(dst Dest, src *os.File, offset, length int64, opts Options) (int64, error)
+
+// Buffer is the traditional copy using a buffer in user space: Seek to
+// offset, then read at most length bytes.
+func Buffer(dst Dest, src *os.File, offset, length int64, opts Options) (int64, error) {
+	if _, err := src.Seek(offset, io.SeekStart); err != nil {
+		return 0, err
+	}
+	r := io.LimitReader(src, length)
+	buffer := make([]byte, opts.BufferSize)
+	var totalWritten int64 = 0
+
+	for {
+		// Read from file into buffer (kernel → user space)
+		n, err := r.Read(buffer)
+		if err != nil && err != io.EOF {
+			return totalWritten, err
+		}
+		if n == 0 {
+			break
+		}
+
+		// Write buffer to socket (user space → kernel)
+		written, err := dst.Write(buffer[:n])
+		if err != nil {
+			return totalWritten, err
+		}
+		totalWritten += int64(written)
+	}
+	if totalWritten < length {
+		return totalWritten, fmt.Errorf("file ended after %d of %d bytes: %w", totalWritten, length, io.ErrUnexpectedEOF)
+	}
+	return totalWritten, nil
+}
+
+// Sendfile uses the sendfile system call.
+//
+// The socket is non-blocking, so a single sendfile only moves what fits in the
+// socket buffer. Loop until length bytes are out: on EAGAIN return false so
+// the runtime poller parks us until the socket is writable again, retry on EINTR.
+// The per-OS calling convention lives in sendfileChunk; offset is advanced
+// here from what each call reports, so both conventions share one loop.
+func Sendfile(dst Dest, src *os.File, offset, length int64, opts Options) (int64, error) {
+	rawConn, err := dst.SyscallConn()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get raw connection: %v", err)
+	}
+
+	var written int64
+	var sysErr error
+
+	err = rawConn.Write(func(fd uintptr) bool {
+		for written < length {
+			chunk := length - written
+			if chunk > maxSendfileChunk {
+				chunk = maxSendfileChunk
+			}
+			n, err := sendfileChunk(int(fd), src, offset+written, chunk)
+			if n > 0 {
+				written += int64(n)
+			}
+			switch {
+			case err == syscall.EAGAIN:
+				return false // wait for writability
+			case err == syscall.EINTR:
+				continue
+			case err != nil:
+				sysErr = err
+				return true
+			case n == 0:
+				sysErr = fmt.Errorf("file ended after %d of %d bytes: %w", written, length, io.ErrUnexpectedEOF)
+				return true
+			}
+		}
+		return true
+	})
+
+	if err != nil {
+		return written, err
+	}
+	return written, sysErr
+}
+
+// maxSendfileChunk keeps each sendfile call well below the kernel's ~2GB per-call limit.
+const maxSendfileChunk = 1 << 30
+
+// ReadFrom uses the standard library: TCPConn.ReadFrom (and *os.File's, for a
+// pipe) recognises an *os.File source and uses sendfile/splice under the hood.
+// The range is expressed as Seek plus *io.LimitedReader, the one wrapper the
+// fast path still sees through.
+func ReadFrom(dst Dest, src *os.File, offset, length int64, opts Options) (int64, error) {
+	rf, ok := dst.(io.ReaderFrom)
+	if !ok {
+		return 0, fmt.Errorf("%T has no ReadFrom: %w", dst, ErrUnsupported)
+	}
+	if _, err := src.Seek(offset, io.SeekStart); err != nil {
+		return 0, err
+	}
+	return rf.ReadFrom(&io.LimitedReader{R: src, N: length})
+}
+
+// IOCopy uses io.Copy, which ends up in the same ReadFrom/WriteTo fast path.
+func IOCopy(dst Dest, src *os.File, offset, length int64, opts Options) (int64, error) {
+	if _, err := src.Seek(offset, io.SeekStart); err != nil {
+		return 0, err
+	}
+	return io.Copy(dst, io.LimitReader(src, length))
+}
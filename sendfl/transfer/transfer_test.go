@@ -0,0 +1,190 @@
+package transfer
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// benchFileSize matches the standalone binary's default payload.
+const benchFileSize = 100 * 1024 * 1024
+
+// benchFile creates a benchFileSize file once per benchmark.
+func benchFile(b *testing.B) string {
+	b.Helper()
+	path := filepath.Join(b.TempDir(), "bench.dat")
+	f, err := os.Create(path)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := io.CopyN(f, zeroReader{}, benchFileSize); err != nil {
+		b.Fatal(err)
+	}
+	return path
+}
+
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = 0
+	}
+	return len(p), nil
+}
+
+// loopbackPair returns the two ends of a loopback TCP connection.
+func loopbackPair(b testing.TB) (server, client net.Conn) {
+	b.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer ln.Close()
+	client, err = net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		b.Fatal(err)
+	}
+	server, err = ln.Accept()
+	if err != nil {
+		b.Fatal(err)
+	}
+	return server, client
+}
+
+// runTransfer benchmarks fn end to end: the timed region covers the peer
+// having drained every byte, as in the standalone binary.
+func runTransfer(b *testing.B, fn Func, opts Options) {
+	path := benchFile(b)
+	b.SetBytes(benchFileSize)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		server, client := loopbackPair(b)
+		file, err := os.Open(path)
+		if err != nil {
+			b.Fatal(err)
+		}
+		received := make(chan int64, 1)
+		go func() {
+			n, _ := io.Copy(io.Discard, server)
+			received <- n
+		}()
+		b.StartTimer()
+
+		written, err := fn(client.(*net.TCPConn), file, 0, benchFileSize, opts)
+		client.(*net.TCPConn).CloseWrite()
+		got := <-received
+
+		b.StopTimer()
+		if err != nil {
+			b.Fatal(err)
+		}
+		if written != benchFileSize || got != benchFileSize {
+			b.Fatalf("sent %d, received %d, want %d", written, got, benchFileSize)
+		}
+		file.Close()
+		client.Close()
+		server.Close()
+		b.StartTimer()
+	}
+}
+
+func TestSendfileLoopback(t *testing.T) {
+	const size = 64 << 20
+	path := filepath.Join(t.TempDir(), "send.dat")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := sha256.New()
+	if _, err := io.CopyN(io.MultiWriter(f, want), &patternReader{}, size); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	server, client := loopbackPair(t)
+	defer server.Close()
+	defer client.Close()
+	// A send buffer far below the file size means each sendfile call only
+	// gets part of the range out and the loop has to wait for writability.
+	if err := client.(*net.TCPConn).SetWriteBuffer(64 * 1024); err != nil {
+		t.Fatal(err)
+	}
+
+	type result struct {
+		n   int64
+		sum []byte
+	}
+	received := make(chan result, 1)
+	go func() {
+		got := sha256.New()
+		n, _ := io.Copy(got, server)
+		received <- result{n, got.Sum(nil)}
+	}()
+
+	written, err := Sendfile(client.(*net.TCPConn), file, 0, size, Options{})
+	if errors.Is(err, ErrUnsupported) {
+		t.Skip(err)
+	}
+	client.(*net.TCPConn).CloseWrite()
+	got := <-received
+	if err != nil {
+		t.Fatal(err)
+	}
+	if written != size || got.n != size {
+		t.Fatalf("sent %d, received %d, want %d", written, got.n, size)
+	}
+	if !bytes.Equal(got.sum, want.Sum(nil)) {
+		t.Fatal("received bytes differ from the file")
+	}
+}
+
+// patternReader mixes the page number into every byte, so data sent from
+// the wrong offset changes the checksum.
+type patternReader struct{ pos int64 }
+
+func (r *patternReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = byte(r.pos ^ r.pos>>12)
+		r.pos++
+	}
+	return len(p), nil
+}
+
+func BenchmarkBuffer(b *testing.B) {
+	for _, size := range []int{4 * 1024, 8 * 1024, 32 * 1024, 64 * 1024} {
+		b.Run(fmt.Sprintf("%dKB", size/1024), func(b *testing.B) {
+			runTransfer(b, Buffer, Options{BufferSize: size})
+		})
+	}
+}
+
+func BenchmarkSendfile(b *testing.B) { runTransfer(b, Sendfile, Options{}) }
+
+func BenchmarkSplice(b *testing.B) { runTransfer(b, Splice, Options{}) }
+
+func BenchmarkMmap(b *testing.B) {
+	for _, size := range []int{4 * 1024, 64 * 1024} {
+		b.Run(fmt.Sprintf("%dKB", size/1024), func(b *testing.B) {
+			runTransfer(b, Mmap, Options{BufferSize: size, AdviseSequential: true})
+		})
+	}
+}
+
+func BenchmarkReadFrom(b *testing.B) { runTransfer(b, ReadFrom, Options{}) }
+
+func BenchmarkIOCopy(b *testing.B) { runTransfer(b, IOCopy, Options{}) }
@@ -1,3 +1,8 @@
-module tcpq
+module goexp/tcpq
 
 go 1.24.3
+
+require goexp/internal v0.0.0
+
+// The latency summaries use the Summary shared with sendfl and iowait.
+replace goexp/internal => ../internal
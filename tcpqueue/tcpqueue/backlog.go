@@ -0,0 +1,37 @@
+package tcpqueue
+
+import (
+	"context"
+	"log"
+	"net"
+)
+
+// listen opens a TCP or unix listener on addr with opts set on its socket. A
+// backlog above 0 replaces Go's default (net.core.somaxconn) where the
+// platform allows it. It returns the backlog the kernel will actually
+// apply, or 0 if that is unknown.
+func listen(network, addr string, backlog int, opts []sockopt) (net.Listener, int, error) {
+	lc := net.ListenConfig{Control: controlSockopts(opts, &onceLog{})}
+	l, err := lc.Listen(context.Background(), network, addr)
+	if err != nil {
+		return nil, 0, err
+	}
+	max, err := somaxconn()
+	if err != nil {
+		max = 0
+	}
+	if backlog <= 0 {
+		log.Printf("backlog: Go's default, net.core.somaxconn=%d", max)
+		return l, max, nil
+	}
+	if err := setBacklog(l, backlog); err != nil {
+		log.Printf("warning: cannot set the backlog to %d (%v), keeping Go's default", backlog, err)
+		return l, max, nil
+	}
+	effective := backlog
+	if max > 0 && max < backlog {
+		effective = max
+	}
+	log.Printf("backlog: %d requested, %d effective (net.core.somaxconn=%d)", backlog, effective, max)
+	return l, effective, nil
+}
@@ -0,0 +1,40 @@
+package tcpqueue
+
+import (
+	"errors"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// setBacklog calls listen(2) again on l's socket. Linux takes that as the
+// new backlog of a socket that is already listening, so the size of the
+// accept queue no longer depends on net.core.somaxconn alone.
+func setBacklog(l net.Listener, backlog int) error {
+	sc, ok := l.(syscall.Conn)
+	if !ok {
+		return errors.New("listener has no socket")
+	}
+	rc, err := sc.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var lerr error
+	if err := rc.Control(func(fd uintptr) {
+		lerr = syscall.Listen(int(fd), backlog)
+	}); err != nil {
+		return err
+	}
+	return os.NewSyscallError("listen", lerr)
+}
+
+// somaxconn reads net.core.somaxconn, which caps every backlog.
+func somaxconn() (int, error) {
+	b, err := os.ReadFile("/proc/sys/net/core/somaxconn")
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(strings.TrimSpace(string(b)))
+}
@@ -0,0 +1,66 @@
+package tcpqueue
+
+import (
+	"context"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestBacklogLimitsTheAcceptQueue(t *testing.T) {
+	captureLog(t)
+	const backlog = 2
+	l, effective, err := listen("tcp", "127.0.0.1:0", backlog, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	if effective != backlog {
+		t.Fatalf("effective backlog %d, want %d", effective, backlog)
+	}
+
+	// Nothing accepts, so once the queue is full further handshakes never
+	// complete. Linux queues one more than the backlog.
+	established := 0
+	for i := 0; i < backlog+4; i++ {
+		conn, err := net.DialTimeout("tcp", l.Addr().String(), 200*time.Millisecond)
+		if err != nil {
+			continue
+		}
+		defer conn.Close()
+		established++
+	}
+	if established != backlog+1 {
+		t.Errorf("%d connections established, want %d", established, backlog+1)
+	}
+}
+
+func TestUnixBacklogFull(t *testing.T) {
+	captureLog(t)
+	const backlog = 2
+	path := filepath.Join(t.TempDir(), "s.sock")
+	l, _, err := listen("unix", path, backlog, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	// A unix socket's queue also holds one more than the backlog, but a
+	// dial that finds it full fails at once instead of waiting.
+	d := newDialer("unix", time.Second, nil)
+	counts := map[string]int{}
+	for i := 0; i < backlog+3; i++ {
+		a := attempt{i: i}
+		if conn := establishConn(context.Background(), d, path, nil, &a); conn != nil {
+			defer conn.Close()
+		}
+		counts[a.dialErr]++
+		if a.dialErr == outcomeFull && a.dial > 100*time.Millisecond {
+			t.Errorf("attempt %d took %s to find the queue full", i, a.dial)
+		}
+	}
+	if got, want := formatCounts(counts, outcomes), "ok=3 full=2"; got != want {
+		t.Errorf("dials %s, want %s", got, want)
+	}
+}
@@ -0,0 +1,16 @@
+//go:build !linux
+
+package tcpqueue
+
+import (
+	"errors"
+	"net"
+)
+
+func setBacklog(net.Listener, int) error {
+	return errors.ErrUnsupported
+}
+
+func somaxconn() (int, error) {
+	return 0, errors.ErrUnsupported
+}
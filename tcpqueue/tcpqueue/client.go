@@ -0,0 +1,231 @@
+// The client concurrently requests the server -n times and sends data to the server after the TCP connection is established.
+package tcpqueue
+
+import (
+	"context"
+	"encoding/binary"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// client runs `tcpqueue client`: -n connections at once, each sending a
+// -payload-size pattern and then held open until Ctrl+C. Once every attempt
+// has dialed and written, it prints how they went, and on Ctrl+C how long
+// the server says the accepted ones were queued and whether their payloads
+// arrived intact. -mode=flood dials at a fixed rate instead; see flood.
+// -proto=udp sends datagrams; see udpClient. -proto=unix dials the unix
+// socket at -path instead of -addr.
+func client(args []string) error {
+	fs := flag.NewFlagSet("client", flag.ExitOnError)
+	addr := fs.String("addr", "localhost:8888", "server address")
+	proto := fs.String("proto", "tcp", "tcp, unix to dial -path instead, or udp to send -n datagrams at -rate")
+	path := fs.String("path", "/tmp/tcpqueue.sock", "with -proto=unix, the server's socket path")
+	mode := fs.String("mode", "parallel", "parallel: -n connections at once; flood: -rate a second for -duration")
+	n := fs.Int("n", 5, "connections to open in parallel")
+	rate := fs.Float64("rate", 100, "with -mode=flood, connection attempts a second; with -proto=udp, datagrams a second")
+	duration := fs.Duration("duration", 10*time.Second, "with -mode=flood, how long to keep it up")
+	workers := fs.Int("workers", 256, "with -mode=flood, dials in flight at most")
+	floodTimeout := fs.Duration("flood-timeout", 100*time.Millisecond, "with -mode=flood, dial timeout per attempt")
+	timeout := fs.Duration("timeout", 5*time.Second, "dial timeout per connection, and write timeout after it")
+	synRetries := fs.Int("syn-retries", 0, "TCP_SYNCNT: SYN retransmits before a dial gives up (0 = net.ipv4.tcp_syn_retries)")
+	nodelay := fs.Bool("nodelay", true, "TCP_NODELAY; false turns Nagle's algorithm back on")
+	linger0 := fs.Bool("linger0", false, "SO_LINGER with a zero timeout, so closing a connection sends an RST")
+	payloadSize := fs.Int("payload-size", 1024, "bytes of pattern written on each connection once it is established")
+	seed := fs.Uint64("seed", 1, "seed of the first connection's pattern; the i'th uses seed+i")
+	csvPath := fs.String("csv", "", "on exit, also write one row per attempt to this CSV file")
+	fs.Parse(args)
+	if *payloadSize < 0 || *payloadSize > maxPayload {
+		return fmt.Errorf("-payload-size must be between 0 and %d", maxPayload)
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	opts := clientSockopts(*synRetries, *nodelay, *linger0)
+	switch *proto {
+	case "tcp":
+	case "unix":
+		// The options are all TCP's; a unix socket would only warn about
+		// each.
+		*addr, opts = *path, nil
+	case "udp":
+		return udpClient(ctx, *addr, *n, *rate, *payloadSize, *seed, *csvPath)
+	default:
+		return fmt.Errorf("unknown -proto %q", *proto)
+	}
+
+	switch *mode {
+	case "parallel":
+	case "flood":
+		if *rate <= 0 || *workers <= 0 {
+			return fmt.Errorf("-rate and -workers must be above 0")
+		}
+		d := newDialer(*proto, *floodTimeout, opts)
+		totals := flood(ctx, d, *addr, *rate, *duration, *workers, os.Stdout)
+		fmt.Printf("Flood total: %s\n", formatCounts(totals, floodOutcomes))
+		return nil
+	default:
+		return fmt.Errorf("unknown -mode %q", *mode)
+	}
+
+	d := newDialer(*proto, *timeout, opts)
+	attempts := make([]attempt, *n)
+	var dialed, held sync.WaitGroup
+	for i := 0; i < *n; i++ {
+		attempts[i].i = i
+		dialed.Add(1)
+		held.Add(1)
+		go func(i int) {
+			defer held.Done()
+			payload := appendPayload(nil, *payloadSize, *seed+uint64(i))
+			conn := establishConn(ctx, d, *addr, payload, &attempts[i])
+			dialed.Done()
+			if conn == nil {
+				return
+			}
+			defer conn.Close()
+			stop := context.AfterFunc(ctx, func() { conn.Close() })
+			defer stop()
+			if awaitEcho(ctx, conn, &attempts[i]) {
+				awaitEnd(ctx, conn, &attempts[i])
+			}
+			<-ctx.Done()
+			log.Printf("%d, dial close", i)
+		}(i)
+	}
+	dialed.Wait()
+	summarize(os.Stdout, attempts)
+
+	held.Wait()
+	summarizeQueueing(os.Stdout, attempts)
+	if *csvPath != "" {
+		if err := writeCSVFile(*csvPath, attempts); err != nil {
+			return err
+		}
+	}
+	log.Printf("client exit")
+	return nil
+}
+
+// dialer dials the client's connections over network, tcp or unix, with
+// the socket options its flags ask for, logging each option's effect once
+// rather than per connection.
+type dialer struct {
+	net.Dialer
+	network string
+	opts    []sockopt
+	log     *onceLog
+}
+
+func newDialer(network string, timeout time.Duration, opts []sockopt) *dialer {
+	lg := &onceLog{}
+	return &dialer{Dialer: net.Dialer{Timeout: timeout, Control: controlSockopts(opts, lg)}, network: network, opts: opts, log: lg}
+}
+
+// dial connects to addr and sets the options that have to wait until the
+// connection is up.
+func (d *dialer) dial(ctx context.Context, addr string) (net.Conn, error) {
+	conn, err := d.DialContext(ctx, d.network, addr)
+	if err != nil {
+		return nil, err
+	}
+	if rc, err := conn.(syscall.Conn).SyscallConn(); err == nil {
+		setSockopts(rc, d.opts, true, d.log)
+	}
+	return conn, nil
+}
+
+// establishConn dials addr and writes the time the dial completed
+// (big-endian Unix nanoseconds, so the server can tell how long the
+// connection was queued) and payload, recording both steps in a. The write
+// gets as long as the dial did, and ctx cancels either. It returns the
+// connection, or nil if either step failed.
+func establishConn(ctx context.Context, d *dialer, addr string, payload []byte, a *attempt) net.Conn {
+	start := time.Now()
+	conn, err := d.dial(ctx, addr)
+	a.dial, a.dialErr = time.Since(start), classify(err)
+	if err != nil {
+		log.Printf("%d, dial error: %v", a.i, err)
+		return nil
+	}
+	log.Printf("%d, dial success", a.i)
+
+	// The write returns once the kernel has the data, which it can take
+	// before the server accepts, until the socket buffers fill up.
+	msg := binary.BigEndian.AppendUint64(make([]byte, 0, 8+len(payload)), uint64(time.Now().UnixNano()))
+	conn.SetWriteDeadline(time.Now().Add(d.Timeout))
+	stop := context.AfterFunc(ctx, func() { conn.SetWriteDeadline(time.Unix(1, 0)) })
+	start = time.Now()
+	_, err = conn.Write(append(msg, payload...))
+	stop()
+	if err != nil && ctx.Err() != nil {
+		err = ctx.Err()
+	}
+	a.write, a.writeErr, a.wrote = time.Since(start), classify(err), true
+	if err != nil {
+		log.Printf("%d, send error: %v", a.i, err)
+		conn.Close()
+		return nil
+	}
+	return conn
+}
+
+// awaitEcho waits for the server to accept conn and echo how long it was
+// queued, and then for its verdict on the payload, and records both in a.
+// Until ctx is done, that is; whatever has not arrived by then stays
+// pending. It reports whether both arrived; if not, the connection is over
+// and a records how it ended.
+func awaitEcho(ctx context.Context, conn net.Conn, a *attempt) bool {
+	var echo [8 + 1]byte
+	got, err := io.ReadFull(conn, echo[:])
+	if got >= 8 {
+		a.queued, a.echo = time.Duration(binary.BigEndian.Uint64(echo[:8])), outcomeOK
+		log.Printf("%d, queued for %s", a.i, a.queued)
+	}
+	switch {
+	case err == nil:
+		a.verdict = outcomeOther
+		if v := int(echo[8]); v < len(verdicts) {
+			a.verdict = verdicts[v]
+		}
+		log.Printf("%d, payload %s", a.i, a.verdict)
+		return true
+	case ctx.Err() != nil:
+		err = nil
+		a.verdict = outcomePending
+	default:
+		a.verdict = classify(err)
+	}
+	if a.echo == "" {
+		a.echo = a.verdict
+	}
+	a.end = a.verdict
+	if err != nil {
+		log.Printf("%d, no answer from the server: %v", a.i, err)
+	}
+	return false
+}
+
+// awaitEnd reads conn until the server closes or resets it, or until ctx
+// is done and it is still open, and records which in a.
+func awaitEnd(ctx context.Context, conn net.Conn, a *attempt) {
+	_, err := io.Copy(io.Discard, conn)
+	switch {
+	case ctx.Err() != nil:
+		a.end = outcomePending
+		return
+	case err == nil:
+		a.end = outcomeClosed
+	default:
+		a.end = classify(err)
+	}
+	log.Printf("%d, connection ended: %s", a.i, a.end)
+}
@@ -0,0 +1,126 @@
+package tcpqueue
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestEstablishConnSendsPayload(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	got := make(chan string, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		b, _ := io.ReadAll(conn)
+		got <- string(b)
+	}()
+
+	var a attempt
+	conn := establishConn(context.Background(), newDialer("tcp", time.Second, nil), ln.Addr().String(), []byte("hello"), &a)
+	if conn == nil {
+		t.Fatalf("establishConn failed: %+v", a)
+	}
+	conn.Close()
+	if a.dialErr != outcomeOK || !a.wrote || a.writeErr != outcomeOK {
+		t.Errorf("attempt %+v, want a successful dial and write", a)
+	}
+	select {
+	case s := <-got:
+		if len(s) != 8+5 || s[8:] != "hello" {
+			t.Errorf("server read %q, want a timestamp and %q", s, "hello")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the connection was not closed")
+	}
+}
+
+func TestEstablishConnRefused(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+
+	var a attempt
+	if conn := establishConn(context.Background(), newDialer("tcp", time.Second, nil), addr, []byte("hello"), &a); conn != nil {
+		conn.Close()
+		t.Fatal("dialed a closed port")
+	}
+	if a.dialErr != outcomeRefused || a.wrote {
+		t.Errorf("attempt %+v, want a refused dial and no write", a)
+	}
+}
+
+func TestAwaitEcho(t *testing.T) {
+	logs := captureLog(t)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	gate := newAcceptGate(ln.(deadliner))
+	stats := &serverStats{}
+	go acceptLoop(ln, gate, acceptPolicy{}, stats)
+
+	var a attempt
+	conn := establishConn(context.Background(), newDialer("tcp", time.Second, nil), ln.Addr().String(), appendPayload(nil, 100, 1), &a)
+	if conn == nil {
+		t.Fatalf("establishConn failed: %+v", a)
+	}
+	defer conn.Close()
+	const queued = 100 * time.Millisecond
+	time.AfterFunc(queued, func() { gate.set(true) })
+	awaitEcho(context.Background(), conn, &a)
+	if a.echo != outcomeOK || a.queued < queued || a.queued > 5*time.Second || a.verdict != verdictIntact {
+		t.Errorf("attempt %+v, want an echo of at least %s and an intact payload", a, queued)
+	}
+	waitForLog(t, logs, conn.LocalAddr().String()+": waited "+a.queued.String())
+
+	// A connection that is never accepted stays pending.
+	gate.set(false)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	var b attempt
+	other := establishConn(context.Background(), newDialer("tcp", time.Second, nil), ln.Addr().String(), nil, &b)
+	if other == nil {
+		t.Fatalf("establishConn failed: %+v", b)
+	}
+	defer other.Close()
+	stop := context.AfterFunc(ctx, func() { other.Close() })
+	defer stop()
+	awaitEcho(ctx, other, &b)
+	if b.echo != outcomePending || b.verdict != outcomePending {
+		t.Errorf("echo %q, payload %q for a connection never accepted, want %q", b.echo, b.verdict, outcomePending)
+	}
+}
+
+func TestEstablishConnCanceled(t *testing.T) {
+	captureLog(t)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	var a attempt
+	if conn := establishConn(ctx, newDialer("tcp", time.Second, nil), ln.Addr().String(), nil, &a); conn != nil {
+		conn.Close()
+		t.Fatal("dialed with a canceled context")
+	}
+	if a.dialErr != outcomeCanceled {
+		t.Errorf("dial %q, want %q", a.dialErr, outcomeCanceled)
+	}
+}
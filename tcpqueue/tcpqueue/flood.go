@@ -0,0 +1,128 @@
+package tcpqueue
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// floodTick is how often the flood hands out the attempts that have come
+// due, so rates above 1000/s need no finer timer.
+const floodTick = 10 * time.Millisecond
+
+// outcomeSkipped counts attempts the flood could not start because every
+// worker was still busy.
+const outcomeSkipped = "skipped"
+
+var floodOutcomes = []string{outcomeOK, outcomeTimeout, outcomeRefused, outcomeReset, outcomeFull, outcomeOther, outcomeCanceled, outcomeSkipped}
+
+// floodCounts counts a flood's attempts by outcome. The map is filled in
+// up front, so workers only touch the counters.
+type floodCounts map[string]*atomic.Int64
+
+func newFloodCounts() floodCounts {
+	c := floodCounts{}
+	for _, o := range floodOutcomes {
+		c[o] = &atomic.Int64{}
+	}
+	return c
+}
+
+// take returns the counts so far and resets them.
+func (c floodCounts) take() map[string]int {
+	m := map[string]int{}
+	for o, n := range c {
+		m[o] = int(n.Swap(0))
+	}
+	return m
+}
+
+// flood dials addr at rate attempts a second for duration, or until ctx is
+// done, which also cancels the dials in flight. At most workers dials are
+// in flight. An attempt that finds every
+// worker busy is skipped rather than queued, so the rate stays what was
+// asked for. Completed handshakes are held open until the flood ends, so
+// they keep their place in the accept queue. Every second it writes a line
+// of that second's outcomes to w, and it returns the totals.
+func flood(ctx context.Context, d *dialer, addr string, rate float64, duration time.Duration, workers int, w io.Writer) map[string]int {
+	// Only ctx cancels dials; the end of the duration just stops new ones.
+	run, cancel := context.WithTimeout(ctx, duration)
+	defer cancel()
+
+	counts := newFloodCounts()
+	var (
+		mu   sync.Mutex
+		held []net.Conn
+	)
+	jobs := make(chan struct{})
+	var wg sync.WaitGroup
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range jobs {
+				conn, err := d.dial(ctx, addr)
+				counts[classify(err)].Add(1)
+				if err != nil {
+					continue
+				}
+				mu.Lock()
+				held = append(held, conn)
+				mu.Unlock()
+			}
+		}()
+	}
+
+	totals := map[string]int{}
+	report := func(elapsed time.Duration) {
+		sec := counts.take()
+		n := 0
+		for o, k := range sec {
+			totals[o] += k
+			n += k
+		}
+		fmt.Fprintf(w, "flood %s: attempts=%d %s\n", elapsed.Round(time.Second), n, formatCounts(sec, floodOutcomes))
+	}
+
+	start := time.Now()
+	tick := time.NewTicker(floodTick)
+	defer tick.Stop()
+	second := time.NewTicker(time.Second)
+	defer second.Stop()
+	sent := 0
+loop:
+	for {
+		select {
+		case <-run.Done():
+			break loop
+		case now := <-second.C:
+			report(now.Sub(start))
+		case now := <-tick.C:
+			due := int(now.Sub(start).Seconds()*rate) - sent
+			for ; due > 0; due-- {
+				sent++
+				select {
+				case jobs <- struct{}{}:
+				default:
+					counts[outcomeSkipped].Add(1)
+				}
+			}
+		}
+	}
+	close(jobs)
+	wg.Wait()
+	report(time.Since(start))
+
+	mu.Lock()
+	defer mu.Unlock()
+	log.Printf("flood: closing %d established connections", len(held))
+	for _, conn := range held {
+		conn.Close()
+	}
+	return totals
+}
@@ -0,0 +1,66 @@
+package tcpqueue
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"regexp"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFlood(t *testing.T) {
+	captureLog(t)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closed.Close()
+
+	for _, tc := range []struct {
+		name, addr string
+		outcome    string
+	}{
+		{"listening", ln.Addr().String(), outcomeOK},
+		{"closed", closed.Addr().String(), outcomeRefused},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var out bytes.Buffer
+			totals := flood(context.Background(), newDialer("tcp", time.Second, nil), tc.addr, 100, 1500*time.Millisecond, 8, &out)
+			if n := totals[tc.outcome]; n < 130 || n > 160 {
+				t.Errorf("%d attempts %s, want about 150: %v", n, tc.outcome, totals)
+			}
+			lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+			if len(lines) != 2 || !regexp.MustCompile(`^flood 1s: attempts=\d+ `+tc.outcome+`=\d+$`).MatchString(lines[0]) {
+				t.Errorf("want a line for the first second and a final one:\n%s", out.String())
+			}
+		})
+	}
+}
+
+func TestFloodSkipsWhenWorkersAreBusy(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("needs -backlog to fill the accept queue")
+	}
+	captureLog(t)
+	ln, _, err := listen("tcp", "127.0.0.1:0", 1, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	// Two handshakes fill the queue; the rest hang until the timeout, which
+	// keeps the one worker busy for most of the ticks.
+	var out bytes.Buffer
+	totals := flood(context.Background(), newDialer("tcp", 100*time.Millisecond, nil), ln.Addr().String(), 200, time.Second, 1, &out)
+	if totals[outcomeOK] != 2 || totals[outcomeTimeout] == 0 || totals[outcomeSkipped] < 100 {
+		t.Errorf("totals %v, want 2 ok, some timeouts and most skipped", totals)
+	}
+}
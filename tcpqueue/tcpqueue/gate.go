@@ -0,0 +1,117 @@
+package tcpqueue
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// deadliner is the part of a listener the gate needs to interrupt a
+// blocked Accept without closing it.
+type deadliner interface {
+	SetDeadline(t time.Time) error
+}
+
+// acceptGate says whether the server is accepting. Turning it off expires
+// the listener's deadline, so an Accept already waiting returns a timeout
+// instead of taking one more connection off the queue; the listener and the
+// queue stay as they are. With -proto=udp it gates reading the same way.
+type acceptGate struct {
+	ln   deadliner
+	what string // what the gate turns on and off, for the log
+
+	mu      sync.Mutex
+	on      bool
+	shut    bool          // for good, see shutDown
+	changed chan struct{} // closed and replaced on every change
+}
+
+func newAcceptGate(ln deadliner) *acceptGate {
+	return &acceptGate{ln: ln, what: "accepting", changed: make(chan struct{})}
+}
+
+// set turns accepting on or off and reports whether that changed anything.
+func (g *acceptGate) set(on bool) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.on == on {
+		return false
+	}
+	g.on = on
+	if on {
+		g.ln.SetDeadline(time.Time{})
+		log.Printf("%s started", g.what)
+	} else {
+		g.ln.SetDeadline(time.Now())
+		log.Printf("%s stopped, the socket stays open", g.what)
+	}
+	close(g.changed)
+	g.changed = make(chan struct{})
+	return true
+}
+
+func (g *acceptGate) toggle() {
+	g.mu.Lock()
+	on := g.on
+	g.mu.Unlock()
+	g.set(!on)
+}
+
+// state returns whether accepting is on, and a channel closed when that
+// changes.
+func (g *acceptGate) state() (bool, <-chan struct{}) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.on, g.changed
+}
+
+// waitOn blocks until accepting is on, and reports false instead if the
+// gate is shut down first.
+func (g *acceptGate) waitOn() bool {
+	for {
+		g.mu.Lock()
+		on, shut, changed := g.on, g.shut, g.changed
+		g.mu.Unlock()
+		if shut {
+			return false
+		}
+		if on {
+			return true
+		}
+		<-changed
+	}
+}
+
+// shutDown releases an accept loop waiting for the gate to open, for when
+// the server is exiting while accepting is off.
+func (g *acceptGate) shutDown() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if !g.shut {
+		g.shut = true
+		close(g.changed)
+		g.changed = make(chan struct{})
+	}
+}
+
+// ServeHTTP handles -control-addr: POST /accept/start or /accept/stop.
+func (g *acceptGate) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var on bool
+	switch r.URL.Path {
+	case "/accept/start":
+		on = true
+	case "/accept/stop":
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "use POST", http.StatusMethodNotAllowed)
+		return
+	}
+	changed := g.set(on)
+	fmt.Fprintf(w, "accepting=%t changed=%t\n", on, changed)
+}
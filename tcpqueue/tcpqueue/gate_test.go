@@ -0,0 +1,304 @@
+package tcpqueue
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// syncBuffer is a log destination the test can read while servers write.
+type syncBuffer struct {
+	mu sync.Mutex
+	b  bytes.Buffer
+}
+
+func (s *syncBuffer) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.b.Write(p)
+}
+
+func (s *syncBuffer) String() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.b.String()
+}
+
+// captureLog sends the log package's output to a buffer for one test.
+func captureLog(t *testing.T) *syncBuffer {
+	t.Helper()
+	buf, saved := &syncBuffer{}, log.Writer()
+	log.SetOutput(buf)
+	t.Cleanup(func() { log.SetOutput(saved) })
+	return buf
+}
+
+// waitForLog waits until a logged line contains substr, and returns it.
+func waitForLog(t *testing.T, logs *syncBuffer, substr string) string {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		for _, line := range strings.Split(logs.String(), "\n") {
+			if strings.Contains(line, substr) {
+				return line
+			}
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("no log line contains %q:\n%s", substr, logs.String())
+	return ""
+}
+
+// queuedConn connects to addr and sends a connect timestamp and a payload
+// of size bytes, as the client does.
+func queuedConn(t *testing.T, addr string, size int) net.Conn {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	msg := binary.BigEndian.AppendUint64(nil, uint64(time.Now().UnixNano()))
+	if _, err := conn.Write(appendPayload(msg, size, uint64(size))); err != nil {
+		t.Fatal(err)
+	}
+	return conn
+}
+
+func TestAcceptGate(t *testing.T) {
+	logs := captureLog(t)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	gate := newAcceptGate(ln.(deadliner))
+	done := make(chan error, 1)
+	go func() { done <- acceptLoop(ln, gate, acceptPolicy{}, &serverStats{}) }()
+
+	// Off: the handshake completes, but the connection stays queued.
+	first := queuedConn(t, ln.Addr().String(), 5)
+	time.Sleep(100 * time.Millisecond)
+	if strings.Contains(logs.String(), "in the queue") {
+		t.Fatalf("a connection was accepted with the gate off:\n%s", logs)
+	}
+
+	gate.set(true)
+	line := waitForLog(t, logs, first.LocalAddr().String()+": waited")
+	if !strings.HasSuffix(line, ", 25 bytes, payload intact") {
+		t.Errorf("drain logged %q, want the 25 bytes sent and an intact payload", line)
+	}
+
+	// Off again, with the loop already back in Accept.
+	time.Sleep(50 * time.Millisecond)
+	gate.set(false)
+	second := queuedConn(t, ln.Addr().String(), 5)
+	time.Sleep(100 * time.Millisecond)
+	if strings.Contains(logs.String(), second.LocalAddr().String()) {
+		t.Fatalf("a connection was accepted after the gate closed:\n%s", logs)
+	}
+	gate.set(true)
+	waitForLog(t, logs, second.LocalAddr().String()+": waited")
+
+	ln.Close()
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Error("acceptLoop returned nil after the listener closed")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("acceptLoop still running after the listener closed")
+	}
+}
+
+func TestAcceptGateShutDown(t *testing.T) {
+	captureLog(t)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	gate := newAcceptGate(ln.(deadliner))
+	done := make(chan error, 1)
+	go func() { done <- acceptLoop(ln, gate, acceptPolicy{}, &serverStats{}) }()
+
+	// With the gate off the loop is not in Accept, so closing the listener
+	// alone would not end it.
+	time.Sleep(50 * time.Millisecond)
+	gate.shutDown()
+	select {
+	case err := <-done:
+		if !errors.Is(err, net.ErrClosed) {
+			t.Errorf("acceptLoop returned %v, want net.ErrClosed", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("acceptLoop still waiting after the gate was shut down")
+	}
+}
+
+func TestAcceptGateHTTP(t *testing.T) {
+	captureLog(t)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	gate := newAcceptGate(ln.(deadliner))
+
+	for _, tc := range []struct {
+		method, path string
+		code         int
+		body         string
+		on           bool
+	}{
+		{http.MethodPost, "/accept/start", http.StatusOK, "accepting=true changed=true\n", true},
+		{http.MethodPost, "/accept/start", http.StatusOK, "accepting=true changed=false\n", true},
+		{http.MethodGet, "/accept/stop", http.StatusMethodNotAllowed, "use POST\n", true},
+		{http.MethodPost, "/accept/stop", http.StatusOK, "accepting=false changed=true\n", false},
+		{http.MethodPost, "/accept/pause", http.StatusNotFound, "404 page not found\n", false},
+	} {
+		rec := httptest.NewRecorder()
+		gate.ServeHTTP(rec, httptest.NewRequest(tc.method, tc.path, nil))
+		if rec.Code != tc.code || rec.Body.String() != tc.body {
+			t.Errorf("%s %s = %d %q, want %d %q", tc.method, tc.path, rec.Code, rec.Body, tc.code, tc.body)
+		}
+		if on, _ := gate.state(); on != tc.on {
+			t.Errorf("after %s %s accepting=%t, want %t", tc.method, tc.path, on, tc.on)
+		}
+	}
+}
+
+func TestDrainTimestamps(t *testing.T) {
+	logs := captureLog(t)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	gate := newAcceptGate(ln.(deadliner))
+	stats := &serverStats{}
+	go acceptLoop(ln, gate, acceptPolicy{}, stats)
+
+	ok := queuedConn(t, ln.Addr().String(), 5)
+	short, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	short.Write([]byte{1, 2, 3})
+	short.Close()
+	reset, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	reset.(*net.TCPConn).SetLinger(0)
+	reset.Close()
+	time.Sleep(50 * time.Millisecond)
+	gate.set(true)
+
+	ok.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var echo [8]byte
+	if _, err := io.ReadFull(ok, echo[:]); err != nil {
+		t.Fatalf("no queueing delay echoed: %v", err)
+	}
+	if d := time.Duration(binary.BigEndian.Uint64(echo[:])); d < 50*time.Millisecond {
+		t.Errorf("echoed %s, want at least the 50ms the connection was queued", d)
+	}
+	waitForLog(t, logs, short.LocalAddr().String()+": short connect timestamp, 3 of 8 bytes")
+	waitForLog(t, logs, reset.LocalAddr().String()+": reset before sending its connect timestamp")
+	waitForLog(t, logs, ok.LocalAddr().String()+": waited")
+
+	if !stats.waitDrains(5 * time.Second) {
+		t.Fatal("connections still being read")
+	}
+	var out bytes.Buffer
+	stats.summarize(&out)
+	// 8+12+5 bytes from ok and 3 from short.
+	for _, want := range []string{"Accepted: 3\n", "Still queued: unknown, not sampled\n", "Bytes received: 28\n", "Timestamps: ok=1 short=1 reset=1\n", "Queueing delay: n=1 min="} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("summary lacks %q:\n%s", want, out.String())
+		}
+	}
+}
+
+func TestAcceptRate(t *testing.T) {
+	logs := captureLog(t)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	gate := newAcceptGate(ln.(deadliner))
+	go acceptLoop(ln, gate, acceptPolicy{rate: 20}, &serverStats{})
+
+	const n = 5
+	var conns []net.Conn
+	for range n {
+		conns = append(conns, queuedConn(t, ln.Addr().String(), 5))
+	}
+	time.Sleep(50 * time.Millisecond)
+	gate.set(true)
+
+	// One accept every 50ms, so each connection waits about that much
+	// longer than the one before.
+	var waited []time.Duration
+	for _, conn := range conns {
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		var echo [8]byte
+		if _, err := io.ReadFull(conn, echo[:]); err != nil {
+			t.Fatalf("no queueing delay echoed: %v", err)
+		}
+		waited = append(waited, time.Duration(binary.BigEndian.Uint64(echo[:])))
+	}
+	slices.Sort(waited)
+	if spread := waited[n-1] - waited[0]; spread < (n-1)*40*time.Millisecond {
+		t.Errorf("queueing delays %v spread over %s, want about %s at 20 accepts a second", waited, spread, (n-1)*50*time.Millisecond)
+	}
+	waitForLog(t, logs, conns[0].LocalAddr().String()+": waited")
+}
+
+func TestHold(t *testing.T) {
+	const hold = 300 * time.Millisecond
+	for _, tc := range []struct {
+		read bool
+		log  string
+	}{
+		{true, ", 25 bytes, payload intact, held 300ms"},
+		{false, ", held 300ms without reading"},
+	} {
+		logs := captureLog(t)
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer ln.Close()
+		gate := newAcceptGate(ln.(deadliner))
+		gate.set(true)
+		go acceptLoop(ln, gate, acceptPolicy{hold: hold, holdRead: tc.read}, &serverStats{})
+
+		// The client keeps the connection open, so only the hold ends it.
+		conn := queuedConn(t, ln.Addr().String(), 5)
+		start := time.Now()
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		answer, _ := io.ReadAll(conn)
+		if d := time.Since(start); d < hold-50*time.Millisecond {
+			t.Errorf("hold-read=%t: closed after %s, want %s", tc.read, d, hold)
+		}
+		// The echo, then a verdict only if the payload was read.
+		if want := 8; !tc.read && len(answer) != want || tc.read && len(answer) != want+1 {
+			t.Errorf("hold-read=%t: got %d bytes back", tc.read, len(answer))
+		}
+		line := waitForLog(t, logs, conn.LocalAddr().String()+": waited")
+		if !strings.HasSuffix(line, tc.log) {
+			t.Errorf("hold-read=%t: drain logged %q, want it to end in %q", tc.read, line, tc.log)
+		}
+	}
+}
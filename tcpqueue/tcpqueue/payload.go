@@ -0,0 +1,91 @@
+package tcpqueue
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net"
+	"time"
+)
+
+// After the connect timestamp the client sends a payload header, the length
+// of the payload and the seed of its pattern (both big-endian), then the
+// payload itself. That lets the server check that what it reads after
+// Accept is exactly what the client wrote while the connection was queued.
+const (
+	payloadHeader = 4 + 8
+	maxPayload    = 64 << 20
+)
+
+// What the server made of a payload. It sends the index of the verdict back
+// as one byte once it has read the payload.
+const (
+	verdictIntact    = "intact"
+	verdictMismatch  = "mismatch"
+	verdictTruncated = "truncated"
+)
+
+var verdicts = []string{verdictIntact, verdictMismatch, verdictTruncated}
+
+// appendPayload appends the header and size bytes of the pattern for seed.
+func appendPayload(b []byte, size int, seed uint64) []byte {
+	b = binary.BigEndian.AppendUint32(b, uint32(size))
+	b = binary.BigEndian.AppendUint64(b, seed)
+	return append(b, pattern(seed, size)...)
+}
+
+// pattern returns n pseudo-random bytes that depend only on seed.
+func pattern(seed uint64, n int) []byte {
+	r := rand.New(rand.NewPCG(seed, 0))
+	p := make([]byte, n)
+	var v uint64
+	for i := range p {
+		if i%8 == 0 {
+			v = r.Uint64()
+		}
+		p[i] = byte(v >> (8 * (i % 8)))
+	}
+	return p
+}
+
+// verifyPayload reads a payload from r and returns how many bytes that took
+// and its verdict, with what went wrong for anything but intact.
+func verifyPayload(r io.Reader) (int64, string, error) {
+	var hdr [payloadHeader]byte
+	got, err := io.ReadFull(r, hdr[:])
+	if err != nil {
+		return int64(got), verdictTruncated, fmt.Errorf("%d of %d header bytes: %w", got, len(hdr), err)
+	}
+	size, seed := binary.BigEndian.Uint32(hdr[:4]), binary.BigEndian.Uint64(hdr[4:])
+	if size > maxPayload {
+		return int64(got), verdictMismatch, fmt.Errorf("header claims %d bytes", size)
+	}
+	p := make([]byte, size)
+	m, err := io.ReadFull(r, p)
+	n := int64(got + m)
+	if err != nil {
+		return n, verdictTruncated, fmt.Errorf("%d of %d bytes: %w", m, size, err)
+	}
+	want := pattern(seed, int(size))
+	if !bytes.Equal(p, want) {
+		i := 0
+		for p[i] == want[i] {
+			i++
+		}
+		return n, verdictMismatch, fmt.Errorf("byte %d of %d differs", i, size)
+	}
+	return n, verdictIntact, nil
+}
+
+// idleReader reads from conn, giving up once it has been quiet for idle.
+type idleReader struct {
+	conn net.Conn
+	idle time.Duration
+}
+
+func (r idleReader) Read(p []byte) (int, error) {
+	r.conn.SetReadDeadline(time.Now().Add(r.idle))
+	return r.conn.Read(p)
+}
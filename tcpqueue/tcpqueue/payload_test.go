@@ -0,0 +1,62 @@
+package tcpqueue
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestVerifyPayload(t *testing.T) {
+	good := appendPayload(nil, 100, 7)
+	flipped := bytes.Clone(good)
+	flipped[payloadHeader+42] ^= 1
+	otherSeed := appendPayload(nil, 100, 8)
+	copy(otherSeed[:payloadHeader], good[:payloadHeader])
+	huge := binary.BigEndian.AppendUint32(nil, maxPayload+1)
+	huge = binary.BigEndian.AppendUint64(huge, 7)
+
+	for _, tc := range []struct {
+		name    string
+		data    []byte
+		n       int64
+		verdict string
+		why     string
+	}{
+		{"intact", good, int64(len(good)), verdictIntact, ""},
+		{"empty", appendPayload(nil, 0, 7), payloadHeader, verdictIntact, ""},
+		{"flipped bit", flipped, int64(len(good)), verdictMismatch, "byte 42 of 100 differs"},
+		{"other seed", otherSeed, int64(len(good)), verdictMismatch, "byte 0 of 100 differs"},
+		{"short header", good[:5], 5, verdictTruncated, "5 of 12 header bytes"},
+		{"short payload", good[:payloadHeader+60], payloadHeader + 60, verdictTruncated, "60 of 100 bytes"},
+		{"nothing", nil, 0, verdictTruncated, "0 of 12 header bytes"},
+		{"huge", huge, payloadHeader, verdictMismatch, "header claims"},
+	} {
+		n, verdict, why := verifyPayload(bytes.NewReader(tc.data))
+		if n != tc.n || verdict != tc.verdict || (why == nil) != (tc.why == "") || why != nil && !strings.Contains(why.Error(), tc.why) {
+			t.Errorf("%s: got %d, %s, %v; want %d, %s, %q", tc.name, n, verdict, why, tc.n, tc.verdict, tc.why)
+		}
+	}
+
+	// The rest of the stream is left to the caller.
+	r := io.MultiReader(bytes.NewReader(good), strings.NewReader("more"))
+	if _, verdict, _ := verifyPayload(r); verdict != verdictIntact {
+		t.Fatalf("verdict %s with data after the payload", verdict)
+	}
+	if rest, _ := io.ReadAll(r); string(rest) != "more" {
+		t.Errorf("left %q unread, want %q", rest, "more")
+	}
+}
+
+func TestPatternDependsOnSeed(t *testing.T) {
+	if !bytes.Equal(pattern(3, 50), pattern(3, 50)) {
+		t.Error("pattern(3) differs between calls")
+	}
+	if bytes.Equal(pattern(3, 50), pattern(4, 50)) {
+		t.Error("seeds 3 and 4 give the same pattern")
+	}
+	if !bytes.HasPrefix(pattern(3, 50), pattern(3, 13)) {
+		t.Error("a shorter pattern is not a prefix of a longer one")
+	}
+}
@@ -0,0 +1,92 @@
+package tcpqueue
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"strconv"
+	"time"
+)
+
+// sampleAcceptQueue logs how many connections are waiting in l's accept
+// queue every interval, out of the backlog listen returned, and records it
+// in stats. It asks the kernel rather than the accept loop, so it carries
+// on whether or not the server is accepting. When ctx is done it takes one
+// last sample for the report and returns, so stop it before closing l.
+// Where the queue cannot be sampled at all it only warns.
+func sampleAcceptQueue(ctx context.Context, l net.Listener, backlog int, every time.Duration, stats *serverStats) error {
+	depth, err := queueDepth(l)
+	if err != nil {
+		log.Printf("warning: cannot sample the accept queue: %v", err)
+		return nil
+	}
+	of := "?"
+	if backlog > 0 {
+		of = strconv.Itoa(backlog)
+	}
+	return sampleUntil(ctx, every, func(last bool) error {
+		n, err := depth()
+		if err != nil {
+			return fmt.Errorf("sampling the accept queue: %w", err)
+		}
+		stats.sampled(n)
+		if !last {
+			log.Printf("accept backlog: %d/%s", n, of)
+		}
+		return nil
+	})
+}
+
+// sampleReceiveQueue is sampleAcceptQueue for -proto=udp: it logs the bytes
+// waiting in pc's receive buffer and how many datagrams the kernel has
+// dropped for want of room.
+func sampleReceiveQueue(ctx context.Context, pc net.PacketConn, every time.Duration, stats *serverStats) error {
+	depth, err := udpQueue(pc)
+	if err != nil {
+		log.Printf("warning: cannot sample the receive queue: %v", err)
+		return nil
+	}
+	return sampleUntil(ctx, every, func(last bool) error {
+		n, drops, err := depth()
+		if err != nil {
+			return fmt.Errorf("sampling the receive queue: %w", err)
+		}
+		stats.sampled(n)
+		stats.dropped(drops)
+		if !last {
+			log.Printf("receive queue: %d bytes, %d datagrams dropped", n, drops)
+		}
+		return nil
+	})
+}
+
+// sampleAccepts stands in for sampleAcceptQueue with -proto=unix. Linux
+// keeps no /proc table of a unix socket's queue, so it logs how many
+// connections have been accepted so far instead.
+func sampleAccepts(ctx context.Context, backlog int, every time.Duration, stats *serverStats) error {
+	log.Printf("note: kernel-side queue introspection is unavailable for unix sockets (backlog %d); logging accept counts instead", backlog)
+	return sampleUntil(ctx, every, func(last bool) error {
+		if !last {
+			log.Printf("accepted: %d", stats.acceptCount())
+		}
+		return nil
+	})
+}
+
+// sampleUntil calls sample every interval until ctx is done, and then once
+// more with last set.
+func sampleUntil(ctx context.Context, every time.Duration, sample func(last bool) error) error {
+	t := time.NewTicker(every)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return sample(true)
+		case <-t.C:
+			if err := sample(false); err != nil {
+				return err
+			}
+		}
+	}
+}
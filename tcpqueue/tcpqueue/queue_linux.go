@@ -0,0 +1,139 @@
+package tcpqueue
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// queueDepth returns a function that reports how many connections are
+// queued on l, from the rx_queue column of its line in /proc/net/tcp or
+// tcp6. For a listening socket that column is the accept queue length,
+// the same number `ss -lnt` shows as Recv-Q.
+func queueDepth(l net.Listener) (func() (int, error), error) {
+	sc, ok := l.(syscall.Conn)
+	if !ok {
+		return nil, errors.New("listener has no socket")
+	}
+	read, err := procSocket(sc, []string{"/proc/net/tcp", "/proc/net/tcp6"}, tcpListen)
+	if err != nil {
+		return nil, err
+	}
+	return func() (int, error) {
+		e, err := read()
+		return e.rxQueue, err
+	}, nil
+}
+
+// udpQueue returns a function that reports the bytes waiting in pc's
+// receive buffer and how many datagrams the kernel has dropped because it
+// was full, from pc's line in /proc/net/udp or udp6.
+func udpQueue(pc net.PacketConn) (func() (queued, drops int, err error), error) {
+	sc, ok := pc.(syscall.Conn)
+	if !ok {
+		return nil, errors.New("packet conn has no socket")
+	}
+	read, err := procSocket(sc, []string{"/proc/net/udp", "/proc/net/udp6"}, "")
+	if err != nil {
+		return nil, err
+	}
+	return func() (int, int, error) {
+		e, err := read()
+		if err != nil {
+			return 0, 0, err
+		}
+		const colDrops = 12
+		if len(e.fields) <= colDrops {
+			return 0, 0, errors.New("no drops column")
+		}
+		drops, err := strconv.Atoi(e.fields[colDrops])
+		return e.rxQueue, drops, err
+	}, nil
+}
+
+// tcpListen is the st column of a listening TCP socket.
+const tcpListen = "0A"
+
+// procEntry is a socket's line in one of the /proc/net tables.
+type procEntry struct {
+	rxQueue int
+	fields  []string
+}
+
+// procSocket returns a function that finds c's line in tables, by the
+// socket's inode so another socket on the same port cannot be mistaken for
+// it, and with the st column state unless that is empty.
+func procSocket(c syscall.Conn, tables []string, state string) (func() (procEntry, error), error) {
+	rc, err := c.SyscallConn()
+	if err != nil {
+		return nil, err
+	}
+	var st syscall.Stat_t
+	var serr error
+	if err := rc.Control(func(fd uintptr) {
+		serr = syscall.Fstat(int(fd), &st)
+	}); err != nil {
+		return nil, err
+	}
+	if serr != nil {
+		return nil, os.NewSyscallError("fstat", serr)
+	}
+	ino := st.Ino
+	read := func() (procEntry, error) {
+		for _, name := range tables {
+			f, err := os.Open(name)
+			if err != nil {
+				return procEntry{}, err
+			}
+			e, found, err := parseProcNet(f, ino, state)
+			f.Close()
+			if err != nil {
+				return procEntry{}, fmt.Errorf("%s: %w", name, err)
+			}
+			if found {
+				return e, nil
+			}
+		}
+		return procEntry{}, fmt.Errorf("no socket with inode %d", ino)
+	}
+	// Fail now rather than on the first tick if /proc is no use.
+	if _, err := read(); err != nil {
+		return nil, err
+	}
+	return read, nil
+}
+
+// parseProcNet scans a /proc/net/tcp style table for the socket with inode
+// ino, in the given state unless that is empty, and returns its line.
+func parseProcNet(r io.Reader, ino uint64, state string) (e procEntry, found bool, err error) {
+	const (
+		colState  = 3
+		colQueues = 4 // tx_queue:rx_queue
+		colInode  = 9
+	)
+	want := strconv.FormatUint(ino, 10)
+	s := bufio.NewScanner(r)
+	s.Scan() // header
+	for s.Scan() {
+		f := strings.Fields(s.Text())
+		if len(f) <= colInode || f[colInode] != want || state != "" && f[colState] != state {
+			continue
+		}
+		_, rx, ok := strings.Cut(f[colQueues], ":")
+		if !ok {
+			return e, false, fmt.Errorf("bad queue column %q", f[colQueues])
+		}
+		q, err := strconv.ParseUint(rx, 16, 32)
+		if err != nil {
+			return e, false, fmt.Errorf("bad rx_queue %q: %w", rx, err)
+		}
+		return procEntry{rxQueue: int(q), fields: f}, true, nil
+	}
+	return e, false, s.Err()
+}
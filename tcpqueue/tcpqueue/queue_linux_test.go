@@ -0,0 +1,103 @@
+package tcpqueue
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseProcNet(t *testing.T) {
+	const table = `  sl  local_address rem_address   st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode
+   0: 00000000:22B8 00000000:0000 0A 00000000:00000003 00:00000000 00000000  1000        0 4242 1 0000000000000000 100 0 0 10 0
+   1: 0100007F:22B8 0100007F:C350 01 00000000:0000000C 00:00000000 00000000  1000        0 4243 1 0000000000000000 20 4 30 10 -1
+   2: 0100007F:1F90 00000000:0000 0A 00000000:00000011 00:00000000 00000000     0        0 99 1 0000000000000000 100 0 0 10 0
+`
+	for _, tc := range []struct {
+		ino   uint64
+		n     int
+		found bool
+	}{
+		{4242, 3, true},
+		{99, 17, true},
+		{4243, 0, false}, // established, not listening
+		{7, 0, false},
+	} {
+		e, found, err := parseProcNet(strings.NewReader(table), tc.ino, tcpListen)
+		if err != nil || e.rxQueue != tc.n || found != tc.found {
+			t.Errorf("inode %d: got %d, %t, %v; want %d, %t", tc.ino, e.rxQueue, found, err, tc.n, tc.found)
+		}
+	}
+}
+
+func TestQueueDepthCountsQueuedConnections(t *testing.T) {
+	captureLog(t)
+	l, _, err := listen("tcp", "127.0.0.1:0", 8, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	depth, err := queueDepth(l)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for want := 0; want <= 3; want++ {
+		if n, err := depth(); err != nil || n != want {
+			t.Fatalf("depth = %d, %v; want %d", n, err, want)
+		}
+		conn, err := net.DialTimeout("tcp", l.Addr().String(), time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+	}
+
+	// Accepting one takes it off the queue.
+	conn, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+	if n, err := depth(); err != nil || n != 3 {
+		t.Errorf("after accepting one, depth = %d, %v; want 3", n, err)
+	}
+
+	l.Close()
+	if _, err := depth(); err == nil {
+		t.Error("no error once the listener is closed")
+	}
+}
+
+func TestSampleAcceptQueueLastSample(t *testing.T) {
+	logs := captureLog(t)
+	l, _, err := listen("tcp", "127.0.0.1:0", 4, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	stats := &serverStats{}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- sampleAcceptQueue(ctx, l, 4, 10*time.Millisecond, stats) }()
+	waitForLog(t, logs, "accept backlog: 0/4")
+
+	for i := 0; i < 2; i++ {
+		conn, err := net.Dial("tcp", l.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+	}
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	stats.summarize(&out)
+	if !strings.Contains(out.String(), "Still queued: 2\n") {
+		t.Errorf("report does not have the last sample:\n%s", out.String())
+	}
+}
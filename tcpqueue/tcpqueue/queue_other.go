@@ -0,0 +1,16 @@
+//go:build !linux
+
+package tcpqueue
+
+import (
+	"errors"
+	"net"
+)
+
+func queueDepth(net.Listener) (func() (int, error), error) {
+	return nil, errors.ErrUnsupported
+}
+
+func udpQueue(net.PacketConn) (func() (int, int, error), error) {
+	return nil, errors.ErrUnsupported
+}
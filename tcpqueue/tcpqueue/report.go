@@ -0,0 +1,182 @@
+package tcpqueue
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"slices"
+	"strconv"
+	"syscall"
+	"time"
+
+	"goexp/internal/bench"
+)
+
+// Outcomes of a dial or a write.
+const (
+	outcomeOK       = "ok"
+	outcomeTimeout  = "timeout"
+	outcomeRefused  = "refused"
+	outcomeReset    = "reset"
+	outcomeFull     = "full"   // a unix socket's accept queue had no room
+	outcomeClosed   = "closed" // the server closed the connection
+	outcomeOther    = "other"
+	outcomeCanceled = "canceled" // cut short by Ctrl+C
+	outcomePending  = "pending"  // still waiting when the client stopped
+)
+
+var outcomes = []string{outcomeOK, outcomeTimeout, outcomeRefused, outcomeReset, outcomeFull, outcomeClosed, outcomeOther, outcomeCanceled, outcomePending}
+
+// payloadOutcomes are what the client can learn of a payload: the server's
+// verdict, or why there was none.
+var payloadOutcomes = append(slices.Clone(verdicts), outcomes[1:]...)
+
+// classify sorts err into one of the outcomes.
+func classify(err error) string {
+	var ne net.Error
+	switch {
+	case err == nil:
+		return outcomeOK
+	case errors.Is(err, context.Canceled):
+		return outcomeCanceled
+	case errors.Is(err, syscall.EAGAIN):
+		// Connecting to a unix socket whose accept queue is full fails at
+		// once rather than waiting for room. Go counts EAGAIN as a
+		// timeout, so this comes first.
+		return outcomeFull
+	case errors.As(err, &ne) && ne.Timeout():
+		return outcomeTimeout
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return outcomeRefused
+	case errors.Is(err, syscall.ECONNRESET), errors.Is(err, syscall.EPIPE):
+		return outcomeReset
+	case errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+		return outcomeClosed
+	}
+	return outcomeOther
+}
+
+// attempt is how one of the client's connections went.
+type attempt struct {
+	i        int
+	dial     time.Duration
+	dialErr  string // an outcome
+	wrote    bool   // whether the write was tried, i.e. the dial succeeded
+	write    time.Duration
+	writeErr string
+	echo     string        // an outcome; empty if the write failed
+	queued   time.Duration // echoed by the server, if echo is ok
+	verdict  string        // the server's verdict on the payload, or an outcome
+	end      string        // how the connection ended: closed, reset, pending...
+}
+
+// summarize prints the outcomes of the dials and the writes, and the
+// distribution of the successful dials' latencies.
+func summarize(w io.Writer, attempts []attempt) {
+	dials, writes := map[string]int{}, map[string]int{}
+	var connected []time.Duration
+	for _, a := range attempts {
+		dials[a.dialErr]++
+		if a.dialErr == outcomeOK {
+			connected = append(connected, a.dial)
+		}
+		if a.wrote {
+			writes[a.writeErr]++
+		}
+	}
+	fmt.Fprintf(w, "Attempts: %d\n", len(attempts))
+	fmt.Fprintf(w, "Dials:  %s\n", formatCounts(dials, outcomes))
+	fmt.Fprintf(w, "Writes: %s\n", formatCounts(writes, outcomes))
+	fmt.Fprintf(w, "Connect latency: %s\n", bench.SummarizeDurations(connected).Format(bench.Durations))
+}
+
+// summarizeDatagrams is summarize for -proto=udp, where there is nothing
+// to dial.
+func summarizeDatagrams(w io.Writer, attempts []attempt) {
+	writes := map[string]int{}
+	for _, a := range attempts {
+		if a.wrote {
+			writes[a.writeErr]++
+		} else {
+			writes[a.dialErr]++
+		}
+	}
+	fmt.Fprintf(w, "Datagrams: %d\n", len(attempts))
+	fmt.Fprintf(w, "Writes: %s\n", formatCounts(writes, outcomes))
+}
+
+// summarizeQueueing prints how many connections the server accepted, the
+// distribution of the queueing delays it echoed, its verdicts on the
+// payloads and, for connections, whether it closed or reset them.
+func summarizeQueueing(w io.Writer, attempts []attempt) {
+	echoes, payloads, ends := map[string]int{}, map[string]int{}, map[string]int{}
+	var queued []time.Duration
+	for _, a := range attempts {
+		if a.echo == "" {
+			continue
+		}
+		echoes[a.echo]++
+		payloads[a.verdict]++
+		if a.end != "" {
+			ends[a.end]++
+		}
+		if a.echo == outcomeOK {
+			queued = append(queued, a.queued)
+		}
+	}
+	fmt.Fprintf(w, "Echoes: %s\n", formatCounts(echoes, outcomes))
+	fmt.Fprintf(w, "Queueing delay: %s\n", bench.SummarizeDurations(queued).Format(bench.Durations))
+	fmt.Fprintf(w, "Payloads: %s\n", formatCounts(payloads, payloadOutcomes))
+	if len(ends) > 0 {
+		fmt.Fprintf(w, "Ends: %s\n", formatCounts(ends, outcomes))
+	}
+}
+
+// formatCounts prints counts in the given order, leaving out zeros.
+func formatCounts(counts map[string]int, order []string) string {
+	s := ""
+	for _, o := range order {
+		if counts[o] > 0 {
+			s += fmt.Sprintf("%s=%d ", o, counts[o])
+		}
+	}
+	if s == "" {
+		return "none"
+	}
+	return s[:len(s)-1]
+}
+
+// writeCSV writes one row per attempt, with latencies in microseconds and
+// empty columns for the steps an attempt never got to.
+func writeCSV(w io.Writer, attempts []attempt) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"attempt", "dial_us", "dial", "write_us", "write", "queued_us", "echo", "payload", "end"})
+	for _, a := range attempts {
+		row := []string{strconv.Itoa(a.i), strconv.FormatInt(a.dial.Microseconds(), 10), a.dialErr, "", "", "", a.echo, a.verdict, a.end}
+		if a.wrote {
+			row[3], row[4] = strconv.FormatInt(a.write.Microseconds(), 10), a.writeErr
+		}
+		if a.echo == outcomeOK {
+			row[5] = strconv.FormatInt(a.queued.Microseconds(), 10)
+		}
+		cw.Write(row)
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func writeCSVFile(path string, attempts []attempt) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := writeCSV(f, attempts); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,95 @@
+package tcpqueue
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestClassify(t *testing.T) {
+	for _, tc := range []struct {
+		err  error
+		want string
+	}{
+		{nil, outcomeOK},
+		{&net.OpError{Op: "dial", Err: os.ErrDeadlineExceeded}, outcomeTimeout},
+		{context.DeadlineExceeded, outcomeTimeout},
+		{&net.OpError{Op: "dial", Err: os.NewSyscallError("connect", syscall.ECONNREFUSED)}, outcomeRefused},
+		{&net.OpError{Op: "write", Err: os.NewSyscallError("write", syscall.ECONNRESET)}, outcomeReset},
+		{&net.OpError{Op: "write", Err: os.NewSyscallError("write", syscall.EPIPE)}, outcomeReset},
+		{&net.OpError{Op: "dial", Err: os.NewSyscallError("connect", syscall.EAGAIN)}, outcomeFull},
+		{io.EOF, outcomeClosed},
+		{errors.New("something else"), outcomeOther},
+	} {
+		if got := classify(tc.err); got != tc.want {
+			t.Errorf("classify(%v) = %s, want %s", tc.err, got, tc.want)
+		}
+	}
+}
+
+func TestSummarize(t *testing.T) {
+	var attempts []attempt
+	for i := 1; i <= 20; i++ {
+		attempts = append(attempts, attempt{i: i, dial: time.Duration(i) * time.Millisecond, dialErr: outcomeOK, wrote: true, writeErr: outcomeOK})
+	}
+	attempts[3].writeErr = outcomeReset
+	attempts = append(attempts,
+		attempt{i: 21, dial: time.Second, dialErr: outcomeTimeout},
+		attempt{i: 22, dial: time.Millisecond, dialErr: outcomeRefused},
+	)
+
+	var out bytes.Buffer
+	summarize(&out, attempts)
+	want := "Attempts: 22\n" +
+		"Dials:  ok=20 timeout=1 refused=1\n" +
+		"Writes: ok=19 reset=1\n" +
+		"Connect latency: n=20 min=1ms mean=10.5ms p50=10.5ms p95=19.05ms p99=19.81ms max=20ms\n"
+	if out.String() != want {
+		t.Errorf("summary:\n%s\nwant:\n%s", out.String(), want)
+	}
+
+	out.Reset()
+	if err := writeCSV(&out, attempts[20:]); err != nil {
+		t.Fatal(err)
+	}
+	want = "attempt,dial_us,dial,write_us,write,queued_us,echo,payload,end\n" +
+		"21,1000000,timeout,,,,,,\n" +
+		"22,1000,refused,,,,,,\n"
+	if out.String() != want {
+		t.Errorf("csv:\n%s\nwant:\n%s", out.String(), want)
+	}
+}
+
+func TestSummarizeQueueing(t *testing.T) {
+	attempts := []attempt{
+		{i: 0, dialErr: outcomeTimeout},
+		{i: 1, wrote: true, writeErr: outcomeOK, echo: outcomeOK, queued: 3 * time.Second, verdict: verdictIntact, end: outcomeClosed},
+		{i: 2, wrote: true, writeErr: outcomeOK, echo: outcomeOK, queued: time.Second, verdict: verdictTruncated, end: outcomePending},
+		{i: 3, wrote: true, writeErr: outcomeOK, echo: outcomeReset, verdict: outcomeReset, end: outcomeReset},
+		{i: 4, wrote: true, writeErr: outcomeOK, echo: outcomePending, verdict: outcomePending, end: outcomePending},
+	}
+	var out bytes.Buffer
+	summarizeQueueing(&out, attempts)
+	want := "Echoes: ok=2 reset=1 pending=1\n" +
+		"Queueing delay: n=2 min=1s mean=2s p50=2s p95=2.9s p99=2.98s max=3s\n" +
+		"Payloads: intact=1 truncated=1 reset=1 pending=1\n" +
+		"Ends: reset=1 closed=1 pending=2\n"
+	if out.String() != want {
+		t.Errorf("summary:\n%s\nwant:\n%s", out.String(), want)
+	}
+
+	out.Reset()
+	if err := writeCSV(&out, attempts[1:2]); err != nil {
+		t.Fatal(err)
+	}
+	if want := "1,0,,0,ok,3000000,ok,intact,closed\n"; !strings.HasSuffix(out.String(), want) {
+		t.Errorf("csv:\n%s\nwant a row %q", out.String(), want)
+	}
+}
@@ -0,0 +1,473 @@
+// server listens on the 8888 TCP port
+
+package tcpqueue
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"slices"
+	"sync"
+	"syscall"
+	"time"
+
+	"goexp/internal/bench"
+)
+
+// drainIdle is how long a connection may stay quiet before the server
+// stops reading it and closes it.
+const drainIdle = time.Second
+
+// stopGrace is how long the server waits on exit for the connections it is
+// still reading before it reports without them.
+const stopGrace = 2 * drainIdle
+
+// server runs `tcpqueue server`. Without -accept it starts out not calling
+// Accept, so connections pile up in the accept queue until it is full.
+// SIGUSR1 or the -control-addr endpoints turn accepting on and off while it
+// runs. Ctrl+C stops it and prints a report of the run. It returns an
+// error if anything went wrong in the server itself; what happens to the
+// connections is the experiment, not a failure. -proto=udp reads a UDP
+// socket instead, and -proto=unix listens on the unix socket at -path.
+func server(args []string) error {
+	fs := flag.NewFlagSet("server", flag.ExitOnError)
+	proto := fs.String("proto", "tcp", "tcp, unix to listen on -path instead, or udp to bind a UDP socket and gate reading it instead of accepting")
+	addr := fs.String("addr", ":8888", "address to listen on")
+	path := fs.String("path", "/tmp/tcpqueue.sock", "with -proto=unix, the socket path; a stale socket left there is removed")
+	accept := fs.Bool("accept", false, "start accepting connections by itself, after -sleep")
+	sleep := fs.Duration("sleep", 100*time.Second, "with -accept, how long to leave connections queued before accepting the first")
+	backlog := fs.Int("backlog", 0, "accept queue size passed to listen(2), capped by net.core.somaxconn (0 = Go's default, somaxconn itself)")
+	controlAddr := fs.String("control-addr", "", "serve POST /accept/start and /accept/stop on this address")
+	deferAccept := fs.Int("defer-accept", 0, "TCP_DEFER_ACCEPT seconds: hold connections back from the accept queue until data arrives (0 = off)")
+	reuseaddr := fs.Bool("reuseaddr", false, "set SO_REUSEADDR on the listener (Go already does on most systems)")
+	reuseport := fs.Bool("reuseport", false, "set SO_REUSEPORT on the listener, so several servers can share -addr")
+	sample := fs.Duration("sample", time.Second, "how often to log the accept queue depth, Linux only (0 = never)")
+	acceptRate := fs.Float64("accept-rate", 0, "while accepting, connections accepted a second at most (0 = as fast as possible)")
+	hold := fs.Duration("hold", 0, "close each accepted connection after this long (0 = once the client closes it or goes quiet for a second)")
+	holdRead := fs.Bool("hold-read", true, "with -hold, read the connection while holding it; false leaves what the client sends unread")
+	postAccept := fs.String("post-accept", postAcceptDrain, "what to do with an accepted connection: drain it, close it, rst it (SO_LINGER 0), or read-then-close its payload")
+	fs.Parse(args)
+	if *acceptRate < 0 || *hold < 0 {
+		return fmt.Errorf("-accept-rate and -hold cannot be negative")
+	}
+	if !slices.Contains(postAccepts, *postAccept) {
+		return fmt.Errorf("unknown -post-accept %q", *postAccept)
+	}
+	if *hold > 0 && *postAccept != postAcceptDrain {
+		return fmt.Errorf("-hold only applies with -post-accept=%s", postAcceptDrain)
+	}
+	policy := acceptPolicy{rate: *acceptRate, hold: *hold, holdRead: *holdRead, then: *postAccept}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	opts := serverSockopts(*deferAccept, *reuseaddr, *reuseport)
+	stats := &serverStats{udp: *proto == "udp", unix: *proto == "unix"}
+	var (
+		sock    io.Closer
+		gate    *acceptGate
+		loop    func() error
+		sampler func(context.Context) error
+	)
+	switch *proto {
+	case "tcp":
+		l, effective, err := listen("tcp", *addr, *backlog, opts)
+		if err != nil {
+			return err
+		}
+		sock, gate = l, newAcceptGate(l.(deadliner))
+		loop = func() error { return acceptLoop(l, gate, policy, stats) }
+		sampler = func(ctx context.Context) error { return sampleAcceptQueue(ctx, l, effective, *sample, stats) }
+	case "unix":
+		if err := removeStaleSocket(*path); err != nil {
+			return err
+		}
+		l, effective, err := listen("unix", *path, *backlog, opts)
+		if err != nil {
+			return err
+		}
+		// Go unlinks the path when it closes a listener it created, so a
+		// clean shutdown leaves nothing behind.
+		l.(*net.UnixListener).SetUnlinkOnClose(true)
+		*addr = *path
+		sock, gate = l, newAcceptGate(l.(deadliner))
+		loop = func() error { return acceptLoop(l, gate, policy, stats) }
+		sampler = func(ctx context.Context) error { return sampleAccepts(ctx, effective, *sample, stats) }
+	case "udp":
+		if *backlog > 0 || policy != (acceptPolicy{holdRead: true, then: postAcceptDrain}) {
+			log.Printf("warning: -backlog, -accept-rate, -hold and -post-accept do not apply to udp")
+		}
+		pc, err := listenUDP(*addr, opts)
+		if err != nil {
+			return err
+		}
+		sock, gate = pc, newAcceptGate(readDeadliner{pc})
+		gate.what = "reading"
+		loop = func() error { return readLoop(pc, gate, stats) }
+		sampler = func(ctx context.Context) error { return sampleReceiveQueue(ctx, pc, *sample, stats) }
+	default:
+		return fmt.Errorf("unknown -proto %q", *proto)
+	}
+	defer sock.Close()
+	log.Printf("listen %s success", *addr)
+
+	// Internal errors from the goroutines below, for the exit status.
+	errc := make(chan error, 2)
+	sampled := make(chan struct{})
+	go func() {
+		defer close(sampled)
+		if *sample <= 0 {
+			return
+		}
+		if err := sampler(ctx); err != nil {
+			log.Print(err)
+			errc <- err
+		}
+	}()
+
+	startHint := toggleOnSignal(gate)
+	if *controlAddr != "" {
+		go func() {
+			log.Printf("control endpoint on %s: POST /accept/start, /accept/stop", *controlAddr)
+			if err := http.ListenAndServe(*controlAddr, gate); err != nil {
+				log.Printf("control endpoint: %v", err)
+				errc <- fmt.Errorf("control endpoint: %w", err)
+			}
+		}()
+	}
+	if *accept {
+		log.Printf("%s in %s", gate.what, *sleep)
+		time.AfterFunc(*sleep, func() { gate.set(true) })
+	} else {
+		log.Printf("not %s; %s to start", gate.what, startHint)
+	}
+
+	go func() {
+		<-ctx.Done()
+		<-sampled
+		gate.shutDown()
+		sock.Close()
+	}()
+	err := loop()
+	if ctx.Err() != nil {
+		err = nil // closed to stop
+	}
+	cancel()
+	<-sampled
+	if !stats.waitDrains(stopGrace) {
+		log.Printf("still reading connections after %s, reporting without them", stopGrace)
+	}
+	stats.summarize(os.Stdout)
+
+	errs := []error{err}
+	for len(errc) > 0 {
+		errs = append(errs, <-errc)
+	}
+	return errors.Join(errs...)
+}
+
+// What the server does with a connection once it has accepted it.
+const (
+	postAcceptDrain         = "drain"           // see drain
+	postAcceptClose         = "close"           // close it unread
+	postAcceptRST           = "rst"             // reset it unread
+	postAcceptReadThenClose = "read-then-close" // drain it up to the payload's verdict, then close it
+)
+
+var postAccepts = []string{postAcceptDrain, postAcceptClose, postAcceptRST, postAcceptReadThenClose}
+
+// acceptPolicy is how the server treats connections once accepting is on:
+// how fast it takes them off the queue, and what it does with each.
+type acceptPolicy struct {
+	rate     float64       // accepts a second at most, 0 for no limit
+	hold     time.Duration // close each connection after this long, 0 to read it until it ends
+	holdRead bool          // read the connection during hold
+	then     string        // one of postAccepts
+}
+
+// acceptLoop accepts connections from l whenever gate is on, at p.rate at
+// most, and drains each in its own goroutine, recording what it saw in
+// stats. It returns when l fails, e.g. because it was closed, or when gate
+// is shut down.
+func acceptLoop(l net.Listener, gate *acceptGate, p acceptPolicy, stats *serverStats) error {
+	// A ticker rather than a sleep after each Accept, so the time Accept
+	// takes does not slow the rate down. A tick missed while the gate was
+	// off is not made up for with a burst.
+	var tick <-chan time.Time
+	if p.rate > 0 {
+		t := time.NewTicker(time.Duration(float64(time.Second) / p.rate))
+		defer t.Stop()
+		tick = t.C
+	}
+	for {
+		if !gate.waitOn() {
+			return net.ErrClosed
+		}
+		if tick != nil {
+			_, changed := gate.state()
+			select {
+			case <-tick:
+			case <-changed:
+				continue
+			}
+		}
+		conn, err := l.Accept()
+		if err != nil {
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Timeout() {
+				continue // the gate closed while Accept was waiting
+			}
+			return err
+		}
+		who := peerName(conn, stats.accepted())
+		stats.drains.Add(1)
+		go func() {
+			defer stats.drains.Done()
+			switch p.then {
+			case postAcceptClose, postAcceptRST:
+				dropConn(conn, who, p.then == postAcceptRST)
+			default:
+				drain(conn, who, p, stats)
+			}
+		}()
+	}
+}
+
+// drain reads the connect timestamp the client sends first, echoes back
+// how long the connection sat in the accept queue, verifies the payload
+// that follows and sends back the verdict. Then it reads whatever else the
+// client sends, until it closes the connection or goes quiet for drainIdle.
+// With p.hold it closes the connection once that long has passed since it
+// was accepted instead, and without p.holdRead it reads nothing after the
+// timestamp. With -post-accept=read-then-close it closes the connection
+// as soon as it has sent the verdict. It logs how long the connection
+// waited, how much arrived and whether the payload survived, under the
+// name who.
+func drain(conn net.Conn, who string, p acceptPolicy, stats *serverStats) {
+	defer conn.Close()
+	accepted := time.Now()
+	var r io.Reader = idleReader{conn, drainIdle}
+	held := ""
+	if p.hold > 0 {
+		conn.SetReadDeadline(accepted.Add(p.hold))
+		r, held = conn, ", held "+p.hold.String()
+	}
+
+	var ts [8]byte
+	if got, err := io.ReadFull(r, ts[:]); err != nil {
+		if classify(err) == outcomeReset {
+			log.Printf("%s: reset before sending its connect timestamp", who)
+		} else {
+			log.Printf("%s: short connect timestamp, %d of %d bytes: %v", who, got, len(ts), err)
+		}
+		stats.received(int64(got))
+		stats.noTimestamp(classify(err) == outcomeReset)
+		return
+	}
+	connected := time.Unix(0, int64(binary.BigEndian.Uint64(ts[:])))
+	waited := accepted.Sub(connected)
+	stats.queued(waited)
+
+	conn.SetWriteDeadline(time.Now().Add(drainIdle))
+	if _, err := conn.Write(binary.BigEndian.AppendUint64(nil, uint64(waited))); err != nil {
+		log.Printf("%s: cannot echo the queueing delay: %v", who, err)
+	}
+	stats.received(int64(len(ts)))
+	if p.hold > 0 && !p.holdRead {
+		// Closing with the payload still unread makes the kernel reset
+		// the connection rather than close it.
+		time.Sleep(time.Until(accepted.Add(p.hold)))
+		log.Printf("%s: waited %s in the queue, held %s without reading", who, waited, p.hold)
+		return
+	}
+
+	m, verdict, why := verifyPayload(r)
+	stats.verified(verdict)
+	if _, err := conn.Write([]byte{byte(slices.Index(verdicts, verdict))}); err != nil && why == nil {
+		log.Printf("%s: cannot send the verdict: %v", who, err)
+	}
+	if why != nil {
+		verdict += " (" + why.Error() + ")"
+	}
+	if p.then == postAcceptReadThenClose {
+		stats.received(m)
+		log.Printf("%s: waited %s in the queue, %d bytes, payload %s, closed after reading it", who, waited, int64(len(ts))+m, verdict)
+		return
+	}
+
+	rest, err := io.Copy(io.Discard, r)
+	stats.received(m + rest)
+	n := int64(len(ts)) + m + rest
+	var ne net.Error
+	switch {
+	case err == nil:
+		log.Printf("%s: waited %s in the queue, %d bytes, payload %s, closed by the client", who, waited, n, verdict)
+	case errors.As(err, &ne) && ne.Timeout():
+		log.Printf("%s: waited %s in the queue, %d bytes, payload %s%s", who, waited, n, verdict, held)
+	default:
+		log.Printf("%s: waited %s in the queue, payload %s, read error after %d bytes: %v", who, waited, verdict, n, err)
+	}
+}
+
+// dropConn closes a connection the server has just accepted without
+// reading what the client sent. Linux resets such a connection rather than
+// closing it whenever data is left unread, as the client's connect
+// timestamp always is; with rst SO_LINGER 0 resets it regardless.
+func dropConn(conn net.Conn, who string, rst bool) {
+	if !rst {
+		conn.Close()
+		log.Printf("%s: closed without reading", who)
+		return
+	}
+	if l, ok := conn.(interface{ SetLinger(sec int) error }); !ok {
+		log.Printf("%s: warning: cannot set SO_LINGER on a %s connection, closing it instead", who, conn.LocalAddr().Network())
+	} else if err := l.SetLinger(0); err != nil {
+		log.Printf("%s: warning: cannot set SO_LINGER to 0: %v", who, err)
+	}
+	conn.Close()
+	log.Printf("%s: reset without reading", who)
+}
+
+// peerName is how the logs refer to conn, the n'th connection accepted:
+// its remote address, or its number where the client is an unnamed unix
+// socket.
+func peerName(conn net.Conn, n int) string {
+	if a := conn.RemoteAddr(); a != nil && a.String() != "" && a.String() != "@" {
+		return a.String()
+	}
+	return fmt.Sprintf("#%d", n)
+}
+
+// serverStats adds up what happened to the connections the server
+// accepted: how long they were queued, how much they sent and whether their
+// payloads survived, and how many were left in the queue.
+type serverStats struct {
+	udp    bool           // counting datagrams rather than connections
+	unix   bool           // on a unix socket, whose queue cannot be sampled
+	drains sync.WaitGroup // connections still being read
+
+	mu       sync.Mutex
+	accepts  int
+	bytes    int64
+	waited   []time.Duration
+	short    int // closed or timed out before the whole timestamp arrived
+	reset    int // reset before the timestamp arrived
+	verdicts map[string]int
+	queue    int // accept queue depth, or bytes with udp, at the last sample
+	hasQueue bool
+	drops    int // datagrams the kernel dropped, with udp
+}
+
+// accepted counts a connection accepted or, with -proto=udp, a datagram
+// read, and returns the count so far.
+func (s *serverStats) accepted() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.accepts++
+	return s.accepts
+}
+
+func (s *serverStats) acceptCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.accepts
+}
+
+// waitDrains waits up to d for the connections being read, and reports
+// whether they all finished.
+func (s *serverStats) waitDrains(d time.Duration) bool {
+	done := make(chan struct{})
+	go func() {
+		s.drains.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-time.After(d):
+		return false
+	}
+}
+
+func (s *serverStats) received(n int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.bytes += n
+}
+
+func (s *serverStats) queued(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.waited = append(s.waited, d)
+}
+
+func (s *serverStats) noTimestamp(reset bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if reset {
+		s.reset++
+	} else {
+		s.short++
+	}
+}
+
+func (s *serverStats) verified(verdict string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.verdicts == nil {
+		s.verdicts = map[string]int{}
+	}
+	s.verdicts[verdict]++
+}
+
+func (s *serverStats) sampled(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.queue, s.hasQueue = n, true
+}
+
+func (s *serverStats) dropped(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.drops = n
+}
+
+// summarize prints the report: how many connections were accepted and how
+// many were still queued, how much they sent, the distribution of their
+// queueing delays and what became of their payloads, in the client's
+// format. With udp it counts datagrams read, bytes still queued and
+// datagrams dropped instead, and with unix it says the queue is unknown.
+func (s *serverStats) summarize(w io.Writer) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.udp {
+		fmt.Fprintf(w, "Read: %d datagrams\n", s.accepts)
+	} else {
+		fmt.Fprintf(w, "Accepted: %d\n", s.accepts)
+	}
+	switch {
+	case s.unix:
+		fmt.Fprintf(w, "Still queued: unknown, the kernel does not expose a unix socket's accept queue\n")
+	case !s.hasQueue:
+		fmt.Fprintf(w, "Still queued: unknown, not sampled\n")
+	case s.udp:
+		fmt.Fprintf(w, "Still queued: %d bytes\n", s.queue)
+		fmt.Fprintf(w, "Dropped: %d\n", s.drops)
+	default:
+		fmt.Fprintf(w, "Still queued: %d\n", s.queue)
+	}
+	fmt.Fprintf(w, "Bytes received: %d\n", s.bytes)
+	fmt.Fprintf(w, "Timestamps: ok=%d short=%d reset=%d\n", len(s.waited), s.short, s.reset)
+	fmt.Fprintf(w, "Queueing delay: %s\n", bench.SummarizeDurations(s.waited).Format(bench.Durations))
+	fmt.Fprintf(w, "Payloads: %s\n", formatCounts(s.verdicts, verdicts))
+}
+
+// sudo sysctl -w net.core.somaxconn=5
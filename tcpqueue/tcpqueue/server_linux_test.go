@@ -0,0 +1,61 @@
+package tcpqueue
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestPostAccept(t *testing.T) {
+	for _, tc := range []struct {
+		then               string
+		echo, verdict, end string
+	}{
+		// Linux resets a connection closed with data unread, so close
+		// looks the same to the client as rst.
+		{postAcceptClose, outcomeReset, outcomeReset, outcomeReset},
+		{postAcceptRST, outcomeReset, outcomeReset, outcomeReset},
+		{postAcceptReadThenClose, outcomeOK, verdictIntact, outcomeClosed},
+	} {
+		t.Run(tc.then, func(t *testing.T) {
+			captureLog(t)
+			ln, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer ln.Close()
+			gate := newAcceptGate(ln.(deadliner))
+			go acceptLoop(ln, gate, acceptPolicy{holdRead: true, then: tc.then}, &serverStats{})
+
+			// The client has written its timestamp and payload by the
+			// time the server accepts.
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			d := newDialer("tcp", time.Second, nil)
+			attempts := make([]attempt, 3)
+			var conns []net.Conn
+			for i := range attempts {
+				attempts[i].i = i
+				conn := establishConn(ctx, d, ln.Addr().String(), appendPayload(nil, 100, uint64(i)), &attempts[i])
+				if conn == nil {
+					t.Fatalf("attempt %d: dial %s, write %s", i, attempts[i].dialErr, attempts[i].writeErr)
+				}
+				defer conn.Close()
+				conns = append(conns, conn)
+			}
+			time.Sleep(50 * time.Millisecond)
+			gate.set(true)
+
+			for i, conn := range conns {
+				a := &attempts[i]
+				if awaitEcho(ctx, conn, a) {
+					awaitEnd(ctx, conn, a)
+				}
+				if a.echo != tc.echo || a.verdict != tc.verdict || a.end != tc.end {
+					t.Errorf("attempt %d: echo=%s payload=%s end=%s, want %s %s %s", i, a.echo, a.verdict, a.end, tc.echo, tc.verdict, tc.end)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,9 @@
+//go:build !unix
+
+package tcpqueue
+
+// toggleOnSignal does nothing: there is no SIGUSR1 here, which leaves
+// -control-addr to turn gate on and off.
+func toggleOnSignal(*acceptGate) string {
+	return "POST /accept/start to -control-addr"
+}
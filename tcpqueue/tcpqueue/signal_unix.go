@@ -0,0 +1,22 @@
+//go:build unix
+
+package tcpqueue
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// toggleOnSignal flips gate on every SIGUSR1 and returns how to start it.
+func toggleOnSignal(gate *acceptGate) string {
+	usr1 := make(chan os.Signal, 1)
+	signal.Notify(usr1, syscall.SIGUSR1)
+	go func() {
+		for range usr1 {
+			gate.toggle()
+		}
+	}()
+	return fmt.Sprintf("send SIGUSR1 to pid %d", os.Getpid())
+}
@@ -0,0 +1,98 @@
+package tcpqueue
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"syscall"
+)
+
+// sockopt is a socket option one of the flags asks for, named as in
+// setsockopt(2). setSockopt knows the platform's level and number for it.
+type sockopt struct {
+	name  string
+	value int
+	// Set once the socket is connected rather than from a Control callback,
+	// because Go itself sets the option while connecting.
+	afterConnect bool
+}
+
+// serverSockopts maps the server's flags to the options set on its
+// listener before it listens.
+func serverSockopts(deferAccept int, reuseaddr, reuseport bool) []sockopt {
+	var opts []sockopt
+	if deferAccept > 0 {
+		opts = append(opts, sockopt{name: "TCP_DEFER_ACCEPT", value: deferAccept})
+	}
+	if reuseaddr {
+		opts = append(opts, sockopt{name: "SO_REUSEADDR", value: 1})
+	}
+	if reuseport {
+		opts = append(opts, sockopt{name: "SO_REUSEPORT", value: 1})
+	}
+	return opts
+}
+
+// clientSockopts maps the client's flags to the options set on each of its
+// connections. SO_LINGER's value is the linger time, so 0 makes Close send
+// an RST.
+func clientSockopts(synRetries int, nodelay, linger0 bool) []sockopt {
+	var opts []sockopt
+	if synRetries > 0 {
+		opts = append(opts, sockopt{name: "TCP_SYNCNT", value: synRetries})
+	}
+	opts = append(opts, sockopt{name: "TCP_NODELAY", value: boolInt(nodelay), afterConnect: true})
+	if linger0 {
+		opts = append(opts, sockopt{name: "SO_LINGER", value: 0})
+	}
+	return opts
+}
+
+func boolInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// controlSockopts returns a Control function for net.ListenConfig or
+// net.Dialer that sets opts on the socket before it listens or connects.
+func controlSockopts(opts []sockopt, lg *onceLog) func(network, address string, c syscall.RawConn) error {
+	return func(_, _ string, c syscall.RawConn) error {
+		setSockopts(c, opts, false, lg)
+		return nil
+	}
+}
+
+// setSockopts sets those opts that belong before or after connecting on c
+// and logs to lg the value each ended up with, as getsockopt(2) reads it
+// back. An option the platform does not support only earns a warning.
+func setSockopts(c syscall.RawConn, opts []sockopt, afterConnect bool, lg *onceLog) {
+	for _, o := range opts {
+		if o.afterConnect != afterConnect {
+			continue
+		}
+		var got int
+		var err error
+		if cerr := c.Control(func(fd uintptr) { got, err = setSockopt(fd, o) }); cerr != nil {
+			err = cerr
+		}
+		if err != nil {
+			lg.printf("warning: cannot set %s=%d: %v", o.name, o.value, err)
+			continue
+		}
+		lg.printf("sockopt %s=%d (asked for %d)", o.name, got, o.value)
+	}
+}
+
+// onceLog logs each distinct line once, however many sockets repeat it.
+type onceLog struct {
+	seen sync.Map
+}
+
+func (l *onceLog) printf(format string, v ...any) {
+	s := fmt.Sprintf(format, v...)
+	if _, dup := l.seen.LoadOrStore(s, true); !dup {
+		log.Print(s)
+	}
+}
@@ -0,0 +1,67 @@
+// The raw getsockopt call and SO_REUSEPORT's number below hold on these
+// two; elsewhere every option is a warning, as on other systems.
+
+//go:build linux && (amd64 || arm64)
+
+package tcpqueue
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// soReusePort is SO_REUSEPORT, which the syscall package lacks.
+const soReusePort = 0xf
+
+var sockoptNums = map[string]struct{ level, opt int }{
+	"SO_REUSEADDR":     {syscall.SOL_SOCKET, syscall.SO_REUSEADDR},
+	"SO_REUSEPORT":     {syscall.SOL_SOCKET, soReusePort},
+	"SO_LINGER":        {syscall.SOL_SOCKET, syscall.SO_LINGER},
+	"TCP_DEFER_ACCEPT": {syscall.IPPROTO_TCP, syscall.TCP_DEFER_ACCEPT},
+	"TCP_SYNCNT":       {syscall.IPPROTO_TCP, syscall.TCP_SYNCNT},
+	"TCP_NODELAY":      {syscall.IPPROTO_TCP, syscall.TCP_NODELAY},
+}
+
+// setSockopt sets o on fd and reads it back. The kernel may round the
+// value, e.g. TCP_DEFER_ACCEPT to a whole number of SYN-ACK retransmits.
+// SO_LINGER reads back as its linger time, or -1 if lingering is off.
+func setSockopt(fd uintptr, o sockopt) (int, error) {
+	num, ok := sockoptNums[o.name]
+	if !ok {
+		return 0, fmt.Errorf("%s: %w", o.name, errors.ErrUnsupported)
+	}
+	if o.name == "SO_LINGER" {
+		l := syscall.Linger{Onoff: 1, Linger: int32(o.value)}
+		if err := syscall.SetsockoptLinger(int(fd), num.level, num.opt, &l); err != nil {
+			return 0, os.NewSyscallError("setsockopt", err)
+		}
+		l, err := getsockoptLinger(fd)
+		if err != nil {
+			return 0, err
+		}
+		if l.Onoff == 0 {
+			return -1, nil
+		}
+		return int(l.Linger), nil
+	}
+	if err := syscall.SetsockoptInt(int(fd), num.level, num.opt, o.value); err != nil {
+		return 0, os.NewSyscallError("setsockopt", err)
+	}
+	v, err := syscall.GetsockoptInt(int(fd), num.level, num.opt)
+	return v, os.NewSyscallError("getsockopt", err)
+}
+
+// getsockoptLinger reads SO_LINGER, which the syscall package can only set.
+func getsockoptLinger(fd uintptr) (syscall.Linger, error) {
+	var l syscall.Linger
+	n := uint32(unsafe.Sizeof(l))
+	_, _, errno := syscall.Syscall6(syscall.SYS_GETSOCKOPT, fd, syscall.SOL_SOCKET, syscall.SO_LINGER,
+		uintptr(unsafe.Pointer(&l)), uintptr(unsafe.Pointer(&n)), 0)
+	if errno != 0 {
+		return l, os.NewSyscallError("getsockopt", errno)
+	}
+	return l, nil
+}
@@ -0,0 +1,107 @@
+//go:build linux && (amd64 || arm64)
+
+package tcpqueue
+
+import (
+	"context"
+	"net"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// getsockopt reads an int option from conn's socket.
+func getsockopt(t *testing.T, conn syscall.Conn, level, opt int) int {
+	t.Helper()
+	rc, err := conn.SyscallConn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var v int
+	var gerr error
+	rc.Control(func(fd uintptr) { v, gerr = syscall.GetsockoptInt(int(fd), level, opt) })
+	if gerr != nil {
+		t.Fatal(gerr)
+	}
+	return v
+}
+
+func TestSockoptsApplied(t *testing.T) {
+	logs := captureLog(t)
+	l, _, err := listen("tcp", "127.0.0.1:0", 0, serverSockopts(5, true, true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	if v := getsockopt(t, l.(syscall.Conn), syscall.SOL_SOCKET, soReusePort); v != 1 {
+		t.Errorf("SO_REUSEPORT = %d, want 1", v)
+	}
+	// The kernel keeps TCP_DEFER_ACCEPT as SYN-ACK retransmits and reads it
+	// back as the timeout they add up to, which need not be what was asked.
+	deferAccept := getsockopt(t, l.(syscall.Conn), syscall.IPPROTO_TCP, syscall.TCP_DEFER_ACCEPT)
+	if deferAccept < 5 {
+		t.Errorf("TCP_DEFER_ACCEPT = %d, want at least 5", deferAccept)
+	}
+	waitForLog(t, logs, "sockopt SO_REUSEADDR=1 (asked for 1)")
+	waitForLog(t, logs, "sockopt SO_REUSEPORT=1 (asked for 1)")
+	waitForLog(t, logs, "sockopt TCP_DEFER_ACCEPT=")
+
+	d := newDialer("tcp", time.Second, clientSockopts(3, false, true))
+	conn, err := d.dial(context.Background(), l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	sc := conn.(syscall.Conn)
+	if v := getsockopt(t, sc, syscall.IPPROTO_TCP, syscall.TCP_SYNCNT); v != 3 {
+		t.Errorf("TCP_SYNCNT = %d, want 3", v)
+	}
+	if v := getsockopt(t, sc, syscall.IPPROTO_TCP, syscall.TCP_NODELAY); v != 0 {
+		t.Errorf("TCP_NODELAY = %d, want 0 after Go's own setting", v)
+	}
+	if v := getsockopt(t, sc, syscall.SOL_SOCKET, syscall.SO_LINGER); v != 1 {
+		t.Errorf("SO_LINGER is off")
+	}
+	waitForLog(t, logs, "sockopt TCP_SYNCNT=3 (asked for 3)")
+	waitForLog(t, logs, "sockopt TCP_NODELAY=0 (asked for 0)")
+	waitForLog(t, logs, "sockopt SO_LINGER=0 (asked for 0)")
+}
+
+func TestSockoptUnsupportedWarns(t *testing.T) {
+	logs := captureLog(t)
+	l, _, err := listen("tcp", "127.0.0.1:0", 0, []sockopt{{name: "SO_BOGUS", value: 1}, {name: "SO_REUSEADDR", value: 1}})
+	if err != nil {
+		t.Fatalf("an unsupported option failed the listen: %v", err)
+	}
+	defer l.Close()
+	line := waitForLog(t, logs, "warning: cannot set SO_BOGUS=1")
+	if !strings.Contains(line, "unsupported") {
+		t.Errorf("warning %q does not say the option is unsupported", line)
+	}
+	waitForLog(t, logs, "sockopt SO_REUSEADDR=1")
+}
+
+func TestLinger0SendsRST(t *testing.T) {
+	captureLog(t)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	conn, err := newDialer("tcp", time.Second, clientSockopts(0, true, true)).dial(context.Background(), l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	conn.Close()
+
+	server.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := server.Read(make([]byte, 1)); classify(err) != outcomeReset {
+		t.Errorf("read after a linger0 close = %v, want a reset", err)
+	}
+}
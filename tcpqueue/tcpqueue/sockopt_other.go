@@ -0,0 +1,12 @@
+//go:build !linux || !(amd64 || arm64)
+
+package tcpqueue
+
+import (
+	"errors"
+	"fmt"
+)
+
+func setSockopt(_ uintptr, o sockopt) (int, error) {
+	return 0, fmt.Errorf("%s: %w", o.name, errors.ErrUnsupported)
+}
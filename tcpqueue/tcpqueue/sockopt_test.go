@@ -0,0 +1,42 @@
+package tcpqueue
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestServerSockopts(t *testing.T) {
+	for _, tc := range []struct {
+		deferAccept          int
+		reuseaddr, reuseport bool
+		want                 []sockopt
+	}{
+		{0, false, false, nil},
+		{5, false, false, []sockopt{{name: "TCP_DEFER_ACCEPT", value: 5}}},
+		{-1, true, true, []sockopt{{name: "SO_REUSEADDR", value: 1}, {name: "SO_REUSEPORT", value: 1}}},
+	} {
+		if got := serverSockopts(tc.deferAccept, tc.reuseaddr, tc.reuseport); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("serverSockopts(%d, %t, %t) = %+v, want %+v", tc.deferAccept, tc.reuseaddr, tc.reuseport, got, tc.want)
+		}
+	}
+}
+
+func TestClientSockopts(t *testing.T) {
+	for _, tc := range []struct {
+		synRetries       int
+		nodelay, linger0 bool
+		want             []sockopt
+	}{
+		{0, true, false, []sockopt{{name: "TCP_NODELAY", value: 1, afterConnect: true}}},
+		{0, false, false, []sockopt{{name: "TCP_NODELAY", value: 0, afterConnect: true}}},
+		{2, true, true, []sockopt{
+			{name: "TCP_SYNCNT", value: 2},
+			{name: "TCP_NODELAY", value: 1, afterConnect: true},
+			{name: "SO_LINGER", value: 0},
+		}},
+	} {
+		if got := clientSockopts(tc.synRetries, tc.nodelay, tc.linger0); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("clientSockopts(%d, %t, %t) = %+v, want %+v", tc.synRetries, tc.nodelay, tc.linger0, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,35 @@
+// Package tcpqueue is an experiment for watching the TCP accept queue fill
+// up. `tcpqueue server` listens and, unless told to, never accepts;
+// `tcpqueue client` opens connections to it in parallel and sends some
+// data on each.
+package tcpqueue
+
+import (
+	"errors"
+	"fmt"
+	"os"
+)
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: tcpqueue server [flags]\n       tcpqueue client [flags]\nRun either with -h for its flags.\n")
+}
+
+// Run runs the server or the client, as the first of args says, with the
+// rest of them.
+func Run(args []string) error {
+	if len(args) == 0 {
+		usage()
+		return errors.New("no command given")
+	}
+	switch args[0] {
+	case "server":
+		return server(args[1:])
+	case "client":
+		return client(args[1:])
+	case "-h", "-help", "--help":
+		usage()
+		return nil
+	}
+	usage()
+	return fmt.Errorf("unknown command %q", args[0])
+}
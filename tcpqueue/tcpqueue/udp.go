@@ -0,0 +1,202 @@
+package tcpqueue
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"slices"
+	"time"
+)
+
+// maxDatagram is the most a UDP datagram can carry over IPv4.
+const maxDatagram = 65507
+
+// A -proto=udp datagram is what a TCP connection sends, in one piece: the
+// send time (8 bytes, big-endian Unix nanoseconds) and a payload. The server
+// answers each datagram it reads with an ack: how long it waited (8 bytes,
+// big-endian nanoseconds), the payload verdict (1 byte) and the seed from
+// the payload header (8 bytes), which tells the client which datagram it
+// was.
+const ackSize = 8 + 1 + 8
+
+// listenUDP binds a UDP socket on addr with opts set on it.
+func listenUDP(addr string, opts []sockopt) (net.PacketConn, error) {
+	lc := net.ListenConfig{Control: controlSockopts(opts, &onceLog{})}
+	return lc.ListenPacket(context.Background(), "udp", addr)
+}
+
+// readDeadliner lets the gate interrupt a blocked read without also timing
+// out the acks.
+type readDeadliner struct {
+	net.PacketConn
+}
+
+func (r readDeadliner) SetDeadline(t time.Time) error {
+	return r.SetReadDeadline(t)
+}
+
+// readLoop is acceptLoop for -proto=udp: it reads datagrams from pc
+// whenever gate is on and acks each one. It returns when pc fails, e.g.
+// because it was closed, or when gate is shut down.
+func readLoop(pc net.PacketConn, gate *acceptGate, stats *serverStats) error {
+	buf := make([]byte, maxDatagram)
+	for {
+		if !gate.waitOn() {
+			return net.ErrClosed
+		}
+		n, from, err := pc.ReadFrom(buf)
+		if err != nil {
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Timeout() {
+				continue // the gate closed while ReadFrom was waiting
+			}
+			return err
+		}
+		stats.accepted()
+		readDatagram(pc, buf[:n], from, time.Now(), stats)
+	}
+}
+
+// readDatagram checks a datagram read at the given time, acks it and logs
+// how long it waited in the receive buffer and whether its payload is
+// intact.
+func readDatagram(pc net.PacketConn, b []byte, from net.Addr, read time.Time, stats *serverStats) {
+	stats.received(int64(len(b)))
+	if len(b) < 8 {
+		log.Printf("%s: short datagram, %d bytes", from, len(b))
+		stats.noTimestamp(false)
+		return
+	}
+	waited := read.Sub(time.Unix(0, int64(binary.BigEndian.Uint64(b))))
+	stats.queued(waited)
+	_, verdict, why := verifyPayload(bytes.NewReader(b[8:]))
+	stats.verified(verdict)
+
+	var seed uint64
+	if len(b) >= 8+payloadHeader {
+		seed = binary.BigEndian.Uint64(b[8+4:])
+	}
+	ack := binary.BigEndian.AppendUint64(make([]byte, 0, ackSize), uint64(waited))
+	ack = append(ack, byte(slices.Index(verdicts, verdict)))
+	ack = binary.BigEndian.AppendUint64(ack, seed)
+	if _, err := pc.WriteTo(ack, from); err != nil {
+		log.Printf("%s: cannot ack: %v", from, err)
+	}
+	if why != nil {
+		verdict += " (" + why.Error() + ")"
+	}
+	log.Printf("%s: datagram waited %s in the receive buffer, %d bytes, payload %s", from, waited, len(b), verdict)
+}
+
+// udpClient runs `tcpqueue client -proto=udp`: it sends n datagrams at
+// rate a second, each with a -payload-size pattern, and prints how the
+// writes went. Until Ctrl+C it collects the server's acks, then prints how
+// many datagrams the server read, how long they waited and whether their
+// payloads arrived intact, in the same format as the TCP client.
+func udpClient(ctx context.Context, addr string, n int, rate float64, payloadSize int, seed uint64, csvPath string) error {
+	if 8+payloadHeader+payloadSize > maxDatagram {
+		return fmt.Errorf("-payload-size %d does not fit in a datagram", payloadSize)
+	}
+	if rate <= 0 {
+		return fmt.Errorf("-rate must be above 0")
+	}
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	attempts := make([]attempt, n)
+	acked := make(chan struct{})
+	go func() {
+		defer close(acked)
+		readAcks(conn, attempts, seed)
+	}()
+
+	tick := time.NewTicker(time.Duration(float64(time.Second) / rate))
+	defer tick.Stop()
+	for i := range attempts {
+		a := &attempts[i]
+		a.i = i
+		if ctx.Err() != nil {
+			a.dialErr = outcomeCanceled
+			continue
+		}
+		a.dialErr = outcomeOK
+		sendDatagram(conn, payloadSize, seed+uint64(i), a)
+		if i < n-1 {
+			select {
+			case <-ctx.Done():
+			case <-tick.C:
+			}
+		}
+	}
+	summarizeDatagrams(os.Stdout, attempts)
+
+	<-ctx.Done()
+	conn.Close()
+	<-acked
+	for i := range attempts {
+		if a := &attempts[i]; a.wrote && a.writeErr == outcomeOK && a.echo == "" {
+			a.echo, a.verdict = outcomePending, outcomePending
+		}
+	}
+	summarizeQueueing(os.Stdout, attempts)
+	if csvPath != "" {
+		if err := writeCSVFile(csvPath, attempts); err != nil {
+			return err
+		}
+	}
+	log.Printf("client exit")
+	return nil
+}
+
+// sendDatagram writes one datagram with the send time and a payload of
+// size bytes for seed, and records the write in a.
+func sendDatagram(conn net.Conn, size int, seed uint64, a *attempt) {
+	msg := binary.BigEndian.AppendUint64(make([]byte, 0, 8+payloadHeader+size), uint64(time.Now().UnixNano()))
+	msg = appendPayload(msg, size, seed)
+	start := time.Now()
+	_, err := conn.Write(msg)
+	a.write, a.writeErr, a.wrote = time.Since(start), classify(err), true
+	if err != nil {
+		log.Printf("%d, send error: %v", a.i, err)
+	}
+}
+
+// readAcks records the server's acks in attempts, the first of which was
+// sent with seed, until conn is closed.
+func readAcks(conn net.Conn, attempts []attempt, seed uint64) {
+	buf := make([]byte, ackSize)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			if classify(err) == outcomeRefused {
+				continue // an ICMP error from an earlier datagram
+			}
+			return
+		}
+		if n != ackSize {
+			log.Printf("ack of %d bytes, want %d", n, ackSize)
+			continue
+		}
+		i := binary.BigEndian.Uint64(buf[9:]) - seed
+		if i >= uint64(len(attempts)) {
+			log.Printf("ack for an unknown datagram")
+			continue
+		}
+		a := &attempts[i]
+		a.queued, a.echo, a.verdict = time.Duration(binary.BigEndian.Uint64(buf)), outcomeOK, outcomeOther
+		if v := int(buf[8]); v < len(verdicts) {
+			a.verdict = verdicts[v]
+		}
+		log.Printf("%d, waited %s, payload %s", a.i, a.queued, a.verdict)
+	}
+}
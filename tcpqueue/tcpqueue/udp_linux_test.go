@@ -0,0 +1,59 @@
+package tcpqueue
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestUDPReceiveQueueDrops(t *testing.T) {
+	logs := captureLog(t)
+	pc, err := listenUDP("127.0.0.1:0", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+	// The kernel doubles this and then allows a few datagrams' overhead.
+	if err := pc.(*net.UDPConn).SetReadBuffer(4096); err != nil {
+		t.Fatal(err)
+	}
+	stats := &serverStats{udp: true}
+	ctx, cancel := context.WithCancel(context.Background())
+	sampled := make(chan error, 1)
+	go func() { sampled <- sampleReceiveQueue(ctx, pc, 10*time.Millisecond, stats) }()
+	waitForLog(t, logs, "receive queue: 0 bytes, 0 datagrams dropped")
+
+	// Unlike a full accept queue, which makes the client wait, a full
+	// receive buffer loses datagrams without telling the sender.
+	conn, err := net.Dial("udp", pc.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	const sent = 50
+	for i := range sent {
+		var a attempt
+		sendDatagram(conn, 1000, uint64(i), &a)
+		if a.writeErr != outcomeOK {
+			t.Fatalf("datagram %d: write %s", i, a.writeErr)
+		}
+	}
+	cancel()
+	if err := <-sampled; err != nil {
+		t.Fatal(err)
+	}
+
+	gate := newAcceptGate(readDeadliner{pc})
+	gate.set(true)
+	go readLoop(pc, gate, stats)
+	time.Sleep(100 * time.Millisecond)
+	gate.shutDown()
+	pc.Close()
+
+	stats.mu.Lock()
+	defer stats.mu.Unlock()
+	if stats.queue == 0 || stats.drops == 0 || stats.accepts == 0 || stats.accepts+stats.drops != sent {
+		t.Errorf("queued %d bytes, %d dropped and %d read, want some of each adding up to the %d sent", stats.queue, stats.drops, stats.accepts, sent)
+	}
+}
@@ -0,0 +1,86 @@
+package tcpqueue
+
+import (
+	"bytes"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestUDPReadLoop(t *testing.T) {
+	logs := captureLog(t)
+	pc, err := listenUDP("127.0.0.1:0", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+	gate := newAcceptGate(readDeadliner{pc})
+	gate.what = "reading"
+	stats := &serverStats{udp: true}
+	done := make(chan error, 1)
+	go func() { done <- readLoop(pc, gate, stats) }()
+
+	conn, err := net.Dial("udp", pc.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	attempts := make([]attempt, 4)
+	acked := make(chan struct{})
+	go func() {
+		defer close(acked)
+		readAcks(conn, attempts, 100)
+	}()
+
+	// Nothing is read while the gate is off; the datagrams wait in the
+	// receive buffer.
+	for i := range 3 {
+		attempts[i].i = i
+		sendDatagram(conn, 50, 100+uint64(i), &attempts[i])
+	}
+	conn.Write([]byte("short"))
+	time.Sleep(100 * time.Millisecond)
+	if strings.Contains(logs.String(), "datagram waited") {
+		t.Fatalf("a datagram was read with the gate off:\n%s", logs)
+	}
+
+	gate.set(true)
+	waitForLog(t, logs, "short datagram, 5 bytes")
+	for i := range 3 {
+		waitForLog(t, logs, strconv.Itoa(i)+", waited")
+	}
+	conn.Close()
+	<-acked
+	for i, a := range attempts[:3] {
+		if a.writeErr != outcomeOK || a.echo != outcomeOK || a.verdict != verdictIntact || a.queued < 100*time.Millisecond {
+			t.Errorf("datagram %d: %+v, want an intact payload acked after at least 100ms", i, a)
+		}
+	}
+
+	pc.Close()
+	if err := <-done; err == nil {
+		t.Error("readLoop returned nil after the socket closed")
+	}
+	var out bytes.Buffer
+	stats.summarize(&out)
+	for _, want := range []string{"Read: 4 datagrams\n", "Still queued: unknown, not sampled\n", "Bytes received: 215\n", "Timestamps: ok=3 short=1 reset=0\n", "Payloads: intact=3\n"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("report lacks %q:\n%s", want, out.String())
+		}
+	}
+}
+
+func TestSummarizeDatagrams(t *testing.T) {
+	attempts := []attempt{
+		{dialErr: outcomeOK, wrote: true, writeErr: outcomeOK},
+		{dialErr: outcomeOK, wrote: true, writeErr: outcomeRefused},
+		{dialErr: outcomeCanceled},
+	}
+	var out bytes.Buffer
+	summarizeDatagrams(&out, attempts)
+	if want := "Datagrams: 3\nWrites: ok=1 refused=1 canceled=1\n"; out.String() != want {
+		t.Errorf("summary:\n%s\nwant:\n%s", out.String(), want)
+	}
+}
@@ -0,0 +1,42 @@
+package tcpqueue
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"net"
+	"os"
+	"syscall"
+	"time"
+)
+
+// removeStaleSocket removes the unix socket a previous server left at path,
+// so listening there does not fail with "address already in use". It
+// leaves alone anything that is not a socket, and a socket another server
+// is still accepting on.
+func removeStaleSocket(path string) error {
+	fi, err := os.Lstat(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if fi.Mode()&fs.ModeSocket == 0 {
+		return fmt.Errorf("%s exists and is not a socket", path)
+	}
+	// Nobody listening is refused; a server whose queue is full is not.
+	conn, err := net.DialTimeout("unix", path, time.Second)
+	if err == nil {
+		conn.Close()
+	}
+	if !errors.Is(err, syscall.ECONNREFUSED) {
+		return fmt.Errorf("%s is in use by another server", path)
+	}
+	if err := os.Remove(path); err != nil {
+		return err
+	}
+	log.Printf("removed the stale socket %s", path)
+	return nil
+}
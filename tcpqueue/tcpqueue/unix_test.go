@@ -0,0 +1,100 @@
+package tcpqueue
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRemoveStaleSocket(t *testing.T) {
+	captureLog(t)
+	dir := t.TempDir()
+
+	stale := filepath.Join(dir, "stale.sock")
+	l, err := net.Listen("unix", stale)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.(*net.UnixListener).SetUnlinkOnClose(false)
+	l.Close()
+
+	live := filepath.Join(dir, "live.sock")
+	l, err = net.Listen("unix", live)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	file := filepath.Join(dir, "file")
+	if err := os.WriteFile(file, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		path    string
+		err     string
+		removed bool
+	}{
+		{filepath.Join(dir, "missing.sock"), "", true},
+		{stale, "", true},
+		{live, "in use by another server", false},
+		{file, "is not a socket", false},
+	} {
+		err := removeStaleSocket(tc.path)
+		if tc.err == "" && err != nil || tc.err != "" && (err == nil || !strings.Contains(err.Error(), tc.err)) {
+			t.Errorf("removeStaleSocket(%s) = %v, want %q", filepath.Base(tc.path), err, tc.err)
+		}
+		if _, err := os.Lstat(tc.path); os.IsNotExist(err) != tc.removed {
+			t.Errorf("after removeStaleSocket(%s) the path exists=%t, want %t", filepath.Base(tc.path), !os.IsNotExist(err), !tc.removed)
+		}
+	}
+}
+
+func TestUnixRoundTrip(t *testing.T) {
+	logs := captureLog(t)
+	path := filepath.Join(t.TempDir(), "s.sock")
+	l, _, err := listen("unix", path, 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gate := newAcceptGate(l.(deadliner))
+	stats := &serverStats{unix: true}
+	done := make(chan error, 1)
+	go func() { done <- acceptLoop(l, gate, acceptPolicy{}, stats) }()
+
+	a := attempt{i: 0}
+	conn := establishConn(context.Background(), newDialer("unix", time.Second, nil), path, appendPayload(nil, 100, 1), &a)
+	if conn == nil {
+		t.Fatalf("dial %s, write %s", a.dialErr, a.writeErr)
+	}
+	defer conn.Close()
+	time.Sleep(50 * time.Millisecond)
+	gate.set(true)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	awaitEcho(context.Background(), conn, &a)
+	if a.echo != outcomeOK || a.verdict != verdictIntact || a.queued < 50*time.Millisecond {
+		t.Errorf("%+v, want an intact payload queued for at least 50ms", a)
+	}
+	waitForLog(t, logs, "#1: waited")
+
+	conn.Close()
+	l.Close()
+	<-done
+	if _, err := os.Lstat(path); !os.IsNotExist(err) {
+		t.Errorf("%s left behind after the listener closed", path)
+	}
+	if !stats.waitDrains(5 * time.Second) {
+		t.Fatal("connection still being read")
+	}
+	var out strings.Builder
+	stats.summarize(&out)
+	for _, want := range []string{"Accepted: 1\n", "Still queued: unknown, the kernel does not expose", "Payloads: intact=1\n"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("report lacks %q:\n%s", want, out.String())
+		}
+	}
+}
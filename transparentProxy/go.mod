@@ -1,3 +1,8 @@
 module tproxy
 
 go 1.23.3
+
+require s1 v0.0.0
+
+// The PROXY protocol header builders live in the proxyProto experiment.
+replace s1 => ../proxyProto
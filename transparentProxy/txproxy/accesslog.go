@@ -0,0 +1,113 @@
+package txproxy
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"sync"
+)
+
+// accessLog appends one JSON object per finished connection to a file. A
+// single goroutine owns the file, so entries from concurrent connections
+// never interleave, and Reopen lets logrotate move the file away.
+type accessLog struct {
+	path    string
+	entries chan connSummary
+	reopens chan chan error
+	done    chan struct{} // closed once run has returned
+
+	closeOnce sync.Once
+	closeErr  error
+}
+
+func openAccessLog(path string) (*accessLog, error) {
+	f, err := openAppend(path)
+	if err != nil {
+		return nil, err
+	}
+	a := &accessLog{
+		path:    path,
+		entries: make(chan connSummary, 64),
+		reopens: make(chan chan error),
+		done:    make(chan struct{}),
+	}
+	go a.run(f)
+	return a, nil
+}
+
+func openAppend(path string) (*os.File, error) {
+	return os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+}
+
+// write queues c; it must not be called after Close.
+func (a *accessLog) write(c connSummary) { a.entries <- c }
+
+// Reopen writes out everything queued so far, syncs and closes the file,
+// and opens path afresh. If path cannot be opened the old file stays in use.
+func (a *accessLog) Reopen() error {
+	res := make(chan error, 1)
+	select {
+	case a.reopens <- res:
+		return <-res
+	case <-a.done:
+		return os.ErrClosed
+	}
+}
+
+// reopenOn calls Reopen for every signal on sigs (SIGUSR1 from logrotate).
+func (a *accessLog) reopenOn(sigs <-chan os.Signal) {
+	for range sigs {
+		if err := a.Reopen(); err != nil {
+			log.Printf("Reopening access log %s: %v", a.path, err)
+			continue
+		}
+		log.Printf("Reopened access log %s", a.path)
+	}
+}
+
+// Close writes out the queued entries and syncs and closes the file.
+func (a *accessLog) Close() error {
+	a.closeOnce.Do(func() {
+		close(a.entries)
+		<-a.done
+	})
+	return a.closeErr
+}
+
+func (a *accessLog) run(f *os.File) {
+	defer close(a.done)
+	write := func(c connSummary) {
+		// Encode issues a single Write per entry.
+		if err := json.NewEncoder(f).Encode(c); err != nil {
+			log.Printf("Access log %s: %v", a.path, err)
+		}
+	}
+	for {
+		select {
+		case c, ok := <-a.entries:
+			if !ok {
+				a.closeErr = syncClose(f)
+				return
+			}
+			write(c)
+		case res := <-a.reopens:
+			for n := len(a.entries); n > 0; n-- {
+				write(<-a.entries)
+			}
+			nf, err := openAppend(a.path)
+			if err == nil {
+				err = syncClose(f)
+				f = nf
+			}
+			res <- err
+		}
+	}
+}
+
+func syncClose(f *os.File) error {
+	err := f.Sync()
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
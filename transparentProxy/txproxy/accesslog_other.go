@@ -0,0 +1,7 @@
+//go:build !unix
+
+package txproxy
+
+// reopenOnSIGUSR1 does nothing: without SIGUSR1 the access log stays on the
+// file it opened until the proxy exits.
+func (a *accessLog) reopenOnSIGUSR1() {}
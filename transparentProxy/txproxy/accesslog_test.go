@@ -0,0 +1,111 @@
+package txproxy
+
+import (
+	"bufio"
+	"encoding/json"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// readAccessLog parses every line of path.
+func readAccessLog(t *testing.T, path string) []connSummary {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var out []connSummary
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		var c connSummary
+		if err := json.Unmarshal(sc.Bytes(), &c); err != nil {
+			t.Fatalf("line %q: %v", sc.Text(), err)
+		}
+		out = append(out, c)
+	}
+	if err := sc.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return out
+}
+
+func TestAccessLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "access.log")
+	accessLog, err := openAccessLog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	milter := fakeMilter(t)
+	p := newProxy(ln, []string{milter})
+	p.accessLog = accessLog
+	p.start()
+	t.Cleanup(func() { p.Shutdown(time.Second) })
+
+	// converse runs n conversations at once, each one HELO and a hangup,
+	// and waits until the proxy has logged them all.
+	logged := 0
+	converse := func(n int) {
+		var wg sync.WaitGroup
+		for i := 0; i < n; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				conn, err := net.Dial("tcp", ln.Addr().String())
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				defer conn.Close()
+				roundTrip(t, conn)
+				conn.(*net.TCPConn).CloseWrite()
+				ReadPacket(conn) // EOF once the proxy is done with us
+			}()
+		}
+		wg.Wait()
+		logged += n
+		for deadline := time.Now().Add(2 * time.Second); p.stats.open.Load() != 0; time.Sleep(5 * time.Millisecond) {
+			if time.Now().After(deadline) {
+				t.Fatal("connections still open")
+			}
+		}
+	}
+
+	converse(5)
+	// logrotate renames the file, then signals us to reopen.
+	rotated := path + ".1"
+	if err := os.Rename(path, rotated); err != nil {
+		t.Fatal(err)
+	}
+	if err := accessLog.Reopen(); err != nil {
+		t.Fatal(err)
+	}
+	converse(3)
+	if !p.Shutdown(time.Second) {
+		t.Fatal("connections did not drain")
+	}
+
+	before, after := readAccessLog(t, rotated), readAccessLog(t, path)
+	if len(before) != 5 || len(after) != 3 {
+		t.Fatalf("%d entries before rotation and %d after, want 5 and 3", len(before), len(after))
+	}
+	for _, c := range append(before, after...) {
+		if c.Upstream != milter || c.Reason != "client EOF" || c.Start.IsZero() || c.Duration <= 0 {
+			t.Errorf("entry = %+v", c)
+		}
+		if c.ToMilterBytes != 16 || c.ToMilterFrames != 1 || c.ToMTABytes != 5 || c.ToMTAFrames != 1 {
+			t.Errorf("entry counts = %+v, want one HELO and one CONTINUE", c)
+		}
+	}
+	if err := accessLog.Reopen(); err != os.ErrClosed {
+		t.Errorf("Reopen after Shutdown = %v, want os.ErrClosed", err)
+	}
+}
@@ -0,0 +1,16 @@
+//go:build unix
+
+package txproxy
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// reopenOnSIGUSR1 reopens a every time the process gets SIGUSR1.
+func (a *accessLog) reopenOnSIGUSR1() {
+	reopen := make(chan os.Signal, 1)
+	signal.Notify(reopen, syscall.SIGUSR1)
+	go a.reopenOn(reopen)
+}
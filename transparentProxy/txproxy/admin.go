@@ -0,0 +1,85 @@
+package txproxy
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"time"
+)
+
+// serveAdmin serves the proxy's counters at /debug/vars on ln until Shutdown.
+func (p *proxy) serveAdmin(ln net.Listener) {
+	vars := p.vars()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/vars", func(w http.ResponseWriter, r *http.Request) {
+		writeVars(w, vars)
+	})
+	p.admin = &http.Server{Handler: mux}
+	go func() {
+		if err := p.admin.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Printf("Admin endpoint: %v", err)
+		}
+	}()
+	log.Printf("Admin endpoint on http://%s/debug/vars", ln.Addr())
+}
+
+func (p *proxy) stopAdmin() {
+	if p.admin == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := p.admin.Shutdown(ctx); err != nil {
+		p.admin.Close()
+	}
+}
+
+// vars lays out the counters the way expvar renders them. Every value is
+// read when the page is rendered, so it is live.
+func (p *proxy) vars() *expvar.Map {
+	load := func(v interface{ Load() int64 }) expvar.Func {
+		return func() any { return v.Load() }
+	}
+	m := new(expvar.Map)
+	m.Set("open", load(&p.stats.open))
+	m.Set("accepted", load(&p.stats.accepted))
+	m.Set("rejected", load(&p.stats.rejected))
+	tlsFailures := new(expvar.Map)
+	tlsFailures.Set("client", load(&p.stats.clientTLSFailures))
+	tlsFailures.Set("milter", load(&p.stats.milterTLSFailures))
+	m.Set("tls_handshake_failures", tlsFailures)
+
+	bytes, frames := new(expvar.Map), new(expvar.Map)
+	for _, dir := range []direction{toMilter, toMTA} {
+		bytes.Set(dir.String(), load(&p.stats.bytes[dir]))
+		frames.Set(dir.String(), &p.stats.frames[dir])
+	}
+	m.Set("bytes", bytes)
+	m.Set("frames", frames)
+
+	upstreams := new(expvar.Map)
+	for _, b := range p.upstreams.backends {
+		bm := new(expvar.Map)
+		bm.Set("conns", load(&b.conns))
+		bm.Set("dial_failures", load(&b.dialFailures))
+		upstreams.Set(b.addr, bm)
+	}
+	m.Set("upstreams", upstreams)
+	return m
+}
+
+// writeVars writes what expvar.Handler would (cmdline, memstats and any
+// other published variable) plus vars under "tproxy". vars is not published
+// itself, so several proxies in one process each report their own.
+func writeVars(w http.ResponseWriter, vars *expvar.Map) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	fmt.Fprintf(w, "{\n")
+	expvar.Do(func(kv expvar.KeyValue) {
+		fmt.Fprintf(w, "%q: %s,\n", kv.Key, kv.Value)
+	})
+	fmt.Fprintf(w, "%q: %s\n}\n", "tproxy", vars)
+}
@@ -0,0 +1,84 @@
+package txproxy
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestAdminVars(t *testing.T) {
+	dead := startFakeMilter(t)
+	dead.Close()
+	live := fakeMilter(t)
+	p := startTestProxy(t, dead.Addr().String(), live)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.serveAdmin(ln)
+	url := "http://" + ln.Addr().String() + "/debug/vars"
+
+	conn, err := net.Dial("tcp", p.listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	roundTrip(t, conn)
+	roundTrip(t, conn)
+
+	var vars struct {
+		Cmdline []string `json:"cmdline"`
+		Tproxy  struct {
+			Open, Accepted, Rejected int64
+			TLSHandshakeFailures     map[string]int64 `json:"tls_handshake_failures"`
+			Bytes                    map[string]int64
+			Frames                   map[string]map[string]int64
+			Upstreams                map[string]struct {
+				Conns        int64 `json:"conns"`
+				DialFailures int64 `json:"dial_failures"`
+			}
+		} `json:"tproxy"`
+	}
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = json.NewDecoder(resp.Body).Decode(&vars)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := vars.Tproxy
+	if len(vars.Cmdline) == 0 {
+		t.Error("the usual expvar variables are missing")
+	}
+	if got.Open != 1 || got.Accepted != 1 || got.Rejected != 0 {
+		t.Errorf("open=%d accepted=%d rejected=%d, want 1 1 0", got.Open, got.Accepted, got.Rejected)
+	}
+	if f := got.TLSHandshakeFailures; len(f) != 2 || f["client"] != 0 || f["milter"] != 0 {
+		t.Errorf("tls_handshake_failures = %v, want client and milter at 0", f)
+	}
+	// Two HELOs of 16 bytes each way in, two 5-byte CONTINUEs back.
+	if got.Bytes["client -> milter"] != 32 || got.Bytes["milter -> client"] != 10 {
+		t.Errorf("bytes = %v", got.Bytes)
+	}
+	if got.Frames["client -> milter"]["SMFIC_HELO"] != 2 || got.Frames["milter -> client"]["SMFIR_CONTINUE"] != 2 {
+		t.Errorf("frames = %v", got.Frames)
+	}
+	if u := got.Upstreams[dead.Addr().String()]; u.Conns != 0 || u.DialFailures != 1 {
+		t.Errorf("dead milter = %+v, want one failed dial", u)
+	}
+	if u := got.Upstreams[live]; u.Conns != 1 || u.DialFailures != 0 {
+		t.Errorf("live milter = %+v, want one connection", u)
+	}
+
+	conn.Close()
+	p.Shutdown(time.Second)
+	if resp, err := http.Get(url); err == nil {
+		resp.Body.Close()
+		t.Error("admin endpoint still answering after Shutdown")
+	}
+}
@@ -0,0 +1,80 @@
+package txproxy
+
+import (
+	"errors"
+	"log"
+	"math/rand/v2"
+	"sync/atomic"
+	"time"
+)
+
+// errDropped ends a session that -drop-after cut short.
+var errDropped = errors.New("dropped by -drop-after")
+
+// chaos is one session's fault injection for -delay-ms, -delay-jitter-ms,
+// -drop-after and -tempfail-every. Every event it injects is logged with the
+// connection id. With all of them at 0 it changes nothing.
+type chaos struct {
+	id     int64
+	frames atomic.Int64 // relayed in both directions, for -drop-after
+
+	// Only the milter -> client relay touches these.
+	jitter   *rand.Rand // seeded with id, so a rerun repeats the same delays
+	verdicts int64      // seen so far, for -tempfail-every
+}
+
+func newChaos(id int64) *chaos {
+	return &chaos{id: id, jitter: rand.New(rand.NewPCG(uint64(id), 0))}
+}
+
+// delay holds msg back on its way to the MTA for -delay-ms plus up to
+// -delay-jitter-ms.
+func (c *chaos) delay(msg *Message) {
+	d := time.Duration(*delayMS) * time.Millisecond
+	if *delayJitterMS > 0 {
+		d += time.Duration(c.jitter.IntN(*delayJitterMS+1)) * time.Millisecond
+	}
+	if d == 0 {
+		return
+	}
+	log.Printf("[conn %d] chaos: delaying %s by %s", c.id, codeName(msg.Code, toMTA), d)
+	time.Sleep(d)
+}
+
+// tempfail returns SMFIR_TEMPFAIL in place of every -tempfail-every'th
+// verdict, and msg otherwise.
+func (c *chaos) tempfail(msg *Message) *Message {
+	if *tempfailEvery <= 0 || !isVerdict(msg.Code) {
+		return msg
+	}
+	c.verdicts++
+	if c.verdicts%int64(*tempfailEvery) != 0 {
+		return msg
+	}
+	log.Printf("[conn %d] chaos: verdict %d %s -> SMFIR_TEMPFAIL", c.id, c.verdicts, codeName(msg.Code, toMTA))
+	return &Message{Code: SMFIR_TEMPFAIL}
+}
+
+// relayed counts a frame that went through and returns errDropped once
+// -drop-after of them have, in either direction.
+func (c *chaos) relayed() error {
+	n := c.frames.Add(1)
+	if *dropAfter <= 0 || n < int64(*dropAfter) {
+		return nil
+	}
+	if n == int64(*dropAfter) {
+		log.Printf("[conn %d] chaos: dropping the connection after %d frames", c.id, n)
+	}
+	return errDropped
+}
+
+// isVerdict reports whether code is one of the payload-free responses
+// -rewrite can produce.
+func isVerdict(code byte) bool {
+	for _, v := range verdicts {
+		if v == code {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,145 @@
+package txproxy
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// setChaos sets the chaos knobs for one test.
+func setChaos(t *testing.T, delay, jitter, drop, tempfail int) {
+	t.Helper()
+	saved := [4]int{*delayMS, *delayJitterMS, *dropAfter, *tempfailEvery}
+	t.Cleanup(func() {
+		*delayMS, *delayJitterMS, *dropAfter, *tempfailEvery = saved[0], saved[1], saved[2], saved[3]
+	})
+	*delayMS, *delayJitterMS, *dropAfter, *tempfailEvery = delay, jitter, drop, tempfail
+}
+
+func TestChaosOffIsPassthrough(t *testing.T) {
+	setChaos(t, 0, 0, 0, 0)
+	milter, received := scriptedMilter(t, milterSide)
+	p := startTestProxy(t, milter)
+	if got := converse(t, p.listener.Addr().String(), mtaSide); !bytes.Equal(got, milterSide) {
+		t.Errorf("MTA received %q\nwant %q", got, milterSide)
+	}
+	if sent := <-received; !bytes.Equal(sent, mtaSide) {
+		t.Errorf("milter received %d bytes, want the MTA's %d unchanged", len(sent), len(mtaSide))
+	}
+}
+
+func TestChaosTempfailEvery(t *testing.T) {
+	// milterSide's verdicts are CONTINUE, TEMPFAIL and REJECT, in that order.
+	for _, tc := range []struct {
+		every int
+		want  []byte
+		log   string
+	}{
+		{1, frames(
+			Message{Code: SMFIR_OPTNEG, Data: []byte("\x00\x00\x00\x06\x00\x00\x00\x01\x00\x00\x00\x00")},
+			Message{Code: SMFIR_TEMPFAIL},
+			Message{Code: SMFIR_TEMPFAIL},
+			Message{Code: SMFIR_ADDHEADER, Data: []byte("X-Spam\x00yes\x00")},
+			Message{Code: SMFIR_REPLYCODE, Data: []byte("550 5.7.1 no\x00")},
+			Message{Code: SMFIR_TEMPFAIL},
+		), "[conn 1] chaos: verdict 1 SMFIR_CONTINUE -> SMFIR_TEMPFAIL"},
+		{3, frames(
+			Message{Code: SMFIR_OPTNEG, Data: []byte("\x00\x00\x00\x06\x00\x00\x00\x01\x00\x00\x00\x00")},
+			Message{Code: SMFIR_CONTINUE},
+			Message{Code: SMFIR_TEMPFAIL},
+			Message{Code: SMFIR_ADDHEADER, Data: []byte("X-Spam\x00yes\x00")},
+			Message{Code: SMFIR_REPLYCODE, Data: []byte("550 5.7.1 no\x00")},
+			Message{Code: SMFIR_TEMPFAIL},
+		), "[conn 1] chaos: verdict 3 SMFIR_REJECT -> SMFIR_TEMPFAIL"},
+	} {
+		t.Run(tc.log, func(t *testing.T) {
+			logs := captureLog(t)
+			setChaos(t, 0, 0, 0, tc.every)
+			milter, received := scriptedMilter(t, milterSide)
+			p := startTestProxy(t, milter)
+			if got := converse(t, p.listener.Addr().String(), mtaSide); !bytes.Equal(got, tc.want) {
+				t.Errorf("MTA received %q\nwant %q", got, tc.want)
+			}
+			<-received
+			waitForLog(t, logs, tc.log)
+		})
+	}
+}
+
+func TestChaosDropAfter(t *testing.T) {
+	logs := captureLog(t)
+	setChaos(t, 0, 0, 2, 0)
+	// A milter that never answers, so only client -> milter frames count.
+	milter, received := scriptedMilter(t, nil)
+	p := startTestProxy(t, milter)
+
+	firstTwo := frames(
+		Message{Code: SMFIC_OPTNEG, Data: []byte("\x00\x00\x00\x06\x00\x00\x01\xff\x00\x1f\xff\xff")},
+		Message{Code: SMFIC_HELO, Data: []byte("mx.example\x00")},
+	)
+	sent := append(firstTwo, frames(Message{Code: SMFIC_MAIL, Data: []byte("<a@example.org>\x00")})...)
+	conn, err := net.Dial("tcp", p.listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	if _, err := conn.Write(sent); err != nil {
+		t.Fatal(err)
+	}
+	// The proxy hangs up on us; whether that reads as EOF or a reset
+	// depends on timing.
+	io.Copy(io.Discard, conn)
+
+	if got := <-received; !bytes.Equal(got, firstTwo) {
+		t.Errorf("milter received %q, want the first two frames %q", got, firstTwo)
+	}
+	waitForLog(t, logs, "[conn 1] chaos: dropping the connection after 2 frames")
+	if line := waitForLog(t, logs, "Connection summary:"); !strings.Contains(line, `reason="dropped by -drop-after"`) {
+		t.Errorf("summary %q does not blame -drop-after", line)
+	}
+}
+
+func TestChaosDelay(t *testing.T) {
+	logs := captureLog(t)
+	setChaos(t, 40, 0, 0, 0)
+	p := startTestProxy(t, fakeMilter(t))
+	conn, err := net.Dial("tcp", p.listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	start := time.Now()
+	roundTrip(t, conn)
+	if took := time.Since(start); took < 40*time.Millisecond {
+		t.Errorf("round trip took %s, want at least -delay-ms", took)
+	}
+	waitForLog(t, logs, "[conn 1] chaos: delaying SMFIR_CONTINUE by 40ms")
+}
+
+func TestChaosJitterRepeats(t *testing.T) {
+	setChaos(t, 0, 3, 0, 0)
+	delays := func(id int64) string {
+		logs := captureLog(t)
+		c := newChaos(id)
+		for i := 0; i < 8; i++ {
+			c.delay(&Message{Code: SMFIR_CONTINUE})
+		}
+		// Drop the timestamps.
+		var out []string
+		for _, line := range strings.Split(logs.String(), "\n") {
+			if _, msg, ok := strings.Cut(line, "chaos: "); ok {
+				out = append(out, msg)
+			}
+		}
+		return strings.Join(out, "\n")
+	}
+	if a, b := delays(7), delays(7); a == "" || a != b {
+		t.Errorf("connection 7 got different delays on a rerun:\n%s\nthen\n%s", a, b)
+	}
+}
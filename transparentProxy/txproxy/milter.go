@@ -0,0 +1,270 @@
+package txproxy
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"strings"
+)
+
+// direction says which way a packet travels through the proxy, and so
+// whether its code is a command (SMFIC_*) or a response (SMFIR_*).
+type direction int
+
+const (
+	toMilter direction = iota // MTA -> milter: commands
+	toMTA                     // milter -> MTA: responses
+)
+
+func (d direction) String() string {
+	if d == toMilter {
+		return "client -> milter"
+	}
+	return "milter -> client"
+}
+
+// Command codes sent by the MTA, from libmilter's mfdef.h.
+const (
+	SMFIC_ABORT   = 'A'
+	SMFIC_BODY    = 'B'
+	SMFIC_CONNECT = 'C'
+	SMFIC_MACRO   = 'D'
+	SMFIC_BODYEOB = 'E'
+	SMFIC_HELO    = 'H'
+	SMFIC_QUIT_NC = 'K'
+	SMFIC_HEADER  = 'L'
+	SMFIC_MAIL    = 'M'
+	SMFIC_EOH     = 'N'
+	SMFIC_OPTNEG  = 'O'
+	SMFIC_QUIT    = 'Q'
+	SMFIC_RCPT    = 'R'
+	SMFIC_DATA    = 'T'
+	SMFIC_UNKNOWN = 'U'
+)
+
+// Response codes sent by the milter.
+const (
+	SMFIR_ADDRCPT     = '+'
+	SMFIR_DELRCPT     = '-'
+	SMFIR_ADDRCPT_PAR = '2'
+	SMFIR_SHUTDOWN    = '4'
+	SMFIR_ACCEPT      = 'a'
+	SMFIR_REPLBODY    = 'b'
+	SMFIR_CONTINUE    = 'c'
+	SMFIR_DISCARD     = 'd'
+	SMFIR_CHGFROM     = 'e'
+	SMFIR_CONN_FAIL   = 'f'
+	SMFIR_ADDHEADER   = 'h'
+	SMFIR_INSHEADER   = 'i'
+	SMFIR_SETSYMLIST  = 'l'
+	SMFIR_CHGHEADER   = 'm'
+	SMFIR_OPTNEG      = 'O'
+	SMFIR_PROGRESS    = 'p'
+	SMFIR_QUARANTINE  = 'q'
+	SMFIR_REJECT      = 'r'
+	SMFIR_SKIP        = 's'
+	SMFIR_TEMPFAIL    = 't'
+	SMFIR_REPLYCODE   = 'y'
+)
+
+var commandNames = map[byte]string{
+	SMFIC_ABORT:   "SMFIC_ABORT",
+	SMFIC_BODY:    "SMFIC_BODY",
+	SMFIC_CONNECT: "SMFIC_CONNECT",
+	SMFIC_MACRO:   "SMFIC_MACRO",
+	SMFIC_BODYEOB: "SMFIC_BODYEOB",
+	SMFIC_HELO:    "SMFIC_HELO",
+	SMFIC_QUIT_NC: "SMFIC_QUIT_NC",
+	SMFIC_HEADER:  "SMFIC_HEADER",
+	SMFIC_MAIL:    "SMFIC_MAIL",
+	SMFIC_EOH:     "SMFIC_EOH",
+	SMFIC_OPTNEG:  "SMFIC_OPTNEG",
+	SMFIC_QUIT:    "SMFIC_QUIT",
+	SMFIC_RCPT:    "SMFIC_RCPT",
+	SMFIC_DATA:    "SMFIC_DATA",
+	SMFIC_UNKNOWN: "SMFIC_UNKNOWN",
+}
+
+var responseNames = map[byte]string{
+	SMFIR_ADDRCPT:     "SMFIR_ADDRCPT",
+	SMFIR_DELRCPT:     "SMFIR_DELRCPT",
+	SMFIR_ADDRCPT_PAR: "SMFIR_ADDRCPT_PAR",
+	SMFIR_SHUTDOWN:    "SMFIR_SHUTDOWN",
+	SMFIR_ACCEPT:      "SMFIR_ACCEPT",
+	SMFIR_REPLBODY:    "SMFIR_REPLBODY",
+	SMFIR_CONTINUE:    "SMFIR_CONTINUE",
+	SMFIR_DISCARD:     "SMFIR_DISCARD",
+	SMFIR_CHGFROM:     "SMFIR_CHGFROM",
+	SMFIR_CONN_FAIL:   "SMFIR_CONN_FAIL",
+	SMFIR_ADDHEADER:   "SMFIR_ADDHEADER",
+	SMFIR_INSHEADER:   "SMFIR_INSHEADER",
+	SMFIR_SETSYMLIST:  "SMFIR_SETSYMLIST",
+	SMFIR_CHGHEADER:   "SMFIR_CHGHEADER",
+	SMFIR_OPTNEG:      "SMFIR_OPTNEG",
+	SMFIR_PROGRESS:    "SMFIR_PROGRESS",
+	SMFIR_QUARANTINE:  "SMFIR_QUARANTINE",
+	SMFIR_REJECT:      "SMFIR_REJECT",
+	SMFIR_SKIP:        "SMFIR_SKIP",
+	SMFIR_TEMPFAIL:    "SMFIR_TEMPFAIL",
+	SMFIR_REPLYCODE:   "SMFIR_REPLYCODE",
+}
+
+// codeName returns the libmilter name of code, or its hex value when the code
+// is not one we know for that direction.
+func codeName(code byte, dir direction) string {
+	names := commandNames
+	if dir == toMTA {
+		names = responseNames
+	}
+	if name, ok := names[code]; ok {
+		return name
+	}
+	return fmt.Sprintf("0x%02x", code)
+}
+
+// describePacket names msg and decodes the fields of the structured packets
+// worth seeing in a log. A payload that does not parse is reported as
+// malformed rather than failing; the relay forwards it untouched either way.
+func describePacket(msg *Message, dir direction) string {
+	name := codeName(msg.Code, dir)
+	fields, ok := decodeFields(msg, dir)
+	if !ok {
+		return name + " (malformed)"
+	}
+	if fields == "" {
+		return name
+	}
+	return name + " " + fields
+}
+
+func decodeFields(msg *Message, dir direction) (string, bool) {
+	data := msg.Data
+	if dir == toMTA {
+		switch msg.Code {
+		case SMFIR_OPTNEG:
+			return decodeOptneg(data)
+		case SMFIR_ADDHEADER:
+			return decodeHeader(data)
+		case SMFIR_CHGHEADER, SMFIR_INSHEADER:
+			if len(data) < 4 {
+				return "", false
+			}
+			fields, ok := decodeHeader(data[4:])
+			return fmt.Sprintf("index=%d %s", binary.BigEndian.Uint32(data), fields), ok
+		case SMFIR_REPLYCODE:
+			text, ok := nulStrings(data, 1)
+			return fmt.Sprintf("text=%q", firstOf(text)), ok
+		case SMFIR_ADDRCPT, SMFIR_DELRCPT, SMFIR_CHGFROM, SMFIR_QUARANTINE:
+			args, ok := nulStrings(data, -1)
+			return quoteAll("args", args), ok
+		}
+		return "", true
+	}
+
+	switch msg.Code {
+	case SMFIC_OPTNEG:
+		return decodeOptneg(data)
+	case SMFIC_HEADER:
+		return decodeHeader(data)
+	case SMFIC_HELO:
+		host, ok := nulStrings(data, 1)
+		return fmt.Sprintf("helo=%q", firstOf(host)), ok
+	case SMFIC_MAIL, SMFIC_RCPT:
+		args, ok := nulStrings(data, -1)
+		return quoteAll("args", args), ok
+	case SMFIC_CONNECT:
+		return decodeConnect(data)
+	case SMFIC_MACRO:
+		if len(data) < 1 {
+			return "", false
+		}
+		pairs, ok := nulStrings(data[1:], -1)
+		return fmt.Sprintf("for=%s %s", codeName(data[0], toMilter), quoteAll("macros", pairs)), ok
+	case SMFIC_BODY:
+		return fmt.Sprintf("chunk=%d", len(data)), true
+	}
+	return "", true
+}
+
+// decodeOptneg reads the version, actions and protocol words shared by the
+// command and the response; anything after them (the v6 macro lists) is
+// left alone.
+func decodeOptneg(data []byte) (string, bool) {
+	if len(data) < 12 {
+		return "", false
+	}
+	return fmt.Sprintf("version=%d actions=0x%x protocol=0x%x",
+		binary.BigEndian.Uint32(data[0:4]),
+		binary.BigEndian.Uint32(data[4:8]),
+		binary.BigEndian.Uint32(data[8:12])), true
+}
+
+// decodeHeader reads a NUL-terminated name and value.
+func decodeHeader(data []byte) (string, bool) {
+	parts, ok := nulStrings(data, 2)
+	if !ok || len(parts) != 2 {
+		return "", false
+	}
+	return fmt.Sprintf("name=%q value=%q", parts[0], parts[1]), true
+}
+
+// decodeConnect reads hostname\0, a family byte, and for inet families a
+// port and address\0.
+func decodeConnect(data []byte) (string, bool) {
+	host, rest, ok := cutNul(data)
+	if !ok || len(rest) < 1 {
+		return "", false
+	}
+	family, rest := rest[0], rest[1:]
+	switch family {
+	case '4', '6':
+		if len(rest) < 2 {
+			return "", false
+		}
+		port := binary.BigEndian.Uint16(rest)
+		addr, _, ok := cutNul(rest[2:])
+		return fmt.Sprintf("host=%q family=%c port=%d addr=%q", host, family, port, addr), ok
+	case 'L':
+		path, _, ok := cutNul(rest)
+		return fmt.Sprintf("host=%q family=L path=%q", host, path), ok
+	}
+	return fmt.Sprintf("host=%q family=%c", host, family), true
+}
+
+// nulStrings splits data into NUL-terminated strings, at most max of them
+// (max < 0 means all). ok is false if the last string is unterminated.
+func nulStrings(data []byte, max int) ([]string, bool) {
+	var out []string
+	for len(data) > 0 && (max < 0 || len(out) < max) {
+		s, rest, ok := cutNul(data)
+		if !ok {
+			return out, false
+		}
+		out = append(out, s)
+		data = rest
+	}
+	return out, true
+}
+
+func cutNul(data []byte) (string, []byte, bool) {
+	i := bytes.IndexByte(data, 0)
+	if i < 0 {
+		return "", nil, false
+	}
+	return string(data[:i]), data[i+1:], true
+}
+
+func firstOf(s []string) string {
+	if len(s) == 0 {
+		return ""
+	}
+	return s[0]
+}
+
+func quoteAll(key string, values []string) string {
+	quoted := make([]string, len(values))
+	for i, v := range values {
+		quoted[i] = fmt.Sprintf("%q", v)
+	}
+	return key + "=[" + strings.Join(quoted, " ") + "]"
+}
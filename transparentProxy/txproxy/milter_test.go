@@ -0,0 +1,131 @@
+package txproxy
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestDescribePacket(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		raw  string // a whole frame as captured on the wire
+		dir  direction
+		want string
+	}{
+		{
+			name: "optneg command",
+			raw:  "\x00\x00\x00\x0dO\x00\x00\x00\x06\x00\x00\x01\xff\x00\x1f\xff\xff",
+			dir:  toMilter,
+			want: "SMFIC_OPTNEG version=6 actions=0x1ff protocol=0x1fffff",
+		},
+		{
+			name: "optneg response",
+			raw:  "\x00\x00\x00\x0dO\x00\x00\x00\x06\x00\x00\x00\x01\x00\x00\x00\x00",
+			dir:  toMTA,
+			want: "SMFIR_OPTNEG version=6 actions=0x1 protocol=0x0",
+		},
+		{
+			name: "header",
+			raw:  "\x00\x00\x00\x12LSubject\x00hi there\x00",
+			dir:  toMilter,
+			want: `SMFIC_HEADER name="Subject" value="hi there"`,
+		},
+		{
+			name: "connect inet",
+			raw:  "\x00\x00\x00\x19Cmx.example\x004\x00\x19127.0.0.1\x00",
+			dir:  toMilter,
+			want: `SMFIC_CONNECT host="mx.example" family=4 port=25 addr="127.0.0.1"`,
+		},
+		{
+			name: "mail from with esmtp args",
+			raw:  "\x00\x00\x00\x15M<a@example>\x00SIZE=10\x00",
+			dir:  toMilter,
+			want: `SMFIC_MAIL args=["<a@example>" "SIZE=10"]`,
+		},
+		{
+			name: "macro",
+			raw:  "\x00\x00\x00\x09DCj\x00mx.e\x00",
+			dir:  toMilter,
+			want: `SMFIC_MACRO for=SMFIC_CONNECT macros=["j" "mx.e"]`,
+		},
+		{
+			name: "body chunk",
+			raw:  "\x00\x00\x00\x06Bhello",
+			dir:  toMilter,
+			want: "SMFIC_BODY chunk=5",
+		},
+		{
+			name: "bodyeob carries no fields",
+			raw:  "\x00\x00\x00\x01E",
+			dir:  toMilter,
+			want: "SMFIC_BODYEOB",
+		},
+		{
+			name: "continue",
+			raw:  "\x00\x00\x00\x01c",
+			dir:  toMTA,
+			want: "SMFIR_CONTINUE",
+		},
+		{
+			name: "replycode",
+			raw:  "\x00\x00\x00\x0ey550 5.7.1 no\x00",
+			dir:  toMTA,
+			want: `SMFIR_REPLYCODE text="550 5.7.1 no"`,
+		},
+		{
+			name: "addheader",
+			raw:  "\x00\x00\x00\x0bhX-Spam\x00no\x00",
+			dir:  toMTA,
+			want: `SMFIR_ADDHEADER name="X-Spam" value="no"`,
+		},
+		{
+			name: "chgheader has an index first",
+			raw:  "\x00\x00\x00\x0dm\x00\x00\x00\x01X-Spam\x00\x00",
+			dir:  toMTA,
+			want: `SMFIR_CHGHEADER index=1 name="X-Spam" value=""`,
+		},
+		{
+			name: "unknown command code logs as hex",
+			raw:  "\x00\x00\x00\x02zz",
+			dir:  toMilter,
+			want: "0x7a",
+		},
+		{
+			name: "command letters are not responses",
+			raw:  "\x00\x00\x00\x01Q",
+			dir:  toMTA,
+			want: "0x51",
+		},
+		{
+			name: "truncated header",
+			raw:  "\x00\x00\x00\x08LSubject",
+			dir:  toMilter,
+			want: "SMFIC_HEADER (malformed)",
+		},
+		{
+			name: "short optneg",
+			raw:  "\x00\x00\x00\x03O\x00\x00",
+			dir:  toMilter,
+			want: "SMFIC_OPTNEG (malformed)",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			msg, err := ReadPacket(bytes.NewReader([]byte(tc.raw)))
+			if err != nil {
+				t.Fatalf("ReadPacket: %v", err)
+			}
+			if got := describePacket(msg, tc.dir); got != tc.want {
+				t.Errorf("describePacket = %s\n                   want %s", got, tc.want)
+			}
+
+			// Whatever the decoder thinks of it, the frame goes out unchanged.
+			var out bytes.Buffer
+			if err := WritePacket(&out, msg); err != nil {
+				t.Fatal(err)
+			}
+			if out.String() != tc.raw {
+				t.Errorf("WritePacket = %q, want %q", out.String(), tc.raw)
+			}
+		})
+	}
+}
@@ -0,0 +1,65 @@
+package txproxy
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// Payload logging modes for -log-payload.
+const (
+	payloadOff   = "off"
+	payloadASCII = "ascii"
+	payloadHex   = "hex"
+)
+
+// formatPayload renders data for the log: ascii escapes everything that is
+// not printable ASCII, hex is an offset/hex/ASCII dump. Either is cut at max
+// bytes with a note of how much was left out. It returns "" for off.
+func formatPayload(data []byte, mode string, max int) string {
+	if mode == payloadOff {
+		return ""
+	}
+	var more int
+	if max >= 0 && len(data) > max {
+		data, more = data[:max], len(data)-max
+	}
+
+	var out string
+	switch mode {
+	case payloadHex:
+		out = strings.TrimSuffix(hex.Dump(data), "\n")
+	default:
+		out = escapeASCII(data)
+	}
+	if more > 0 {
+		if mode == payloadHex {
+			out += "\n"
+		}
+		out += fmt.Sprintf("... (%d more bytes)", more)
+	}
+	return out
+}
+
+func escapeASCII(data []byte) string {
+	var b strings.Builder
+	for _, c := range data {
+		switch {
+		case c == '\\':
+			b.WriteString(`\\`)
+		case c == '\n':
+			b.WriteString(`\n`)
+		case c == '\r':
+			b.WriteString(`\r`)
+		case c == '\t':
+			b.WriteString(`\t`)
+		case c == 0:
+			b.WriteString(`\0`)
+		case c >= 0x20 && c < 0x7f:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, `\x%02x`, c)
+		}
+	}
+	return b.String()
+}
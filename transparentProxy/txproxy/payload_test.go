@@ -0,0 +1,34 @@
+package txproxy
+
+import "testing"
+
+func TestFormatPayload(t *testing.T) {
+	data := []byte("Subject\x00hi\tthere\r\n\x7f\xff\\ end of a longer line")
+	for _, tc := range []struct {
+		name string
+		mode string
+		max  int
+		want string
+	}{
+		{"off", payloadOff, 100, ""},
+		{"ascii", payloadASCII, 100, `Subject\0hi\tthere\r\n\x7f\xff\\ end of a longer line`},
+		{"ascii truncated", payloadASCII, 10, `Subject\0hi... (32 more bytes)`},
+		{"hex", payloadHex, 100, "" +
+			"00000000  53 75 62 6a 65 63 74 00  68 69 09 74 68 65 72 65  |Subject.hi.there|\n" +
+			"00000010  0d 0a 7f ff 5c 20 65 6e  64 20 6f 66 20 61 20 6c  |....\\ end of a l|\n" +
+			"00000020  6f 6e 67 65 72 20 6c 69  6e 65                    |onger line|"},
+		{"hex truncated", payloadHex, 20, "" +
+			"00000000  53 75 62 6a 65 63 74 00  68 69 09 74 68 65 72 65  |Subject.hi.there|\n" +
+			"00000010  0d 0a 7f ff                                       |....|\n" +
+			"... (22 more bytes)"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := formatPayload(data, tc.mode, tc.max); got != tc.want {
+				t.Errorf("formatPayload(%s, %d) =\n%s\nwant\n%s", tc.mode, tc.max, got, tc.want)
+			}
+		})
+	}
+	if got := formatPayload(nil, payloadHex, 100); got != "" {
+		t.Errorf("empty payload = %q, want nothing", got)
+	}
+}
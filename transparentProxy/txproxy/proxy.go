@@ -0,0 +1,277 @@
+package txproxy
+
+import (
+	"crypto/tls"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"s1/proxyproto"
+)
+
+// proxy accepts MTA connections and relays each one to a milter. It runs
+// until Shutdown.
+type proxy struct {
+	listener  net.Listener
+	upstreams *upstreamPool
+
+	acceptDone chan struct{} // closed when the accept loop has returned
+	wg         sync.WaitGroup
+	slots      chan struct{} // one per relayed connection under -max-conns; nil if unlimited
+	saturated  bool          // at the limit; only touched by the accept loop
+	stats      counters
+
+	mu    sync.Mutex
+	conns map[net.Conn]time.Time // open client connections and when they arrived
+
+	admin       *http.Server // -admin-addr; nil if off
+	accessLog   *accessLog   // -access-log; nil if off
+	upstreamTLS *tls.Config  // -upstream-tls; nil for plain connections to the milter
+}
+
+// startProxy starts relaying every connection accepted on listener to a
+// milter from upstreams, taken in turn with failover, and returns at once.
+func startProxy(listener net.Listener, upstreams []string) *proxy {
+	p := newProxy(listener, upstreams)
+	p.start()
+	return p
+}
+
+// newProxy sets up a proxy without accepting yet, for callers that need to
+// attach an access log or upstream TLS first.
+func newProxy(listener net.Listener, upstreams []string) *proxy {
+	p := &proxy{
+		listener:   listener,
+		upstreams:  newUpstreamPool(upstreams),
+		acceptDone: make(chan struct{}),
+		conns:      make(map[net.Conn]time.Time),
+	}
+	if *maxConns > 0 {
+		p.slots = make(chan struct{}, *maxConns)
+	}
+	return p
+}
+
+func (p *proxy) start() {
+	log.Printf("Listening on %s\n", p.listener.Addr())
+	go p.acceptLoop()
+}
+
+// maxAcceptDelay caps the backoff after failed Accepts.
+const maxAcceptDelay = time.Second
+
+func (p *proxy) acceptLoop() {
+	defer close(p.acceptDone)
+	var delay time.Duration
+	for {
+		// handleConn logs each accepted connection; the loop only logs trouble.
+		clientConn, err := p.listener.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			return
+		}
+		if err != nil {
+			// Typically EMFILE; retrying at once would just spin.
+			switch {
+			case delay == 0:
+				delay = 5 * time.Millisecond
+			case delay < maxAcceptDelay/2:
+				delay *= 2
+			default:
+				delay = maxAcceptDelay
+			}
+			log.Printf("Failed to accept connection: %v; retrying in %s", err, delay)
+			time.Sleep(delay)
+			continue
+		}
+		delay = 0
+
+		// Take a slot before dialing the milter; without one, turn the
+		// client away now rather than queueing it.
+		if !p.acquire() {
+			p.stats.rejected.Add(1)
+			if !p.saturated {
+				log.Printf("Connection limit of %d reached, rejecting new connections", cap(p.slots))
+				p.saturated = true
+			}
+			clientConn.Close()
+			continue
+		}
+		p.saturated = false
+		id := p.stats.accepted.Add(1)
+
+		// Handle each connection in a separate goroutine
+		p.track(clientConn)
+		go func() {
+			defer p.release()
+			defer p.untrack(clientConn)
+			p.handleConn(clientConn, id)
+		}()
+	}
+}
+
+// acquire takes a -max-conns slot if one is free.
+func (p *proxy) acquire() bool {
+	if p.slots == nil {
+		return true
+	}
+	select {
+	case p.slots <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+func (p *proxy) release() {
+	if p.slots != nil {
+		<-p.slots
+	}
+}
+
+func (p *proxy) track(conn net.Conn) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.wg.Add(1)
+	p.conns[conn] = time.Now()
+	p.stats.open.Add(1)
+}
+
+func (p *proxy) untrack(conn net.Conn) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.conns, conn)
+	p.stats.open.Add(-1)
+	p.wg.Done()
+}
+
+// Shutdown stops accepting, then gives open connections up to timeout to
+// finish on their own before closing them, logs the final counters, closes
+// the access log and stops the admin endpoint. It reports whether every connection finished
+// without being forced.
+func (p *proxy) Shutdown(timeout time.Duration) bool {
+	// Closing a unix listener also removes its socket file.
+	p.listener.Close()
+	<-p.acceptDone
+	// The admin endpoint stays up while connections drain.
+	defer p.stopAdmin()
+	defer p.logStats()
+	if p.accessLog != nil {
+		defer p.accessLog.Close()
+	}
+
+	drained := make(chan struct{})
+	go func() {
+		p.wg.Wait()
+		close(drained)
+	}()
+	select {
+	case <-drained:
+		return true
+	case <-time.After(timeout):
+	}
+
+	p.mu.Lock()
+	for conn, since := range p.conns {
+		log.Printf("Drain timeout: closing connection from %s (open for %s)", clientName(conn), time.Since(since).Round(time.Millisecond))
+		// The relay notices, closes the milter side and untracks it.
+		conn.Close()
+	}
+	p.mu.Unlock()
+	<-drained
+	return false
+}
+
+// runUntilSignal waits for a signal on sigs, drains p for up to
+// drainTimeout, and returns the exit code: 0 if every connection finished,
+// 1 if some had to be closed.
+func runUntilSignal(p *proxy, drainTimeout time.Duration, sigs <-chan os.Signal) int {
+	sig := <-sigs
+	p.mu.Lock()
+	open := len(p.conns)
+	p.mu.Unlock()
+	log.Printf("Received %s, no longer accepting; draining %d connection(s) for up to %s", sig, open, drainTimeout)
+
+	if !p.Shutdown(drainTimeout) {
+		log.Printf("Drain incomplete, exiting")
+		return 1
+	}
+	log.Printf("All connections drained, exiting")
+	return 0
+}
+
+// handleConn dials the milter for client connection number id, relays frames
+// between them until either side is done, and logs a summary line (and an
+// access log entry with -access-log).
+func (p *proxy) handleConn(clientConn net.Conn, id int64) {
+	defer clientConn.Close()
+	log.Printf("Connection %d accepted from %s\n", id, clientName(clientConn))
+
+	if err := clientHandshake(clientConn); err != nil {
+		p.stats.clientTLSFailures.Add(1)
+		log.Printf("TLS handshake with %s failed: %v", clientName(clientConn), err)
+		p.logSummary(connSummary{ID: id, Start: time.Now(), Remote: clientName(clientConn), ClientTLS: true, Reason: "TLS handshake: " + err.Error()})
+		return
+	}
+
+	// Connect to the Milter service
+	milterConn, upstream, err := p.upstreams.dial()
+	if err != nil {
+		p.logSummary(connSummary{ID: id, Start: time.Now(), Remote: clientName(clientConn), ClientTLS: isTLS(clientConn), Reason: err.Error()})
+		return
+	}
+	defer milterConn.Close()
+
+	log.Printf("Connected %s to Milter service at %s\n", clientName(clientConn), upstream.addr)
+
+	// The header has to reach the milter before anything the client sends,
+	// and ahead of TLS like any PROXY protocol sender.
+	// The header describes the client and our end of the milter
+	// connection. The empty Write sends it right away, and fails if the
+	// addresses cannot be described.
+	if version := proxyVersion(*sendProxy); version != 0 {
+		milterConn = proxyproto.WrapConn(milterConn, clientConn.RemoteAddr(), milterConn.LocalAddr(), version)
+		if _, err := milterConn.Write(nil); err != nil {
+			p.logSummary(connSummary{ID: id, Start: time.Now(), Remote: clientName(clientConn), Upstream: upstream.addr, ClientTLS: isTLS(clientConn), Reason: "sending PROXY header: " + err.Error()})
+			return
+		}
+	}
+	if p.upstreamTLS != nil {
+		tlsConn, err := upstreamHandshake(milterConn, upstream.addr, p.upstreamTLS)
+		if err != nil {
+			p.stats.milterTLSFailures.Add(1)
+			log.Printf("TLS handshake with Milter service at %s failed: %v", upstream.addr, err)
+			p.logSummary(connSummary{ID: id, Start: time.Now(), Remote: clientName(clientConn), Upstream: upstream.addr, ClientTLS: isTLS(clientConn), MilterTLS: true, Reason: "milter TLS handshake: " + err.Error()})
+			return
+		}
+		// Closing the TLS connection closes the one under it too.
+		milterConn = tlsConn
+	}
+
+	s := newSession(id, clientConn, milterConn, &p.stats)
+	if *recordDir != "" {
+		rec, err := newRecorder(*recordDir, clientName(clientConn), s.started)
+		if err != nil {
+			log.Printf("Not recording connection from %s: %v", clientName(clientConn), err)
+		} else {
+			s.rec = rec
+			defer func() {
+				if err := rec.Close(); err != nil {
+					log.Printf("Recording of %s incomplete: %v", clientName(clientConn), err)
+				}
+			}()
+		}
+	}
+	reason := s.relay()
+	p.logSummary(s.summary(upstream.addr, reason))
+}
+
+func (p *proxy) logSummary(c connSummary) {
+	log.Printf("Connection summary: %s", c)
+	if p.accessLog != nil {
+		p.accessLog.write(c)
+	}
+}
@@ -0,0 +1,137 @@
+package txproxy
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A recording is one proxied conversation as the proxy received it, before
+// any -rewrite:
+//
+//	header: "MLTR" | version uint16 | start unix nanoseconds int64
+//	frame:  direction byte | nanoseconds since start int64 | code byte |
+//	        payload length uint32 | payload
+//
+// All integers are big-endian. Readers must reject versions they do not
+// know; anything added later bumps recordVersion.
+const (
+	recordMagic   = "MLTR"
+	recordVersion = 1
+)
+
+// recordedFrame is one packet in a recording.
+type recordedFrame struct {
+	Dir    direction
+	Offset time.Duration // since the connection was accepted
+	Msg    Message
+}
+
+// recorder appends frames from both relay directions to one file.
+type recorder struct {
+	mu    sync.Mutex
+	file  *os.File
+	w     *bufio.Writer
+	start time.Time
+	err   error // first write error; later frames are not recorded
+}
+
+// newRecorder creates dir/<timestamp>-<client>.mrec, remote being the
+// client's name as clientName gives it.
+func newRecorder(dir, remote string, start time.Time) (*recorder, error) {
+	name := start.UTC().Format("20060102T150405.000000000") + "-" +
+		strings.NewReplacer(":", "_", "[", "", "]", "", "/", "_").Replace(remote) + ".mrec"
+	file, err := os.OpenFile(filepath.Join(dir, name), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	r := &recorder{file: file, w: bufio.NewWriter(file), start: start}
+	r.w.WriteString(recordMagic)
+	binary.Write(r.w, binary.BigEndian, uint16(recordVersion))
+	binary.Write(r.w, binary.BigEndian, start.UnixNano())
+	return r, nil
+}
+
+func (r *recorder) record(dir direction, msg *Message) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.err != nil {
+		return
+	}
+	var hdr [1 + 8 + 1 + 4]byte
+	hdr[0] = byte(dir)
+	binary.BigEndian.PutUint64(hdr[1:], uint64(time.Since(r.start)))
+	hdr[9] = msg.Code
+	binary.BigEndian.PutUint32(hdr[10:], uint32(len(msg.Data)))
+	r.w.Write(hdr[:])
+	_, r.err = r.w.Write(msg.Data)
+}
+
+func (r *recorder) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	err := r.w.Flush()
+	if cerr := r.file.Close(); err == nil {
+		err = cerr
+	}
+	if r.err != nil {
+		return r.err
+	}
+	return err
+}
+
+// readRecording loads a whole recording.
+func readRecording(path string) (start time.Time, frames []recordedFrame, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return time.Time{}, nil, err
+	}
+	defer f.Close()
+	br := bufio.NewReader(f)
+
+	var hdr struct {
+		Magic   [4]byte
+		Version uint16
+		Start   int64
+	}
+	if err := binary.Read(br, binary.BigEndian, &hdr); err != nil {
+		return time.Time{}, nil, fmt.Errorf("%s: reading header: %v", path, err)
+	}
+	if string(hdr.Magic[:]) != recordMagic {
+		return time.Time{}, nil, fmt.Errorf("%s: not a milter recording", path)
+	}
+	if hdr.Version != recordVersion {
+		return time.Time{}, nil, fmt.Errorf("%s: recording version %d, this build reads %d", path, hdr.Version, recordVersion)
+	}
+
+	for {
+		var fh struct {
+			Dir    byte
+			Offset int64
+			Code   byte
+			Length uint32
+		}
+		if err := binary.Read(br, binary.BigEndian, &fh); err != nil {
+			if errors.Is(err, io.EOF) {
+				return time.Unix(0, hdr.Start), frames, nil
+			}
+			return time.Time{}, nil, fmt.Errorf("%s: frame %d: %v", path, len(frames)+1, err)
+		}
+		data := make([]byte, fh.Length)
+		if _, err := io.ReadFull(br, data); err != nil {
+			return time.Time{}, nil, fmt.Errorf("%s: frame %d payload: %v", path, len(frames)+1, err)
+		}
+		frames = append(frames, recordedFrame{
+			Dir:    direction(fh.Dir),
+			Offset: time.Duration(fh.Offset),
+			Msg:    Message{Code: fh.Code, Data: data},
+		})
+	}
+}
@@ -0,0 +1,88 @@
+package txproxy
+
+import (
+	"bytes"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRecordAndReplay(t *testing.T) {
+	dir := t.TempDir()
+	saved := *recordDir
+	t.Cleanup(func() { *recordDir = saved })
+	*recordDir = dir
+
+	p := startTestProxy(t, fakeMilter(t))
+	conn, err := net.Dial("tcp", p.listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		roundTrip(t, conn)
+		time.Sleep(20 * time.Millisecond)
+	}
+	conn.Close()
+	p.Shutdown(time.Second)
+
+	files, _ := filepath.Glob(filepath.Join(dir, "*.mrec"))
+	if len(files) != 1 {
+		t.Fatalf("recordings: %v, want one", files)
+	}
+	_, frames, err := readRecording(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(frames) != 6 {
+		t.Fatalf("recorded %d frames, want 6", len(frames))
+	}
+	for i, f := range frames {
+		want := Message{Code: SMFIC_HELO, Data: []byte("mx.example\x00")}
+		if i%2 == 1 {
+			want = Message{Code: SMFIR_CONTINUE, Data: []byte{}}
+		}
+		if f.Dir != direction(i%2) || f.Msg.Code != want.Code || !bytes.Equal(f.Msg.Data, want.Data) {
+			t.Errorf("frame %d = %s %q %q", i, f.Dir, f.Msg.Code, f.Msg.Data)
+		}
+		if i > 0 && f.Offset < frames[i-1].Offset {
+			t.Errorf("frame %d offset %s before frame %d's %s", i, f.Offset, i-1, frames[i-1].Offset)
+		}
+	}
+	if gap := frames[2].Offset - frames[0].Offset; gap < 20*time.Millisecond {
+		t.Errorf("recorded gap %s, want at least the 20ms we waited", gap)
+	}
+
+	t.Run("same milter", func(t *testing.T) {
+		var out bytes.Buffer
+		start := time.Now()
+		n, err := replay(files[0], fakeMilter(t), false, time.Second, &out)
+		if err != nil || n != 0 {
+			t.Fatalf("replay = %d divergences, %v:\n%s", n, err, out.String())
+		}
+		if took := time.Since(start); took < frames[4].Offset-frames[0].Offset {
+			t.Errorf("replay took %s, faster than the recorded timing", took)
+		}
+	})
+
+	t.Run("different answers", func(t *testing.T) {
+		var out bytes.Buffer
+		n, err := replay(files[0], startAnsweringMilter(t, SMFIR_REJECT).Addr().String(), true, time.Second, &out)
+		if err != nil || n != 3 {
+			t.Fatalf("replay = %d divergences, %v, want 3", n, err)
+		}
+		if want := "frame 2: expected SMFIR_CONTINUE, got SMFIR_REJECT\n"; !strings.HasPrefix(out.String(), want) {
+			t.Errorf("report starts %q, want %q", out.String(), want)
+		}
+	})
+}
+
+func TestReadRecordingRejectsOtherVersions(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "future.mrec")
+	os.WriteFile(path, []byte("MLTR\x00\x02\x00\x00\x00\x00\x00\x00\x00\x00"), 0o600)
+	if _, _, err := readRecording(path); err == nil || !strings.Contains(err.Error(), "version 2") {
+		t.Fatalf("readRecording = %v, want a version error", err)
+	}
+}
@@ -0,0 +1,100 @@
+package txproxy
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"time"
+)
+
+// replayMain runs `tproxy replay [flags] recording`, playing the MTA side
+// of a -record file against a milter and checking its answers. It returns
+// nil if the milter answered exactly as recorded.
+func replayMain(args []string) error {
+	fs := flag.NewFlagSet("replay", flag.ExitOnError)
+	upstream := fs.String("upstream", envOr("PROXY_UPSTREAM", "127.0.0.1:1234"), "milter to replay against (env PROXY_UPSTREAM)")
+	fast := fs.Bool("as-fast-as-possible", false, "send packets back to back instead of with the recorded timing")
+	replyTimeout := fs.Duration("reply-timeout", 10*time.Second, "how long to wait for each milter response")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: tproxy replay [flags] recording.mrec\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return exitError{2, fmt.Errorf("replay takes one recording, got %d arguments", fs.NArg())}
+	}
+
+	divergences, err := replay(fs.Arg(0), *upstream, *fast, *replyTimeout, os.Stdout)
+	if err != nil {
+		return fmt.Errorf("replay: %w", err)
+	}
+	if divergences > 0 {
+		return fmt.Errorf("%d divergence(s)", divergences)
+	}
+	fmt.Println("milter answered as recorded")
+	return nil
+}
+
+// replay sends the recorded MTA packets to the milter at addr in order and,
+// wherever the recording has a milter packet, reads one and compares it.
+// Every mismatch is written to out; the count is returned.
+func replay(path, addr string, fast bool, replyTimeout time.Duration, out io.Writer) (divergences int, err error) {
+	_, frames, err := readRecording(path)
+	if err != nil {
+		return 0, err
+	}
+	network, address := splitAddr(addr)
+	conn, err := net.DialTimeout(network, address, *dialTimeout)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+
+	diverge := func(i int, format string, args ...interface{}) {
+		divergences++
+		fmt.Fprintf(out, "frame %d: %s\n", i+1, fmt.Sprintf(format, args...))
+	}
+	start := time.Now()
+	for i, f := range frames {
+		if f.Dir == toMilter {
+			if !fast {
+				time.Sleep(time.Until(start.Add(f.Offset)))
+			}
+			if err := WritePacket(conn, &f.Msg); err != nil {
+				return divergences, fmt.Errorf("frame %d: sending %s: %v", i+1, codeName(f.Msg.Code, toMilter), err)
+			}
+			continue
+		}
+
+		conn.SetReadDeadline(time.Now().Add(replyTimeout))
+		got, err := ReadPacket(conn)
+		if err != nil {
+			diverge(i, "expected %s, got %v", describePacket(&f.Msg, toMTA), err)
+			// Nothing more will arrive in step with the recording.
+			return divergences, nil
+		}
+		switch {
+		case got.Code != f.Msg.Code:
+			diverge(i, "expected %s, got %s", describePacket(&f.Msg, toMTA), describePacket(got, toMTA))
+		case !bytes.Equal(got.Data, f.Msg.Data):
+			diverge(i, "%s payload differs: expected %s, got %s", codeName(got.Code, toMTA),
+				formatPayload(f.Msg.Data, payloadASCII, 80), formatPayload(got.Data, payloadASCII, 80))
+		}
+	}
+
+	// Anything the milter still has to say was not in the recording.
+	closeWrite(conn)
+	conn.SetReadDeadline(time.Now().Add(replyTimeout))
+	for {
+		got, err := ReadPacket(conn)
+		if err != nil {
+			break
+		}
+		diverge(len(frames), "unexpected extra %s", describePacket(got, toMTA))
+	}
+	return divergences, nil
+}
@@ -0,0 +1,102 @@
+package txproxy
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// rewriteRules maps a milter response code to what the proxy sends the MTA
+// instead, for -rewrite. Rules only ever apply to milter -> MTA packets.
+type rewriteRules map[byte]rewriteRule
+
+type rewriteRule struct {
+	to   byte // replacement code, when not drop
+	drop bool // forward nothing at all
+}
+
+// verdicts are the payload-free responses a rule may translate to, by the
+// short names used in -rewrite.
+var verdicts = map[string]byte{
+	"accept":   SMFIR_ACCEPT,
+	"continue": SMFIR_CONTINUE,
+	"reject":   SMFIR_REJECT,
+	"tempfail": SMFIR_TEMPFAIL,
+	"discard":  SMFIR_DISCARD,
+}
+
+// responseCode looks up a response by its short name: the libmilter name
+// without SMFIR_, in any case ("reject", "addheader").
+func responseCode(name string) (byte, bool) {
+	want := "SMFIR_" + strings.ToUpper(name)
+	for code, n := range responseNames {
+		if n == want {
+			return code, true
+		}
+	}
+	return 0, false
+}
+
+func (r rewriteRules) String() string {
+	var rules []string
+	for from, rule := range r {
+		name := strings.ToLower(strings.TrimPrefix(responseNames[from], "SMFIR_"))
+		if rule.drop {
+			rules = append(rules, "strip-"+name)
+		} else {
+			rules = append(rules, name+"->"+strings.ToLower(strings.TrimPrefix(responseNames[rule.to], "SMFIR_")))
+		}
+	}
+	sort.Strings(rules)
+	return strings.Join(rules, ",")
+}
+
+// Set adds comma-separated rules: "from->to" (or "from→to") translates a
+// response into one of the verdicts, "strip-name" drops it.
+func (r *rewriteRules) Set(value string) error {
+	if *r == nil {
+		*r = make(rewriteRules)
+	}
+	for _, spec := range strings.Split(value, ",") {
+		spec = strings.TrimSpace(spec)
+		if spec == "" {
+			continue
+		}
+		if name, ok := strings.CutPrefix(spec, "strip-"); ok {
+			code, ok := responseCode(name)
+			if !ok {
+				return fmt.Errorf("rewrite %q: unknown response %q", spec, name)
+			}
+			(*r)[code] = rewriteRule{drop: true}
+			continue
+		}
+		from, to, ok := strings.Cut(strings.ReplaceAll(spec, "→", "->"), "->")
+		if !ok {
+			return fmt.Errorf("rewrite %q: want from->to or strip-name", spec)
+		}
+		fromCode, ok := responseCode(strings.TrimSpace(from))
+		if !ok {
+			return fmt.Errorf("rewrite %q: unknown response %q", spec, from)
+		}
+		toCode, ok := verdicts[strings.ToLower(strings.TrimSpace(to))]
+		if !ok {
+			return fmt.Errorf("rewrite %q: can only rewrite to accept, continue, reject, tempfail or discard", spec)
+		}
+		(*r)[fromCode] = rewriteRule{to: toCode}
+	}
+	return nil
+}
+
+// apply returns the packet to send in msg's place: msg itself when no rule
+// matches, a payload-free verdict when one translates it, or nil when it is
+// to be dropped.
+func (r rewriteRules) apply(msg *Message) *Message {
+	rule, ok := r[msg.Code]
+	switch {
+	case !ok:
+		return msg
+	case rule.drop:
+		return nil
+	}
+	return &Message{Code: rule.to}
+}
@@ -0,0 +1,91 @@
+package txproxy
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestRewriteRulesSet(t *testing.T) {
+	var r rewriteRules
+	if err := r.Set("reject->accept, tempfail→continue"); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Set("strip-addheader"); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := r.String(), "reject->accept,strip-addheader,tempfail->continue"; got != want {
+		t.Fatalf("rules = %q, want %q", got, want)
+	}
+
+	for _, bad := range []string{"reject", "bogus->accept", "reject->addheader", "strip-bogus"} {
+		var r rewriteRules
+		if err := r.Set(bad); err == nil {
+			t.Errorf("Set(%q) accepted", bad)
+		}
+	}
+}
+
+// conversation is an MTA and a milter side that between them use a good
+// spread of packet shapes.
+var (
+	mtaSide = frames(
+		Message{Code: SMFIC_OPTNEG, Data: []byte("\x00\x00\x00\x06\x00\x00\x01\xff\x00\x1f\xff\xff")},
+		Message{Code: SMFIC_CONNECT, Data: []byte("mx.example\x004\x00\x19127.0.0.1\x00")},
+		Message{Code: SMFIC_HEADER, Data: []byte("Subject\x00hi\x00")},
+		Message{Code: SMFIC_BODY, Data: bytes.Repeat([]byte("body "), 20000)},
+		Message{Code: SMFIC_BODYEOB},
+	)
+	milterSide = frames(
+		Message{Code: SMFIR_OPTNEG, Data: []byte("\x00\x00\x00\x06\x00\x00\x00\x01\x00\x00\x00\x00")},
+		Message{Code: SMFIR_CONTINUE},
+		Message{Code: SMFIR_TEMPFAIL},
+		Message{Code: SMFIR_ADDHEADER, Data: []byte("X-Spam\x00yes\x00")},
+		Message{Code: SMFIR_REPLYCODE, Data: []byte("550 5.7.1 no\x00")},
+		Message{Code: SMFIR_REJECT},
+	)
+)
+
+func TestRewriteStreams(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		rules string
+		want  []byte // what the MTA should receive
+	}{
+		{"no rules is byte-exact", "", milterSide},
+		{"translate and strip", "reject->accept,tempfail->continue,strip-addheader,replycode->tempfail", frames(
+			Message{Code: SMFIR_OPTNEG, Data: []byte("\x00\x00\x00\x06\x00\x00\x00\x01\x00\x00\x00\x00")},
+			Message{Code: SMFIR_CONTINUE},
+			Message{Code: SMFIR_CONTINUE},
+			Message{Code: SMFIR_TEMPFAIL},
+			Message{Code: SMFIR_ACCEPT},
+		)},
+		// Command codes that look like rewritten responses must be left alone.
+		{"commands untouched", "strip-optneg", frames(
+			Message{Code: SMFIR_CONTINUE},
+			Message{Code: SMFIR_TEMPFAIL},
+			Message{Code: SMFIR_ADDHEADER, Data: []byte("X-Spam\x00yes\x00")},
+			Message{Code: SMFIR_REPLYCODE, Data: []byte("550 5.7.1 no\x00")},
+			Message{Code: SMFIR_REJECT},
+		)},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var rules rewriteRules
+			if err := rules.Set(tc.rules); err != nil {
+				t.Fatal(err)
+			}
+			saved := rewrites
+			t.Cleanup(func() { rewrites = saved })
+			rewrites = rules
+
+			milter, received := scriptedMilter(t, milterSide)
+			p := startTestProxy(t, milter)
+			got := converse(t, p.listener.Addr().String(), mtaSide)
+			if !bytes.Equal(got, tc.want) {
+				t.Errorf("MTA received %q\nwant %q", got, tc.want)
+			}
+			if sent := <-received; !bytes.Equal(sent, mtaSide) {
+				t.Errorf("milter received %d bytes, want the MTA's %d unchanged", len(sent), len(mtaSide))
+			}
+		})
+	}
+}
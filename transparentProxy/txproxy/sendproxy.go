@@ -0,0 +1,20 @@
+package txproxy
+
+// PROXY protocol versions for -send-proxy.
+const (
+	sendProxyOff = "off"
+	sendProxyV1  = "v1"
+	sendProxyV2  = "v2"
+)
+
+// proxyVersion returns the PROXY protocol version -send-proxy asks for,
+// as proxyproto.WrapConn takes it, or 0 for off.
+func proxyVersion(sendProxy string) int {
+	switch sendProxy {
+	case sendProxyV1:
+		return 1
+	case sendProxyV2:
+		return 2
+	}
+	return 0
+}
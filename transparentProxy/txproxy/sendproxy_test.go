@@ -0,0 +1,116 @@
+package txproxy
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"s1/proxyproto"
+)
+
+// headerMilter accepts one connection and reports everything it received,
+// once the proxy half-closes, along with the proxy's end of that connection.
+func headerMilter(t *testing.T, network, addr string) (ln net.Listener, received <-chan []byte, proxySide <-chan net.Addr) {
+	t.Helper()
+	ln, err := net.Listen(network, addr)
+	if err != nil {
+		t.Skipf("cannot listen on %s: %v", addr, err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	got, peer := make(chan []byte, 1), make(chan net.Addr, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		peer <- conn.RemoteAddr()
+		data, _ := io.ReadAll(conn)
+		got <- data
+	}()
+	return ln, got, peer
+}
+
+func TestSendProxy(t *testing.T) {
+	v := *sendProxy
+	t.Cleanup(func() { *sendProxy = v })
+
+	mta := frames(Message{Code: SMFIC_OPTNEG, Data: []byte("\x00\x00\x00\x06\x00\x00\x01\xff\x00\x1f\xff\xff")}, Message{Code: SMFIC_QUIT})
+	tests := []struct {
+		version string
+		loop    string // loopback address for the proxy and the milter
+		prefix  string // what the header starts with
+	}{
+		{sendProxyV1, "127.0.0.1", "PROXY TCP4 127.0.0.1 127.0.0.1 "},
+		{sendProxyV1, "::1", "PROXY TCP6 ::1 ::1 "},
+		{sendProxyV2, "127.0.0.1", "\r\n\r\n\x00\r\nQUIT\n\x21\x11\x00\x0c"},
+		{sendProxyV2, "::1", "\r\n\r\n\x00\r\nQUIT\n\x21\x21\x00\x24"},
+		{sendProxyOff, "127.0.0.1", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.version+" "+tt.loop, func(t *testing.T) {
+			*sendProxy = tt.version
+			milter, received, proxySide := headerMilter(t, "tcp", net.JoinHostPort(tt.loop, "0"))
+			ln, err := net.Listen("tcp", net.JoinHostPort(tt.loop, "0"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			p := startProxy(ln, []string{milter.Addr().String()})
+			t.Cleanup(func() { p.Shutdown(time.Second) })
+
+			client, err := net.Dial("tcp", ln.Addr().String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer client.Close()
+			client.SetDeadline(time.Now().Add(5 * time.Second))
+			if _, err := client.Write(mta); err != nil {
+				t.Fatal(err)
+			}
+			client.(*net.TCPConn).CloseWrite()
+
+			var local net.Addr
+			select {
+			case local = <-proxySide:
+			case <-time.After(5 * time.Second):
+				t.Fatal("proxy never dialed the milter")
+			}
+			var got []byte
+			select {
+			case got = <-received:
+			case <-time.After(5 * time.Second):
+				t.Fatal("milter never saw EOF")
+			}
+
+			// The header describes the client as the proxy saw it and our
+			// end of the milter connection, and comes before the first frame.
+			var want []byte
+			switch tt.version {
+			case sendProxyV1:
+				want, err = proxyproto.V1(client.LocalAddr(), local)
+			case sendProxyV2:
+				want, err = proxyproto.V2(client.LocalAddr(), local)
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.HasPrefix(want, []byte(tt.prefix)) {
+				t.Fatalf("header %q does not start with %q", want, tt.prefix)
+			}
+			want = append(want, mta...)
+			if !bytes.Equal(got, want) {
+				t.Fatalf("milter received\n%q\nwant\n%q", got, want)
+			}
+		})
+	}
+}
+
+func TestProxyVersion(t *testing.T) {
+	for flag, want := range map[string]int{sendProxyOff: 0, sendProxyV1: 1, sendProxyV2: 2} {
+		if got := proxyVersion(flag); got != want {
+			t.Errorf("proxyVersion(%q) = %d, want %d", flag, got, want)
+		}
+	}
+}
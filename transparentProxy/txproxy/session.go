@@ -0,0 +1,218 @@
+package txproxy
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+// session is one relayed conversation: an MTA connection, the milter
+// connection dialed for it, and what has happened on them so far.
+type session struct {
+	id             int64 // the connection number, for logs
+	client, milter net.Conn
+	started        time.Time
+	relayedAny     atomic.Bool // a packet has made it through in either direction
+	rec            *recorder   // nil unless -record
+	totals         *counters   // the proxy-wide traffic counters
+	chaos          *chaos
+
+	// What has been written to each destination so far, indexed by direction.
+	bytes, frames [2]atomic.Int64
+}
+
+// newSession arms the first deadline: the client has -header-timeout to
+// send its first packet, after which -idle-timeout applies. Relayed traffic
+// is added to totals as well as to the session's own counts.
+func newSession(id int64, client, milter net.Conn, totals *counters) *session {
+	s := &session{id: id, client: client, milter: milter, started: time.Now(), totals: totals, chaos: newChaos(id)}
+	if *headerTimeout > 0 {
+		s.setDeadline(*headerTimeout)
+	} else {
+		s.setDeadline(*idleTimeout)
+	}
+	return s
+}
+
+// setDeadline gives both connections d from now, or no deadline if d is 0.
+// Both move together so a long one-way stretch (a message body, say) keeps
+// the quiet direction alive too.
+func (s *session) setDeadline(d time.Duration) {
+	var t time.Time
+	if d > 0 {
+		t = time.Now().Add(d)
+	}
+	s.client.SetDeadline(t)
+	s.milter.SetDeadline(t)
+}
+
+// frameRelayed pushes the idle deadline out after a packet made it through.
+func (s *session) frameRelayed() {
+	s.relayedAny.Store(true)
+	s.setDeadline(*idleTimeout)
+}
+
+// relay runs both directions until each has finished, then closes both
+// connections. A direction that reaches EOF half-closes its destination so
+// the peer sees EOF too and can finish its side; one that fails closes both
+// connections at once, which stops the other. It returns why the
+// conversation ended, judged by whichever direction finished first.
+func (s *session) relay() (reason string) {
+	type result struct {
+		dir direction
+		err error
+	}
+	results := make(chan result, 2)
+	pipe := func(src, dst net.Conn, dir direction) {
+		err := s.transferData(src, dst, dir)
+		if err == nil {
+			closeWrite(dst)
+		}
+		results <- result{dir, err}
+	}
+	go pipe(s.client, s.milter, toMilter)
+	go pipe(s.milter, s.client, toMTA)
+
+	for i := 0; i < 2; i++ {
+		r := <-results
+		if reason == "" {
+			reason = s.endReason(r.dir, r.err)
+		}
+		if r.err == nil {
+			log.Printf("[%s] EOF, half-closed the other side", r.dir)
+			continue
+		}
+		s.client.Close()
+		s.milter.Close()
+	}
+	s.client.Close()
+	s.milter.Close()
+	return reason
+}
+
+// endReason describes how the dir relay finished.
+func (s *session) endReason(dir direction, err error) string {
+	var netErr net.Error
+	switch {
+	case err == nil && dir == toMilter:
+		return "client EOF"
+	case err == nil:
+		return "milter EOF"
+	case errors.As(err, &netErr) && netErr.Timeout() && !s.relayedAny.Load():
+		return "header timeout"
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return "idle timeout"
+	case errors.Is(err, errDropped):
+		return errDropped.Error()
+	case errors.Is(err, net.ErrClosed):
+		// Nothing on the relay closes a connection before reporting an
+		// error, so this is Shutdown giving up on the drain.
+		return "closed by proxy"
+	}
+	return fmt.Sprintf("%s error: %v", dir, err)
+}
+
+// summary sums up the session for the log once relay has returned.
+func (s *session) summary(upstream string, reason string) connSummary {
+	return connSummary{
+		ID:             s.id,
+		Start:          s.started,
+		Remote:         clientName(s.client),
+		Upstream:       upstream,
+		ClientTLS:      isTLS(s.client),
+		MilterTLS:      isTLS(s.milter),
+		Duration:       time.Since(s.started),
+		Reason:         reason,
+		ToMilterBytes:  s.bytes[toMilter].Load(),
+		ToMilterFrames: s.frames[toMilter].Load(),
+		ToMTABytes:     s.bytes[toMTA].Load(),
+		ToMTAFrames:    s.frames[toMTA].Load(),
+	}
+}
+
+// transferData relays whole milter packets from src to dst, preserving their
+// framing, and logs the decoded command or response and payload length of
+// each one. It counts what it writes in the session and proxy totals, and returns
+// nil once src reaches EOF between packets.
+func (s *session) transferData(src, dst net.Conn, direction direction) error {
+	for {
+		// Read one complete packet from the source
+		msg, err := ReadPacket(src)
+		if err == io.EOF {
+			return nil
+		}
+		if errors.Is(err, ErrEmptyFrame) || errors.Is(err, ErrFrameTooLarge) {
+			log.Printf("[%s] Bad packet from %s, closing the connection: %v", direction, s.peer(direction), err)
+		}
+		if err != nil {
+			return fmt.Errorf("reading packet: %w", err)
+		}
+
+		if s.rec != nil {
+			s.rec.record(direction, msg)
+		}
+
+		// Log the frame being transferred
+		log.Printf("[%s] %s length=%d", direction, describePacket(msg, direction), len(msg.Data))
+		if payload := formatPayload(msg.Data, *logPayload, *logPayloadMax); payload != "" {
+			if *logPayload == payloadHex {
+				payload = "\n" + payload
+			}
+			log.Printf("[%s] payload: %s", direction, payload)
+		}
+
+		// Responses may be rewritten or dropped on their way to the MTA
+		if direction == toMTA && len(rewrites) > 0 {
+			out := rewrites.apply(msg)
+			switch {
+			case out == nil:
+				log.Printf("[%s] rewrite: dropped %s", direction, codeName(msg.Code, direction))
+				s.frameRelayed()
+				continue
+			case out != msg:
+				log.Printf("[%s] rewrite: %s -> %s", direction, codeName(msg.Code, direction), codeName(out.Code, direction))
+				msg = out
+			}
+		}
+		if direction == toMTA {
+			msg = s.chaos.tempfail(msg)
+			s.chaos.delay(msg)
+		}
+
+		// Write it to the destination with the same framing
+		if err := WritePacket(dst, msg); err != nil {
+			return fmt.Errorf("writing packet: %w", err)
+		}
+		n := int64(4 + 1 + len(msg.Data))
+		s.bytes[direction].Add(n)
+		s.frames[direction].Add(1)
+		s.totals.bytes[direction].Add(n)
+		s.totals.frames[direction].Add(codeName(msg.Code, direction), 1)
+		s.frameRelayed()
+		if err := s.chaos.relayed(); err != nil {
+			return err
+		}
+	}
+}
+
+// peer names the sender of dir's packets.
+func (s *session) peer(dir direction) string {
+	if dir == toMilter {
+		return "client " + clientName(s.client)
+	}
+	return "milter " + s.milter.RemoteAddr().String()
+}
+
+// closeWrite shuts down the sending side of conn, or all of it when the
+// connection type has no half-close.
+func closeWrite(conn net.Conn) {
+	if cw, ok := conn.(interface{ CloseWrite() error }); ok {
+		cw.CloseWrite()
+		return
+	}
+	conn.Close()
+}
@@ -0,0 +1,66 @@
+package txproxy
+
+import (
+	"expvar"
+	"fmt"
+	"log"
+	"sync/atomic"
+	"time"
+)
+
+// counters are the proxy-wide connection numbers, updated from the accept
+// loop and the relay goroutines.
+type counters struct {
+	open     atomic.Int64 // connections being relayed right now
+	accepted atomic.Int64 // connections taken on since startup
+	rejected atomic.Int64 // connections closed on arrival because of -max-conns
+
+	clientTLSFailures atomic.Int64 // failed handshakes on the -tls-cert listener
+	milterTLSFailures atomic.Int64 // failed -upstream-tls handshakes
+
+	// Relayed traffic, indexed by direction: bytes written to the
+	// destination and frames by libmilter code name.
+	bytes  [2]atomic.Int64
+	frames [2]expvar.Map
+}
+
+// connSummary is the one line logged for every connection once it is over,
+// and the record written for it to the -access-log.
+type connSummary struct {
+	ID        int64         `json:"id"` // the connection number in the other log lines
+	Start     time.Time     `json:"start"`
+	Remote    string        `json:"remote"`
+	Upstream  string        `json:"upstream"` // empty if no milter could be reached
+	ClientTLS bool          `json:"client_tls"`
+	MilterTLS bool          `json:"milter_tls"`
+	Duration  time.Duration `json:"duration_ns"`
+	Reason    string        `json:"reason"` // why it ended: client EOF, milter EOF, a timeout or an error
+
+	ToMilterBytes  int64 `json:"client_to_milter_bytes"`
+	ToMilterFrames int64 `json:"client_to_milter_frames"`
+	ToMTABytes     int64 `json:"milter_to_client_bytes"`
+	ToMTAFrames    int64 `json:"milter_to_client_frames"`
+}
+
+func (c connSummary) String() string {
+	upstream := c.Upstream
+	if upstream == "" {
+		upstream = "-"
+	}
+	return fmt.Sprintf("conn=%d remote=%s upstream=%s client_tls=%t milter_tls=%t duration=%s reason=%q client->milter bytes=%d frames=%d milter->client bytes=%d frames=%d",
+		c.ID, c.Remote, upstream, c.ClientTLS, c.MilterTLS, c.Duration.Round(time.Millisecond), c.Reason,
+		c.ToMilterBytes, c.ToMilterFrames, c.ToMTABytes, c.ToMTAFrames)
+}
+
+// logStats reports the counters and how each milter fared; Shutdown calls
+// it last so every run ends with a summary.
+func (p *proxy) logStats() {
+	log.Printf("Connections: %d accepted, %d rejected by -max-conns, %d still open",
+		p.stats.accepted.Load(), p.stats.rejected.Load(), p.stats.open.Load())
+	if c, m := p.stats.clientTLSFailures.Load(), p.stats.milterTLSFailures.Load(); c > 0 || m > 0 {
+		log.Printf("TLS handshakes failed: %d with clients, %d with milters", c, m)
+	}
+	for _, b := range p.upstreams.backends {
+		log.Printf("Milter %s: %d connections, %d failed dials", b.addr, b.conns.Load(), b.dialFailures.Load())
+	}
+}
@@ -0,0 +1,56 @@
+package txproxy
+
+import (
+	"crypto/tls"
+	"net"
+	"time"
+)
+
+// serverTLSConfig loads -tls-cert and -tls-key for the client-facing
+// listener.
+func serverTLSConfig(certFile, keyFile string) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Config{Certificates: []tls.Certificate{cert}}, nil
+}
+
+// clientHandshake completes the handshake on a connection accepted from a
+// -tls-cert listener, within -header-timeout, so a failure is reported
+// against the client before a milter is dialed for it. Plain connections
+// pass through.
+func clientHandshake(conn net.Conn) error {
+	tc, ok := conn.(*tls.Conn)
+	if !ok {
+		return nil
+	}
+	if *headerTimeout > 0 {
+		tc.SetDeadline(time.Now().Add(*headerTimeout))
+	}
+	return tc.Handshake()
+}
+
+// upstreamHandshake starts TLS on a milter connection dialed for the
+// -upstream value addr, within -dial-timeout. Unless cfg names a server, the
+// milter's certificate has to match addr's host.
+func upstreamHandshake(conn net.Conn, addr string, cfg *tls.Config) (*tls.Conn, error) {
+	cfg = cfg.Clone()
+	if network, address := splitAddr(addr); cfg.ServerName == "" && network == "tcp" {
+		cfg.ServerName, _, _ = net.SplitHostPort(address)
+	}
+	tc := tls.Client(conn, cfg)
+	if *dialTimeout > 0 {
+		tc.SetDeadline(time.Now().Add(*dialTimeout))
+	}
+	if err := tc.Handshake(); err != nil {
+		return nil, err
+	}
+	return tc, nil
+}
+
+// isTLS reports whether conn is one leg of a session carried over TLS.
+func isTLS(conn net.Conn) bool {
+	_, ok := conn.(*tls.Conn)
+	return ok
+}
@@ -0,0 +1,201 @@
+package txproxy
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testCert writes a self-signed certificate for 127.0.0.1 and its key to a
+// temporary directory, and returns their paths and a pool trusting it.
+func testCert(t *testing.T) (certFile, keyFile string, roots *x509.CertPool) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "tproxy test"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1)},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	certFile, keyFile = filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	roots = x509.NewCertPool()
+	roots.AddCert(cert)
+	return certFile, keyFile, roots
+}
+
+// startTLSProxy runs a proxy with a TLS listener built from certFile and
+// keyFile, relaying to upstream with upstreamTLS (nil for plain).
+func startTLSProxy(t *testing.T, certFile, keyFile, upstream string, upstreamTLS *tls.Config) *proxy {
+	t.Helper()
+	cfg, err := serverTLSConfig(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := newProxy(tls.NewListener(ln, cfg), []string{upstream})
+	p.upstreamTLS = upstreamTLS
+	p.start()
+	t.Cleanup(func() { p.Shutdown(time.Second) })
+	return p
+}
+
+// tlsMilter is fakeMilter behind TLS with the certificate in certFile.
+func tlsMilter(t *testing.T, certFile, keyFile string) string {
+	t.Helper()
+	cfg, err := serverTLSConfig(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go answerPackets(ln, SMFIR_CONTINUE)
+	return ln.Addr().String()
+}
+
+func TestTLSBothLegs(t *testing.T) {
+	logs := captureLog(t)
+	certFile, keyFile, roots := testCert(t)
+	p := startTLSProxy(t, certFile, keyFile, tlsMilter(t, certFile, keyFile), &tls.Config{RootCAs: roots})
+
+	conn, err := tls.Dial("tcp", p.listener.Addr().String(), &tls.Config{RootCAs: roots})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	for i := 0; i < 3; i++ {
+		roundTrip(t, conn)
+	}
+	// Half-close works the same over TLS: the milter sees EOF and so do we.
+	if err := conn.CloseWrite(); err != nil {
+		t.Fatal(err)
+	}
+	if rest, err := io.ReadAll(conn); err != nil || len(rest) != 0 {
+		t.Fatalf("after half-close read %q, %v; want EOF", rest, err)
+	}
+
+	line := waitForLog(t, logs, "Connection summary:")
+	for _, want := range []string{"client_tls=true", "milter_tls=true", `reason="client EOF"`, "client->milter bytes=48 frames=3"} {
+		if !strings.Contains(line, want) {
+			t.Errorf("summary %q lacks %q", line, want)
+		}
+	}
+}
+
+func TestTLSHandshakeFailures(t *testing.T) {
+	logs := captureLog(t)
+	certFile, keyFile, roots := testCert(t)
+
+	t.Run("client", func(t *testing.T) {
+		p := startTLSProxy(t, certFile, keyFile, fakeMilter(t), nil)
+
+		// A plaintext milter packet is not a ClientHello.
+		plain, err := net.Dial("tcp", p.listener.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer plain.Close()
+		plain.SetDeadline(time.Now().Add(5 * time.Second))
+		plain.Write(frames(Message{Code: SMFIC_HELO, Data: []byte("mx.example\x00")}))
+		io.Copy(io.Discard, plain)
+		waitForLog(t, logs, "TLS handshake with "+plain.LocalAddr().String()+" failed")
+		if n := p.stats.clientTLSFailures.Load(); n != 1 {
+			t.Errorf("client TLS failures = %d, want 1", n)
+		}
+
+		// The accept loop carries on.
+		conn, err := tls.Dial("tcp", p.listener.Addr().String(), &tls.Config{RootCAs: roots})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+		roundTrip(t, conn)
+		if !strings.Contains(waitForLog(t, logs, "milter_tls=false"), "client_tls=true") {
+			t.Error("summary does not mark the client leg as TLS")
+		}
+	})
+
+	t.Run("milter", func(t *testing.T) {
+		// No RootCAs: the self-signed milter certificate is not trusted.
+		p := startTLSProxy(t, certFile, keyFile, tlsMilter(t, certFile, keyFile), &tls.Config{})
+		conn, err := tls.Dial("tcp", p.listener.Addr().String(), &tls.Config{RootCAs: roots})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+		io.Copy(io.Discard, conn)
+		if line := waitForLog(t, logs, "milter TLS handshake:"); !strings.Contains(line, "milter_tls=true") {
+			t.Errorf("summary %q does not mark the milter leg as TLS", line)
+		}
+		if n := p.stats.milterTLSFailures.Load(); n != 1 {
+			t.Errorf("milter TLS failures = %d, want 1", n)
+		}
+	})
+}
+
+func TestUpstreamTLSInsecure(t *testing.T) {
+	certFile, keyFile, _ := testCert(t)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := newProxy(ln, []string{tlsMilter(t, certFile, keyFile)})
+	p.upstreamTLS = &tls.Config{InsecureSkipVerify: true}
+	p.start()
+	t.Cleanup(func() { p.Shutdown(time.Second) })
+
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	roundTrip(t, conn)
+}
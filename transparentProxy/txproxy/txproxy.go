@@ -0,0 +1,280 @@
+// Package txproxy is a transparent proxy between MTAs and a milter: it relays
+// milter frames both ways and can log, rewrite, delay, record and replay
+// them.
+package txproxy
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// flags are the command line of Run, other than replay's.
+var flags = flag.NewFlagSet("txproxy", flag.ExitOnError)
+
+func init() {
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "usage: tproxy [flags]\n       tproxy replay [flags] recording.mrec\n")
+		flags.PrintDefaults()
+	}
+	flags.Var(&upstreams, "upstream", "milter host:port or unix:/path; repeat or comma-separate to list several (env PROXY_UPSTREAM, default 127.0.0.1:1234)")
+	flags.Var(&rewrites, "rewrite", "change milter responses on their way to the MTA: from->to (e.g. reject->accept, tempfail->continue) or strip-name (e.g. strip-addheader); repeat or comma-separate")
+}
+
+var (
+	listenAddr = flags.String("listen", envOr("PROXY_LISTEN", "0.0.0.0:2525"), "host:port or unix:/path to accept MTA connections on (env PROXY_LISTEN)")
+	socketMode = flags.String("socket-mode", "0660", "permissions, in octal, of a unix:/path -listen socket")
+	upstreams  addrList     // -upstream, registered in init
+	rewrites   rewriteRules // -rewrite, registered in init
+
+	logPayload    = flags.String("log-payload", payloadOff, "log packet payloads: off, ascii (non-printables escaped) or hex (dump)")
+	logPayloadMax = flags.Int("log-payload-max", 256, "bytes of each payload to log before truncating")
+
+	// libmilter itself never sends more than a 64 KiB body chunk plus a
+	// little framing.
+	maxFrame = flags.Int("max-frame", 1<<20, "largest milter packet, in bytes, either side may send; a longer one closes that connection")
+
+	tlsCert             = flags.String("tls-cert", "", "serve TLS to MTAs with the certificate in `file` (needs -tls-key)")
+	tlsKey              = flags.String("tls-key", "", "private key `file` for -tls-cert")
+	upstreamTLS         = flags.Bool("upstream-tls", false, "speak TLS to the milter")
+	upstreamTLSInsecure = flags.Bool("upstream-tls-insecure", false, "with -upstream-tls, accept any certificate from the milter (self-signed lab setups)")
+
+	delayMS       = flags.Int("delay-ms", 0, "chaos: hold back every milter -> client frame this many milliseconds")
+	delayJitterMS = flags.Int("delay-jitter-ms", 0, "chaos: add a random 0 to this many milliseconds to each -delay-ms")
+	dropAfter     = flags.Int("drop-after", 0, "chaos: close each connection once this many frames have been relayed (0 = never)")
+	tempfailEvery = flags.Int("tempfail-every", 0, "chaos: send the MTA SMFIR_TEMPFAIL in place of every Nth verdict of a connection (0 = never)")
+
+	sendProxy = flags.String("send-proxy", sendProxyOff, "write a PROXY protocol header carrying the client address to the milter before any frames: off, v1 or v2")
+
+	dialTimeout   = flags.Duration("dial-timeout", 10*time.Second, "how long to wait for the milter to accept a connection")
+	headerTimeout = flags.Duration("header-timeout", 30*time.Second, "how long a new client has to send its first packet (0 = no limit)")
+	idleTimeout   = flags.Duration("idle-timeout", 5*time.Minute, "close a session after this long without a packet in either direction (0 = never)")
+
+	cooldown = flags.Duration("cooldown", 30*time.Second, "how long to skip a milter after repeated dial failures")
+
+	recordDir = flags.String("record", "", "write every conversation to a `dir`ectory, one file per connection, for the replay subcommand")
+
+	accessLogPath = flags.String("access-log", "", "append one JSON object per finished connection to `file`; SIGUSR1 reopens it after rotation")
+
+	adminAddr = flags.String("admin-addr", "", "serve live counters as expvar JSON at http://`host:port`/debug/vars (off by default)")
+
+	maxConns     = flags.Int("max-conns", 0, "most connections relayed at once; more are closed on arrival (0 = no limit)")
+	drainTimeout = flags.Duration("drain-timeout", 30*time.Second, "on SIGTERM/SIGINT, how long to let open connections finish before closing them")
+)
+
+// exitError is an error Run returns with an exit status other than 1,
+// which the commands exit with.
+type exitError struct {
+	code int
+	err  error
+}
+
+func (e exitError) Error() string { return e.err.Error() }
+func (e exitError) Unwrap() error { return e.err }
+func (e exitError) ExitCode() int { return e.code }
+
+// Run runs the proxy until SIGTERM or SIGINT and a drain, or with "replay"
+// as the first argument, a replay. It returns an error if the drain had to
+// close connections, or the milter did not answer as recorded.
+func Run(args []string) error {
+	if len(args) > 0 && args[0] == "replay" {
+		return replayMain(args[1:])
+	}
+
+	flags.Parse(args)
+	switch *logPayload {
+	case payloadOff, payloadASCII, payloadHex:
+	default:
+		return fmt.Errorf("unknown -log-payload %q (want off, ascii or hex)", *logPayload)
+	}
+	for name, v := range map[string]int{"delay-ms": *delayMS, "delay-jitter-ms": *delayJitterMS, "drop-after": *dropAfter, "tempfail-every": *tempfailEvery} {
+		if v < 0 {
+			return fmt.Errorf("invalid -%s %d (want 0 or more)", name, v)
+		}
+	}
+	if *maxFrame < 1 {
+		return fmt.Errorf("invalid -max-frame %d (want at least 1)", *maxFrame)
+	}
+	switch *sendProxy {
+	case sendProxyOff, sendProxyV1, sendProxyV2:
+	default:
+		return fmt.Errorf("unknown -send-proxy %q (want off, v1 or v2)", *sendProxy)
+	}
+	if len(upstreams) == 0 {
+		upstreams.Set(envOr("PROXY_UPSTREAM", "127.0.0.1:1234"))
+	}
+
+	if *recordDir != "" {
+		if fi, err := os.Stat(*recordDir); err != nil || !fi.IsDir() {
+			return fmt.Errorf("-record %s: not a directory", *recordDir)
+		}
+	}
+
+	if (*tlsCert == "") != (*tlsKey == "") {
+		return errors.New("-tls-cert and -tls-key must be given together")
+	}
+
+	mode, err := strconv.ParseUint(*socketMode, 8, 32)
+	if err != nil || mode > 0o777 {
+		return fmt.Errorf("invalid -socket-mode %q (want octal permissions such as 0660)", *socketMode)
+	}
+
+	// Catch typos at startup rather than on the first connection.
+	for _, addr := range append([]string{*listenAddr}, upstreams...) {
+		network, address := splitAddr(addr)
+		if network == "unix" {
+			if address == "" {
+				return fmt.Errorf("invalid address %q: empty socket path", addr)
+			}
+			continue
+		}
+		if _, err := net.ResolveTCPAddr(network, address); err != nil {
+			return fmt.Errorf("invalid address %q: %w", addr, err)
+		}
+	}
+
+	listener, err := listen(*listenAddr, fs.FileMode(mode))
+	if err != nil {
+		return fmt.Errorf("starting proxy: %w", err)
+	}
+	if *tlsCert != "" {
+		cfg, err := serverTLSConfig(*tlsCert, *tlsKey)
+		if err != nil {
+			return fmt.Errorf("loading TLS certificate: %w", err)
+		}
+		listener = tls.NewListener(listener, cfg)
+	}
+
+	// Start the proxy
+	log.Printf("Starting proxy on %s, forwarding to %s\n", listener.Addr(), strings.Join(upstreams, ", "))
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+	p := newProxy(listener, upstreams)
+	if *upstreamTLS {
+		p.upstreamTLS = &tls.Config{InsecureSkipVerify: *upstreamTLSInsecure}
+	}
+	if *accessLogPath != "" {
+		accessLog, err := openAccessLog(*accessLogPath)
+		if err != nil {
+			return fmt.Errorf("opening access log: %w", err)
+		}
+		p.accessLog = accessLog
+		accessLog.reopenOnSIGUSR1()
+	}
+	p.start()
+	if *adminAddr != "" {
+		adminListener, err := net.Listen("tcp", *adminAddr)
+		if err != nil {
+			return fmt.Errorf("starting admin endpoint: %w", err)
+		}
+		p.serveAdmin(adminListener)
+	}
+	if runUntilSignal(p, *drainTimeout, sigs) != 0 {
+		return errors.New("drain incomplete, connections closed")
+	}
+	return nil
+}
+
+// addrList collects -upstream values, whether repeated or comma-separated.
+type addrList []string
+
+func (l *addrList) String() string { return strings.Join(*l, ",") }
+
+func (l *addrList) Set(value string) error {
+	for _, addr := range strings.Split(value, ",") {
+		if addr = strings.TrimSpace(addr); addr != "" {
+			*l = append(*l, addr)
+		}
+	}
+	return nil
+}
+
+func envOr(key, fallback string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return fallback
+}
+
+type Message struct {
+	Code byte
+	Data []byte
+}
+
+// Errors ReadPacket returns for a length word it will not act on.
+var (
+	ErrEmptyFrame    = errors.New("empty frame")
+	ErrFrameTooLarge = errors.New("frame too large")
+)
+
+// ReadPacket reads incoming milter packet
+func ReadPacket(sock io.Reader) (*Message, error) {
+	// read packet length
+	var length uint32
+	if err := binary.Read(sock, binary.BigEndian, &length); err != nil {
+		return nil, err
+	}
+	// every packet carries at least its code byte
+	if length == 0 {
+		return nil, ErrEmptyFrame
+	}
+	// a corrupt or hostile length word must not make us allocate up to 4 GiB
+	if int64(length) > int64(*maxFrame) {
+		return nil, fmt.Errorf("%w: length %d exceeds -max-frame %d", ErrFrameTooLarge, length, *maxFrame)
+	}
+
+	// read packet data
+	data := make([]byte, length)
+	if _, err := io.ReadFull(sock, data); err != nil {
+		return nil, err
+	}
+
+	// prepare response data
+	message := Message{
+		Code: data[0],
+		Data: data[1:],
+	}
+
+	return &message, nil
+}
+
+// WritePacket sends a milter response packet to socket stream
+func WritePacket(sock io.Writer, msg *Message) error {
+	buffer := bufio.NewWriter(sock)
+
+	// calculate and write response length
+	length := uint32(len(msg.Data) + 1)
+	if err := binary.Write(buffer, binary.BigEndian, length); err != nil {
+		return err
+	}
+
+	// write response code
+	if err := buffer.WriteByte(msg.Code); err != nil {
+		return err
+	}
+
+	// write response data
+	if _, err := buffer.Write(msg.Data); err != nil {
+		return err
+	}
+
+	// flush data to network socket stream
+	if err := buffer.Flush(); err != nil {
+		return err
+	}
+
+	return nil
+}
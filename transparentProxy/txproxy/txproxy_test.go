@@ -0,0 +1,746 @@
+package txproxy
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"reflect"
+	"runtime"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// fakeMilter answers every packet it reads with SMFIR_CONTINUE and returns
+// its address.
+func fakeMilter(t *testing.T) string {
+	t.Helper()
+	return startFakeMilter(t).Addr().String()
+}
+
+// startFakeMilter is fakeMilter for tests that need to stop it; closing
+// the listener refuses new connections but leaves open ones working.
+func startFakeMilter(t *testing.T) net.Listener {
+	t.Helper()
+	return startAnsweringMilter(t, SMFIR_CONTINUE)
+}
+
+// startAnsweringMilter answers every packet with code.
+func startAnsweringMilter(t *testing.T, code byte) net.Listener {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go answerPackets(ln, code)
+	return ln
+}
+
+// answerPackets accepts connections on ln until it is closed and answers
+// every packet on them with code.
+func answerPackets(ln net.Listener, code byte) {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			defer conn.Close()
+			for {
+				if _, err := ReadPacket(conn); err != nil {
+					return
+				}
+				if err := WritePacket(conn, &Message{Code: code}); err != nil {
+					return
+				}
+			}
+		}()
+	}
+}
+
+// startTestProxy runs startProxy on a free loopback port; its address is
+// p.listener.Addr().
+func startTestProxy(t *testing.T, upstreams ...string) *proxy {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := startProxy(ln, upstreams)
+	t.Cleanup(func() { p.Shutdown(time.Second) })
+	return p
+}
+
+// roundTrip sends one HELO on conn and expects the fake milter's CONTINUE.
+func roundTrip(t *testing.T, conn net.Conn) {
+	t.Helper()
+	if err := WritePacket(conn, &Message{Code: SMFIC_HELO, Data: []byte("mx.example\x00")}); err != nil {
+		t.Fatal(err)
+	}
+	msg, err := ReadPacket(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.Code != SMFIR_CONTINUE || len(msg.Data) != 0 {
+		t.Fatalf("reply = %q %q, want SMFIR_CONTINUE", msg.Code, msg.Data)
+	}
+}
+
+func TestAddrList(t *testing.T) {
+	var l addrList
+	l.Set("a:1, b:2")
+	l.Set("c:3")
+	l.Set(",")
+	if want := (addrList{"a:1", "b:2", "c:3"}); !reflect.DeepEqual(l, want) {
+		t.Fatalf("addrList = %q, want %q", l, want)
+	}
+}
+
+func TestProxyRelaysFrames(t *testing.T) {
+	p := startTestProxy(t, fakeMilter(t))
+
+	conn, err := net.Dial("tcp", p.listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	for i := 0; i < 3; i++ {
+		roundTrip(t, conn)
+	}
+}
+
+func TestBadLengthDropsOnlyThatConnection(t *testing.T) {
+	logs := captureLog(t)
+	p := startTestProxy(t, fakeMilter(t))
+
+	for _, tc := range []struct {
+		name   string
+		header []byte
+	}{
+		{"zero length", []byte{0, 0, 0, 0}},
+		{"oversized length", []byte{0xff, 0xff, 0xff, 0xff}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			bystander, err := net.Dial("tcp", p.listener.Addr().String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer bystander.Close()
+			roundTrip(t, bystander)
+
+			bad, err := net.Dial("tcp", p.listener.Addr().String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer bad.Close()
+			if _, err := bad.Write(tc.header); err != nil {
+				t.Fatal(err)
+			}
+			bad.SetReadDeadline(time.Now().Add(2 * time.Second))
+			if n, err := bad.Read(make([]byte, 1)); err == nil {
+				t.Fatalf("read %d bytes after a bad frame, want the connection closed", n)
+			} else if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				t.Fatal("connection left open after a bad frame")
+			}
+			waitForLog(t, logs, "Bad packet from client "+bad.LocalAddr().String())
+
+			// The proxy is still up for the open connection and for new ones.
+			roundTrip(t, bystander)
+			fresh, err := net.Dial("tcp", p.listener.Addr().String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer fresh.Close()
+			roundTrip(t, fresh)
+		})
+	}
+}
+
+// readOverPipe feeds raw to ReadPacket through a net.Pipe, so a short input
+// reaches it as a connection going away mid-packet.
+func readOverPipe(raw []byte) (*Message, error) {
+	client, server := net.Pipe()
+	defer server.Close()
+	go func() {
+		client.Write(raw)
+		client.Close()
+	}()
+	return ReadPacket(server)
+}
+
+func TestReadPacketMalformed(t *testing.T) {
+	m := *maxFrame
+	t.Cleanup(func() { *maxFrame = m })
+	*maxFrame = 16
+
+	for _, tc := range []struct {
+		name string
+		raw  []byte
+		code byte  // of the packet, if one is read
+		err  error // matched with errors.Is
+	}{
+		{"zero length", []byte{0, 0, 0, 0}, 0, ErrEmptyFrame},
+		{"all ones length", []byte{0xff, 0xff, 0xff, 0xff}, 0, ErrFrameTooLarge},
+		{"one over -max-frame", append([]byte{0, 0, 0, 17}, make([]byte, 17)...), 0, ErrFrameTooLarge},
+		{"exactly -max-frame", append([]byte{0, 0, 0, 16, 'C'}, make([]byte, 15)...), 'C', nil},
+		{"code only", []byte{0, 0, 0, 1, 'Q'}, 'Q', nil},
+		{"nothing", nil, 0, io.EOF},
+		{"truncated length", []byte{0, 0}, 0, io.ErrUnexpectedEOF},
+		{"truncated payload", []byte{0, 0, 0, 10, 'H', 'm', 'x'}, 0, io.ErrUnexpectedEOF},
+		{"length but no payload", []byte{0, 0, 0, 1}, 0, io.EOF},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			msg, err := readOverPipe(tc.raw)
+			if !errors.Is(err, tc.err) {
+				t.Fatalf("ReadPacket err = %v, want %v", err, tc.err)
+			}
+			if err == nil && msg.Code != tc.code {
+				t.Fatalf("ReadPacket code = %q, want %q", msg.Code, tc.code)
+			}
+		})
+	}
+}
+
+func FuzzReadPacket(f *testing.F) {
+	f.Add([]byte{0, 0, 0, 0})
+	f.Add([]byte{0xff, 0xff, 0xff, 0xff})
+	f.Add([]byte{0, 0, 0, 5, 'H', 'm', 'x', 0})
+	f.Add(frames(Message{Code: SMFIC_QUIT}))
+	f.Fuzz(func(t *testing.T, raw []byte) {
+		msg, err := readOverPipe(raw)
+		if err != nil {
+			return
+		}
+		// Whatever was read came from raw and respected its length word.
+		length := binary.BigEndian.Uint32(raw)
+		if int64(length) > int64(*maxFrame) || uint32(1+len(msg.Data)) != length {
+			t.Fatalf("read a %d-byte packet from length word %d", 1+len(msg.Data), length)
+		}
+	})
+}
+
+func TestSIGTERMDrainsOpenConnections(t *testing.T) {
+	p := startTestProxy(t, fakeMilter(t))
+	addr := p.listener.Addr().String()
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGTERM)
+	defer signal.Stop(sigs)
+	exit := make(chan int, 1)
+	go func() { exit <- runUntilSignal(p, 10*time.Second, sigs) }()
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	roundTrip(t, conn)
+
+	self, _ := os.FindProcess(os.Getpid())
+	if err := self.Signal(syscall.SIGTERM); err != nil {
+		t.Fatal(err)
+	}
+	// Wait for the listener to go away, then keep using the old connection.
+	for deadline := time.Now().Add(time.Second); ; time.Sleep(10 * time.Millisecond) {
+		c, err := net.Dial("tcp", addr)
+		if err != nil {
+			break
+		}
+		c.Close()
+		if time.Now().After(deadline) {
+			t.Fatal("proxy still accepting after SIGTERM")
+		}
+	}
+	for end := time.Now().Add(2 * time.Second); time.Now().Before(end); time.Sleep(100 * time.Millisecond) {
+		roundTrip(t, conn)
+	}
+	select {
+	case code := <-exit:
+		t.Fatalf("runUntilSignal returned %d with a connection still open", code)
+	default:
+	}
+
+	conn.Close()
+	if code := <-exit; code != 0 {
+		t.Fatalf("exit code after clean drain = %d, want 0", code)
+	}
+}
+
+func TestDrainTimeoutForcesClose(t *testing.T) {
+	p := startTestProxy(t, fakeMilter(t))
+	conn, err := net.Dial("tcp", p.listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	roundTrip(t, conn)
+
+	sigs := make(chan os.Signal, 1)
+	sigs <- syscall.SIGTERM
+	if code := runUntilSignal(p, 100*time.Millisecond, sigs); code != 1 {
+		t.Fatalf("exit code after forced close = %d, want 1", code)
+	}
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := ReadPacket(conn); err != io.EOF {
+		t.Fatalf("read after forced close: %v, want EOF", err)
+	}
+}
+
+func TestHalfCloseStillDeliversReply(t *testing.T) {
+	p := startTestProxy(t, fakeMilter(t))
+	conn, err := net.Dial("tcp", p.listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// Like an MTA sending its last command and shutting down its side.
+	if err := WritePacket(conn, &Message{Code: SMFIC_HELO, Data: []byte("mx.example\x00")}); err != nil {
+		t.Fatal(err)
+	}
+	conn.(*net.TCPConn).CloseWrite()
+
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	msg, err := ReadPacket(conn)
+	if err != nil || msg.Code != SMFIR_CONTINUE {
+		t.Fatalf("reply after half-close = %v, %v; want SMFIR_CONTINUE", msg, err)
+	}
+	if _, err := ReadPacket(conn); err != io.EOF {
+		t.Fatalf("after reply: %v, want EOF once the milter hung up", err)
+	}
+}
+
+func TestNoGoroutineLeak(t *testing.T) {
+	p := startTestProxy(t, fakeMilter(t))
+	addr := p.listener.Addr().String()
+	baseline := runtime.NumGoroutine()
+
+	for i := 0; i < 100; i++ {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		roundTrip(t, conn)
+		conn.Close()
+	}
+
+	var n int
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(20 * time.Millisecond) {
+		if n = runtime.NumGoroutine(); n <= baseline {
+			return
+		}
+	}
+	t.Fatalf("%d goroutines after 100 closed connections, baseline %d", n, baseline)
+}
+
+func TestMaxConns(t *testing.T) {
+	defer func(n int) { *maxConns = n }(*maxConns)
+	*maxConns = 1
+	p := startTestProxy(t, fakeMilter(t))
+	addr := p.listener.Addr().String()
+
+	first, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	roundTrip(t, first)
+
+	second, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer second.Close()
+	second.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := ReadPacket(second); err != io.EOF {
+		t.Fatalf("connection over the limit: %v, want EOF", err)
+	}
+	if got := p.stats.rejected.Load(); got != 1 {
+		t.Fatalf("rejected = %d, want 1", got)
+	}
+
+	// Freeing the slot lets the next connection through.
+	first.Close()
+	for deadline := time.Now().Add(2 * time.Second); p.stats.open.Load() != 0; time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("first connection never finished")
+		}
+	}
+	third, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer third.Close()
+	roundTrip(t, third)
+	if got := p.stats.accepted.Load(); got != 2 {
+		t.Fatalf("accepted = %d, want 2", got)
+	}
+}
+
+// failingListener fails Accept with EMFILE, recording when, until calls is
+// full; then it reports itself closed.
+type failingListener struct {
+	net.Listener
+	calls chan time.Time
+}
+
+func (l *failingListener) Accept() (net.Conn, error) {
+	select {
+	case l.calls <- time.Now():
+		return nil, syscall.EMFILE
+	default:
+		return nil, net.ErrClosed
+	}
+}
+
+func TestAcceptErrorBackoff(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	fl := &failingListener{Listener: ln, calls: make(chan time.Time, 6)}
+
+	p := startProxy(fl, []string{"127.0.0.1:1"})
+	<-p.acceptDone
+	close(fl.calls)
+
+	var gaps []time.Duration
+	var last time.Time
+	for at := range fl.calls {
+		if !last.IsZero() {
+			gaps = append(gaps, at.Sub(last))
+		}
+		last = at
+	}
+	// 5ms, 10ms, 20ms, 40ms, 80ms between the six failures.
+	for i, gap := range gaps {
+		if want := 5 * time.Millisecond << i; gap < want {
+			t.Errorf("gap %d = %s, want at least %s", i, gap, want)
+		}
+	}
+}
+
+func TestTimeouts(t *testing.T) {
+	// Restore after the proxy's own cleanup has stopped its handlers.
+	h, i := *headerTimeout, *idleTimeout
+	t.Cleanup(func() { *headerTimeout, *idleTimeout = h, i })
+	*headerTimeout, *idleTimeout = 100*time.Millisecond, 200*time.Millisecond
+	p := startTestProxy(t, fakeMilter(t))
+	addr := p.listener.Addr().String()
+
+	dial := func() net.Conn {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { conn.Close() })
+		return conn
+	}
+	// closedAfter waits for the proxy to hang up on conn and says how long
+	// that took.
+	closedAfter := func(conn net.Conn) time.Duration {
+		start := time.Now()
+		conn.SetReadDeadline(start.Add(2 * time.Second))
+		if _, err := ReadPacket(conn); err != io.EOF {
+			t.Fatalf("waiting for the proxy to close: %v, want EOF", err)
+		}
+		return time.Since(start)
+	}
+
+	t.Run("header", func(t *testing.T) {
+		if d := closedAfter(dial()); d > time.Second {
+			t.Fatalf("silent client closed after %s, want about %s", d, *headerTimeout)
+		}
+	})
+
+	t.Run("idle", func(t *testing.T) {
+		conn := dial()
+		roundTrip(t, conn)
+		if d := closedAfter(conn); d < 150*time.Millisecond || d > time.Second {
+			t.Fatalf("idle session closed after %s, want about %s", d, *idleTimeout)
+		}
+	})
+
+	t.Run("activity keeps it open", func(t *testing.T) {
+		conn := dial()
+		// Well past both timeouts, but never idle for long.
+		for end := time.Now().Add(600 * time.Millisecond); time.Now().Before(end); time.Sleep(50 * time.Millisecond) {
+			roundTrip(t, conn)
+		}
+	})
+}
+
+func TestFailover(t *testing.T) {
+	a, b := startFakeMilter(t), startFakeMilter(t)
+	p := startTestProxy(t, a.Addr().String(), b.Addr().String())
+	addr := p.listener.Addr().String()
+	session := func() {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		roundTrip(t, conn)
+	}
+	backendA, backendB := p.upstreams.backends[0], p.upstreams.backends[1]
+
+	for i := 0; i < 4; i++ {
+		session()
+	}
+	if backendA.conns.Load() != 2 || backendB.conns.Load() != 2 {
+		t.Fatalf("round robin: a=%d b=%d, want 2 each", backendA.conns.Load(), backendB.conns.Load())
+	}
+
+	a.Close()
+	for i := 0; i < 10; i++ {
+		session()
+	}
+	if got := backendB.conns.Load(); got != 12 {
+		t.Fatalf("survivor took %d connections, want 12", got)
+	}
+	// Two refused dials put a in cooldown; after that it is not tried.
+	if got := backendA.dialFailures.Load(); got != maxDialFailures {
+		t.Fatalf("dead backend dialed %d times, want %d", got, maxDialFailures)
+	}
+}
+
+// logBuffer collects log output for the duration of a test.
+type logBuffer struct {
+	mu sync.Mutex
+	b  bytes.Buffer
+}
+
+func captureLog(t *testing.T) *logBuffer {
+	t.Helper()
+	l := &logBuffer{}
+	log.SetOutput(l)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+	return l
+}
+
+func (l *logBuffer) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.b.Write(p)
+}
+
+func (l *logBuffer) String() string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.b.String()
+}
+
+func TestShutdownLogsStats(t *testing.T) {
+	logs := captureLog(t)
+	dead := startFakeMilter(t)
+	dead.Close()
+	live := fakeMilter(t)
+	p := startTestProxy(t, dead.Addr().String(), live)
+
+	conn, err := net.Dial("tcp", p.listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	roundTrip(t, conn)
+	conn.Close()
+	if !p.Shutdown(time.Second) {
+		t.Fatal("connection did not drain")
+	}
+
+	out := logs.String()
+	for _, want := range []string{
+		"Connections: 1 accepted, 0 rejected by -max-conns, 0 still open",
+		"Milter " + dead.Addr().String() + ": 0 connections, 1 failed dials",
+		"Milter " + live + ": 1 connections, 0 failed dials",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("no %q in log:\n%s", want, out)
+		}
+	}
+}
+
+// waitForLog polls logs until a line containing substr shows up and returns it.
+func waitForLog(t *testing.T, logs *logBuffer, substr string) string {
+	t.Helper()
+	for deadline := time.Now().Add(2 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		for _, line := range strings.Split(logs.String(), "\n") {
+			if strings.Contains(line, substr) {
+				return line
+			}
+		}
+	}
+	t.Fatalf("no %q in log:\n%s", substr, logs.String())
+	return ""
+}
+
+// hangupMilter answers one packet per connection with SMFIR_CONTINUE and
+// then closes it.
+func hangupMilter(t *testing.T) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			if _, err := ReadPacket(conn); err == nil {
+				WritePacket(conn, &Message{Code: SMFIR_CONTINUE})
+			}
+			conn.Close()
+		}
+	}()
+	return ln.Addr().String()
+}
+
+func TestConnectionSummary(t *testing.T) {
+	h := *headerTimeout
+	t.Cleanup(func() { *headerTimeout = h })
+	*headerTimeout = 100 * time.Millisecond
+
+	// A HELO as sent by roundTrip is 16 bytes on the wire, CONTINUE 5.
+	for _, tc := range []struct {
+		name   string
+		milter func(t *testing.T) string
+		client func(t *testing.T, conn net.Conn)
+		want   string
+	}{
+		{
+			name:   "client hangs up",
+			milter: fakeMilter,
+			client: func(t *testing.T, conn net.Conn) {
+				roundTrip(t, conn)
+				conn.(*net.TCPConn).CloseWrite()
+			},
+			want: `reason="client EOF" client->milter bytes=16 frames=1 milter->client bytes=5 frames=1`,
+		},
+		{
+			name:   "milter hangs up",
+			milter: hangupMilter,
+			client: func(t *testing.T, conn net.Conn) {
+				roundTrip(t, conn)
+				// The proxy passes the hangup on; the MTA then closes too.
+				if _, err := ReadPacket(conn); err != io.EOF {
+					t.Errorf("after the milter hung up: %v, want EOF", err)
+				}
+				conn.Close()
+			},
+			want: `reason="milter EOF" client->milter bytes=16 frames=1 milter->client bytes=5 frames=1`,
+		},
+		{
+			name:   "silent client",
+			milter: fakeMilter,
+			client: func(t *testing.T, conn net.Conn) {},
+			want:   `reason="header timeout" client->milter bytes=0 frames=0 milter->client bytes=0 frames=0`,
+		},
+		{
+			name:   "bad frame",
+			milter: fakeMilter,
+			client: func(t *testing.T, conn net.Conn) {
+				roundTrip(t, conn)
+				conn.Write([]byte{0, 0, 0, 0})
+			},
+			want: `reason="client -> milter error: reading packet: empty frame" client->milter bytes=16 frames=1`,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			logs := captureLog(t)
+			milter := tc.milter(t)
+			p := startTestProxy(t, milter)
+			conn, err := net.Dial("tcp", p.listener.Addr().String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+			tc.client(t, conn)
+
+			line := waitForLog(t, logs, "Connection summary:")
+			for _, want := range []string{"remote=" + conn.LocalAddr().String(), "upstream=" + milter, tc.want} {
+				if !strings.Contains(line, want) {
+					t.Errorf("summary %q lacks %q", line, want)
+				}
+			}
+		})
+	}
+
+	t.Run("no milter", func(t *testing.T) {
+		logs := captureLog(t)
+		dead := startFakeMilter(t)
+		dead.Close()
+		p := startTestProxy(t, dead.Addr().String())
+		conn, err := net.Dial("tcp", p.listener.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		line := waitForLog(t, logs, "Connection summary:")
+		if !strings.Contains(line, "upstream=- ") || !strings.Contains(line, `reason="no milter reachable`) {
+			t.Errorf("summary %q does not say no milter was reached", line)
+		}
+	})
+}
+
+// scriptedMilter accepts one connection, writes reply to it, and reports
+// every byte it received once the proxy half-closes.
+func scriptedMilter(t *testing.T, reply []byte) (addr string, received <-chan []byte) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	got := make(chan []byte, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.Write(reply)
+		data, _ := io.ReadAll(conn)
+		got <- data
+	}()
+	return ln.Addr().String(), got
+}
+
+// converse sends raw bytes through the proxy at addr, half-closes, and
+// returns everything that came back.
+func converse(t *testing.T, addr string, send []byte) []byte {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	if _, err := conn.Write(send); err != nil {
+		t.Fatal(err)
+	}
+	conn.(*net.TCPConn).CloseWrite()
+	data, err := io.ReadAll(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// frames concatenates packets as they appear on the wire.
+func frames(msgs ...Message) []byte {
+	var b bytes.Buffer
+	for i := range msgs {
+		WritePacket(&b, &msgs[i])
+	}
+	return b.Bytes()
+}
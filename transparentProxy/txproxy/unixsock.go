@@ -0,0 +1,78 @@
+package txproxy
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+// unixPrefix marks an -listen or -upstream value as a unix socket path, as
+// in unix:/var/run/milter.sock.
+const unixPrefix = "unix:"
+
+// splitAddr maps an -listen or -upstream value to the network and address
+// net.Listen and net.Dial take: "unix:/path" is a unix socket, anything else
+// is host:port over TCP.
+func splitAddr(addr string) (network, address string) {
+	if path, ok := strings.CutPrefix(addr, unixPrefix); ok {
+		return "unix", path
+	}
+	return "tcp", addr
+}
+
+// listen opens the -listen socket. A unix socket file left behind by a proxy
+// that did not shut down cleanly is removed first, and the new one gets
+// mode. Closing the listener removes the file again.
+func listen(addr string, mode fs.FileMode) (net.Listener, error) {
+	network, address := splitAddr(addr)
+	if network != "unix" {
+		return net.Listen(network, address)
+	}
+	if err := removeStaleSocket(address); err != nil {
+		return nil, err
+	}
+	ln, err := net.Listen(network, address)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(address, mode); err != nil {
+		ln.Close()
+		return nil, err
+	}
+	return ln, nil
+}
+
+// removeStaleSocket deletes the socket file at path unless something still
+// accepts connections on it. Anything other than a socket is left alone.
+func removeStaleSocket(path string) error {
+	fi, err := os.Lstat(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if fi.Mode().Type() != fs.ModeSocket {
+		return fmt.Errorf("%s exists and is not a socket", path)
+	}
+	if conn, err := net.DialTimeout("unix", path, time.Second); err == nil {
+		conn.Close()
+		return fmt.Errorf("%s is in use by another process", path)
+	}
+	log.Printf("Removing stale socket %s", path)
+	return os.Remove(path)
+}
+
+// clientName identifies a client connection in logs. Unix clients are
+// usually unnamed ("@"), so they go by the socket they connected to.
+func clientName(conn net.Conn) string {
+	if _, ok := conn.RemoteAddr().(*net.UnixAddr); ok {
+		return unixPrefix + conn.LocalAddr().String()
+	}
+	return conn.RemoteAddr().String()
+}
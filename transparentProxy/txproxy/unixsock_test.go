@@ -0,0 +1,161 @@
+package txproxy
+
+import (
+	"errors"
+	"io/fs"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSplitAddr(t *testing.T) {
+	tests := []struct {
+		addr             string
+		network, address string
+	}{
+		{"127.0.0.1:1234", "tcp", "127.0.0.1:1234"},
+		{"[::1]:1234", "tcp", "[::1]:1234"},
+		{"unix:/var/run/milter.sock", "unix", "/var/run/milter.sock"},
+		{"unix:milter.sock", "unix", "milter.sock"},
+	}
+	for _, tt := range tests {
+		network, address := splitAddr(tt.addr)
+		if network != tt.network || address != tt.address {
+			t.Errorf("splitAddr(%q) = %q, %q, want %q, %q", tt.addr, network, address, tt.network, tt.address)
+		}
+	}
+}
+
+// unixMilter is startAnsweringMilter on a unix socket in a temporary
+// directory; it returns the -upstream value for it.
+func unixMilter(t *testing.T) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "milter.sock")
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go answerPackets(ln, SMFIR_CONTINUE)
+	return unixPrefix + path
+}
+
+func TestUnixSockets(t *testing.T) {
+	logs := captureLog(t)
+	path := filepath.Join(t.TempDir(), "tproxy.sock")
+	ln, err := listen(unixPrefix+path, 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := startProxy(ln, []string{unixMilter(t)})
+	shutdown := false
+	t.Cleanup(func() {
+		if !shutdown {
+			p.Shutdown(time.Second)
+		}
+	})
+
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode().Type() != fs.ModeSocket || fi.Mode().Perm() != 0o600 {
+		t.Fatalf("socket mode = %v, want a socket with 0600", fi.Mode())
+	}
+
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	for i := 0; i < 3; i++ {
+		roundTrip(t, conn)
+	}
+	conn.Close()
+	line := waitForLog(t, logs, "Connection summary:")
+	if !strings.Contains(line, "remote=unix:"+path) || !strings.Contains(line, "upstream=unix:") {
+		t.Errorf("summary %q does not name the unix sockets", line)
+	}
+
+	shutdown = true
+	if !p.Shutdown(time.Second) {
+		t.Fatal("Shutdown did not drain")
+	}
+	if _, err := os.Stat(path); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("socket file still there after shutdown: %v", err)
+	}
+}
+
+func TestListenRemovesStaleSocket(t *testing.T) {
+	dir := t.TempDir()
+
+	stale := filepath.Join(dir, "stale.sock")
+	ln, err := net.Listen("unix", stale)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Leave the file behind, as a killed proxy would.
+	ln.(*net.UnixListener).SetUnlinkOnClose(false)
+	ln.Close()
+	ln, err = listen(unixPrefix+stale, 0o660)
+	if err != nil {
+		t.Fatalf("listen over a stale socket: %v", err)
+	}
+	ln.Close()
+
+	live := filepath.Join(dir, "live.sock")
+	ln, err = net.Listen("unix", live)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	if _, err := listen(unixPrefix+live, 0o660); err == nil || !strings.Contains(err.Error(), "in use") {
+		t.Errorf("listen over a live socket: err = %v, want in use", err)
+	}
+	if _, err := os.Stat(live); err != nil {
+		t.Errorf("live socket was removed: %v", err)
+	}
+
+	regular := filepath.Join(dir, "file")
+	if err := os.WriteFile(regular, []byte("keep"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := listen(unixPrefix+regular, 0o660); err == nil || !strings.Contains(err.Error(), "not a socket") {
+		t.Errorf("listen over a regular file: err = %v, want not a socket", err)
+	}
+}
+
+func TestSendProxyUnixClient(t *testing.T) {
+	v := *sendProxy
+	t.Cleanup(func() { *sendProxy = v })
+	*sendProxy = sendProxyV1
+
+	milter, received, _ := headerMilter(t, "tcp", "127.0.0.1:0")
+	ln, err := listen(unixPrefix+filepath.Join(t.TempDir(), "tproxy.sock"), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := startProxy(ln, []string{milter.Addr().String()})
+	t.Cleanup(func() { p.Shutdown(time.Second) })
+
+	conn, err := net.Dial("unix", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	quit := frames(Message{Code: SMFIC_QUIT})
+	conn.Write(quit)
+	conn.(*net.UnixConn).CloseWrite()
+
+	select {
+	case got := <-received:
+		if want := "PROXY UNKNOWN\r\n" + string(quit); string(got) != want {
+			t.Fatalf("milter received %q, want %q", got, want)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("milter never saw EOF")
+	}
+}
@@ -0,0 +1,104 @@
+package txproxy
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// maxDialFailures consecutive failed dials put a backend in -cooldown.
+	maxDialFailures = 2
+	// failoverDelay is the pause before trying the next backend.
+	failoverDelay = 50 * time.Millisecond
+)
+
+// upstreamPool hands out milter connections in round-robin order, failing
+// over to the next backend when a dial fails and skipping backends that
+// keep failing until their cooldown is over.
+type upstreamPool struct {
+	backends []*backend
+	next     atomic.Uint64
+}
+
+// backend is one -upstream milter.
+type backend struct {
+	addr string
+
+	mu        sync.Mutex
+	failures  int       // consecutive dial failures
+	downUntil time.Time // skipped until then
+
+	conns        atomic.Int64 // successful dials
+	dialFailures atomic.Int64
+}
+
+func newUpstreamPool(addrs []string) *upstreamPool {
+	pool := &upstreamPool{}
+	for _, addr := range addrs {
+		pool.backends = append(pool.backends, &backend{addr: addr})
+	}
+	return pool
+}
+
+// dial connects to the next healthy backend, trying the others in turn if
+// it fails. Backends in cooldown are only tried once every healthy one has
+// failed, so a dead host is not dialed while another is up.
+func (p *upstreamPool) dial() (net.Conn, *backend, error) {
+	start := int(p.next.Add(1) - 1)
+	var healthy, cooling []*backend
+	now := time.Now()
+	for i := range p.backends {
+		b := p.backends[(start+i)%len(p.backends)]
+		if b.coolingDown(now) {
+			cooling = append(cooling, b)
+		} else {
+			healthy = append(healthy, b)
+		}
+	}
+
+	var lastErr error
+	for i, b := range append(healthy, cooling...) {
+		if i > 0 {
+			time.Sleep(failoverDelay)
+		}
+		network, address := splitAddr(b.addr)
+		conn, err := net.DialTimeout(network, address, *dialTimeout)
+		if err == nil {
+			b.dialed()
+			return conn, b, nil
+		}
+		b.failed()
+		log.Printf("Failed to connect to Milter service at %s: %v", b.addr, err)
+		lastErr = err
+	}
+	return nil, nil, fmt.Errorf("no milter reachable: %w", lastErr)
+}
+
+func (b *backend) coolingDown(now time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return now.Before(b.downUntil)
+}
+
+func (b *backend) dialed() {
+	b.conns.Add(1)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures = 0
+	b.downUntil = time.Time{}
+}
+
+func (b *backend) failed() {
+	b.dialFailures.Add(1)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures++
+	if b.failures >= maxDialFailures {
+		b.downUntil = time.Now().Add(*cooldown)
+		log.Printf("Milter %s failed %d times in a row, skipping it for %s", b.addr, b.failures, *cooldown)
+	}
+}